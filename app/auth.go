@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+
+	authenticationv1 "k8s.io/kubernetes/pkg/apis/authentication/v1"
+	authorizationv1 "k8s.io/kubernetes/pkg/apis/authorization/v1"
+	authenticationclient "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/authentication/v1"
+	authorizationclient "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/authorization/v1"
+)
+
+type requestUserKey int
+
+const userKey requestUserKey = 0
+
+// withAuthentication only lets requests through to handler that carry a bearer token the
+// apiserver accepts in a TokenReview. The authenticated user is available to handler via requestUser
+// and requestUserInfo.
+func withAuthentication(client authenticationclient.TokenReviewInterface, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth := strings.TrimSpace(req.Header.Get("Authorization"))
+		parts := strings.SplitN(auth, " ", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") || strings.TrimSpace(parts[1]) == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		review, err := client.Create(&authenticationv1.TokenReview{
+			Spec: authenticationv1.TokenReviewSpec{Token: strings.TrimSpace(parts[1])},
+		})
+		if err != nil {
+			glog.Errorf("Couldn't review token for request to %s: %v", req.URL.Path, err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !review.Status.Authenticated {
+			glog.V(2).Infof("Rejected unauthenticated request to %s from %s", req.URL.Path, req.RemoteAddr)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), userKey, review.Status.User)
+		handler.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// withAuthorization only lets requests authenticated by withAuthentication through to handler if
+// the apiserver allows their user the HTTP method of the request on its path in a
+// SubjectAccessReview, e.g. through a ClusterRole with nonResourceURLs ["/debug/cache/invalidate"]
+// and verbs ["post"].
+func withAuthorization(client authorizationclient.SubjectAccessReviewInterface, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user, ok := requestUserInfo(req)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		extra := map[string]authorizationv1.ExtraValue{}
+		for k, v := range user.Extra {
+			extra[k] = authorizationv1.ExtraValue(v)
+		}
+		review, err := client.Create(&authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+					Path: req.URL.Path,
+					Verb: strings.ToLower(req.Method),
+				},
+				User:   user.Username,
+				Groups: user.Groups,
+				Extra:  extra,
+			},
+		})
+		if err != nil {
+			glog.Errorf("Couldn't review access of %s to %s: %v", user.Username, req.URL.Path, err)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !review.Status.Allowed {
+			glog.V(2).Infof("Rejected %s request of %s to %s: %s", req.Method, user.Username, req.URL.Path, review.Status.Reason)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// requestUserInfo returns the user a request was authenticated as, false if the request did not
+// go through withAuthentication.
+func requestUserInfo(req *http.Request) (authenticationv1.UserInfo, bool) {
+	user, ok := req.Context().Value(userKey).(authenticationv1.UserInfo)
+	return user, ok
+}
+
+// requestUser returns the name of the user a request was authenticated as, or "" if
+// the request did not go through withAuthentication.
+func requestUser(req *http.Request) string {
+	user, _ := requestUserInfo(req)
+	return user.Username
+}
@@ -0,0 +1,389 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package app
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	clientv1 "k8s.io/client-go/pkg/api/v1"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/clientset"
+	informers "k8s.io/kubernetes/pkg/client/informers/informers_generated/externalversions"
+	"k8s.io/kubernetes/pkg/client/leaderelection"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+	"k8s.io/kubernetes/pkg/controller"
+	"k8s.io/kubernetes/pkg/util/configz"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/rancher/rancher-cloud-controller-manager/app/options"
+	"github.com/rancher/rancher-cloud-controller-manager/controller/supervisor"
+)
+
+const (
+	// Jitter used when starting controller managers
+	ControllerStartJitter = 1.0
+
+	// Number of node resyncs requested through the resync hook that may be pending at once
+	nodeResyncQueueLength = 100
+
+	// How long the controllers have to stop after losing leadership before the process exits anyway
+	leadershipLossStopTimeout = 30 * time.Second
+)
+
+// NewCloudControllerManagerCommand creates a *cobra.Command object with default parameters
+func NewCloudControllerManagerCommand() *cobra.Command {
+	s := options.NewCloudControllerManagerServer()
+	s.AddFlags(pflag.CommandLine, KnownControllers())
+	cmd := &cobra.Command{
+		Use: "cloud-controller-manager",
+		Long: `The Cloud controller manager is a daemon that embeds
+the cloud specific control loops shipped with Kubernetes.`,
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	return cmd
+}
+
+// resyncPeriod computes the time interval a shared informer waits before resyncing with the api server
+func resyncPeriod(s *options.CloudControllerManagerServer) func() time.Duration {
+	return func() time.Duration {
+		factor := rand.Float64() + 1
+		return time.Duration(float64(s.MinResyncPeriod.Nanoseconds()) * factor)
+	}
+}
+
+// Run runs the ExternalCMServer.  This should never exit.
+func Run(s *options.CloudControllerManagerServer, cloud cloudprovider.Interface) error {
+	if err := validateControllers(s.Controllers); err != nil {
+		return err
+	}
+	if s.ConcurrentServiceSyncs < 1 {
+		return fmt.Errorf("--concurrent-service-syncs must be at least 1, found %d", s.ConcurrentServiceSyncs)
+	}
+	if s.LeaderElection.LeaderElect {
+		if _, err := newResourceLock(s.LeaderElectionResourceLock, s.LeaderElectionNamespace, s.LeaderElectionLockName, "", nil, nil); err != nil {
+			return fmt.Errorf("invalid --leader-elect-resource-lock: %v", err)
+		}
+	}
+	if s.CloudCredentialsSecret != "" {
+		if _, _, err := parseSecretName(s.CloudCredentialsSecret); err != nil {
+			return fmt.Errorf("invalid --cloud-credentials-secret: %v", err)
+		}
+	}
+	if s.CloudCallHealthWindow.Duration < 0 {
+		return fmt.Errorf("--cloud-call-health-window must not be negative, found %v", s.CloudCallHealthWindow.Duration)
+	}
+	if s.EnableProfiling {
+		if err := validateDebugAddress(s.DebugAddress); err != nil {
+			return err
+		}
+	}
+	if s.LoadBalancerGCPeriod.Duration < 0 {
+		return fmt.Errorf("--load-balancer-gc-period must not be negative, found %v", s.LoadBalancerGCPeriod.Duration)
+	}
+	// Resolve "startup" now, so the controllers see the time the process started
+	manageNodesCreatedAfter, err := parseManageNodesCreatedAfter(s.ManageNodesCreatedAfter, time.Now())
+	if err != nil {
+		return fmt.Errorf("invalid --manage-nodes-created-after: %v", err)
+	}
+	if !manageNodesCreatedAfter.IsZero() {
+		s.ManageNodesCreatedAfter = manageNodesCreatedAfter.Format(time.RFC3339Nano)
+	}
+	if _, err := cloudNodeControllerOptions(s, time.Now()); err != nil {
+		return fmt.Errorf("invalid cloud node controller flags: %v", err)
+	}
+
+	if c, err := configz.New("componentconfig"); err == nil {
+		c.Set(s.KubeControllerManagerConfiguration)
+	} else {
+		glog.Errorf("unable to register configz: %s", err)
+	}
+	kubeconfig, err := clientcmd.BuildConfigFromFlags(s.Master, s.Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	// Set the ContentType of the requests from kube client
+	kubeconfig.ContentConfig.ContentType = s.ContentType
+	// Override kubeconfig qps/burst settings from flags
+	kubeconfig.QPS = s.KubeAPIQPS
+	kubeconfig.Burst = int(s.KubeAPIBurst)
+	kubeClient, err := clientset.NewForConfig(restclient.AddUserAgent(kubeconfig, "cloud-controller-manager"))
+	if err != nil {
+		glog.Fatalf("Invalid API configuration: %v", err)
+	}
+	if err := checkPermissions(kubeClient.Authorization(), s.Controllers, s.UseServiceAccountCredentials, s.DrainNodesBeforeDeletion); err != nil {
+		if s.FailOnMissingPermissions {
+			return err
+		}
+		glog.Warning(err)
+	}
+	leaderElectionClient := clientset.NewForConfigOrDie(restclient.AddUserAgent(kubeconfig, "leader-election"))
+
+	// Requests for an immediate node status update, e.g. after the host cache was invalidated
+	nodeStatusResync := make(chan struct{}, 1)
+	go handleCacheInvalidationSignal(cloud, nodeStatusResync)
+	// Names of nodes to update right away, requested through the resync hook
+	nodeResync := make(chan string, nodeResyncQueueLength)
+	// Set while this instance leads and runs the cloud node controller, which reads nodeResync
+	nodeControllerRunning := new(int32)
+
+	// Start the external controller manager server
+	address := net.JoinHostPort(s.Address, strconv.Itoa(int(s.Port)))
+	go func() {
+		mux := http.NewServeMux()
+		installHealthChecks(mux, cloud, supervisor.Default.HealthzCheck(), s.CloudCallHealthWindow.Duration)
+		mux.Handle("/debug/cache/invalidate", withAuthentication(kubeClient.Authentication().TokenReviews(),
+			withAuthorization(kubeClient.Authorization().SubjectAccessReviews(),
+				&cacheInvalidateHandler{cloud: cloud, resync: nodeStatusResync})))
+		if s.ResyncHookToken != "" {
+			mux.Handle("/hooks/resync", &resyncHookHandler{
+				token:   s.ResyncHookToken,
+				nodes:   kubeClient.Core().Nodes(),
+				cloud:   cloud,
+				resync:  nodeResync,
+				running: nodeControllerRunning,
+			})
+		}
+		// The pprof handlers share the mux of the metrics if they share their address
+		if s.EnableProfiling && s.DebugAddress == address {
+			installProfiling(mux, s.EnableContentionProfiling)
+		}
+		configz.InstallHandler(mux)
+		mux.Handle("/metrics", prometheus.Handler())
+
+		server := &http.Server{
+			Addr:    address,
+			Handler: mux,
+		}
+		glog.Fatal(server.ListenAndServe())
+	}()
+	if s.HealthzBindAddress != "" {
+		go func() {
+			mux := http.NewServeMux()
+			installHealthChecks(mux, cloud, supervisor.Default.HealthzCheck(), s.CloudCallHealthWindow.Duration)
+			glog.Fatal(http.ListenAndServe(s.HealthzBindAddress, mux))
+		}()
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(glog.Infof)
+	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: v1core.New(kubeClient.Core().RESTClient()).Events("")})
+	recorder := eventBroadcaster.NewRecorder(api.Scheme, clientv1.EventSource{Component: "cloud-controller-manager"})
+
+	// Stop the controllers on SIGTERM or SIGINT, once the node updates in flight complete. A
+	// second signal exits right away.
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		glog.Infof("Received %v, stopping controllers", sig)
+		close(stop)
+	}()
+
+	if s.EnableProfiling && s.DebugAddress != address {
+		listener, err := net.Listen("tcp", s.DebugAddress)
+		if err != nil {
+			return fmt.Errorf("couldn't listen on --debug-address: %v", err)
+		}
+		go func() {
+			mux := http.NewServeMux()
+			installProfiling(mux, s.EnableContentionProfiling)
+			if err := serveDebug(listener, mux, stop); err != nil {
+				glog.Fatal(err)
+			}
+		}()
+	}
+
+	// Every instance, leading or not, keeps its cloud provider on the current credentials
+	if s.CloudCredentialsSecret != "" {
+		if updater, ok := cloud.(CloudCredentialsUpdater); ok {
+			namespace, name, _ := parseSecretName(s.CloudCredentialsSecret)
+			go newCredentialsWatcher(updater, recorder, readCredentials).run(kubeClient.Core(), namespace, name, stop)
+		} else {
+			glog.Warningf("The cloud provider can't update its credentials, changes of --cloud-credentials-secret are ignored")
+		}
+	}
+
+	// leading is closed once this instance loses leadership, nil without leader election
+	run := func(leading <-chan struct{}) {
+		var controllersStop <-chan struct{} = stop
+		if leading != nil {
+			controllersStop = untilEither(stop, leading)
+		}
+		rootClientBuilder := controller.SimpleControllerClientBuilder{
+			ClientConfig: kubeconfig,
+		}
+		var clientBuilder controller.ControllerClientBuilder
+		if s.UseServiceAccountCredentials {
+			clientBuilder = controller.SAControllerClientBuilder{
+				ClientConfig:         restclient.AnonymousClientConfig(kubeconfig),
+				CoreClient:           kubeClient.Core(),
+				AuthenticationClient: kubeClient.Authentication(),
+				Namespace:            controllerServiceAccountNamespace,
+			}
+		} else {
+			clientBuilder = rootClientBuilder
+		}
+
+		if err := StartControllers(s, kubeconfig, rootClientBuilder, clientBuilder, controllersStop, recorder, cloud, nodeStatusResync, nodeResync, nodeControllerRunning); err != nil {
+			glog.Fatalf("error running controllers: %v", err)
+		}
+		select {
+		case <-stop:
+		default:
+			// Exit so the restarted process runs for leadership again with fresh informers
+			glog.Fatalf("leaderelection lost, controllers stopped")
+		}
+		glog.Infof("Controllers stopped")
+		glog.Flush()
+		os.Exit(0)
+	}
+
+	if !s.LeaderElection.LeaderElect {
+		// Without leader election this instance is always active
+		isLeader.Set(1)
+		run(nil)
+		panic("unreachable")
+	}
+
+	// Identity used to distinguish between multiple cloud controller manager instances
+	id, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	transitions := newLeaderTransitions(id+"-external-cloud-controller", time.Now())
+	registerLeaderTransitionAge(transitions)
+
+	// Lock required for leader election
+	rl, err := newResourceLock(s.LeaderElectionResourceLock, s.LeaderElectionNamespace, s.LeaderElectionLockName,
+		transitions.identity, leaderElectionClient, recorder)
+	if err != nil {
+		return err
+	}
+
+	// Try and become the leader and start cloud controller manager loops
+	leaderelection.RunOrDie(leaderelection.LeaderElectionConfig{
+		Lock:          rl,
+		LeaseDuration: s.LeaderElection.LeaseDuration.Duration,
+		RenewDeadline: s.LeaderElection.RenewDeadline.Duration,
+		RetryPeriod:   s.LeaderElection.RetryPeriod.Duration,
+		Callbacks: transitions.callbacks(leaderelection.LeaderCallbacks{
+			OnStartedLeading: run,
+			// The controllers stop writing once the channel of OnStartedLeading is closed, and run
+			// exits when they're done. Exit anyway if they take too long.
+			OnStoppedLeading: func() {
+				glog.Infof("Lost leadership, stopping controllers")
+				time.Sleep(leadershipLossStopTimeout)
+				glog.Fatalf("leaderelection lost, controllers didn't stop within %v", leadershipLossStopTimeout)
+			},
+		}),
+	})
+	panic("unreachable")
+}
+
+// StartControllers starts the cloud specific controller loops. It returns once stop is closed and
+// the controllers tracked in ControllerContext.Running finished.
+func StartControllers(s *options.CloudControllerManagerServer, kubeconfig *restclient.Config, rootClientBuilder, clientBuilder controller.ControllerClientBuilder, stop <-chan struct{}, recorder record.EventRecorder, cloud cloudprovider.Interface, nodeStatusResync <-chan struct{}, nodeResync <-chan string, nodeControllerRunning *int32) error {
+	// Function to build the kube client of a controller, with the credentials of its own service
+	// account when enabled
+	client := func(serviceAccountName string) clientset.Interface {
+		return clientBuilder.ClientOrDie(serviceAccountName)
+	}
+	versionedClient := rootClientBuilder.ClientOrDie("shared-informers")
+	sharedInformers := informers.NewSharedInformerFactory(versionedClient, resyncPeriod(s)())
+
+	running := &sync.WaitGroup{}
+	ctx := ControllerContext{
+		Options:          s,
+		ClientBuilder:    client,
+		InformerFactory:  sharedInformers,
+		Cloud:            cloud,
+		Stop:             stop,
+		Recorder:         recorder,
+		Running:          running,
+		NodeStatusResync: nodeStatusResync,
+		NodeResync:       nodeResync,
+
+		NodeControllerRunning: nodeControllerRunning,
+	}
+
+	active := []string{}
+	initializers := NewControllerInitializers()
+	for _, name := range KnownControllers() {
+		if !IsControllerEnabled(name, s.Controllers) {
+			glog.Warningf("%q is disabled", name)
+			continue
+		}
+
+		glog.V(1).Infof("Starting %q", name)
+		started, err := initializers[name](ctx)
+		if err != nil {
+			glog.Errorf("Error starting %q", name)
+			return err
+		}
+		if !started {
+			glog.Warningf("Skipping %q", name)
+			continue
+		}
+		active = append(active, name)
+		time.Sleep(wait.Jitter(s.ControllerStartInterval.Duration, ControllerStartJitter))
+	}
+	glog.Infof("Active controllers: %v", active)
+
+	// If apiserver is not running we should wait for some time and fail only then. This is particularly
+	// important when we start apiserver and controller manager at the same time.
+	err := wait.PollImmediate(time.Second, 10*time.Second, func() (bool, error) {
+		_, err := restclient.ServerAPIVersions(kubeconfig)
+		if err == nil {
+			return true, nil
+		}
+		glog.Errorf("Failed to get api versions from server: %v", err)
+		return false, nil
+	})
+	if err != nil {
+		glog.Fatalf("Failed to get api versions from server: %v", err)
+	}
+
+	sharedInformers.Start(stop)
+
+	<-stop
+	running.Wait()
+	return nil
+}
@@ -0,0 +1,343 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/clientset"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
+	informers "k8s.io/kubernetes/pkg/client/informers/informers_generated/externalversions"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+	routecontroller "k8s.io/kubernetes/pkg/controller/route"
+	servicecontroller "k8s.io/kubernetes/pkg/controller/service"
+
+	"github.com/rancher/rancher-cloud-controller-manager/app/options"
+	nodecontroller "github.com/rancher/rancher-cloud-controller-manager/controller/cloud"
+	"github.com/rancher/rancher-cloud-controller-manager/controller/lbcleanup"
+	"github.com/rancher/rancher-cloud-controller-manager/controller/localtraffic"
+	"github.com/rancher/rancher-cloud-controller-manager/controller/supervisor"
+)
+
+// ControllerContext holds what controllers need to be started
+type ControllerContext struct {
+	Options *options.CloudControllerManagerServer
+
+	// ClientBuilder returns a client for the controller with the given service account
+	ClientBuilder func(serviceAccountName string) clientset.Interface
+
+	// InformerFactory gives access to the shared informers. Only informers a started controller
+	// asked for are run.
+	InformerFactory informers.SharedInformerFactory
+
+	Cloud cloudprovider.Interface
+
+	// Stop is closed when the controllers should stop
+	Stop <-chan struct{}
+
+	// Recorder records the events of the controllers
+	Recorder record.EventRecorder
+
+	// Running tracks the controllers to wait for once Stop is closed
+	Running *sync.WaitGroup
+
+	// NodeStatusResync receives requests for an immediate node status update
+	NodeStatusResync <-chan struct{}
+
+	// NodeResync receives the names of nodes to update right away
+	NodeResync <-chan string
+
+	// NodeControllerRunning is set to 1 while the cloud node controller runs, if not nil
+	NodeControllerRunning *int32
+}
+
+// parseManageNodesCreatedAfter parses --manage-nodes-created-after, an RFC3339 time or "startup"
+// for now. It returns the zero time if s is empty.
+func parseManageNodesCreatedAfter(s string, now time.Time) (time.Time, error) {
+	switch s {
+	case "":
+		return time.Time{}, nil
+	case "startup":
+		return now, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// ServiceStatusWriter is implemented by cloud providers that update the load balancer status of
+// services themselves, e.g. when it follows the nodes
+type ServiceStatusWriter interface {
+	SetServicesClient(services corev1.ServicesGetter)
+}
+
+// ServiceSecretReader is implemented by cloud providers that read Secrets named by services, e.g.
+// the certificates their load balancers terminate TLS with
+type ServiceSecretReader interface {
+	SetSecretsClient(secrets corev1.SecretsGetter)
+}
+
+// LocalTrafficBalancer is implemented by cloud providers that balance the traffic of the services
+// with externalTrafficPolicy Local to the nodes running their endpoints only
+type LocalTrafficBalancer interface {
+	SetEndpointsClient(endpoints corev1.EndpointsGetter)
+}
+
+// RouteEventRecorder is implemented by cloud providers that record the outcome of programming
+// routes as events of their nodes
+type RouteEventRecorder interface {
+	SetRouteRecorder(recorder record.EventRecorder)
+}
+
+// ServiceEventRecorder is implemented by cloud providers that record events of services the service
+// controller doesn't, e.g. warnings about settings their load balancers can't honor
+type ServiceEventRecorder interface {
+	SetServiceRecorder(recorder record.EventRecorder)
+}
+
+// InitFunc starts a controller. It returns false if the controller is not needed
+// with the current configuration.
+type InitFunc func(ctx ControllerContext) (bool, error)
+
+// NewControllerInitializers returns the controllers that can be enabled with --controllers by name
+func NewControllerInitializers() map[string]InitFunc {
+	return map[string]InitFunc{
+		"cloud-node": startCloudNodeController,
+		"service":    startServiceController,
+		"route":      startRouteController,
+	}
+}
+
+// KnownControllers returns the sorted names of all controllers
+func KnownControllers() []string {
+	names := []string{}
+	for name := range NewControllerInitializers() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsControllerEnabled tells whether the controller name is enabled by controllers, a list of
+// controller names, names prefixed with "-" to disable them and "*" to enable all others.
+func IsControllerEnabled(name string, controllers []string) bool {
+	hasStar := false
+	for _, ctrl := range controllers {
+		if ctrl == name {
+			return true
+		}
+		if ctrl == "-"+name {
+			return false
+		}
+		if ctrl == "*" {
+			hasStar = true
+		}
+	}
+	return hasStar
+}
+
+// validateControllers returns an error if controllers names a controller that doesn't exist
+func validateControllers(controllers []string) error {
+	initializers := NewControllerInitializers()
+	unknown := []string{}
+	for _, ctrl := range controllers {
+		if ctrl == "*" {
+			continue
+		}
+		if _, ok := initializers[strings.TrimPrefix(ctrl, "-")]; !ok {
+			unknown = append(unknown, ctrl)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown controllers %v in --controllers, known controllers are %v", unknown, KnownControllers())
+	}
+	return nil
+}
+
+// cloudNodeControllerOptions returns the options of the cloud node controller set by the flags
+func cloudNodeControllerOptions(s *options.CloudControllerManagerServer, now time.Time) (nodecontroller.CloudNodeControllerOptions, error) {
+	o := nodecontroller.CloudNodeControllerOptions{
+		NodeMonitorPeriod:         s.NodeMonitorPeriod.Duration,
+		NodeMonitorGracePeriod:    s.NodeMonitorGracePeriod.Duration,
+		NodeStatusUpdateFrequency: s.NodeStatusUpdateFrequency.Duration,
+		EnableNodeDeletion:        s.EnableNodeDeletion,
+		NodeDeletionMinimumAge:    s.NodeDeletionMinimumAge.Duration,
+		NodeDeletionMissingChecks: int(s.NodeDeletionMissingChecks),
+		DrainNodes:                s.DrainNodesBeforeDeletion,
+		DrainGracePeriod:          s.NodeDrainGracePeriod.Duration,
+		ReplaceAddresses:          s.ReplaceNodeAddresses,
+		ProvidedNodeIPKey:         s.ProvidedNodeIPKey,
+		WaitForNodeAddresses:      s.WaitForNodeAddresses,
+		InitializeUntaintedNodes:  s.InitializeUntaintedNodes,
+		SkipCordonedNodes:         s.SkipCordonedNodeSync,
+		TopologyLabels:            nodecontroller.TopologyLabelPolicy(s.TopologyLabels),
+		MetadataLabels:            s.NodeMetadataLabels,
+		HostLabels:                s.NodeHostLabels,
+		HostLabelPrefix:           s.NodeHostLabelPrefix,
+		CloudCallTimeout:          s.CloudCallTimeout.Duration,
+		LoopJitter:                s.LoopJitterFactor,
+		ConcurrentNodeSyncs:       int(s.ConcurrentNodeSyncs),
+	}
+	var err error
+	if o.ManageNodesCreatedAfter, err = parseManageNodesCreatedAfter(s.ManageNodesCreatedAfter, now); err != nil {
+		return o, err
+	}
+	return o, o.Validate()
+}
+
+func startCloudNodeController(ctx ControllerContext) (bool, error) {
+	s := ctx.Options
+	options, err := cloudNodeControllerOptions(s, time.Now())
+	if err != nil {
+		return false, err
+	}
+	options.Running = ctx.NodeControllerRunning
+	nodeController, err := nodecontroller.NewCloudNodeController(
+		ctx.InformerFactory.Core().V1().Nodes(),
+		ctx.ClientBuilder(controllerServiceAccounts["cloud-node"]), ctx.Cloud,
+		options)
+	if err != nil {
+		return false, err
+	}
+
+	ctx.Running.Add(1)
+	go func() {
+		defer ctx.Running.Done()
+		nodeController.Run(ctx.Stop)
+	}()
+	supervisor.Default.Go("node-status-resync", func() {
+		for range ctx.NodeStatusResync {
+			nodeController.UpdateNodeStatus()
+		}
+	})
+	supervisor.Default.Go("node-resync", func() {
+		for name := range ctx.NodeResync {
+			if err := nodeController.UpdateNode(name); err != nil {
+				glog.Errorf("Error updating node %s: %v", name, err)
+			}
+		}
+	})
+	if source, ok := ctx.Cloud.(nodecontroller.HostEventSource); ok {
+		supervisor.Default.Go("host-events", func() {
+			source.WatchHostEvents(ctx.Stop, func(name string) {
+				if err := nodeController.HostChanged(name); err != nil {
+					glog.Errorf("Error updating node %s after its host changed: %v", name, err)
+				}
+			})
+		})
+	}
+	return true, nil
+}
+
+func startServiceController(ctx ControllerContext) (bool, error) {
+	client := ctx.ClientBuilder(controllerServiceAccounts["service"])
+	serviceController, err := servicecontroller.New(
+		ctx.Cloud,
+		client,
+		ctx.InformerFactory.Core().V1().Services(),
+		ctx.InformerFactory.Core().V1().Nodes(),
+		ctx.Options.ClusterName,
+	)
+	if err != nil {
+		// Keep running the other controllers, as before the service controller could be disabled
+		glog.Errorf("Failed to start service controller: %v", err)
+		return false, nil
+	}
+	if writer, ok := ctx.Cloud.(ServiceStatusWriter); ok {
+		writer.SetServicesClient(client.Core())
+	}
+	if reader, ok := ctx.Cloud.(ServiceSecretReader); ok {
+		reader.SetSecretsClient(client.Core())
+	}
+	if recorder, ok := ctx.Cloud.(ServiceEventRecorder); ok {
+		recorder.SetServiceRecorder(ctx.Recorder)
+	}
+	if balancer, ok := ctx.Cloud.(LocalTrafficBalancer); ok {
+		balancer.SetEndpointsClient(client.Core())
+		startLocalTrafficController(ctx)
+	}
+	startLoadBalancerCleanupController(ctx, client.Core())
+	workers := int(ctx.Options.ConcurrentServiceSyncs)
+	glog.Infof("Starting service controller with %d workers", workers)
+	serviceSyncWorkers.Set(float64(workers))
+	go serviceController.Run(ctx.Stop, workers)
+	return true, nil
+}
+
+// startLocalTrafficController updates the load balancers of the services with externalTrafficPolicy
+// Local when their endpoints move, along with the service controller
+func startLocalTrafficController(ctx ControllerContext) {
+	balancer, ok := ctx.Cloud.LoadBalancer()
+	if !ok {
+		return
+	}
+	localTrafficController := localtraffic.New(
+		ctx.Options.ClusterName,
+		balancer,
+		ctx.InformerFactory.Core().V1().Services(),
+		ctx.InformerFactory.Core().V1().Nodes(),
+		ctx.InformerFactory.Core().V1().Endpoints(),
+	)
+	ctx.Running.Add(1)
+	go func() {
+		defer ctx.Running.Done()
+		localTrafficController.Run(ctx.Stop)
+	}()
+}
+
+// startLoadBalancerCleanupController deletes the load balancers of the services deleted while
+// the service controller was down, along with the service controller
+func startLoadBalancerCleanupController(ctx ControllerContext, services corev1.ServicesGetter) {
+	balancer, ok := ctx.Cloud.LoadBalancer()
+	if !ok {
+		return
+	}
+	collector, _ := ctx.Cloud.(lbcleanup.Collector)
+	cleanupController := lbcleanup.New(
+		ctx.Options.ClusterName,
+		balancer,
+		collector,
+		ctx.Options.LoadBalancerGCPeriod.Duration,
+		services,
+		ctx.InformerFactory.Core().V1().Services(),
+	)
+	ctx.Running.Add(1)
+	go func() {
+		defer ctx.Running.Done()
+		cleanupController.Run(ctx.Stop)
+	}()
+}
+
+func startRouteController(ctx ControllerContext) (bool, error) {
+	s := ctx.Options
+	// If CIDRs should be allocated for pods and set on the CloudProvider, then start the route controller
+	if !s.AllocateNodeCIDRs || !s.ConfigureCloudRoutes {
+		glog.Infof("Will not configure cloud provider routes for allocate-node-cidrs: %v, configure-cloud-routes: %v.", s.AllocateNodeCIDRs, s.ConfigureCloudRoutes)
+		return false, nil
+	}
+	routes, ok := ctx.Cloud.Routes()
+	if !ok {
+		glog.Warning("configure-cloud-routes is set, but cloud provider does not support routes. Will not configure cloud provider routes.")
+		return false, nil
+	}
+	if recorder, ok := ctx.Cloud.(RouteEventRecorder); ok {
+		recorder.SetRouteRecorder(ctx.Recorder)
+	}
+
+	_, clusterCIDR, err := net.ParseCIDR(s.ClusterCIDR)
+	if err != nil {
+		glog.Warningf("Unsuccessful parsing of cluster CIDR %v: %v", s.ClusterCIDR, err)
+	}
+	routeController := routecontroller.New(routes, ctx.ClientBuilder(controllerServiceAccounts["route"]), ctx.InformerFactory.Core().V1().Nodes(), s.ClusterName, clusterCIDR)
+	// Run waits for the node informer, which is only started once every controller is started
+	ctx.Running.Add(1)
+	go func() {
+		defer ctx.Running.Done()
+		routeController.Run(ctx.Stop, s.RouteReconciliationPeriod.Duration)
+	}()
+	return true, nil
+}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+	"k8s.io/kubernetes/pkg/controller"
+
+	"github.com/rancher/rancher-cloud-controller-manager/app/options"
+)
+
+// fakeRoutes signals listed once the routes are listed
+type fakeRoutes struct {
+	cloudprovider.Routes
+	once   sync.Once
+	listed chan struct{}
+}
+
+func (f *fakeRoutes) ListRoutes(clusterName string) ([]*cloudprovider.Route, error) {
+	f.once.Do(func() { close(f.listed) })
+	return nil, nil
+}
+
+type fakeRoutesCloud struct {
+	cloudprovider.Interface
+	routes   *fakeRoutes
+	recorder record.EventRecorder
+}
+
+func (f *fakeRoutesCloud) Routes() (cloudprovider.Routes, bool) {
+	return f.routes, true
+}
+
+func (f *fakeRoutesCloud) SetRouteRecorder(recorder record.EventRecorder) {
+	f.recorder = recorder
+}
+
+// fakeAPIServer serves the API discovery and empty node listings, watches end right away
+func fakeAPIServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case req.URL.Path == "/api":
+			w.Write([]byte(`{"kind":"APIVersions","versions":["v1"]}`))
+		case req.URL.Path == "/apis":
+			w.Write([]byte(`{"kind":"APIGroupList","groups":[]}`))
+		case req.URL.Path == "/api/v1/nodes" && req.URL.Query().Get("watch") == "":
+			w.Write([]byte(`{"kind":"NodeList","apiVersion":"v1","metadata":{"resourceVersion":"1"},"items":[]}`))
+		case req.URL.Path == "/api/v1/nodes":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestStartControllersWithRoutes(t *testing.T) {
+	server := fakeAPIServer()
+	defer server.Close()
+	kubeconfig := &restclient.Config{Host: server.URL}
+	kubeconfig.ContentConfig.ContentType = "application/json"
+	builder := controller.SimpleControllerClientBuilder{ClientConfig: kubeconfig}
+
+	s := options.NewCloudControllerManagerServer()
+	s.Controllers = []string{"route"}
+	s.AllocateNodeCIDRs = true
+	s.ConfigureCloudRoutes = true
+	s.ClusterCIDR = "10.244.0.0/16"
+	s.RouteReconciliationPeriod.Duration = time.Second
+	cloud := &fakeRoutesCloud{routes: &fakeRoutes{listed: make(chan struct{})}}
+
+	recorder := record.NewFakeRecorder(10)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- StartControllers(s, kubeconfig, builder, builder, stop, recorder, cloud, nil, nil, nil)
+	}()
+
+	// the routes are reconciled once the node informer is started, after every controller
+	select {
+	case <-cloud.routes.listed:
+	case <-time.After(10 * time.Second):
+		t.Errorf("expected the route controller to reconcile the routes")
+	}
+	if cloud.recorder != recorder {
+		t.Errorf("expected the provider to record the route events with the recorder")
+	}
+	close(stop)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected StartControllers to return once stopped")
+	}
+}
+
+func TestIsControllerEnabled(t *testing.T) {
+	tests := []struct {
+		controllers []string
+		enabled     map[string]bool
+	}{
+		{
+			controllers: []string{"*"},
+			enabled:     map[string]bool{"cloud-node": true, "service": true, "route": true},
+		},
+		{
+			controllers: []string{"*", "-service"},
+			enabled:     map[string]bool{"cloud-node": true, "service": false, "route": true},
+		},
+		{
+			controllers: []string{"cloud-node"},
+			enabled:     map[string]bool{"cloud-node": true, "service": false, "route": false},
+		},
+		{
+			controllers: []string{},
+			enabled:     map[string]bool{"cloud-node": false, "service": false, "route": false},
+		},
+	}
+
+	for _, test := range tests {
+		for name, enabled := range test.enabled {
+			if IsControllerEnabled(name, test.controllers) != enabled {
+				t.Errorf("expected %s to be enabled by %v: %v", name, test.controllers, enabled)
+			}
+		}
+	}
+}
+
+func TestValidateControllers(t *testing.T) {
+	tests := []struct {
+		controllers []string
+		valid       bool
+	}{
+		{controllers: []string{"*"}, valid: true},
+		{controllers: []string{"*", "-route"}, valid: true},
+		{controllers: []string{"cloud-node", "service"}, valid: true},
+		{controllers: []string{"*", "-routes"}},
+		{controllers: []string{"node"}},
+	}
+
+	for _, test := range tests {
+		err := validateControllers(test.controllers)
+		if test.valid && err != nil {
+			t.Errorf("unexpected error for %v: %v", test.controllers, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected %v to be rejected", test.controllers)
+		}
+	}
+}
+
+func TestParseManageNodesCreatedAfter(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		value  string
+		expect time.Time
+		valid  bool
+	}{
+		{value: "", valid: true},
+		{value: "startup", expect: now, valid: true},
+		{value: "2017-06-01T10:00:00Z", expect: time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC), valid: true},
+		{value: "2017-06-01"},
+	}
+
+	for _, test := range tests {
+		found, err := parseManageNodesCreatedAfter(test.value, now)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %v, found error %v", test.value, test.valid, err)
+			continue
+		}
+		if !found.Equal(test.expect) {
+			t.Errorf("%q: expected %v, found %v", test.value, test.expect, found)
+		}
+	}
+}
+
+func TestCloudNodeControllerOptions(t *testing.T) {
+	s := options.NewCloudControllerManagerServer()
+	o, err := cloudNodeControllerOptions(s, time.Now())
+	if err != nil {
+		t.Fatalf("expected the default flags to be valid: %v", err)
+	}
+	if o.NodeStatusUpdateFrequency != 10*time.Second || o.CloudCallTimeout != 10*time.Second || o.NodeDeletionMissingChecks != 3 {
+		t.Errorf("unexpected defaults %+v", o)
+	}
+
+	s.NodeMonitorPeriod.Duration = time.Minute
+	if _, err := cloudNodeControllerOptions(s, time.Now()); err == nil {
+		t.Errorf("expected a node monitor period above the grace period to be invalid")
+	}
+}
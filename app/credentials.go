@@ -0,0 +1,144 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/clientset"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
+
+	"github.com/rancher/rancher-cloud-controller-manager/app/options"
+)
+
+// CloudCredentialsUpdater is implemented by cloud providers whose API credentials can be replaced
+// while they run
+type CloudCredentialsUpdater interface {
+	// UpdateCredentials switches to the credentials in data once the API accepted them, keeping
+	// the current ones otherwise
+	UpdateCredentials(data map[string]string) error
+}
+
+// readCredentials is the data ReadCloudCredentials read, which the cloud provider was initialized
+// with
+var readCredentials map[string]string
+
+// parseSecretName splits the namespace/name of a Secret
+func parseSecretName(s string) (string, string, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("expected namespace/name, found %q", s)
+	}
+	return parts[0], parts[1], nil
+}
+
+// secretData returns the data of a Secret as strings
+func secretData(secret *v1.Secret) map[string]string {
+	data := map[string]string{}
+	for key, value := range secret.Data {
+		data[key] = strings.TrimSpace(string(value))
+	}
+	return data
+}
+
+// ReadCloudCredentials reads the Secret named by --cloud-credentials-secret, before the cloud
+// provider is initialized with its data
+func ReadCloudCredentials(s *options.CloudControllerManagerServer) (map[string]string, error) {
+	namespace, name, err := parseSecretName(s.CloudCredentialsSecret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --cloud-credentials-secret: %v", err)
+	}
+	kubeconfig, err := clientcmd.BuildConfigFromFlags(s.Master, s.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := clientset.NewForConfig(restclient.AddUserAgent(kubeconfig, "cloud-controller-manager"))
+	if err != nil {
+		return nil, err
+	}
+	secret, err := kubeClient.Core().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the cloud credentials Secret %s/%s: %v", namespace, name, err)
+	}
+	readCredentials = secretData(secret)
+	return readCredentials, nil
+}
+
+// credentialsWatcher gives the cloud provider the credentials of a Secret whenever it changes
+type credentialsWatcher struct {
+	cloud    CloudCredentialsUpdater
+	recorder record.EventRecorder
+
+	lock sync.Mutex
+	// applied is the data last given to the cloud provider, accepted or not
+	applied map[string]string
+}
+
+// newCredentialsWatcher returns a watcher starting from the credentials the cloud provider was
+// initialized with
+func newCredentialsWatcher(cloud CloudCredentialsUpdater, recorder record.EventRecorder, initial map[string]string) *credentialsWatcher {
+	return &credentialsWatcher{cloud: cloud, recorder: recorder, applied: initial}
+}
+
+// update gives the cloud provider the credentials of secret if they changed. Rejected credentials
+// are reported with a Warning event on the Secret, and the cloud provider keeps the current ones.
+func (w *credentialsWatcher) update(secret *v1.Secret) {
+	data := secretData(secret)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if reflect.DeepEqual(data, w.applied) {
+		return
+	}
+	w.applied = data
+
+	if err := w.cloud.UpdateCredentials(data); err != nil {
+		glog.Errorf("Keeping the current cloud credentials, those of Secret %s/%s were not applied: %v", secret.Namespace, secret.Name, err)
+		w.recorder.Eventf(secret, v1.EventTypeWarning, "CredentialsRejected", "Keeping the current cloud credentials: %v", err)
+		return
+	}
+	glog.Infof("Applied the cloud credentials of Secret %s/%s", secret.Namespace, secret.Name)
+	w.recorder.Event(secret, v1.EventTypeNormal, "CredentialsUpdated", "Applied the new cloud credentials")
+}
+
+// run watches the Secret namespace/name until stop is closed
+func (w *credentialsWatcher) run(secrets corev1.SecretsGetter, namespace, name string, stop <-chan struct{}) {
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return secrets.Secrets(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return secrets.Secrets(namespace).Watch(options)
+		},
+	}
+	_, controller := cache.NewInformer(lw, &v1.Secret{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if secret, ok := obj.(*v1.Secret); ok {
+				w.update(secret)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if secret, ok := obj.(*v1.Secret); ok {
+				w.update(secret)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			glog.Warningf("Cloud credentials Secret %s/%s was deleted, keeping the current credentials", namespace, name)
+		},
+	})
+	controller.Run(stop)
+}
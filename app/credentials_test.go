@@ -0,0 +1,96 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/api/v1"
+)
+
+// fakeCredentialsUpdater rejects the credentials whose token is "rejected"
+type fakeCredentialsUpdater struct {
+	updates []map[string]string
+}
+
+func (f *fakeCredentialsUpdater) UpdateCredentials(data map[string]string) error {
+	f.updates = append(f.updates, data)
+	if data["token"] == "rejected" {
+		return fmt.Errorf("401 Unauthorized")
+	}
+	return nil
+}
+
+func credentialsSecret(token string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "rancher-credentials"},
+		Data:       map[string][]byte{"token": []byte(token + "\n")},
+	}
+}
+
+func TestCredentialsWatcher(t *testing.T) {
+	cloud := &fakeCredentialsUpdater{}
+	recorder := record.NewFakeRecorder(10)
+	w := newCredentialsWatcher(cloud, recorder, map[string]string{"token": "initial"})
+
+	tests := []struct {
+		name    string
+		token   string
+		updates int
+		event   string
+	}{
+		{name: "initial credentials", token: "initial", updates: 0},
+		{name: "rotated", token: "rotated", updates: 1, event: "Normal CredentialsUpdated"},
+		{name: "resync", token: "rotated", updates: 1},
+		{name: "rejected", token: "rejected", updates: 2, event: "Warning CredentialsRejected"},
+		{name: "rejected resync", token: "rejected", updates: 2},
+		{name: "fixed", token: "fixed", updates: 3, event: "Normal CredentialsUpdated"},
+	}
+
+	for _, test := range tests {
+		w.update(credentialsSecret(test.token))
+		if len(cloud.updates) != test.updates {
+			t.Errorf("%s: expected %d updates, found %d", test.name, test.updates, len(cloud.updates))
+		} else if test.updates > 0 && cloud.updates[test.updates-1]["token"] != test.token {
+			t.Errorf("%s: expected the token %q, found %v", test.name, test.token, cloud.updates[test.updates-1])
+		}
+
+		select {
+		case event := <-recorder.Events:
+			if test.event == "" || !strings.HasPrefix(event, test.event) {
+				t.Errorf("%s: expected event %q, found %q", test.name, test.event, event)
+			}
+		default:
+			if test.event != "" {
+				t.Errorf("%s: expected event %q, found none", test.name, test.event)
+			}
+		}
+	}
+}
+
+func TestParseSecretName(t *testing.T) {
+	tests := []struct {
+		value     string
+		namespace string
+		name      string
+		valid     bool
+	}{
+		{value: "kube-system/rancher-credentials", namespace: "kube-system", name: "rancher-credentials", valid: true},
+		{value: "rancher-credentials"},
+		{value: "kube-system/"},
+		{value: "a/b/c"},
+	}
+
+	for _, test := range tests {
+		namespace, name, err := parseSecretName(test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %v, err: %v", test.value, test.valid, err)
+			continue
+		}
+		if namespace != test.namespace || name != test.name {
+			t.Errorf("%q: expected %s/%s, found %s/%s", test.value, test.namespace, test.name, namespace, name)
+		}
+	}
+}
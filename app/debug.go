@@ -0,0 +1,72 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/golang/glog"
+
+	"k8s.io/kubernetes/pkg/cloudprovider"
+
+	nodecontroller "github.com/rancher/rancher-cloud-controller-manager/controller/cloud"
+)
+
+// cacheInvalidateHandler serves POST /debug/cache/invalidate[?host=<name or uuid>]
+type cacheInvalidateHandler struct {
+	cloud  cloudprovider.Interface
+	resync chan<- struct{}
+}
+
+func (h *cacheInvalidateHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	host := req.URL.Query().Get("host")
+	evicted, err := invalidateHostCache(h.cloud, host, h.resync)
+	if err != nil {
+		glog.Errorf("Host cache invalidation requested by %s failed: %v", requestUser(req), err)
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	glog.Infof("Host cache invalidation requested by %s for [%s]: evicted %d hosts", requestUser(req), host, evicted)
+	fmt.Fprintf(w, "evicted %d hosts\n", evicted)
+}
+
+// handleCacheInvalidationSignal flushes the whole host cache every time the process receives SIGUSR1
+func handleCacheInvalidationSignal(cloud cloudprovider.Interface, resync chan<- struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	for range signals {
+		evicted, err := invalidateHostCache(cloud, "", resync)
+		if err != nil {
+			glog.Errorf("Host cache invalidation on SIGUSR1 failed: %v", err)
+			continue
+		}
+		glog.Infof("Host cache invalidation on SIGUSR1: evicted %d hosts", evicted)
+	}
+}
+
+// invalidateHostCache evicts hosts from the cloud provider's cache and requests
+// an immediate node status update so the change is picked up right away.
+func invalidateHostCache(cloud cloudprovider.Interface, key string, resync chan<- struct{}) (int, error) {
+	invalidator, ok := cloud.(nodecontroller.HostCacheInvalidator)
+	if !ok {
+		return 0, fmt.Errorf("cloud provider %s does not cache hosts", cloud.ProviderName())
+	}
+
+	evicted := invalidator.InvalidateHostCache(key)
+
+	select {
+	case resync <- struct{}{}:
+	default:
+		// a resync is already pending
+	}
+	return evicted, nil
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authenticationv1 "k8s.io/kubernetes/pkg/apis/authentication/v1"
+	authorizationv1 "k8s.io/kubernetes/pkg/apis/authorization/v1"
+	authenticationclient "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/authentication/v1"
+	authorizationclient "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/authorization/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// fakeTokenReviews authenticates the tokens of users, as the user with that name in group
+type fakeTokenReviews struct {
+	authenticationclient.TokenReviewInterface
+	users map[string]string
+	group string
+}
+
+func (f *fakeTokenReviews) Create(review *authenticationv1.TokenReview) (*authenticationv1.TokenReview, error) {
+	if name, ok := f.users[review.Spec.Token]; ok {
+		review.Status.Authenticated = true
+		review.Status.User = authenticationv1.UserInfo{Username: name, Groups: []string{f.group}}
+	}
+	return review, nil
+}
+
+// fakeNonResourceAccessReviews allows the requests of the allowed group and records the reviews
+type fakeNonResourceAccessReviews struct {
+	authorizationclient.SubjectAccessReviewInterface
+	allowed string
+	reviews []authorizationv1.SubjectAccessReviewSpec
+}
+
+func (f *fakeNonResourceAccessReviews) Create(review *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error) {
+	f.reviews = append(f.reviews, review.Spec)
+	for _, group := range review.Spec.Groups {
+		review.Status.Allowed = review.Status.Allowed || group == f.allowed
+	}
+	return review, nil
+}
+
+type fakeCachingCloud struct {
+	cloudprovider.Interface
+	invalidated []string
+}
+
+func (f *fakeCachingCloud) InvalidateHostCache(key string) int {
+	f.invalidated = append(f.invalidated, key)
+	return 1
+}
+
+func TestCacheInvalidateHandler(t *testing.T) {
+	cloud := &fakeCachingCloud{}
+	reviews := &fakeNonResourceAccessReviews{allowed: "system:masters"}
+	users := map[string]string{"admin-token": "admin", "viewer-token": "viewer"}
+
+	tests := []struct {
+		name  string
+		token string
+		group string
+		code  int
+	}{
+		{name: "no token", code: http.StatusUnauthorized},
+		{name: "unknown token", token: "guess", group: "system:masters", code: http.StatusUnauthorized},
+		{name: "authenticated but not allowed", token: "viewer-token", group: "system:authenticated", code: http.StatusForbidden},
+		{name: "allowed", token: "admin-token", group: "system:masters", code: http.StatusOK},
+	}
+	for _, test := range tests {
+		handler := withAuthentication(&fakeTokenReviews{users: users, group: test.group},
+			withAuthorization(reviews, &cacheInvalidateHandler{cloud: cloud, resync: make(chan struct{}, 1)}))
+
+		cloud.invalidated = nil
+		req := httptest.NewRequest("POST", "/debug/cache/invalidate?host=host1", nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, found %d: %s", test.name, test.code, w.Code, w.Body.String())
+		}
+		if invalidated := len(cloud.invalidated) > 0; invalidated != (test.code == http.StatusOK) {
+			t.Errorf("%s: expected the cache to be invalidated only when allowed, found %v", test.name, cloud.invalidated)
+		}
+	}
+
+	// the review asks about the method on the path, for the authenticated user
+	last := reviews.reviews[len(reviews.reviews)-1]
+	if attrs := last.NonResourceAttributes; attrs == nil || attrs.Path != "/debug/cache/invalidate" || attrs.Verb != "post" {
+		t.Errorf("expected a review of post /debug/cache/invalidate, found %#v", attrs)
+	}
+	if last.User != "admin" || len(last.Groups) != 1 || last.Groups[0] != "system:masters" {
+		t.Errorf("expected a review for admin in system:masters, found %s in %v", last.User, last.Groups)
+	}
+}
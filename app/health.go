@@ -0,0 +1,76 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apiserver/pkg/server/healthz"
+	"k8s.io/client-go/util/clock"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+
+	nodecontroller "github.com/rancher/rancher-cloud-controller-manager/controller/cloud"
+)
+
+// pingCacheTTL is how long the result of a ping of the cloud provider is reported by /readyz,
+// so frequent probes don't load its API
+const pingCacheTTL = 10 * time.Second
+
+// pingCheck is a /readyz check pinging the cloud provider, caching the result for pingCacheTTL
+type pingCheck struct {
+	pinger nodecontroller.Pinger
+	clock  clock.Clock
+
+	lock    sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func (c *pingCheck) Name() string {
+	return "cloud-provider-ping"
+}
+
+func (c *pingCheck) Check(*http.Request) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.checked.IsZero() || c.clock.Since(c.checked) >= pingCacheTTL {
+		c.err = c.pinger.Ping()
+		c.checked = c.clock.Now()
+	}
+	return c.err
+}
+
+// readyzHandler serves /readyz, failing if any of its checks fails
+type readyzHandler struct {
+	checks []healthz.HealthzChecker
+}
+
+func (h *readyzHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, check := range h.checks {
+		if err := check.Check(req); err != nil {
+			glog.V(2).Infof("readyz check %s failed: %v", check.Name(), err)
+			http.Error(w, fmt.Sprintf("%s failed: %v", check.Name(), err), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Write([]byte("ok"))
+}
+
+// installHealthChecks serves /healthz, failing while the controller loops are unhealthy or the
+// calls to the cloud provider have been failing for longer than window (unless 0), and /readyz,
+// which also pings the cloud provider if it supports it
+func installHealthChecks(mux *http.ServeMux, cloud cloudprovider.Interface, loops healthz.HealthzChecker, window time.Duration) {
+	checks := []healthz.HealthzChecker{loops}
+	if window > 0 {
+		checks = append(checks, nodecontroller.CloudCallsHealthzCheck(window))
+	}
+	healthz.InstallHandler(mux, checks...)
+
+	if pinger, ok := cloud.(nodecontroller.Pinger); ok {
+		checks = append(checks, &pingCheck{pinger: pinger, clock: clock.RealClock{}})
+	}
+	mux.Handle("/readyz", &readyzHandler{checks: checks})
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/apiserver/pkg/server/healthz"
+	"k8s.io/client-go/util/clock"
+)
+
+type fakePinger struct {
+	err   error
+	pings int
+}
+
+func (p *fakePinger) Ping() error {
+	p.pings++
+	return p.err
+}
+
+func TestReadyzPingsCloudProvider(t *testing.T) {
+	pinger := &fakePinger{}
+	c := clock.NewFakeClock(time.Now())
+	h := &readyzHandler{checks: []healthz.HealthzChecker{&pingCheck{pinger: pinger, clock: c}}}
+	readyz := func() int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code
+	}
+
+	if code := readyz(); code != http.StatusOK {
+		t.Errorf("expected status 200 with a reachable cloud provider, got %d", code)
+	}
+
+	// the failure shows up once the cached result expires
+	pinger.err = fmt.Errorf("401 Unauthorized")
+	if code := readyz(); code != http.StatusOK {
+		t.Errorf("expected the cached result to be reported, got %d", code)
+	}
+	if pinger.pings != 1 {
+		t.Errorf("expected a single ping within the cache TTL, got %d", pinger.pings)
+	}
+	c.Step(pingCacheTTL)
+	if code := readyz(); code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 with an unreachable cloud provider, got %d", code)
+	}
+}
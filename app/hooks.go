@@ -0,0 +1,126 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+
+	nodecontroller "github.com/rancher/rancher-cloud-controller-manager/controller/cloud"
+)
+
+// resyncRequest is the body of POST /hooks/resync, naming a node or the UUID of its host
+type resyncRequest struct {
+	Node     string `json:"node"`
+	HostUUID string `json:"hostUUID"`
+}
+
+// resyncHookHandler serves POST /hooks/resync, requesting an immediate address and label
+// update of a node. Requests must carry the configured token as a bearer token, and are only
+// accepted while running is set, by the cloud node controller of the leader.
+type resyncHookHandler struct {
+	token   string
+	nodes   corev1.NodeInterface
+	cloud   cloudprovider.Interface
+	resync  chan<- string
+	running *int32
+}
+
+func (h *resyncHookHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	code, msg := h.serve(req)
+	resyncHookRequests.WithLabelValues(strconv.Itoa(code)).Inc()
+	glog.Infof("Resync hook request from %s: %d %s", req.RemoteAddr, code, msg)
+	if code != http.StatusAccepted {
+		http.Error(w, msg, code)
+		return
+	}
+	w.WriteHeader(code)
+	fmt.Fprintln(w, msg)
+}
+
+func (h *resyncHookHandler) serve(req *http.Request) (int, string) {
+	if req.Method != "POST" {
+		return http.StatusMethodNotAllowed, "Method Not Allowed"
+	}
+	auth := strings.TrimSpace(req.Header.Get("Authorization"))
+	parts := strings.SplitN(auth, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimSpace(parts[1])), []byte(h.token)) != 1 {
+		return http.StatusUnauthorized, "Unauthorized"
+	}
+	// Nothing reads the requests of other instances, the leader has to be asked
+	if atomic.LoadInt32(h.running) != 1 {
+		return http.StatusServiceUnavailable, "not the leader"
+	}
+
+	var body resyncRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err)
+	}
+	if (body.Node == "") == (body.HostUUID == "") {
+		return http.StatusBadRequest, "exactly one of node and hostUUID must be given"
+	}
+
+	name, err := h.nodeName(body)
+	if errors.IsNotFound(err) {
+		return http.StatusNotFound, err.Error()
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+
+	select {
+	case h.resync <- name:
+	default:
+		return http.StatusServiceUnavailable, "too many pending resyncs"
+	}
+	return http.StatusAccepted, fmt.Sprintf("resync of node %s queued", name)
+}
+
+// nodeName returns the name of the node a resync request refers to
+func (h *resyncHookHandler) nodeName(body resyncRequest) (string, error) {
+	if body.Node != "" {
+		node, err := h.nodes.Get(body.Node, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		return node.Name, nil
+	}
+
+	lister, ok := h.cloud.(nodecontroller.HostnameLister)
+	if !ok {
+		return "", fmt.Errorf("cloud provider %s can't look up hosts by UUID", h.cloud.ProviderName())
+	}
+	hostnames, err := lister.HostnamesByUUID()
+	if err != nil {
+		return "", err
+	}
+	notFound := errors.NewNotFound(schema.GroupResource{Resource: "nodes"}, "host "+body.HostUUID)
+	hostname, ok := hostnames[body.HostUUID]
+	if !ok {
+		return "", notFound
+	}
+
+	// nodes are named after the hostname of their host
+	nodes, err := h.nodes.List(metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes.Items {
+		if strings.EqualFold(node.Name, hostname) {
+			return node.Name, nil
+		}
+	}
+	return "", notFound
+}
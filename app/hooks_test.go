@@ -0,0 +1,135 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kubernetes/pkg/api/v1"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+type fakeNodes struct {
+	corev1.NodeInterface
+	names map[string]bool
+}
+
+func (f *fakeNodes) Get(name string, options metav1.GetOptions) (*v1.Node, error) {
+	if !f.names[name] {
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "nodes"}, name)
+	}
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+}
+
+func (f *fakeNodes) List(options metav1.ListOptions) (*v1.NodeList, error) {
+	list := &v1.NodeList{}
+	for name := range f.names {
+		list.Items = append(list.Items, v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	return list, nil
+}
+
+// fakeHostnameCloud lists hostnames, counting the listings
+type fakeHostnameCloud struct {
+	cloudprovider.Interface
+	hostnames map[string]string
+	listings  int
+}
+
+func (f *fakeHostnameCloud) HostnamesByUUID() (map[string]string, error) {
+	f.listings++
+	return f.hostnames, nil
+}
+
+func TestResyncHook(t *testing.T) {
+	resync := make(chan string, 1)
+	cloud := &fakeHostnameCloud{hostnames: map[string]string{"uuid-1": "Node1", "uuid-3": "node3"}}
+	running := int32(1)
+	h := &resyncHookHandler{
+		token:   "s3cr3t",
+		nodes:   &fakeNodes{names: map[string]bool{"node1": true, "node2": true}},
+		cloud:   cloud,
+		resync:  resync,
+		running: &running,
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		auth   string
+		body   string
+		code   int
+	}{
+		{name: "wrong method", method: "GET", auth: "Bearer s3cr3t", code: http.StatusMethodNotAllowed},
+		{name: "no token", method: "POST", body: `{"node": "node1"}`, code: http.StatusUnauthorized},
+		{name: "wrong token", method: "POST", auth: "Bearer guess", body: `{"node": "node1"}`, code: http.StatusUnauthorized},
+		{name: "invalid body", method: "POST", auth: "Bearer s3cr3t", body: `node1`, code: http.StatusBadRequest},
+		{name: "node and host", method: "POST", auth: "Bearer s3cr3t", body: `{"node": "node1", "hostUUID": "abcd"}`, code: http.StatusBadRequest},
+		{name: "unknown node", method: "POST", auth: "Bearer s3cr3t", body: `{"node": "node4"}`, code: http.StatusNotFound},
+		{name: "unknown host", method: "POST", auth: "Bearer s3cr3t", body: `{"hostUUID": "uuid-2"}`, code: http.StatusNotFound},
+		{name: "host without a node", method: "POST", auth: "Bearer s3cr3t", body: `{"hostUUID": "uuid-3"}`, code: http.StatusNotFound},
+		{name: "node", method: "POST", auth: "Bearer s3cr3t", body: `{"node": "node1"}`, code: http.StatusAccepted},
+		{name: "queue full", method: "POST", auth: "Bearer s3cr3t", body: `{"node": "node1"}`, code: http.StatusServiceUnavailable},
+		{name: "host", method: "POST", auth: "Bearer s3cr3t", body: `{"hostUUID": "uuid-1"}`, code: http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/hooks/resync", strings.NewReader(test.body))
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != test.code {
+			t.Errorf("%s: expected %d, found %d: %s", test.name, test.code, w.Code, w.Body.String())
+		}
+	}
+
+	if name := <-resync; name != "node1" {
+		t.Errorf("expected resync of node1, found %s", name)
+	}
+
+	// the node of a host is found once the queue has room, with a single host listing per request
+	cloud.listings = 0
+	req := httptest.NewRequest("POST", "/hooks/resync", strings.NewReader(`{"hostUUID": "uuid-1"}`))
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("expected the host resync to be accepted, found %d: %s", w.Code, w.Body.String())
+	}
+	if name := <-resync; name != "node1" {
+		t.Errorf("expected resync of node1 for its host, found %s", name)
+	}
+	if cloud.listings != 1 {
+		t.Errorf("expected the hosts to be listed once, found %d listings", cloud.listings)
+	}
+}
+
+func TestResyncHookNotLeader(t *testing.T) {
+	resync := make(chan string, 1)
+	running := int32(0)
+	h := &resyncHookHandler{
+		token:   "s3cr3t",
+		nodes:   &fakeNodes{names: map[string]bool{"node1": true}},
+		cloud:   &fakeHostnameCloud{},
+		resync:  resync,
+		running: &running,
+	}
+
+	req := httptest.NewRequest("POST", "/hooks/resync", strings.NewReader(`{"node": "node1"}`))
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "not the leader") {
+		t.Errorf("expected the request to be refused, found %d: %s", w.Code, w.Body.String())
+	}
+	if len(resync) != 0 {
+		t.Errorf("expected no resync to be queued, found %d", len(resync))
+	}
+}
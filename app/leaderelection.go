@@ -0,0 +1,97 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/clientset"
+	"k8s.io/kubernetes/pkg/client/leaderelection"
+	"k8s.io/kubernetes/pkg/client/leaderelection/resourcelock"
+)
+
+// Kinds of objects the leader election can lock
+const (
+	resourceLockEndpoints  = "endpoints"
+	resourceLockConfigMaps = "configmaps"
+)
+
+// newResourceLock creates the lock of the leader election, an endpoints or a configmap object
+func newResourceLock(lockType, namespace, name, identity string, client clientset.Interface, recorder record.EventRecorder) (resourcelock.Interface, error) {
+	meta := metav1.ObjectMeta{Namespace: namespace, Name: name}
+	config := resourcelock.ResourceLockConfig{Identity: identity, EventRecorder: recorder}
+	switch lockType {
+	case resourceLockEndpoints:
+		return &resourcelock.EndpointsLock{EndpointsMeta: meta, Client: client, LockConfig: config}, nil
+	case resourceLockConfigMaps:
+		return &resourcelock.ConfigMapLock{ConfigMapMeta: meta, Client: client, LockConfig: config}, nil
+	}
+	return nil, fmt.Errorf("unknown resource lock %q, must be %s or %s", lockType, resourceLockEndpoints, resourceLockConfigMaps)
+}
+
+// untilEither returns a channel closed once a or b is closed
+func untilEither(a, b <-chan struct{}) <-chan struct{} {
+	both := make(chan struct{})
+	go func() {
+		defer close(both)
+		select {
+		case <-a:
+		case <-b:
+		}
+	}()
+	return both
+}
+
+// leaderTransitions tracks the leadership of this instance for the leader election metrics
+type leaderTransitions struct {
+	identity string
+
+	lock           sync.Mutex
+	lastTransition time.Time
+}
+
+func newLeaderTransitions(identity string, now time.Time) *leaderTransitions {
+	isLeader.Set(0)
+	return &leaderTransitions{identity: identity, lastTransition: now}
+}
+
+// callbacks wraps the leader election callbacks with metrics and logs of the transitions
+func (l *leaderTransitions) callbacks(callbacks leaderelection.LeaderCallbacks) leaderelection.LeaderCallbacks {
+	return leaderelection.LeaderCallbacks{
+		OnStartedLeading: func(stop <-chan struct{}) {
+			l.transition(true, time.Now())
+			callbacks.OnStartedLeading(stop)
+		},
+		OnStoppedLeading: func() {
+			l.transition(false, time.Now())
+			callbacks.OnStoppedLeading()
+		},
+	}
+}
+
+func (l *leaderTransitions) transition(leading bool, now time.Time) {
+	l.lock.Lock()
+	l.lastTransition = now
+	l.lock.Unlock()
+
+	leaderTransitionsTotal.Inc()
+	if leading {
+		glog.V(0).Infof("Acquired leadership of lock with identity %s", l.identity)
+		isLeader.Set(1)
+	} else {
+		glog.V(0).Infof("Lost leadership of lock with identity %s", l.identity)
+		isLeader.Set(0)
+	}
+}
+
+// sinceLastTransition returns the seconds since this instance last acquired or lost leadership,
+// or since it started
+func (l *leaderTransitions) sinceLastTransition(now time.Time) float64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return now.Sub(l.lastTransition).Seconds()
+}
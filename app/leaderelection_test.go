@@ -0,0 +1,99 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/kubernetes/pkg/client/leaderelection"
+)
+
+func TestLeaderTransitions(t *testing.T) {
+	start := time.Now()
+	l := newLeaderTransitions("host1-external-cloud-controller", start)
+
+	value := func() (leader, transitions float64) {
+		m := &dto.Metric{}
+		if err := isLeader.Write(m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		leader = m.GetGauge().GetValue()
+		m = &dto.Metric{}
+		if err := leaderTransitionsTotal.Write(m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return leader, m.GetCounter().GetValue()
+	}
+	_, before := value()
+
+	started, stopped := false, false
+	callbacks := l.callbacks(leaderelection.LeaderCallbacks{
+		OnStartedLeading: func(stop <-chan struct{}) { started = true },
+		OnStoppedLeading: func() { stopped = true },
+	})
+
+	callbacks.OnStartedLeading(nil)
+	if leader, transitions := value(); !started || leader != 1 || transitions != before+1 {
+		t.Errorf("expected leader after acquiring leadership, found leader %v, %v transitions", leader, transitions-before)
+	}
+	if age := l.sinceLastTransition(time.Now().Add(time.Minute)); age < 59 || age > 61 {
+		t.Errorf("expected a minute since the transition, found %vs", age)
+	}
+
+	callbacks.OnStoppedLeading()
+	if leader, transitions := value(); !stopped || leader != 0 || transitions != before+2 {
+		t.Errorf("expected no leader after losing leadership, found leader %v, %v transitions", leader, transitions-before)
+	}
+}
+
+func TestNewResourceLock(t *testing.T) {
+	tests := []struct {
+		lockType string
+		kind     string
+		valid    bool
+	}{
+		{lockType: "endpoints", kind: "*resourcelock.EndpointsLock", valid: true},
+		{lockType: "configmaps", kind: "*resourcelock.ConfigMapLock", valid: true},
+		{lockType: "leases"},
+	}
+
+	for _, test := range tests {
+		lock, err := newResourceLock(test.lockType, "kube-system", "cloud-controller-manager", "host1", nil, nil)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: expected valid to be %v, got error %v", test.lockType, test.valid, err)
+			continue
+		}
+		if !test.valid {
+			continue
+		}
+		if kind := fmt.Sprintf("%T", lock); kind != test.kind {
+			t.Errorf("%s: expected a %s, got a %s", test.lockType, test.kind, kind)
+		}
+		if desc := lock.Describe(); desc != "kube-system/cloud-controller-manager" {
+			t.Errorf("%s: expected lock kube-system/cloud-controller-manager, got %s", test.lockType, desc)
+		}
+		if lock.Identity() != "host1" {
+			t.Errorf("%s: expected identity host1, got %s", test.lockType, lock.Identity())
+		}
+	}
+}
+
+func TestUntilEither(t *testing.T) {
+	stop, leading := make(chan struct{}), make(chan struct{})
+	both := untilEither(stop, leading)
+	select {
+	case <-both:
+		t.Fatalf("expected channel to stay open")
+	default:
+	}
+
+	close(leading)
+	select {
+	case <-both:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Errorf("expected channel to be closed once leadership is lost")
+	}
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "rancher_ccm"
+
+var (
+	serviceSyncWorkers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "service_sync_workers",
+			Help:      "Number of workers of the service controller, 0 if it is not running.",
+		},
+	)
+
+	resyncHookRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "resync_hook_requests_total",
+			Help:      "Number of requests to the node resync hook, partitioned by response code.",
+		},
+		[]string{"code"},
+	)
+
+	isLeader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "leader",
+			Help:      "1 if this instance holds the leader election lock, 0 otherwise.",
+		},
+	)
+
+	leaderTransitionsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "leader_transitions_total",
+			Help:      "Number of times this instance acquired or lost leadership.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(serviceSyncWorkers)
+	prometheus.MustRegister(resyncHookRequests)
+	prometheus.MustRegister(isLeader)
+	prometheus.MustRegister(leaderTransitionsTotal)
+}
+
+// registerLeaderTransitionAge exports the time since the last leadership transition of l
+func registerLeaderTransitionAge(l *leaderTransitions) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "leader_last_transition_age_seconds",
+			Help:      "Seconds since this instance last acquired or lost leadership, or since it started.",
+		},
+		func() float64 { return l.sinceLastTransition(time.Now()) },
+	))
+}
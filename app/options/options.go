@@ -0,0 +1,243 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/kubernetes/pkg/apis/componentconfig"
+	"k8s.io/kubernetes/pkg/client/leaderelection"
+	"k8s.io/kubernetes/pkg/master/ports"
+
+	// add the kubernetes feature gates
+	_ "k8s.io/kubernetes/pkg/features"
+
+	"github.com/spf13/pflag"
+)
+
+// CloudControllerMangerServer is the main context object for the controller manager.
+type CloudControllerManagerServer struct {
+	componentconfig.KubeControllerManagerConfiguration
+
+	Master     string
+	Kubeconfig string
+
+	// EnableNodeDeletion makes the node controller delete the nodes whose host is missing from the
+	// cloud, instead of only reporting them
+	EnableNodeDeletion bool
+	// NodeDeletionMinimumAge is the age below which nodes are never deleted
+	NodeDeletionMinimumAge metav1.Duration
+	// NodeDeletionMissingChecks is how many node monitor periods in a row the host of a node must be
+	// missing from the cloud provider before the node is deleted
+	NodeDeletionMissingChecks int32
+	// DrainNodesBeforeDeletion cordons the nodes to delete and evicts their pods first, giving them
+	// NodeDrainGracePeriod to terminate
+	DrainNodesBeforeDeletion bool
+	NodeDrainGracePeriod     metav1.Duration
+
+	// NodeStatusUpdateFrequency is how often the node controller updates the addresses of all nodes
+	NodeStatusUpdateFrequency metav1.Duration
+	// NodeStatusUpdateRetry and NodeStatusRetrySleepTime are no longer used, nodes without a ready
+	// condition are skipped until a later node monitor pass
+	NodeStatusUpdateRetry    int32
+	NodeStatusRetrySleepTime metav1.Duration
+
+	// ReplaceNodeAddresses makes the node controller overwrite all node addresses with the ones
+	// reported by the cloud, instead of only the address types the cloud reports
+	ReplaceNodeAddresses bool
+	// ProvidedNodeIPKey is the annotation and label key the node controller reads the IPs provided
+	// for nodes from, the standard keys if empty
+	ProvidedNodeIPKey string
+	// WaitForNodeAddresses makes the node controller keep the cloud taint on new nodes until
+	// the cloud reports an IP address for them
+	WaitForNodeAddresses bool
+	// InitializeUntaintedNodes makes the node controller initialize nodes registered without the
+	// cloud taint, such as the nodes of old kubelets, once
+	InitializeUntaintedNodes bool
+	// SkipCordonedNodeSync makes the node controller stop updating the addresses and labels of
+	// unschedulable nodes
+	SkipCordonedNodeSync bool
+	// TopologyLabels is the policy of the node controller for the beta and GA zone and region
+	// labels: beta, ga or both
+	TopologyLabels string
+	// NodeMetadataLabels are the instance metadata fields the node controller labels new nodes with
+	NodeMetadataLabels []string
+	// NodeHostLabels are the patterns of the host labels the node controller syncs onto nodes
+	NodeHostLabels []string
+	// NodeHostLabelPrefix is prepended to the keys of the host labels synced onto nodes
+	NodeHostLabelPrefix string
+	// ManageNodesCreatedAfter makes the node controller leave alone the nodes created before it,
+	// an RFC3339 time or "startup". All nodes are managed if empty.
+	ManageNodesCreatedAfter string
+	// CloudCallTimeout is how long each call of the node controller to the cloud provider may take,
+	// 0 for no limit
+	CloudCallTimeout metav1.Duration
+	// LoopJitterFactor is the jitter factor of the periods of the node controller loops
+	LoopJitterFactor float64
+	// ConcurrentNodeSyncs is the number of workers updating the addresses of nodes, so that a node
+	// whose lookup in the cloud provider is slow doesn't hold the updates of the others up
+	ConcurrentNodeSyncs int32
+
+	// FailOnMissingPermissions makes startup fail instead of warn when the enabled controllers
+	// are missing permissions
+	FailOnMissingPermissions bool
+
+	// ResyncHookToken enables POST /hooks/resync for requests carrying it as a bearer token
+	ResyncHookToken string
+
+	// CloudCredentialsSecret is the namespace/name of a Secret holding the cloud provider
+	// credentials, which are reloaded when it changes
+	CloudCredentialsSecret string
+
+	// LeaderElectionResourceLock is the kind of object locked by the leader election, endpoints or
+	// configmaps, named LeaderElectionLockName in LeaderElectionNamespace
+	LeaderElectionResourceLock string
+	LeaderElectionNamespace    string
+	LeaderElectionLockName     string
+
+	// HealthzBindAddress is the host:port /healthz and /readyz are also served on, besides the
+	// address and port of the http service
+	HealthzBindAddress string
+	// CloudCallHealthWindow is how long the calls to the cloud provider may fail before /healthz
+	// fails, 0 disables the check
+	CloudCallHealthWindow metav1.Duration
+
+	// DebugAddress is the host:port the pprof handlers are served on with --profiling, from the
+	// mux of the http service if it's its address and port
+	DebugAddress string
+
+	// LoadBalancerGCPeriod is how often the load balancers created for services that no longer
+	// exist are deleted, 0 disables it
+	LoadBalancerGCPeriod metav1.Duration
+}
+
+// NewCloudControllerManagerServer creates a new ExternalCMServer with a default config.
+func NewCloudControllerManagerServer() *CloudControllerManagerServer {
+	s := CloudControllerManagerServer{
+		KubeControllerManagerConfiguration: componentconfig.KubeControllerManagerConfiguration{
+			Port:                    ports.CloudControllerManagerPort,
+			Address:                 "0.0.0.0",
+			ConcurrentServiceSyncs:  1,
+			MinResyncPeriod:         metav1.Duration{Duration: 12 * time.Hour},
+			NodeMonitorPeriod:       metav1.Duration{Duration: 5 * time.Second},
+			NodeMonitorGracePeriod:  metav1.Duration{Duration: 40 * time.Second},
+			ClusterName:             "kubernetes",
+			ConfigureCloudRoutes:    true,
+			ContentType:             "application/vnd.kubernetes.protobuf",
+			KubeAPIQPS:              20.0,
+			KubeAPIBurst:            30,
+			LeaderElection:          leaderelection.DefaultLeaderElectionConfiguration(),
+			ControllerStartInterval: metav1.Duration{Duration: 0 * time.Second},
+			Controllers:             []string{"*"},
+		},
+	}
+	s.LeaderElection.LeaderElect = true
+	s.EnableNodeDeletion = true
+	s.NodeDeletionMinimumAge = metav1.Duration{Duration: 5 * time.Minute}
+	s.NodeDeletionMissingChecks = 3
+	s.NodeDrainGracePeriod = metav1.Duration{Duration: 30 * time.Second}
+	s.NodeStatusUpdateFrequency = metav1.Duration{Duration: 10 * time.Second}
+	s.NodeStatusUpdateRetry = 5
+	s.NodeStatusRetrySleepTime = metav1.Duration{Duration: 20 * time.Millisecond}
+	s.WaitForNodeAddresses = true
+	s.FailOnMissingPermissions = true
+	s.TopologyLabels = "both"
+	s.NodeMetadataLabels = []string{"os", "arch"}
+	s.CloudCallTimeout = metav1.Duration{Duration: 10 * time.Second}
+	s.LoopJitterFactor = 0.1
+	s.ConcurrentNodeSyncs = 5
+	s.LeaderElectionResourceLock = "endpoints"
+	s.LeaderElectionNamespace = "kube-system"
+	s.LeaderElectionLockName = "cloud-controller-manager"
+	s.CloudCallHealthWindow = metav1.Duration{Duration: 5 * time.Minute}
+	s.LoadBalancerGCPeriod = metav1.Duration{Duration: 10 * time.Minute}
+	s.DebugAddress = "127.0.0.1:6060"
+	return &s
+}
+
+// AddFlags adds flags for a specific ExternalCMServer to the specified FlagSet
+func (s *CloudControllerManagerServer) AddFlags(fs *pflag.FlagSet, allControllers []string) {
+	fs.StringSliceVar(&s.Controllers, "controllers", s.Controllers, fmt.Sprintf(""+
+		"A list of controllers to enable.  '*' enables all controllers, 'foo' enables the controller "+
+		"named 'foo', '-foo' disables the controller named 'foo'.\nAll controllers: %s",
+		strings.Join(allControllers, ", ")))
+	fs.Int32Var(&s.Port, "port", s.Port, "The port that the cloud-controller-manager's http service runs on")
+	fs.Var(componentconfig.IPVar{Val: &s.Address}, "address", "The IP address to serve on (set to 0.0.0.0 for all interfaces)")
+	fs.StringVar(&s.CloudProvider, "cloud-provider", s.CloudProvider, "The provider of cloud services. Empty for no provider.")
+	fs.StringVar(&s.CloudConfigFile, "cloud-config", s.CloudConfigFile, "The path to the cloud provider configuration file.  Empty string for no configuration file.")
+	fs.Int32Var(&s.ConcurrentServiceSyncs, "concurrent-service-syncs", s.ConcurrentServiceSyncs, "The number of services that are allowed to sync concurrently. Larger number = more responsive service management, but more CPU (and network) load")
+	fs.DurationVar(&s.MinResyncPeriod.Duration, "min-resync-period", s.MinResyncPeriod.Duration, "The resync period in reflectors will be random between MinResyncPeriod and 2*MinResyncPeriod")
+	fs.DurationVar(&s.NodeMonitorPeriod.Duration, "node-monitor-period", s.NodeMonitorPeriod.Duration,
+		"The period for syncing NodeStatus in NodeController.")
+	fs.DurationVar(&s.NodeMonitorGracePeriod.Duration, "node-monitor-grace-period", s.NodeMonitorGracePeriod.Duration,
+		"The grace period of the node lifecycle controller of the kube-controller-manager for nodes that stopped reporting. --node-monitor-period must be lower.")
+	fs.DurationVar(&s.NodeStatusUpdateFrequency.Duration, "node-status-update-frequency", s.NodeStatusUpdateFrequency.Duration, "How often the addresses and labels of all nodes are updated from the cloud provider.")
+	fs.Int32Var(&s.NodeStatusUpdateRetry, "node-status-update-retry", s.NodeStatusUpdateRetry, "Unused, nodes that have no ready condition are skipped until the next node monitor pass.")
+	fs.MarkDeprecated("node-status-update-retry", "nodes that have no ready condition are skipped until the next node monitor pass")
+	fs.DurationVar(&s.NodeStatusRetrySleepTime.Duration, "node-status-retry-sleep-time", s.NodeStatusRetrySleepTime.Duration, "Unused, nodes that have no ready condition are skipped until the next node monitor pass.")
+	fs.MarkDeprecated("node-status-retry-sleep-time", "nodes that have no ready condition are skipped until the next node monitor pass")
+	fs.StringVar(&s.ServiceAccountKeyFile, "service-account-private-key-file", s.ServiceAccountKeyFile, "Filename containing a PEM-encoded private RSA or ECDSA key used to sign service account tokens.")
+	fs.BoolVar(&s.UseServiceAccountCredentials, "use-service-account-credentials", s.UseServiceAccountCredentials, "If true, use individual service account credentials for each controller.")
+	fs.DurationVar(&s.RouteReconciliationPeriod.Duration, "route-reconciliation-period", s.RouteReconciliationPeriod.Duration, "The period for reconciling routes created for Nodes by cloud provider.")
+	fs.BoolVar(&s.ConfigureCloudRoutes, "configure-cloud-routes", true, "Should CIDRs allocated by allocate-node-cidrs be configured on the cloud provider.")
+	fs.BoolVar(&s.EnableProfiling, "profiling", false, "Enable profiling via web interface --debug-address/debug/pprof/")
+	fs.StringVar(&s.DebugAddress, "debug-address", s.DebugAddress, "The host:port the pprof handlers of --profiling are served on. Set to the --address and --port of the http service to serve them with the metrics, the host must be 0.0.0.0 or :: to serve them on all interfaces.")
+	fs.BoolVar(&s.EnableContentionProfiling, "contention-profiling", false, "Enable lock contention profiling, if profiling is enabled")
+	fs.StringVar(&s.ClusterCIDR, "cluster-cidr", s.ClusterCIDR, "CIDR Range for Pods in cluster.")
+	fs.BoolVar(&s.AllocateNodeCIDRs, "allocate-node-cidrs", false, "Should CIDRs for Pods be allocated and set on the cloud provider.")
+	fs.StringVar(&s.Master, "master", s.Master, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	fs.StringVar(&s.Kubeconfig, "kubeconfig", s.Kubeconfig, "Path to kubeconfig file with authorization and master location information.")
+	fs.StringVar(&s.ContentType, "kube-api-content-type", s.ContentType, "Content type of requests sent to apiserver.")
+	fs.Float32Var(&s.KubeAPIQPS, "kube-api-qps", s.KubeAPIQPS, "QPS to use while talking with kubernetes apiserver")
+	fs.Int32Var(&s.KubeAPIBurst, "kube-api-burst", s.KubeAPIBurst, "Burst to use while talking with kubernetes apiserver")
+	fs.DurationVar(&s.ControllerStartInterval.Duration, "controller-start-interval", s.ControllerStartInterval.Duration, "Interval between starting controller managers.")
+	fs.BoolVar(&s.EnableNodeDeletion, "enable-node-deletion", s.EnableNodeDeletion, "Delete the nodes that are not ready and whose host is missing from the cloud provider. If false, they are only reported by logs and warning events.")
+	fs.DurationVar(&s.NodeDeletionMinimumAge.Duration, "node-deletion-minimum-age", s.NodeDeletionMinimumAge.Duration, "Nodes created less than this long ago are never deleted, even if they are not ready and missing from the cloud provider.")
+	fs.Int32Var(&s.NodeDeletionMissingChecks, "node-deletion-missing-checks", s.NodeDeletionMissingChecks, "How many node monitor periods in a row the host of a node that is not ready must be missing from the cloud provider before the node is deleted. A warning event is recorded on the node the first time.")
+	fs.BoolVar(&s.DrainNodesBeforeDeletion, "drain-nodes-before-deletion", s.DrainNodesBeforeDeletion, "If true, cordon the nodes whose host is gone from the cloud provider and evict their pods before deleting them, so their controllers replace them right away. Requires permission to list pods and create pods/eviction.")
+	fs.DurationVar(&s.NodeDrainGracePeriod.Duration, "node-drain-grace-period", s.NodeDrainGracePeriod.Duration, "The grace period of the pods evicted with --drain-nodes-before-deletion.")
+	fs.BoolVar(&s.ReplaceNodeAddresses, "replace-node-addresses", s.ReplaceNodeAddresses, "If true, replace all node addresses with the addresses reported by the cloud provider, dropping addresses set by other components.")
+	fs.StringVar(&s.ProvidedNodeIPKey, "provided-node-ip-key", s.ProvidedNodeIPKey, "Annotation and label key to read the IPs provided for nodes from, instead of the alpha.kubernetes.io/provided-node-ip annotation and the beta.kubernetes.io/provided-node-ip label.")
+	fs.BoolVar(&s.WaitForNodeAddresses, "wait-for-node-addresses", s.WaitForNodeAddresses, "If true, keep the cloud taint on new nodes until the cloud provider reports an IP address for them.")
+	fs.BoolVar(&s.FailOnMissingPermissions, "fail-on-missing-permissions", s.FailOnMissingPermissions, "If true, exit at startup when the enabled controllers are missing RBAC permissions, otherwise only warn.")
+	fs.StringVar(&s.ResyncHookToken, "resync-hook-token", s.ResyncHookToken, "Bearer token of requests to POST /hooks/resync, which updates the addresses and labels of a node right away. Only the leader running the cloud-node controller accepts them. The endpoint is disabled if empty.")
+	fs.StringVar(&s.CloudCredentialsSecret, "cloud-credentials-secret", s.CloudCredentialsSecret, "The namespace/name of a Secret holding the Rancher API credentials, cattle-access-key and cattle-secret-key or token. They take precedence over the credentials of the cloud config file, which take precedence over those of the environment, and are reloaded when the Secret changes. New credentials rejected by the Rancher API are reported with a Warning event on the Secret and not applied.")
+	fs.BoolVar(&s.SkipCordonedNodeSync, "skip-cordoned-node-sync", s.SkipCordonedNodeSync, "If true, don't update the addresses and labels of unschedulable nodes. They are still deleted once they are gone from the cloud provider.")
+	fs.BoolVar(&s.InitializeUntaintedNodes, "initialize-untainted-nodes", s.InitializeUntaintedNodes, "If true, also initialize nodes registered without the cloud taint, once. Their taint-less registration means kubelet did not ask for external initialization, so only enable this when migrating existing nodes to the external cloud provider.")
+	fs.StringVar(&s.TopologyLabels, "topology-labels", s.TopologyLabels, "Which zone and region node labels to write: 'beta' for the deprecated failure-domain.beta.kubernetes.io labels, 'ga' for the topology.kubernetes.io labels, removing the beta ones, or 'both'.")
+	fs.StringSliceVar(&s.NodeMetadataLabels, "node-metadata-labels", s.NodeMetadataLabels, "Instance metadata fields new nodes are labelled with, once, if the cloud provider reports them: 'os' and 'arch' as kubernetes.io/os and kubernetes.io/arch, other fields as rancher.io/<field>.")
+	fs.StringSliceVar(&s.NodeHostLabels, "node-host-labels", s.NodeHostLabels, "Patterns of the host labels synced onto nodes, e.g. 'storage,gpu' or 'io.rancher.label/*'. Labels removed from the host are removed from the node, labels in the kubernetes.io and k8s.io namespaces are never synced. None are synced if empty.")
+	fs.StringVar(&s.NodeHostLabelPrefix, "node-host-label-prefix", s.NodeHostLabelPrefix, "Prefix of the keys of the host labels synced onto nodes, e.g. 'host.rancher.io/'.")
+	fs.StringVar(&s.ManageNodesCreatedAfter, "manage-nodes-created-after", s.ManageNodesCreatedAfter, "If set, only initialize, update and delete the nodes created after this RFC3339 time, or after the controller manager started if 'startup'. Older nodes are left alone.")
+	fs.DurationVar(&s.CloudCallTimeout.Duration, "cloud-call-timeout", s.CloudCallTimeout.Duration, "How long each call of the node controller to the cloud provider, and each request of the Rancher provider to the Rancher API, may take before it fails. 0 for no limit.")
+	fs.Float64Var(&s.LoopJitterFactor, "loop-jitter-factor", s.LoopJitterFactor, "Each period of the node status and node monitor loops is extended by a random delay of up to this fraction of the period, and their first run is delayed by up to as much, so the requests of controllers sharing a Rancher server are spread out instead of sent in synchronized bursts. 0 disables the jitter.")
+	fs.Int32Var(&s.ConcurrentNodeSyncs, "concurrent-node-syncs", s.ConcurrentNodeSyncs, "The number of nodes whose addresses are allowed to be updated concurrently. A node whose lookup in the cloud provider is slow doesn't hold the updates of the others up.")
+	fs.StringVar(&s.HealthzBindAddress, "healthz-bind-address", s.HealthzBindAddress, "The host:port to also serve /healthz and /readyz on, e.g. for probes on a port without the other endpoints. They are always served on --address and --port.")
+	fs.DurationVar(&s.CloudCallHealthWindow.Duration, "cloud-call-health-window", s.CloudCallHealthWindow.Duration, "How long the calls to the cloud provider may keep failing before /healthz fails, e.g. after the Rancher API credentials expired. 0 disables the check.")
+	fs.DurationVar(&s.LoadBalancerGCPeriod.Duration, "load-balancer-gc-period", s.LoadBalancerGCPeriod.Duration, "How often the load balancers created for services that no longer exist, e.g. deleted while the controller manager was down, are deleted. Load balancers the controller manager didn't create are never deleted. 0 disables it.")
+
+	leaderelection.BindFlags(&s.LeaderElection, fs)
+	fs.StringVar(&s.LeaderElectionResourceLock, "leader-elect-resource-lock", s.LeaderElectionResourceLock, "The kind of object locked by the leader election: 'endpoints' or 'configmaps'.")
+	fs.StringVar(&s.LeaderElectionNamespace, "leader-elect-namespace", s.LeaderElectionNamespace, "The namespace of the object locked by the leader election.")
+	fs.StringVar(&s.LeaderElectionLockName, "leader-elect-lock-name", s.LeaderElectionLockName, "The name of the object locked by the leader election. Replicas locking the same object run the controllers one at a time.")
+
+	utilfeature.DefaultFeatureGate.AddFlag(fs)
+}
@@ -0,0 +1,232 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+	authorizationv1 "k8s.io/kubernetes/pkg/apis/authorization/v1"
+	authorizationclient "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/authorization/v1"
+)
+
+// permission is an API request a controller needs to be allowed to make, in the core API
+// group unless group is set
+type permission struct {
+	verb        string
+	group       string
+	resource    string
+	subresource string
+}
+
+func (p permission) String() string {
+	if p.subresource != "" {
+		return p.verb + " " + p.resource + "/" + p.subresource
+	}
+	return p.verb + " " + p.resource
+}
+
+// controllerPermissions lists the permissions each controller needs, in the core API group
+var controllerPermissions = map[string][]permission{
+	"cloud-node": {
+		{verb: "get", resource: "nodes"},
+		{verb: "list", resource: "nodes"},
+		{verb: "watch", resource: "nodes"},
+		{verb: "patch", resource: "nodes"},
+		{verb: "delete", resource: "nodes"},
+		{verb: "patch", resource: "nodes", subresource: "status"},
+		{verb: "create", resource: "events"},
+	},
+	"service": {
+		{verb: "get", resource: "services"},
+		{verb: "list", resource: "services"},
+		{verb: "watch", resource: "services"},
+		{verb: "update", resource: "services"},
+		{verb: "update", resource: "services", subresource: "status"},
+		{verb: "get", resource: "endpoints"},
+		{verb: "list", resource: "endpoints"},
+		{verb: "update", resource: "endpoints"},
+		{verb: "list", resource: "nodes"},
+		{verb: "watch", resource: "nodes"},
+		{verb: "get", resource: "secrets"},
+		{verb: "create", resource: "events"},
+	},
+	"route": {
+		{verb: "list", resource: "nodes"},
+		{verb: "watch", resource: "nodes"},
+		{verb: "patch", resource: "nodes", subresource: "status"},
+		{verb: "create", resource: "events"},
+	},
+}
+
+// nodeDrainPermissions are the permissions the cloud node controller also needs to drain the nodes
+// it deletes
+var nodeDrainPermissions = []permission{
+	{verb: "list", resource: "pods"},
+	{verb: "create", resource: "pods", subresource: "eviction"},
+}
+
+// permissionsOf returns the permissions the controller with the given name needs
+func permissionsOf(name string, drainNodes bool) []permission {
+	perms := append([]permission{}, controllerPermissions[name]...)
+	if name == "cloud-node" && drainNodes {
+		perms = append(perms, nodeDrainPermissions...)
+	}
+	return perms
+}
+
+// Namespace of the service accounts of the controllers with --use-service-account-credentials
+const controllerServiceAccountNamespace = "kube-system"
+
+// controllerServiceAccounts names the service account of each controller
+var controllerServiceAccounts = map[string]string{
+	"cloud-node": "cloud-node-controller",
+	"service":    "service-controller",
+	"route":      "route-controller",
+}
+
+// serviceAccountPermissions lists the permissions the controller manager needs to get the
+// credentials of the controllers' service accounts, and to run the shared informers
+var serviceAccountPermissions = []permission{
+	{verb: "get", resource: "serviceaccounts"},
+	{verb: "create", resource: "serviceaccounts"},
+	{verb: "list", resource: "secrets"},
+	{verb: "watch", resource: "secrets"},
+	{verb: "delete", resource: "secrets"},
+	{verb: "create", group: "authentication.k8s.io", resource: "tokenreviews"},
+	{verb: "list", resource: "nodes"},
+	{verb: "watch", resource: "nodes"},
+	{verb: "list", resource: "services"},
+	{verb: "watch", resource: "services"},
+}
+
+// requiredPermissions returns the permissions the controllers enabled by controllers need
+func requiredPermissions(controllers []string, drainNodes bool) []permission {
+	seen := map[permission]bool{}
+	perms := []permission{}
+	for _, name := range KnownControllers() {
+		if !IsControllerEnabled(name, controllers) {
+			continue
+		}
+		for _, p := range permissionsOf(name, drainNodes) {
+			if !seen[p] {
+				seen[p] = true
+				perms = append(perms, p)
+			}
+		}
+	}
+	sort.Sort(byPermission(perms))
+	return perms
+}
+
+type byPermission []permission
+
+func (p byPermission) Len() int           { return len(p) }
+func (p byPermission) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byPermission) Less(i, j int) bool { return p[i].String() < p[j].String() }
+
+// identityPermissions are the permissions an identity needs, and how to review its access
+type identityPermissions struct {
+	identity    string
+	review      accessReview
+	permissions []permission
+}
+
+// accessReview tells whether an identity is allowed the request described by attributes
+type accessReview func(attributes *authorizationv1.ResourceAttributes) (bool, error)
+
+func selfAccessReview(client authorizationclient.SelfSubjectAccessReviewInterface) accessReview {
+	return func(attributes *authorizationv1.ResourceAttributes) (bool, error) {
+		review, err := client.Create(&authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: attributes},
+		})
+		if err != nil {
+			return false, err
+		}
+		return review.Status.Allowed, nil
+	}
+}
+
+func serviceAccountAccessReview(client authorizationclient.SubjectAccessReviewInterface, name string) accessReview {
+	return func(attributes *authorizationv1.ResourceAttributes) (bool, error) {
+		review, err := client.Create(&authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				ResourceAttributes: attributes,
+				User:               serviceaccount.MakeUsername(controllerServiceAccountNamespace, name),
+				Groups:             serviceaccount.MakeGroupNames(controllerServiceAccountNamespace, name),
+			},
+		})
+		if err != nil {
+			return false, err
+		}
+		return review.Status.Allowed, nil
+	}
+}
+
+// checkPermissions asks the apiserver whether the controller manager has the permissions the
+// enabled controllers need and logs a report. With useServiceAccounts the permissions of each
+// controller are checked for its service account instead. It returns an error listing the
+// missing ones.
+func checkPermissions(client authorizationclient.AuthorizationV1Interface, controllers []string, useServiceAccounts, drainNodes bool) error {
+	self := selfAccessReview(client.SelfSubjectAccessReviews())
+	identities := []identityPermissions{}
+	if !useServiceAccounts {
+		identities = append(identities, identityPermissions{
+			identity:    "the controller manager",
+			review:      self,
+			permissions: requiredPermissions(controllers, drainNodes),
+		})
+	} else {
+		perms := append([]permission{}, serviceAccountPermissions...)
+		sort.Sort(byPermission(perms))
+		identities = append(identities, identityPermissions{identity: "the controller manager", review: self, permissions: perms})
+		for _, name := range KnownControllers() {
+			if !IsControllerEnabled(name, controllers) {
+				continue
+			}
+			account := controllerServiceAccounts[name]
+			perms := permissionsOf(name, drainNodes)
+			sort.Sort(byPermission(perms))
+			identities = append(identities, identityPermissions{
+				identity:    serviceaccount.MakeUsername(controllerServiceAccountNamespace, account),
+				review:      serviceAccountAccessReview(client.SubjectAccessReviews(), account),
+				permissions: perms,
+			})
+		}
+	}
+
+	missing := []string{}
+	for _, id := range identities {
+		report := []string{}
+		for _, p := range id.permissions {
+			allowed, err := id.review(&authorizationv1.ResourceAttributes{
+				Verb:        p.verb,
+				Group:       p.group,
+				Resource:    p.resource,
+				Subresource: p.subresource,
+			})
+			switch {
+			case err != nil:
+				// Not being able to check isn't proof the permission is missing
+				report = append(report, fmt.Sprintf("  %-30s unknown: %v", p, err))
+			case allowed:
+				report = append(report, fmt.Sprintf("  %-30s allowed", p))
+			default:
+				report = append(report, fmt.Sprintf("  %-30s DENIED", p))
+				if useServiceAccounts {
+					missing = append(missing, id.identity+": "+p.String())
+				} else {
+					missing = append(missing, p.String())
+				}
+			}
+		}
+		glog.Infof("Permissions of %s:\n%s", id.identity, strings.Join(report, "\n"))
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing permissions required by the enabled controllers: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
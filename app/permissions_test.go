@@ -0,0 +1,110 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/kubernetes/pkg/apis/authorization/v1"
+	authorizationclient "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/authorization/v1"
+)
+
+// fakeAuthorization allows everything but the denied permissions. Permissions denied to a
+// service account are prefixed with its username.
+type fakeAuthorization struct {
+	authorizationclient.AuthorizationV1Interface
+	denied map[string]bool
+}
+
+func (f *fakeAuthorization) SelfSubjectAccessReviews() authorizationclient.SelfSubjectAccessReviewInterface {
+	return &fakeSelfAccessReviews{denied: f.denied}
+}
+
+func (f *fakeAuthorization) SubjectAccessReviews() authorizationclient.SubjectAccessReviewInterface {
+	return &fakeAccessReviews{denied: f.denied}
+}
+
+type fakeSelfAccessReviews struct {
+	authorizationclient.SelfSubjectAccessReviewInterface
+	denied map[string]bool
+}
+
+func (f *fakeSelfAccessReviews) Create(review *authorizationv1.SelfSubjectAccessReview) (*authorizationv1.SelfSubjectAccessReview, error) {
+	attrs := review.Spec.ResourceAttributes
+	p := permission{verb: attrs.Verb, resource: attrs.Resource, subresource: attrs.Subresource}
+	review.Status.Allowed = !f.denied[p.String()]
+	return review, nil
+}
+
+type fakeAccessReviews struct {
+	authorizationclient.SubjectAccessReviewInterface
+	denied map[string]bool
+}
+
+func (f *fakeAccessReviews) Create(review *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error) {
+	attrs := review.Spec.ResourceAttributes
+	p := permission{verb: attrs.Verb, resource: attrs.Resource, subresource: attrs.Subresource}
+	review.Status.Allowed = !f.denied[review.Spec.User+": "+p.String()]
+	return review, nil
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	has := func(perms []permission, p string) bool {
+		for _, perm := range perms {
+			if perm.String() == p {
+				return true
+			}
+		}
+		return false
+	}
+
+	perms := requiredPermissions([]string{"*"}, false)
+	if !has(perms, "update services") || !has(perms, "delete nodes") {
+		t.Errorf("expected the permissions of all controllers, found %v", perms)
+	}
+
+	perms = requiredPermissions([]string{"*", "-service"}, false)
+	if has(perms, "update services") || has(perms, "update endpoints") {
+		t.Errorf("expected no service permissions with the service controller disabled, found %v", perms)
+	}
+	if !has(perms, "patch nodes/status") {
+		t.Errorf("expected node permissions, found %v", perms)
+	}
+	if has(perms, "create pods/eviction") {
+		t.Errorf("expected no eviction permission without draining nodes, found %v", perms)
+	}
+
+	perms = requiredPermissions([]string{"*"}, true)
+	if !has(perms, "list pods") || !has(perms, "create pods/eviction") {
+		t.Errorf("expected the permissions to drain nodes, found %v", perms)
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	reviews := &fakeAuthorization{denied: map[string]bool{"update services": true}}
+
+	if err := checkPermissions(reviews, []string{"*", "-service"}, false, false); err != nil {
+		t.Errorf("unexpected error with the service controller disabled: %v", err)
+	}
+	err := checkPermissions(reviews, []string{"*"}, false, false)
+	if err == nil || !strings.Contains(err.Error(), "update services") {
+		t.Errorf("expected missing update services permission, found %v", err)
+	}
+}
+
+func TestCheckServiceAccountPermissions(t *testing.T) {
+	// the service controller's account may not delete nodes, the node controller's account must
+	reviews := &fakeAuthorization{denied: map[string]bool{
+		"system:serviceaccount:kube-system:service-controller: delete nodes": true,
+	}}
+	if err := checkPermissions(reviews, []string{"*"}, true, false); err != nil {
+		t.Errorf("expected only the permissions of each controller to be checked, found %v", err)
+	}
+
+	reviews.denied = map[string]bool{
+		"system:serviceaccount:kube-system:cloud-node-controller: delete nodes": true,
+	}
+	err := checkPermissions(reviews, []string{"*"}, true, false)
+	if err == nil || !strings.Contains(err.Error(), "cloud-node-controller: delete nodes") {
+		t.Errorf("expected missing delete nodes permission of the node controller, found %v", err)
+	}
+}
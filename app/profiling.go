@@ -0,0 +1,56 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	goruntime "runtime"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// installProfiling registers the pprof handlers on mux
+func installProfiling(mux *http.ServeMux, contention bool) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	if contention {
+		goruntime.SetBlockProfileRate(1)
+	}
+}
+
+// validateDebugAddress checks the --debug-address the pprof handlers are served on. Its host is
+// required, so that the handlers are only served on all interfaces if 0.0.0.0 or :: is given.
+func validateDebugAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("--debug-address must be host:port, e.g. 127.0.0.1:6060, found %q", address)
+	}
+	if host == "" {
+		return fmt.Errorf("--debug-address must name its host, e.g. 127.0.0.1:6060, or 0.0.0.0:6060 for all interfaces, found %q", address)
+	}
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil && ip.IsUnspecified() {
+		glog.Warningf("--debug-address is %s: the pprof handlers are served on all interfaces", address)
+	}
+	return nil
+}
+
+// serveDebug serves handler on listener until stop is closed
+func serveDebug(listener net.Listener, handler http.Handler, stop <-chan struct{}) error {
+	stopped := make(chan struct{})
+	go func() {
+		<-stop
+		close(stopped)
+		listener.Close()
+	}()
+	err := http.Serve(listener, handler)
+	select {
+	case <-stopped:
+		return nil
+	default:
+		return err
+	}
+}
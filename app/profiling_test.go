@@ -0,0 +1,76 @@
+package app
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInstallProfiling(t *testing.T) {
+	for _, profiling := range []bool{false, true} {
+		mux := http.NewServeMux()
+		if profiling {
+			installProfiling(mux, false)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/", nil))
+		if expected := map[bool]int{false: http.StatusNotFound, true: http.StatusOK}[profiling]; w.Code != expected {
+			t.Errorf("profiling %v: expected status %d, got %d", profiling, expected, w.Code)
+		}
+	}
+}
+
+func TestValidateDebugAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		valid   bool
+	}{
+		{address: "127.0.0.1:6060", valid: true},
+		{address: "localhost:6060", valid: true},
+		{address: "0.0.0.0:6060", valid: true},
+		{address: "[::]:6060", valid: true},
+		{address: ":6060"},
+		{address: "127.0.0.1"},
+		{address: ""},
+	}
+	for _, test := range tests {
+		if err := validateDebugAddress(test.address); (err == nil) != test.valid {
+			t.Errorf("%q: expected valid %v, got %v", test.address, test.valid, err)
+		}
+	}
+}
+
+func TestServeDebugStops(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("couldn't listen: %v", err)
+	}
+	mux := http.NewServeMux()
+	installProfiling(mux, false)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- serveDebug(listener, mux, stop)
+	}()
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/debug/pprof/")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the pprof index to be served, got %v, %v", resp, err)
+	}
+	resp.Body.Close()
+
+	close(stop)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected the server to stop cleanly, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the server to stop with the stop channel")
+	}
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Errorf("expected the listener to be closed")
+	}
+}
@@ -0,0 +1,76 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// cloudCallTimeoutError is returned by the calls to the cloud provider that took longer than the
+// cloud call timeout
+type cloudCallTimeoutError struct {
+	call    string
+	timeout time.Duration
+}
+
+func (e *cloudCallTimeoutError) Error() string {
+	return fmt.Sprintf("cloud provider call %s timed out after %v", e.call, e.timeout)
+}
+
+// Timeout tells the error is a timeout, like net.Error
+func (e *cloudCallTimeoutError) Timeout() bool {
+	return true
+}
+
+// errCloudCallCanceled is returned by the calls to the cloud provider outstanding when the
+// controller stops
+var errCloudCallCanceled = fmt.Errorf("cloud provider call canceled, the controller is stopping")
+
+// callCloud calls the cloud provider with fn, recording the call. The cloud provider interface
+// doesn't take contexts, so fn is left running in the background if it takes longer than the cloud
+// call timeout or if the controller stops, and the call fails right away.
+func (cnc *CloudNodeController) callCloud(call string, fn func() (interface{}, error)) (interface{}, error) {
+	ctx := cnc.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if cnc.cloudCallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cnc.cloudCallTimeout)
+		defer cancel()
+	}
+
+	type result struct {
+		value interface{}
+		err   error
+	}
+	start := time.Now()
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn()
+		done <- result{value, err}
+	}()
+
+	var r result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			r.err = &cloudCallTimeoutError{call: call, timeout: cnc.cloudCallTimeout}
+			cloudCallTimeouts.WithLabelValues(call).Inc()
+		} else {
+			r.err = errCloudCallCanceled
+		}
+	}
+	observeCloudCall(call, start, r.err)
+	return r.value, r.err
+}
+
+// callCloudString is callCloud for the calls returning a string
+func (cnc *CloudNodeController) callCloudString(call string, fn func() (string, error)) (string, error) {
+	result, err := cnc.callCloud(call, func() (interface{}, error) {
+		return fn()
+	})
+	value, _ := result.(string)
+	return value, err
+}
@@ -0,0 +1,55 @@
+package cloud
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestCallCloudTimesOut(t *testing.T) {
+	cnc := &CloudNodeController{cloudCallTimeout: 10 * time.Millisecond}
+	hung := make(chan struct{})
+	defer close(hung)
+
+	_, err := cnc.callCloudString("ExternalID", func() (string, error) {
+		<-hung
+		return "1h1", nil
+	})
+	if timeout, ok := err.(*cloudCallTimeoutError); !ok || !timeout.Timeout() {
+		t.Errorf("expected a timeout error, found %v", err)
+	}
+
+	id, err := cnc.callCloudString("ExternalID", func() (string, error) {
+		return "1h1", nil
+	})
+	if err != nil || id != "1h1" {
+		t.Errorf("expected a call returning in time to succeed, found %q (%v)", id, err)
+	}
+}
+
+func TestCallCloudCanceledOnStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cnc := &CloudNodeController{ctx: ctx, cancel: cancel}
+	hung := make(chan struct{})
+	defer close(hung)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := cnc.callCloudString("ExternalID", func() (string, error) {
+			<-hung
+			return "1h1", nil
+		})
+		errs <- err
+	}()
+	cancel()
+	select {
+	case err := <-errs:
+		if err != errCloudCallCanceled {
+			t.Errorf("expected the call to be canceled, found %v", err)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Errorf("expected the call to return once the controller stopped")
+	}
+}
@@ -0,0 +1,106 @@
+package cloud
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api/v1"
+)
+
+// nodeDiff describes the changes from oldNode to newNode to the addresses, labels and taints, in
+// a compact form with a stable ordering so it can be grepped for. It returns "no changes" if none
+// of them changed.
+func nodeDiff(oldNode, newNode *v1.Node) string {
+	var parts []string
+	if oldAddrs, newAddrs := addressStrings(oldNode), addressStrings(newNode); oldAddrs != newAddrs {
+		parts = append(parts, fmt.Sprintf("addresses=%s->%s", oldAddrs, newAddrs))
+	}
+	if changes := mapDiff(oldNode.Labels, newNode.Labels); len(changes) > 0 {
+		parts = append(parts, fmt.Sprintf("labels{%s}", strings.Join(changes, " ")))
+	}
+	if changes := setDiff(taintStrings(oldNode), taintStrings(newNode)); len(changes) > 0 {
+		parts = append(parts, fmt.Sprintf("taints{%s}", strings.Join(changes, " ")))
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, " ")
+}
+
+// addressStrings formats the addresses of node in their order, which is significant
+func addressStrings(node *v1.Node) string {
+	addrs := make([]string, 0, len(node.Status.Addresses))
+	for _, addr := range node.Status.Addresses {
+		addrs = append(addrs, fmt.Sprintf("%s:%s", addr.Type, addr.Address))
+	}
+	return "[" + strings.Join(addrs, " ") + "]"
+}
+
+// taintStrings returns the taints of node, whether in its spec or in the taints annotation
+func taintStrings(node *v1.Node) map[string]bool {
+	taints := map[string]bool{}
+	for i := range node.Spec.Taints {
+		taints[node.Spec.Taints[i].ToString()] = true
+	}
+	if annotated, err := v1.GetTaintsFromNodeAnnotations(node.Annotations); err == nil {
+		for i := range annotated {
+			taints[annotated[i].ToString()] = true
+		}
+	}
+	return taints
+}
+
+// mapDiff returns the added (+key=value), removed (-key) and changed (~key=old->new) keys, sorted by key
+func mapDiff(oldMap, newMap map[string]string) []string {
+	keys := map[string]bool{}
+	for key := range oldMap {
+		keys[key] = true
+	}
+	for key := range newMap {
+		keys[key] = true
+	}
+	var changes []string
+	for _, key := range sortedKeys(keys) {
+		oldValue, inOld := oldMap[key]
+		newValue, inNew := newMap[key]
+		switch {
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("+%s=%s", key, newValue))
+		case !inNew:
+			changes = append(changes, fmt.Sprintf("-%s", key))
+		case oldValue != newValue:
+			changes = append(changes, fmt.Sprintf("~%s=%s->%s", key, oldValue, newValue))
+		}
+	}
+	return changes
+}
+
+// setDiff returns the added (+item) and removed (-item) items, sorted
+func setDiff(oldSet, newSet map[string]bool) []string {
+	all := map[string]bool{}
+	for item := range oldSet {
+		all[item] = true
+	}
+	for item := range newSet {
+		all[item] = true
+	}
+	var changes []string
+	for _, item := range sortedKeys(all) {
+		if !oldSet[item] {
+			changes = append(changes, "+"+item)
+		} else if !newSet[item] {
+			changes = append(changes, "-"+item)
+		}
+	}
+	return changes
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
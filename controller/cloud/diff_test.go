@@ -0,0 +1,63 @@
+package cloud
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/api/v1"
+)
+
+func TestNodeDiff(t *testing.T) {
+	oldNode := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node0",
+			Labels: map[string]string{
+				"removed": "a",
+				"changed": "b",
+				"same":    "c",
+			},
+		},
+		Spec: v1.NodeSpec{
+			Taints: []v1.Taint{
+				{Key: CloudTaintKey, Value: "true", Effect: v1.TaintEffectNoSchedule},
+				{Key: "kept", Effect: v1.TaintEffectNoExecute},
+			},
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+			},
+		},
+	}
+	newNode := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node0",
+			Labels: map[string]string{
+				"changed": "d",
+				"same":    "c",
+				"added":   "e",
+			},
+		},
+		Spec: v1.NodeSpec{
+			Taints: []v1.Taint{
+				{Key: "kept", Effect: v1.TaintEffectNoExecute},
+			},
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.2"},
+				{Type: v1.NodeHostName, Address: "node0"},
+			},
+		},
+	}
+
+	expected := "addresses=[InternalIP:10.0.0.1]->[InternalIP:10.0.0.2 Hostname:node0] " +
+		"labels{+added=e ~changed=b->d -removed} " +
+		"taints{-" + CloudTaintKey + "=true:NoSchedule}"
+	if diff := nodeDiff(oldNode, newNode); diff != expected {
+		t.Errorf("expected diff %q, got %q", expected, diff)
+	}
+	if diff := nodeDiff(oldNode, oldNode); diff != "no changes" {
+		t.Errorf("expected no changes, got %q", diff)
+	}
+}
@@ -0,0 +1,70 @@
+package cloud
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/kubernetes/pkg/api/v1"
+	policy "k8s.io/kubernetes/pkg/apis/policy/v1beta1"
+	clientretry "k8s.io/kubernetes/pkg/client/retry"
+)
+
+// mirrorPodAnnotation marks the mirror pods of static pods, which can't be evicted
+const mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+// drainNode cordons the node to delete and evicts its pods, so their controllers replace them
+// right away. The pods can't terminate on a node whose host is gone, the node is deleted without
+// waiting for them. It returns false if the node is gone or registered again.
+func (cnc *CloudNodeController) drainNode(ref *v1.ObjectReference, deletion nodeDeletion) (bool, error) {
+	cnc.recorder.Eventf(ref, v1.EventTypeNormal, EventCordoningNode, "Cordoning node %s before deleting it", deletion.name)
+	found := true
+	err := clientretry.RetryOnConflict(UpdateNodeSpecBackoff, func() error {
+		node, err := cnc.kubeClient.Core().Nodes().Get(deletion.name, metav1.GetOptions{})
+		if errors.IsNotFound(err) || (err == nil && node.UID != deletion.uid) {
+			found = false
+			return nil
+		}
+		if err != nil || node.Spec.Unschedulable {
+			return err
+		}
+		newNode := *node
+		newNode.Spec.Unschedulable = true
+		return patchNode(cnc.kubeClient, node, &newNode)
+	})
+	if err != nil || !found {
+		return false, err
+	}
+
+	pods, err := cnc.kubeClient.Core().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", deletion.name).String(),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to list the pods of node %s: %v", deletion.name, err)
+	}
+	cnc.recorder.Eventf(ref, v1.EventTypeNormal, EventDrainingNode, "Evicting the %d pods of node %s before deleting it", len(pods.Items), deletion.name)
+
+	gracePeriod := int64(cnc.drainGracePeriod.Seconds())
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if _, ok := pod.Annotations[mirrorPodAnnotation]; ok || pod.DeletionTimestamp != nil {
+			continue
+		}
+		err := cnc.kubeClient.Core().Pods(pod.Namespace).Evict(&policy.Eviction{
+			ObjectMeta:    metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+			DeleteOptions: &metav1.DeleteOptions{GracePeriodSeconds: &gracePeriod},
+		})
+		switch {
+		case errors.IsNotFound(err):
+		case err != nil:
+			// Including when a disruption budget doesn't allow it yet, the deletion is retried
+			return false, fmt.Errorf("failed to evict pod %s/%s of node %s: %v", pod.Namespace, pod.Name, deletion.name, err)
+		default:
+			glog.V(2).Infof("Evicted pod %s/%s of node %s", pod.Namespace, pod.Name, deletion.name)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,143 @@
+package cloud
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/kubernetes/pkg/api/v1"
+	policy "k8s.io/kubernetes/pkg/apis/policy/v1beta1"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
+)
+
+// fakePods lists items and records the evictions. Evicting the pods in blocked fails like a
+// disruption budget doesn't allow it.
+type fakePods struct {
+	corev1.PodInterface
+	items     []v1.Pod
+	blocked   map[string]bool
+	lists     []metav1.ListOptions
+	evictions []*policy.Eviction
+}
+
+func (f *fakePods) List(options metav1.ListOptions) (*v1.PodList, error) {
+	f.lists = append(f.lists, options)
+	return &v1.PodList{Items: f.items}, nil
+}
+
+func (f *fakePods) Evict(eviction *policy.Eviction) error {
+	f.evictions = append(f.evictions, eviction)
+	if f.blocked[eviction.Name] {
+		return errors.NewGenericServerResponse(http.StatusTooManyRequests, "create", schema.GroupResource{Resource: "pods"}, eviction.Name, "", 0, false)
+	}
+	return nil
+}
+
+func drainTestController(pods *fakePods, drain bool) (*CloudNodeController, *fakeNodes, *record.FakeRecorder) {
+	node := notReadyNode("1")
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}, deleted: make(chan string, 1)}
+	recorder := record.NewFakeRecorder(10)
+	cnc := &CloudNodeController{
+		kubeClient:         &fakeClientset{nodes: nodes, pods: pods},
+		nodeInformer:       &fakeNodeInformer{nodes: nodes},
+		cloud:              &fakeCloud{},
+		recorder:           recorder,
+		missing:            map[string]int{},
+		enableNodeDeletion: true,
+		deletionQueue:      workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		drainNodes:         drain,
+		drainGracePeriod:   10 * time.Second,
+	}
+	return cnc, nodes, recorder
+}
+
+// eventReasons returns the reasons of the recorded events, in order
+func eventReasons(recorder *record.FakeRecorder) []string {
+	reasons := []string{}
+	for len(recorder.Events) > 0 {
+		reasons = append(reasons, strings.Fields(<-recorder.Events)[1])
+	}
+	return reasons
+}
+
+func TestDeleteNodeDrainsIt(t *testing.T) {
+	now := metav1.Now()
+	pods := &fakePods{items: []v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "static", Namespace: "kube-system", Annotations: map[string]string{mirrorPodAnnotation: "1"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "web-2", Namespace: "default", DeletionTimestamp: &now}},
+	}}
+	cnc, nodes, recorder := drainTestController(pods, true)
+	defer cnc.deletionQueue.ShutDown()
+
+	cnc.MonitorNode()
+	processDeletions(cnc)
+
+	select {
+	case <-nodes.deleted:
+	default:
+		t.Fatalf("expected the node to be deleted")
+	}
+	if len(nodes.patches) != 1 || !strings.Contains(nodes.patches[0], `"unschedulable":true`) {
+		t.Errorf("expected the node to be cordoned, found patches %v", nodes.patches)
+	}
+	if len(pods.lists) != 1 || pods.lists[0].FieldSelector != "spec.nodeName=node1" {
+		t.Errorf("expected the pods of the node to be listed, found %v", pods.lists)
+	}
+	if len(pods.evictions) != 1 || pods.evictions[0].Name != "web-1" || *pods.evictions[0].DeleteOptions.GracePeriodSeconds != 10 {
+		t.Errorf("expected web-1 to be evicted with a 10s grace period, found %v", pods.evictions)
+	}
+	expected := []string{"CordoningNode", "DrainingNode", "DeletingNode"}
+	if reasons := eventReasons(recorder); !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("expected events %v, found %v", expected, reasons)
+	}
+}
+
+func TestDeleteNodeWaitsForDisruptionBudget(t *testing.T) {
+	pods := &fakePods{
+		items:   []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "default"}}},
+		blocked: map[string]bool{"db-0": true},
+	}
+	cnc, nodes, _ := drainTestController(pods, true)
+	defer cnc.deletionQueue.ShutDown()
+
+	cnc.MonitorNode()
+	cnc.processNextDeletion()
+
+	select {
+	case <-nodes.deleted:
+		t.Fatalf("expected the node not to be deleted before its pods are evicted")
+	default:
+	}
+	if requeues := cnc.deletionQueue.NumRequeues(nodeDeletion{name: "node1", uid: "1"}); requeues != 1 {
+		t.Errorf("expected the deletion to be retried, found %d requeues", requeues)
+	}
+}
+
+func TestDeleteNodeWithoutDrain(t *testing.T) {
+	pods := &fakePods{items: []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"}}}}
+	cnc, nodes, recorder := drainTestController(pods, false)
+	defer cnc.deletionQueue.ShutDown()
+
+	cnc.MonitorNode()
+	processDeletions(cnc)
+
+	select {
+	case <-nodes.deleted:
+	default:
+		t.Fatalf("expected the node to be deleted")
+	}
+	if len(nodes.patches) != 0 || len(pods.lists) != 0 || len(pods.evictions) != 0 {
+		t.Errorf("expected the node to be deleted right away, found patches %v and evictions %v", nodes.patches, pods.evictions)
+	}
+	if reasons := eventReasons(recorder); !reflect.DeepEqual(reasons, []string{"DeletingNode"}) {
+		t.Errorf("expected a single deletion event, found %v", reasons)
+	}
+}
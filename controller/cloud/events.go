@@ -0,0 +1,31 @@
+package cloud
+
+import (
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/api/v1"
+)
+
+// Reasons of the events the node controller records on nodes
+const (
+	EventWaitingForAddresses           = "WaitingForAddresses"
+	EventNodeAddressesChanged          = "NodeAddressesChanged"
+	EventCloudNodeInitialized          = "CloudNodeInitialized"
+	EventCloudNodeInitializationFailed = "CloudNodeInitializationFailed"
+	EventProviderIDNotFound            = "ProviderIDNotFound"
+	EventInvalidProvidedIP             = "InvalidProvidedIP"
+	EventAmbiguousInstance             = "AmbiguousInstance"
+	EventInstanceMissing               = "InstanceMissing"
+	EventCordoningNode                 = "CordoningNode"
+	EventDrainingNode                  = "DrainingNode"
+	EventDeletingNode                  = "DeletingNode"
+)
+
+// nodeRef returns a reference to the node with the given name and UID to record events on
+func nodeRef(name string, uid types.UID) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		Kind:      "Node",
+		Name:      name,
+		UID:       uid,
+		Namespace: "",
+	}
+}
@@ -0,0 +1,81 @@
+package cloud
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apiserver/pkg/server/healthz"
+	"k8s.io/client-go/util/clock"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// cloudCalls tracks the outcome of the calls of the node controller to the cloud provider
+var cloudCalls = newCloudCallTracker(clock.RealClock{})
+
+// cloudCallTracker records when the cloud provider was last called successfully, and whether the
+// calls are failing since
+type cloudCallTracker struct {
+	clock clock.Clock
+
+	lock sync.Mutex
+	// started stands for the last successful call until there is one
+	started     time.Time
+	lastSuccess time.Time
+	failing     bool
+}
+
+func newCloudCallTracker(c clock.Clock) *cloudCallTracker {
+	return &cloudCallTracker{clock: c, started: c.Now()}
+}
+
+// record records the outcome of a call. An instance not found is an answer of the cloud provider,
+// it counts as a success.
+func (t *cloudCallTracker) record(err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if err != nil && err != cloudprovider.InstanceNotFound {
+		t.failing = true
+		return
+	}
+	t.lastSuccess = t.clock.Now()
+	t.failing = false
+}
+
+// healthy returns an error if the calls are failing and none succeeded within window. A
+// controller that makes no calls, e.g. while it isn't the leader, stays healthy.
+func (t *cloudCallTracker) healthy(window time.Duration) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.failing {
+		return nil
+	}
+	since := t.lastSuccess
+	if since.IsZero() {
+		since = t.started
+	}
+	if t.clock.Since(since) > window {
+		if t.lastSuccess.IsZero() {
+			return fmt.Errorf("calls to the cloud provider failed since startup %v ago", t.clock.Since(since))
+		}
+		return fmt.Errorf("calls to the cloud provider failed since the last successful call %v ago", t.clock.Since(since))
+	}
+	return nil
+}
+
+// LastSuccessfulCloudCall returns when the node controller last called the cloud provider
+// successfully, zero if it never did
+func LastSuccessfulCloudCall() time.Time {
+	cloudCalls.lock.Lock()
+	defer cloudCalls.lock.Unlock()
+	return cloudCalls.lastSuccess
+}
+
+// CloudCallsHealthzCheck returns a /healthz check failing while the calls of the node controller
+// to the cloud provider have been failing for longer than window
+func CloudCallsHealthzCheck(window time.Duration) healthz.HealthzChecker {
+	return healthz.NamedCheck("cloud-provider", func(*http.Request) error {
+		return cloudCalls.healthy(window)
+	})
+}
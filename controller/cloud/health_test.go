@@ -0,0 +1,41 @@
+package cloud
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/clock"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+func TestCloudCallTracker(t *testing.T) {
+	c := clock.NewFakeClock(time.Now())
+	tracker := newCloudCallTracker(c)
+	window := 5 * time.Minute
+
+	c.Step(time.Hour)
+	if err := tracker.healthy(window); err != nil {
+		t.Errorf("expected a controller without calls to be healthy, got %v", err)
+	}
+
+	tracker.record(fmt.Errorf("401 Unauthorized"))
+	if err := tracker.healthy(window); err == nil {
+		t.Errorf("expected calls failing since startup an hour ago to be unhealthy")
+	}
+
+	tracker.record(cloudprovider.InstanceNotFound)
+	if err := tracker.healthy(window); err != nil {
+		t.Errorf("expected an instance not found to count as a successful call, got %v", err)
+	}
+
+	tracker.record(fmt.Errorf("401 Unauthorized"))
+	c.Step(time.Minute)
+	if err := tracker.healthy(window); err != nil {
+		t.Errorf("expected calls failing within the window to be healthy, got %v", err)
+	}
+	c.Step(window)
+	if err := tracker.healthy(window); err == nil {
+		t.Errorf("expected calls failing for longer than the window to be unhealthy")
+	}
+}
@@ -0,0 +1,400 @@
+package cloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// AnnotationManagedLabels records the labels the controller set on a node, and the values it set
+// them to, as a JSON object. Only these labels are ever updated or removed by the controller.
+const AnnotationManagedLabels = "rancher.io/managed-labels"
+
+// AnnotationSkipLabelReconciliation set to "true" keeps the labels the controller set on a node from
+// being restored when they're changed or removed, for nodes whose labels are overridden on purpose.
+// The controller stops managing the labels that are changed.
+const AnnotationSkipLabelReconciliation = "rancher.io/skip-label-reconciliation"
+
+// GA topology labels, replacing the deprecated metav1.LabelZoneFailureDomain and metav1.LabelZoneRegion
+const (
+	LabelTopologyZone   = "topology.kubernetes.io/zone"
+	LabelTopologyRegion = "topology.kubernetes.io/region"
+)
+
+// Instance metadata fields with well-known node labels
+const (
+	MetadataOS   = "os"
+	MetadataArch = "arch"
+
+	LabelOS   = "kubernetes.io/os"
+	LabelArch = "kubernetes.io/arch"
+)
+
+// metadataLabelPrefix prefixes the labels of the instance metadata fields without a well-known label
+const metadataLabelPrefix = "rancher.io/"
+
+// MetadataLabelKey returns the key of the node label of an instance metadata field
+func MetadataLabelKey(field string) string {
+	switch field {
+	case MetadataOS:
+		return LabelOS
+	case MetadataArch:
+		return LabelArch
+	}
+	return metadataLabelPrefix + field
+}
+
+// TopologyLabelPolicy tells which families of zone and region labels are written on nodes
+type TopologyLabelPolicy string
+
+const (
+	// TopologyLabelsBeta writes the failure-domain.beta.kubernetes.io labels only
+	TopologyLabelsBeta TopologyLabelPolicy = "beta"
+	// TopologyLabelsGA writes the topology.kubernetes.io labels only, and removes the beta ones
+	TopologyLabelsGA TopologyLabelPolicy = "ga"
+	// TopologyLabelsBoth writes both families with the same values
+	TopologyLabelsBoth TopologyLabelPolicy = "both"
+)
+
+// ParseTopologyLabelPolicy returns the policy named s
+func ParseTopologyLabelPolicy(s string) (TopologyLabelPolicy, error) {
+	switch policy := TopologyLabelPolicy(s); policy {
+	case TopologyLabelsBeta, TopologyLabelsGA, TopologyLabelsBoth:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid topology label policy %q, must be one of %s, %s or %s", s,
+		TopologyLabelsBeta, TopologyLabelsGA, TopologyLabelsBoth)
+}
+
+// deprecatedTopologyLabels maps the beta topology labels to the GA labels replacing them
+var deprecatedTopologyLabels = map[string]string{
+	metav1.LabelZoneFailureDomain: LabelTopologyZone,
+	metav1.LabelZoneRegion:        LabelTopologyRegion,
+}
+
+// setTopologyLabel sets the beta and GA labels of a zone or region to value according to the policy
+func (cnc *CloudNodeController) setTopologyLabel(labels map[string]string, betaKey, value string) {
+	if cnc.topologyLabels != TopologyLabelsGA {
+		labels[betaKey] = value
+	}
+	if cnc.topologyLabels != TopologyLabelsBeta {
+		labels[deprecatedTopologyLabels[betaKey]] = value
+	}
+}
+
+// cloudLabels returns the labels the cloud reports for node. Labels missing from the result
+// are no longer reported and are removed from the node if the controller set them.
+func (cnc *CloudNodeController) cloudLabels(node *v1.Node) (map[string]string, error) {
+	labels := map[string]string{}
+
+	if instances, ok := cnc.instances(); ok {
+		instanceType, err := instances.InstanceTypeByProviderID(node.Spec.ProviderID)
+		if err != nil {
+			instanceType, err = instances.InstanceType(types.NodeName(node.Name))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if instanceType != "" {
+			labels[metav1.LabelInstanceType] = instanceType
+		}
+	}
+
+	if zones, ok := cnc.cloud.Zones(); ok {
+		zone, err := cnc.nodeZone(node, zones)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get zone from cloud provider: %v", err)
+		}
+		if zone.FailureDomain != "" {
+			cnc.setTopologyLabel(labels, metav1.LabelZoneFailureDomain, zone.FailureDomain)
+		}
+		if zone.Region != "" {
+			cnc.setTopologyLabel(labels, metav1.LabelZoneRegion, zone.Region)
+		}
+	}
+
+	if err := cnc.setEnvironment(node, labels); err != nil {
+		glog.Errorf("failed to get environment of node %s from cloud provider: %v", node.Name, err)
+		// Keep the label until the environment can be looked up again
+		if value, ok := managedLabels(node)[LabelEnvironment]; ok {
+			labels[LabelEnvironment] = value
+		}
+	}
+
+	if err := cnc.setHostLabels(node, labels); err != nil {
+		glog.Errorf("failed to get host labels of node %s from cloud provider: %v", node.Name, err)
+		// Keep the labels until the host can be looked up again
+		for key, value := range managedLabels(node) {
+			if _, reported := labels[key]; !reported && !reservedLabelKey(key) {
+				if _, ok := cnc.hostLabelKey(key); ok {
+					labels[key] = value
+				}
+			}
+		}
+	}
+	return labels, nil
+}
+
+// nodeZone returns the zone of node, looked up by providerID and then by name if the cloud can tell
+// the zone of each instance. Otherwise, or if both lookups fail, it's the zone of the controller.
+func (cnc *CloudNodeController) nodeZone(node *v1.Node, zones cloudprovider.Zones) (cloudprovider.Zone, error) {
+	nodeZones, ok := cnc.cloud.(NodeZoneProvider)
+	if !ok {
+		return cnc.controllerZone(zones)
+	}
+	if node.Spec.ProviderID != "" {
+		zone, err := cnc.callCloudZone("GetZoneByProviderID", func() (cloudprovider.Zone, error) {
+			return nodeZones.GetZoneByProviderID(node.Spec.ProviderID)
+		})
+		if err == nil {
+			return zone, nil
+		}
+		glog.V(2).Infof("failed to get zone of node %s by providerID %s: %v", node.Name, node.Spec.ProviderID, err)
+	}
+	zone, err := cnc.callCloudZone("GetZoneByNodeName", func() (cloudprovider.Zone, error) {
+		return nodeZones.GetZoneByNodeName(types.NodeName(node.Name))
+	})
+	if err == nil {
+		return zone, nil
+	}
+	glog.V(2).Infof("failed to get zone of node %s by name, using the zone of the controller: %v", node.Name, err)
+	return cnc.controllerZone(zones)
+}
+
+// controllerZone returns the zone of the controller
+func (cnc *CloudNodeController) controllerZone(zones cloudprovider.Zones) (cloudprovider.Zone, error) {
+	return cnc.callCloudZone("GetZone", zones.GetZone)
+}
+
+// callCloudZone is callCloud for the zone lookups
+func (cnc *CloudNodeController) callCloudZone(call string, fn func() (cloudprovider.Zone, error)) (cloudprovider.Zone, error) {
+	result, err := cnc.callCloud(call, func() (interface{}, error) {
+		return fn()
+	})
+	zone, _ := result.(cloudprovider.Zone)
+	return zone, err
+}
+
+// setMetadataLabels labels node with the instance metadata fields in the allowlist. They're only set
+// when nodes are initialized, and aren't managed: fields the cloud doesn't report and labels the
+// node already has are left alone, and the labels are never updated afterwards.
+func (cnc *CloudNodeController) setMetadataLabels(node *v1.Node) {
+	if len(cnc.metadataLabels) == 0 {
+		return
+	}
+	provider, ok := cnc.cloud.(InstanceMetadataProvider)
+	if !ok {
+		return
+	}
+	result, err := cnc.callCloud("InstanceMetadataByProviderID", func() (interface{}, error) {
+		return provider.InstanceMetadataByProviderID(node.Spec.ProviderID)
+	})
+	metadata, _ := result.(map[string]string)
+	if err != nil {
+		glog.Errorf("failed to get instance metadata of node %s from cloud provider: %v", node.Name, err)
+		return
+	}
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	for _, field := range cnc.metadataLabels {
+		value, reported := metadata[field]
+		if !reported {
+			continue
+		}
+		key := MetadataLabelKey(field)
+		if current, exists := node.Labels[key]; exists {
+			glog.V(4).Infof("Not overwriting label %s=%s of node %s with instance metadata", key, current, node.Name)
+			continue
+		}
+		value = sanitizeLabelValue(value)
+		glog.Infof("Setting node label from instance metadata: %s=%s", key, value)
+		node.Labels[key] = value
+	}
+}
+
+// hostLabelKey returns the key of the host label synced onto nodes as the node label key, and
+// whether a host label is synced as key
+func (cnc *CloudNodeController) hostLabelKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, cnc.hostLabelPrefix) {
+		return "", false
+	}
+	hostKey := strings.TrimPrefix(key, cnc.hostLabelPrefix)
+	for _, pattern := range cnc.hostLabels {
+		if matched, _ := path.Match(pattern, hostKey); matched {
+			return hostKey, true
+		}
+	}
+	return "", false
+}
+
+// reservedLabelKey tells whether key is in the namespace of the Kubernetes system labels, which
+// host labels must never overwrite
+func reservedLabelKey(key string) bool {
+	i := strings.Index(key, "/")
+	if i < 0 {
+		return false
+	}
+	domain := key[:i]
+	for _, reserved := range []string{"kubernetes.io", "k8s.io"} {
+		if domain == reserved || strings.HasSuffix(domain, "."+reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// setHostLabels adds the host labels of node matching the host label patterns to labels, under the
+// host label prefix. Host labels that would be Kubernetes system labels, invalid labels, or labels
+// the cloud already reports otherwise are skipped.
+func (cnc *CloudNodeController) setHostLabels(node *v1.Node, labels map[string]string) error {
+	if len(cnc.hostLabels) == 0 {
+		return nil
+	}
+	provider, ok := cnc.cloud.(HostLabelProvider)
+	if !ok || node.Spec.ProviderID == "" {
+		return nil
+	}
+	result, err := cnc.callCloud("HostLabelsByProviderID", func() (interface{}, error) {
+		return provider.HostLabelsByProviderID(node.Spec.ProviderID)
+	})
+	if err != nil {
+		return err
+	}
+	hostLabels, _ := result.(map[string]string)
+
+	for hostKey, value := range hostLabels {
+		key := cnc.hostLabelPrefix + hostKey
+		if _, ok := cnc.hostLabelKey(key); !ok {
+			continue
+		}
+		if reservedLabelKey(key) {
+			glog.V(2).Infof("Not syncing host label %s onto node %s, it's a Kubernetes system label", key, node.Name)
+			continue
+		}
+		if _, ok := labels[key]; ok {
+			glog.V(2).Infof("Not syncing host label %s onto node %s, the cloud provider sets it", key, node.Name)
+			continue
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			glog.V(2).Infof("Not syncing host label %s onto node %s: %s", key, node.Name, strings.Join(errs, ", "))
+			continue
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			glog.V(2).Infof("Not syncing host label %s=%s onto node %s: %s", key, value, node.Name, strings.Join(errs, ", "))
+			continue
+		}
+		labels[key] = value
+	}
+	return nil
+}
+
+// managedLabels returns the labels the controller set on node and their values
+func managedLabels(node *v1.Node) map[string]string {
+	managed := map[string]string{}
+	if data, ok := node.Annotations[AnnotationManagedLabels]; ok {
+		if err := json.Unmarshal([]byte(data), &managed); err != nil {
+			glog.Errorf("Ignoring invalid %s annotation of node %s: %v", AnnotationManagedLabels, node.Name, err)
+			return map[string]string{}
+		}
+	}
+	return managed
+}
+
+// syncLabels sets the labels of node to labels like syncManagedLabels. Under the GA topology
+// label policy it also removes the beta topology labels that match their GA replacement, even if
+// they were set before the controller recorded the labels it manages.
+func (cnc *CloudNodeController) syncLabels(node *v1.Node, labels map[string]string) {
+	syncManagedLabels(node, labels, node.Annotations[AnnotationSkipLabelReconciliation] != "true")
+	if cnc.topologyLabels != TopologyLabelsGA {
+		return
+	}
+	for betaKey, gaKey := range deprecatedTopologyLabels {
+		value, ok := node.Labels[betaKey]
+		gaValue, reported := labels[gaKey]
+		if ok && reported && value == gaValue {
+			glog.Infof("Removing deprecated node label %s=%s of node %s", betaKey, value, node.Name)
+			delete(node.Labels, betaKey)
+		}
+	}
+}
+
+// syncManagedLabels sets the labels of node to labels, and removes the labels the controller set
+// that are no longer reported. Labels the controller didn't set are left alone. The labels it set
+// that were changed or removed since are restored if reconcile is true, otherwise they're left
+// alone too.
+func syncManagedLabels(node *v1.Node, labels map[string]string, reconcile bool) {
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	managed := managedLabels(node)
+
+	for key, value := range labels {
+		current, exists := node.Labels[key]
+		written, isManaged := managed[key]
+		switch {
+		case isManaged && current != written && reconcile:
+			glog.Infof("Restoring label %s of node %s from %q to the cloud provider value %q", key, node.Name, current, value)
+			node.Labels[key] = value
+			managed[key] = value
+		case isManaged && current != written:
+			glog.Infof("Label %s of node %s was changed to %q, no longer managing it", key, node.Name, current)
+			delete(managed, key)
+		case !isManaged && exists:
+			glog.V(4).Infof("Not overwriting label %s=%s of node %s, it wasn't set by the cloud provider", key, current, node.Name)
+		default:
+			if current != value {
+				glog.Infof("Setting node label from cloud provider: %s=%s", key, value)
+				node.Labels[key] = value
+			}
+			managed[key] = value
+		}
+	}
+
+	for key, written := range managed {
+		if _, ok := labels[key]; ok {
+			continue
+		}
+		if current, exists := node.Labels[key]; exists && current == written {
+			glog.Infof("Removing node label %s=%s, the cloud provider no longer reports it", key, current)
+			delete(node.Labels, key)
+		}
+		delete(managed, key)
+	}
+
+	if len(managed) == 0 {
+		delete(node.Annotations, AnnotationManagedLabels)
+		return
+	}
+	data, err := json.Marshal(managed)
+	if err != nil {
+		glog.Errorf("failed to record the labels of node %s: %v", node.Name, err)
+		return
+	}
+	node.Annotations[AnnotationManagedLabels] = string(data)
+}
+
+// stringMapsEqual tells whether a and b hold the same entries, a nil map being equal to an empty one
+func stringMapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,500 @@
+package cloud
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+func TestSyncManagedLabels(t *testing.T) {
+	zone := metav1.LabelZoneFailureDomain
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		managed string
+		cloud   map[string]string
+		// skip disables the reconciliation of changed and removed labels
+		skip   bool
+		expect map[string]string
+		// expectManaged is the expected managed labels annotation, "" if it should be removed
+		expectManaged string
+	}{
+		{
+			name:          "new label",
+			labels:        map[string]string{"role": "db"},
+			cloud:         map[string]string{zone: "zone1"},
+			expect:        map[string]string{"role": "db", zone: "zone1"},
+			expectManaged: `{"failure-domain.beta.kubernetes.io/zone":"zone1"}`,
+		},
+		{
+			name:          "value change",
+			labels:        map[string]string{zone: "zone1"},
+			managed:       `{"failure-domain.beta.kubernetes.io/zone":"zone1"}`,
+			cloud:         map[string]string{zone: "zone2"},
+			expect:        map[string]string{zone: "zone2"},
+			expectManaged: `{"failure-domain.beta.kubernetes.io/zone":"zone2"}`,
+		},
+		{
+			name:    "source removed",
+			labels:  map[string]string{zone: "zone1", "role": "db"},
+			managed: `{"failure-domain.beta.kubernetes.io/zone":"zone1"}`,
+			cloud:   map[string]string{},
+			expect:  map[string]string{"role": "db"},
+		},
+		{
+			name:          "user overrode value",
+			labels:        map[string]string{zone: "custom"},
+			managed:       `{"failure-domain.beta.kubernetes.io/zone":"zone1"}`,
+			cloud:         map[string]string{zone: "zone2"},
+			expect:        map[string]string{zone: "zone2"},
+			expectManaged: `{"failure-domain.beta.kubernetes.io/zone":"zone2"}`,
+		},
+		{
+			name:          "user removed label",
+			labels:        map[string]string{"role": "db"},
+			managed:       `{"failure-domain.beta.kubernetes.io/zone":"zone1"}`,
+			cloud:         map[string]string{zone: "zone1"},
+			expect:        map[string]string{"role": "db", zone: "zone1"},
+			expectManaged: `{"failure-domain.beta.kubernetes.io/zone":"zone1"}`,
+		},
+		{
+			name:    "user overrode value without reconciliation",
+			labels:  map[string]string{zone: "custom"},
+			managed: `{"failure-domain.beta.kubernetes.io/zone":"zone1"}`,
+			cloud:   map[string]string{zone: "zone2"},
+			skip:    true,
+			expect:  map[string]string{zone: "custom"},
+		},
+		{
+			name:    "user removed label without reconciliation",
+			labels:  map[string]string{"role": "db"},
+			managed: `{"failure-domain.beta.kubernetes.io/zone":"zone1"}`,
+			cloud:   map[string]string{zone: "zone1"},
+			skip:    true,
+			expect:  map[string]string{"role": "db"},
+		},
+		{
+			name:    "user overrode value and source removed",
+			labels:  map[string]string{zone: "custom"},
+			managed: `{"failure-domain.beta.kubernetes.io/zone":"zone1"}`,
+			cloud:   map[string]string{},
+			expect:  map[string]string{zone: "custom"},
+		},
+		{
+			name:   "label set by someone else",
+			labels: map[string]string{zone: "custom"},
+			cloud:  map[string]string{zone: "zone1"},
+			expect: map[string]string{zone: "custom"},
+		},
+	}
+
+	for _, test := range tests {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: test.labels, Annotations: map[string]string{}}}
+		if test.managed != "" {
+			node.Annotations[AnnotationManagedLabels] = test.managed
+		}
+
+		syncManagedLabels(node, test.cloud, !test.skip)
+
+		if !reflect.DeepEqual(node.Labels, test.expect) {
+			t.Errorf("%s: expected labels %v, found %v", test.name, test.expect, node.Labels)
+		}
+		if managed := node.Annotations[AnnotationManagedLabels]; managed != test.expectManaged {
+			t.Errorf("%s: expected managed labels %q, found %q", test.name, test.expectManaged, managed)
+		}
+	}
+}
+
+func TestTopologyLabelPolicies(t *testing.T) {
+	betaZone, betaRegion := metav1.LabelZoneFailureDomain, metav1.LabelZoneRegion
+	beta := map[string]string{betaZone: "zone1", betaRegion: "region1"}
+	ga := map[string]string{LabelTopologyZone: "zone1", LabelTopologyRegion: "region1"}
+	both := map[string]string{betaZone: "zone1", betaRegion: "region1", LabelTopologyZone: "zone1", LabelTopologyRegion: "region1"}
+
+	tests := []struct {
+		name   string
+		from   TopologyLabelPolicy
+		to     TopologyLabelPolicy
+		expect map[string]string
+	}{
+		{name: "both to ga", from: TopologyLabelsBoth, to: TopologyLabelsGA, expect: ga},
+		{name: "both to beta", from: TopologyLabelsBoth, to: TopologyLabelsBeta, expect: beta},
+		{name: "beta to both", from: TopologyLabelsBeta, to: TopologyLabelsBoth, expect: both},
+		{name: "beta to ga", from: TopologyLabelsBeta, to: TopologyLabelsGA, expect: ga},
+		{name: "ga to both", from: TopologyLabelsGA, to: TopologyLabelsBoth, expect: both},
+		{name: "ga to beta", from: TopologyLabelsGA, to: TopologyLabelsBeta, expect: beta},
+		// Labels written before the controller recorded the labels it manages
+		{name: "unmanaged beta to ga", to: TopologyLabelsGA, expect: ga},
+		{name: "unmanaged beta to both", to: TopologyLabelsBoth, expect: both},
+	}
+
+	for _, test := range tests {
+		cnc := &CloudNodeController{
+			cloud: &fakeCloud{instanceType: "m1", zone: &cloudprovider.Zone{FailureDomain: "zone1", Region: "region1"}},
+		}
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+		if test.from == "" {
+			node.Labels = map[string]string{betaZone: "zone1", betaRegion: "region1"}
+		} else {
+			cnc.topologyLabels = test.from
+			labels, err := cnc.cloudLabels(node)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", test.name, err)
+			}
+			cnc.syncLabels(node, labels)
+		}
+
+		cnc.topologyLabels = test.to
+		labels, err := cnc.cloudLabels(node)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		cnc.syncLabels(node, labels)
+
+		found := map[string]string{}
+		for key, value := range node.Labels {
+			if key != metav1.LabelInstanceType {
+				found[key] = value
+			}
+		}
+		if !reflect.DeepEqual(found, test.expect) {
+			t.Errorf("%s: expected labels %v, found %v", test.name, test.expect, found)
+		}
+	}
+}
+
+// fakeZoneCloud reports the zone of each node by providerID and by name, the lookups fail for
+// the missing ones
+type fakeZoneCloud struct {
+	*fakeCloud
+	byProviderID map[string]cloudprovider.Zone
+	byName       map[types.NodeName]cloudprovider.Zone
+}
+
+func (f *fakeZoneCloud) GetZoneByProviderID(providerID string) (cloudprovider.Zone, error) {
+	zone, ok := f.byProviderID[providerID]
+	if !ok {
+		return cloudprovider.Zone{}, fmt.Errorf("no host with providerID %s", providerID)
+	}
+	return zone, nil
+}
+
+func (f *fakeZoneCloud) GetZoneByNodeName(nodeName types.NodeName) (cloudprovider.Zone, error) {
+	zone, ok := f.byName[nodeName]
+	if !ok {
+		return cloudprovider.Zone{}, fmt.Errorf("no host named %s", nodeName)
+	}
+	return zone, nil
+}
+
+func TestNodeZoneLabels(t *testing.T) {
+	controllerZone := cloudprovider.Zone{FailureDomain: "zone0", Region: "region0"}
+	nodeZone := cloudprovider.Zone{FailureDomain: "zone1", Region: "region1"}
+	tests := []struct {
+		name         string
+		providerID   string
+		byProviderID map[string]cloudprovider.Zone
+		byName       map[types.NodeName]cloudprovider.Zone
+		labels       map[string]string
+		expect       map[string]string
+	}{
+		{
+			name:         "by providerID",
+			providerID:   "rancher://1h1",
+			byProviderID: map[string]cloudprovider.Zone{"rancher://1h1": nodeZone},
+			byName:       map[types.NodeName]cloudprovider.Zone{"node1": {FailureDomain: "zone2"}},
+			expect:       map[string]string{metav1.LabelZoneFailureDomain: "zone1", metav1.LabelZoneRegion: "region1"},
+		},
+		{
+			name:   "by name without providerID",
+			byName: map[types.NodeName]cloudprovider.Zone{"node1": nodeZone},
+			expect: map[string]string{metav1.LabelZoneFailureDomain: "zone1", metav1.LabelZoneRegion: "region1"},
+		},
+		{
+			name:       "by name when the providerID lookup fails",
+			providerID: "rancher://1h1",
+			byName:     map[types.NodeName]cloudprovider.Zone{"node1": nodeZone},
+			expect:     map[string]string{metav1.LabelZoneFailureDomain: "zone1", metav1.LabelZoneRegion: "region1"},
+		},
+		{
+			name:       "zone of the controller when both lookups fail",
+			providerID: "rancher://1h1",
+			expect:     map[string]string{metav1.LabelZoneFailureDomain: "zone0", metav1.LabelZoneRegion: "region0"},
+		},
+		{
+			name:         "no zone keeps the labels set by the admin",
+			providerID:   "rancher://1h1",
+			byProviderID: map[string]cloudprovider.Zone{"rancher://1h1": {}},
+			labels:       map[string]string{metav1.LabelZoneFailureDomain: "rack3"},
+			expect:       map[string]string{metav1.LabelZoneFailureDomain: "rack3"},
+		},
+	}
+
+	for _, test := range tests {
+		cnc := &CloudNodeController{
+			cloud: &fakeZoneCloud{
+				fakeCloud:    &fakeCloud{instanceType: "m1", zone: &controllerZone},
+				byProviderID: test.byProviderID,
+				byName:       test.byName,
+			},
+			topologyLabels: TopologyLabelsBeta,
+		}
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: test.labels},
+			Spec:       v1.NodeSpec{ProviderID: test.providerID},
+		}
+
+		labels, err := cnc.cloudLabels(node)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		cnc.syncLabels(node, labels)
+
+		delete(node.Labels, metav1.LabelInstanceType)
+		if !reflect.DeepEqual(node.Labels, test.expect) {
+			t.Errorf("%s: expected labels %v, found %v", test.name, test.expect, node.Labels)
+		}
+	}
+}
+
+func TestParseTopologyLabelPolicy(t *testing.T) {
+	for _, s := range []string{"beta", "ga", "both"} {
+		if policy, err := ParseTopologyLabelPolicy(s); err != nil || string(policy) != s {
+			t.Errorf("expected policy %s, got %q, %v", s, policy, err)
+		}
+	}
+	if _, err := ParseTopologyLabelPolicy("GA"); err == nil {
+		t.Errorf("expected an error for an unknown policy")
+	}
+}
+
+// fakeMetadataCloud reports the same metadata for every instance
+type fakeMetadataCloud struct {
+	*fakeCloud
+	metadata map[string]string
+}
+
+func (f *fakeMetadataCloud) InstanceMetadataByProviderID(providerID string) (map[string]string, error) {
+	return f.metadata, nil
+}
+
+func TestInitializeNodeMetadataLabels(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1", ResourceVersion: "1", Labels: map[string]string{"rancher.io/kvm": "no"}},
+		Spec: v1.NodeSpec{
+			ProviderID: "rancher://1h1",
+			Taints:     []v1.Taint{{Key: CloudTaintKey, Value: "true", Effect: v1.TaintEffectNoSchedule}},
+		},
+	}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+	cloud := &fakeMetadataCloud{
+		fakeCloud: &fakeCloud{
+			addresses:    []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+			instanceType: "rancher",
+		},
+		metadata: map[string]string{"os": "linux", "arch": "arm64", "kvm": "yes", "docker_version": "1.12+cs"},
+	}
+	cnc := &CloudNodeController{
+		kubeClient:     &fakeClientset{nodes: nodes},
+		recorder:       record.NewFakeRecorder(10),
+		nodeInformer:   &fakeNodeInformer{nodes: nodes},
+		cloud:          cloud,
+		metadataLabels: []string{MetadataOS, MetadataArch, "kvm", "docker_version", "hypervisor"},
+	}
+
+	if err := cnc.initializeNode(node); err != nil {
+		t.Fatalf("unexpected error initializing the node: %v", err)
+	}
+	stored, _ := nodes.Get("node1", metav1.GetOptions{})
+	for key, value := range map[string]string{
+		LabelOS:                     "linux",
+		LabelArch:                   "arm64",
+		"rancher.io/kvm":            "no",
+		"rancher.io/docker_version": "1.12-cs",
+	} {
+		if stored.Labels[key] != value {
+			t.Errorf("expected label %s=%s, found labels %v", key, value, stored.Labels)
+		}
+	}
+	if _, ok := stored.Labels["rancher.io/hypervisor"]; ok {
+		t.Errorf("expected no label for a field the cloud doesn't report, found %v", stored.Labels)
+	}
+	if managed := managedLabels(stored); managed[LabelOS] != "" || managed["rancher.io/kvm"] != "" {
+		t.Errorf("expected the metadata labels not to be managed, found %v", managed)
+	}
+
+	// The operator relabels the node, the address sync leaves the label alone
+	stored.Labels[LabelArch] = "arm"
+	nodes.items["node1"] = stored
+	cloud.metadata = map[string]string{"os": "windows"}
+	instances, _ := cnc.cloud.Instances()
+	if err := cnc.syncNodeAddresses(instances, stored); err != nil {
+		t.Fatalf("unexpected error syncing the node: %v", err)
+	}
+	stored, _ = nodes.Get("node1", metav1.GetOptions{})
+	if stored.Labels[LabelOS] != "linux" || stored.Labels[LabelArch] != "arm" {
+		t.Errorf("expected the address sync not to update the metadata labels, found %v", stored.Labels)
+	}
+}
+
+func TestMetadataLabelKey(t *testing.T) {
+	for field, key := range map[string]string{
+		MetadataOS:       "kubernetes.io/os",
+		MetadataArch:     "kubernetes.io/arch",
+		"docker_version": "rancher.io/docker_version",
+	} {
+		if found := MetadataLabelKey(field); found != key {
+			t.Errorf("expected label %s for field %s, found %s", key, field, found)
+		}
+	}
+}
+
+func TestAddressSyncRestoresLabels(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		annotations := map[string]string{AnnotationManagedLabels: `{"beta.kubernetes.io/instance-type":"rancher"}`}
+		if skip {
+			annotations[AnnotationSkipLabelReconciliation] = "true"
+		}
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", ResourceVersion: "1", Annotations: annotations},
+			Spec:       v1.NodeSpec{ProviderID: "rancher://1h1"},
+			Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}}},
+		}
+		nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+		cnc := &CloudNodeController{
+			kubeClient:   &fakeClientset{nodes: nodes},
+			recorder:     record.NewFakeRecorder(10),
+			nodeInformer: &fakeNodeInformer{nodes: nodes},
+			cloud: &fakeCloud{
+				addresses:    []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+				instanceType: "rancher",
+			},
+		}
+
+		instances, _ := cnc.cloud.Instances()
+		if err := cnc.syncNodeAddresses(instances, node); err != nil {
+			t.Fatalf("unexpected error syncing the node: %v", err)
+		}
+		stored, _ := nodes.Get("node1", metav1.GetOptions{})
+		if restored := stored.Labels[metav1.LabelInstanceType] == "rancher"; restored == skip {
+			t.Errorf("expected the removed instance type label to be restored to be %v, found labels %v", !skip, stored.Labels)
+		}
+	}
+}
+
+// fakeHostLabelCloud reports the same host labels for every instance
+type fakeHostLabelCloud struct {
+	*fakeCloud
+	labels map[string]string
+	err    error
+}
+
+func (f *fakeHostLabelCloud) HostLabelsByProviderID(providerID string) (map[string]string, error) {
+	return f.labels, f.err
+}
+
+func TestSyncHostLabels(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1", ResourceVersion: "1", Labels: map[string]string{"host.rancher.io/rack": "r1"}},
+		Spec:       v1.NodeSpec{ProviderID: "rancher://1h1"},
+		Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}}},
+	}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+	cloud := &fakeHostLabelCloud{fakeCloud: &fakeCloud{
+		addresses:    []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+		instanceType: "rancher",
+	}}
+	cnc := &CloudNodeController{
+		kubeClient:      &fakeClientset{nodes: nodes},
+		recorder:        record.NewFakeRecorder(10),
+		nodeInformer:    &fakeNodeInformer{nodes: nodes},
+		cloud:           cloud,
+		hostLabels:      []string{"storage", "gpu", "rack", "io.rancher.label.*", "kubernetes.io/*", "beta.kubernetes.io/*"},
+		hostLabelPrefix: "host.rancher.io/",
+	}
+	instances, _ := cnc.cloud.Instances()
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		err    error
+		expect map[string]string
+	}{
+		{
+			name:   "add",
+			labels: map[string]string{"storage": "ssd", "gpu": "true", "io.rancher.label.zone": "a", "app": "web"},
+			expect: map[string]string{"host.rancher.io/storage": "ssd", "host.rancher.io/gpu": "true", "host.rancher.io/io.rancher.label.zone": "a"},
+		},
+		{
+			name:   "update",
+			labels: map[string]string{"storage": "hdd", "gpu": "true", "io.rancher.label.zone": "a"},
+			expect: map[string]string{"host.rancher.io/storage": "hdd", "host.rancher.io/gpu": "true", "host.rancher.io/io.rancher.label.zone": "a"},
+		},
+		{
+			name:   "lookup failure",
+			err:    fmt.Errorf("connection refused"),
+			expect: map[string]string{"host.rancher.io/storage": "hdd", "host.rancher.io/gpu": "true", "host.rancher.io/io.rancher.label.zone": "a"},
+		},
+		{
+			name:   "remove",
+			labels: map[string]string{"storage": "hdd", "rack": "r2"},
+			expect: map[string]string{"host.rancher.io/storage": "hdd"},
+		},
+	}
+
+	for _, test := range tests {
+		cloud.labels, cloud.err = test.labels, test.err
+		stored, _ := nodes.Get("node1", metav1.GetOptions{})
+		if err := cnc.syncNodeAddresses(instances, stored); err != nil {
+			t.Fatalf("%s: unexpected error syncing the node: %v", test.name, err)
+		}
+		stored, _ = nodes.Get("node1", metav1.GetOptions{})
+		expect := map[string]string{metav1.LabelInstanceType: "rancher", "host.rancher.io/rack": "r1"}
+		for key, value := range test.expect {
+			expect[key] = value
+		}
+		if !reflect.DeepEqual(stored.Labels, expect) {
+			t.Errorf("%s: expected labels %v, found %v", test.name, expect, stored.Labels)
+		}
+		if _, ok := managedLabels(stored)["host.rancher.io/rack"]; ok {
+			t.Errorf("%s: expected the label the cloud never set not to be managed", test.name)
+		}
+	}
+
+	// host labels colliding with the Kubernetes system labels are rejected, invalid ones skipped
+	cnc.hostLabelPrefix = ""
+	cloud.labels = map[string]string{"kubernetes.io/hostname": "evil", "beta.kubernetes.io/instance-type": "huge", "storage": "ssd", "gpu": "two cards"}
+	stored, _ := nodes.Get("node1", metav1.GetOptions{})
+	if err := cnc.syncNodeAddresses(instances, stored); err != nil {
+		t.Fatalf("unexpected error syncing the node: %v", err)
+	}
+	stored, _ = nodes.Get("node1", metav1.GetOptions{})
+	if _, ok := stored.Labels["kubernetes.io/hostname"]; ok || stored.Labels[metav1.LabelInstanceType] != "rancher" {
+		t.Errorf("expected the Kubernetes system host labels to be rejected, found %v", stored.Labels)
+	}
+	if _, ok := stored.Labels["gpu"]; ok || stored.Labels["storage"] != "ssd" {
+		t.Errorf("expected only the valid storage host label to be synced, found %v", stored.Labels)
+	}
+}
+
+func TestReservedLabelKey(t *testing.T) {
+	for key, reserved := range map[string]bool{
+		"kubernetes.io/hostname":           true,
+		"node-role.kubernetes.io/master":   true,
+		"k8s.io/app":                       true,
+		"storage":                          false,
+		"io.rancher.label/kubernetes.io":   false,
+		"notkubernetes.io/label":           false,
+		"host.rancher.io/kubernetes.io/os": false,
+	} {
+		if found := reservedLabelKey(key); found != reserved {
+			t.Errorf("%s: expected reserved %v, found %v", key, reserved, found)
+		}
+	}
+}
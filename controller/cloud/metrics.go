@@ -0,0 +1,215 @@
+package cloud
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+const metricsNamespace = "rancher_ccm"
+
+var (
+	nodeStatusPatchConflicts = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "node_status_patch_conflicts_total",
+			Help:      "Number of node status patches that conflicted with another update and were retried.",
+		},
+	)
+
+	nodeStatusPatchesSkipped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "node_status_patches_skipped_total",
+			Help:      "Number of node status patches skipped because the addresses and labels of the node were up to date.",
+		},
+	)
+
+	nodePatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "node_patches_total",
+			Help:      "Number of node patches sent, partitioned by target: the node object or its status.",
+		},
+		[]string{"target"},
+	)
+
+	nodeAddressSyncs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "node_address_syncs_total",
+			Help:      "Number of node address updates from the cloud provider, partitioned by result (success or error).",
+		},
+		[]string{"result"},
+	)
+
+	nodeInitializations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "node_initializations_total",
+			Help:      "Number of node initializations that patched the node or failed, partitioned by result (success or error).",
+		},
+		[]string{"result"},
+	)
+
+	nodeDeletions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "node_deletions_total",
+			Help:      "Number of nodes deleted because their host was gone from the cloud provider.",
+		},
+	)
+
+	cloudCallDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "cloud_call_duration_seconds",
+			Help:      "Latency of the calls to the cloud provider in seconds, partitioned by call.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		},
+		[]string{"call"},
+	)
+
+	cloudCallErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cloud_call_errors_total",
+			Help:      "Number of failed calls to the cloud provider, not counting instances not found, partitioned by call.",
+		},
+		[]string{"call"},
+	)
+
+	cloudCallTimeouts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cloud_call_timeouts_total",
+			Help:      "Number of calls to the cloud provider that timed out, partitioned by call. They're counted as errors too.",
+		},
+		[]string{"call"},
+	)
+
+	nodesInLookupBackoff = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "nodes_in_lookup_backoff",
+			Help:      "Number of nodes whose address lookups keep failing and are backed off.",
+		},
+	)
+
+	uninitializedNodes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "uninitialized_nodes",
+			Help:      "Number of nodes still carrying a cloud taint, as of the last node monitor pass.",
+		},
+	)
+
+	ignoredNodes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "ignored_nodes",
+			Help:      "Number of nodes left alone because they were created before --manage-nodes-created-after, as of the last node monitor pass.",
+		},
+	)
+
+	oldestUninitializedNodeAge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "oldest_uninitialized_node_age_seconds",
+			Help:      "Age of the oldest node still carrying a cloud taint as of the last node monitor pass, 0 if there is none.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(nodeStatusPatchConflicts)
+	prometheus.MustRegister(nodeStatusPatchesSkipped)
+	prometheus.MustRegister(uninitializedNodes)
+	prometheus.MustRegister(nodesInLookupBackoff)
+	prometheus.MustRegister(oldestUninitializedNodeAge)
+	prometheus.MustRegister(ignoredNodes)
+	prometheus.MustRegister(nodePatches)
+	prometheus.MustRegister(nodeAddressSyncs)
+	prometheus.MustRegister(nodeInitializations)
+	prometheus.MustRegister(nodeDeletions)
+	prometheus.MustRegister(cloudCallDuration)
+	prometheus.MustRegister(cloudCallErrors)
+	prometheus.MustRegister(cloudCallTimeouts)
+}
+
+// resultLabel returns the result label of an operation that returned err
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// observeCloudCall records the latency and the outcome of a call to the cloud provider started at start
+func observeCloudCall(call string, start time.Time, err error) {
+	cloudCallDuration.WithLabelValues(call).Observe(time.Since(start).Seconds())
+	cloudCalls.record(err)
+	if err != nil && err != cloudprovider.InstanceNotFound {
+		cloudCallErrors.WithLabelValues(call).Inc()
+	}
+}
+
+// instrumentedInstances records the calls the controller makes to the instances of the cloud, and
+// bounds how long they may take
+type instrumentedInstances struct {
+	cloudprovider.Instances
+	cnc *CloudNodeController
+}
+
+func (i instrumentedInstances) NodeAddresses(name types.NodeName) ([]v1.NodeAddress, error) {
+	result, err := i.cnc.callCloud("NodeAddresses", func() (interface{}, error) {
+		return i.Instances.NodeAddresses(name)
+	})
+	addresses, _ := result.([]v1.NodeAddress)
+	return addresses, err
+}
+
+func (i instrumentedInstances) NodeAddressesByProviderID(providerID string) ([]v1.NodeAddress, error) {
+	result, err := i.cnc.callCloud("NodeAddressesByProviderID", func() (interface{}, error) {
+		return i.Instances.NodeAddressesByProviderID(providerID)
+	})
+	addresses, _ := result.([]v1.NodeAddress)
+	return addresses, err
+}
+
+func (i instrumentedInstances) ExternalID(name types.NodeName) (string, error) {
+	return i.cnc.callCloudString("ExternalID", func() (string, error) {
+		return i.Instances.ExternalID(name)
+	})
+}
+
+func (i instrumentedInstances) InstanceID(name types.NodeName) (string, error) {
+	return i.cnc.callCloudString("InstanceID", func() (string, error) {
+		return i.Instances.InstanceID(name)
+	})
+}
+
+func (i instrumentedInstances) InstanceType(name types.NodeName) (string, error) {
+	return i.cnc.callCloudString("InstanceType", func() (string, error) {
+		return i.Instances.InstanceType(name)
+	})
+}
+
+func (i instrumentedInstances) InstanceTypeByProviderID(providerID string) (string, error) {
+	return i.cnc.callCloudString("InstanceTypeByProviderID", func() (string, error) {
+		return i.Instances.InstanceTypeByProviderID(providerID)
+	})
+}
+
+// instances returns the instances of the cloud, recording the calls made to them
+func (cnc *CloudNodeController) instances() (cloudprovider.Instances, bool) {
+	instances, ok := cnc.cloud.Instances()
+	if !ok {
+		return nil, false
+	}
+	return instrumentedInstances{Instances: instances, cnc: cnc}, true
+}
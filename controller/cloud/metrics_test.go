@@ -0,0 +1,68 @@
+package cloud
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// The metric names are relied on by dashboards and alerts, they must not change
+func TestMetricsAfterSync(t *testing.T) {
+	cloudTaint := v1.Taint{Key: CloudTaintKey, Value: "true", Effect: v1.TaintEffectNoSchedule}
+	nodes := &fakeNodes{items: map[string]*v1.Node{
+		"node1": {
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", ResourceVersion: "1"},
+			Spec:       v1.NodeSpec{ProviderID: "rancher://1h1"},
+		},
+		"node2": {
+			ObjectMeta: metav1.ObjectMeta{Name: "node2", ResourceVersion: "1"},
+			Spec:       v1.NodeSpec{ProviderID: "rancher://1h2", Taints: []v1.Taint{cloudTaint}},
+		},
+	}}
+	cnc := &CloudNodeController{
+		kubeClient:   &fakeClientset{nodes: nodes},
+		nodeInformer: &fakeNodeInformer{nodes: nodes},
+		cloud: &fakeCloud{
+			addresses:    []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}},
+			instanceType: "rancher",
+			zone:         &cloudprovider.Zone{FailureDomain: "eu-1a", Region: "eu"},
+		},
+		recorder:       record.NewFakeRecorder(10),
+		waiting:        map[string]bool{},
+		addressBackoff: flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+		failing:        map[string]bool{},
+		lookupBackoff:  flowcontrol.NewBackOff(initialLookupBackoff, maxLookupBackoff),
+	}
+
+	cnc.UpdateNodeStatus()
+
+	w := httptest.NewRecorder()
+	prometheus.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 scraping metrics, got %d", w.Code)
+	}
+	scraped := w.Body.String()
+	for _, name := range []string{
+		`rancher_ccm_node_address_syncs_total{result="success"}`,
+		`rancher_ccm_node_initializations_total{result="success"}`,
+		`rancher_ccm_node_patches_total{target="node"}`,
+		`rancher_ccm_node_patches_total{target="status"}`,
+		`rancher_ccm_node_deletions_total`,
+		`rancher_ccm_node_status_patches_skipped_total`,
+		`rancher_ccm_cloud_call_duration_seconds_count{call="NodeAddressesByProviderID"}`,
+		`rancher_ccm_cloud_call_duration_seconds_count{call="InstanceTypeByProviderID"}`,
+		`rancher_ccm_cloud_call_duration_seconds_count{call="GetZone"}`,
+	} {
+		if !strings.Contains(scraped, name) {
+			t.Errorf("expected metric %s in the scraped metrics", name)
+		}
+	}
+}
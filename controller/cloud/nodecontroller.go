@@ -0,0 +1,1856 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	clientv1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/clientset"
+	coreinformers "k8s.io/kubernetes/pkg/client/informers/informers_generated/externalversions/core/v1"
+	clientretry "k8s.io/kubernetes/pkg/client/retry"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+
+	"github.com/rancher/rancher-cloud-controller-manager/controller/supervisor"
+)
+
+var UpdateNodeSpecBackoff = wait.Backoff{
+	Steps:    20,
+	Duration: 50 * time.Millisecond,
+	Jitter:   1.0,
+}
+
+// UpdateNodeStatusBackoff bounds the retries of node address patches that conflict with
+// other updates, e.g. kubelet heartbeats
+var UpdateNodeStatusBackoff = wait.Backoff{
+	Steps:    4,
+	Duration: 20 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+}
+
+// EnvironmentProvider is implemented by cloud providers that group instances into named environments
+type EnvironmentProvider interface {
+	// InstanceEnvironment returns the ID and the name of the environment of the instance of a node
+	InstanceEnvironment(nodeName types.NodeName) (id string, name string, err error)
+}
+
+// NodeZoneProvider is implemented by cloud providers that can tell the zone of each instance, rather
+// than only the zone the controller manager runs in
+type NodeZoneProvider interface {
+	// GetZoneByProviderID returns the zone of the instance with the given providerID
+	GetZoneByProviderID(providerID string) (cloudprovider.Zone, error)
+	// GetZoneByNodeName returns the zone of the instance of a node
+	GetZoneByNodeName(nodeName types.NodeName) (cloudprovider.Zone, error)
+}
+
+// InstanceShutdownProvider is implemented by cloud providers that can tell whether an instance is
+// shut down, e.g. stopped or deactivated, rather than gone
+type InstanceShutdownProvider interface {
+	// InstanceShutdownByProviderID tells whether the instance with the given providerID is shut down
+	InstanceShutdownByProviderID(providerID string) (bool, error)
+}
+
+// InstanceExistenceProvider is implemented by cloud providers that can tell whether an instance
+// still exists by its providerID, rather than by looking it up by node name
+type InstanceExistenceProvider interface {
+	// InstanceExistsByProviderID tells whether the instance with the given providerID still exists.
+	// It returns an error, never false, when it can't tell.
+	InstanceExistsByProviderID(providerID string) (bool, error)
+}
+
+// AmbiguousInstanceError is implemented by errors of cloud providers that found several instances
+// a node could be
+type AmbiguousInstanceError interface {
+	error
+	// Candidates returns the IDs of the instances
+	Candidates() []string
+}
+
+// InvalidProviderIDError is implemented by errors of cloud providers that were given a providerID
+// that doesn't identify any of their instances, as opposed to cloudprovider.InstanceNotFound for
+// instances that are gone
+type InvalidProviderIDError interface {
+	error
+	// InvalidProviderID returns the providerID
+	InvalidProviderID() string
+}
+
+// ProviderIDOwner is implemented by cloud providers that can tell the providerIDs of their instances
+// from those of other clouds
+type ProviderIDOwner interface {
+	// OwnsProviderID tells whether providerID identifies an instance of the cloud provider
+	OwnsProviderID(providerID string) bool
+}
+
+// ProviderIDProvider is implemented by cloud providers whose providerIDs aren't the provider name
+// followed by the instance ID
+type ProviderIDProvider interface {
+	// ProviderIDByNodeName returns the providerID of the instance of a node
+	ProviderIDByNodeName(nodeName types.NodeName) (string, error)
+}
+
+// InstanceMetadataProvider is implemented by cloud providers that describe their instances beyond
+// their type and zone, e.g. with their operating system and architecture
+type InstanceMetadataProvider interface {
+	// InstanceMetadataByProviderID returns the metadata fields of the instance with the given
+	// providerID, by name
+	InstanceMetadataByProviderID(providerID string) (map[string]string, error)
+}
+
+// HostLabelProvider is implemented by cloud providers whose instances carry labels
+type HostLabelProvider interface {
+	// HostLabelsByProviderID returns the labels of the instance with the given providerID
+	HostLabelsByProviderID(providerID string) (map[string]string, error)
+}
+
+// RequestTimeoutSetter is implemented by cloud providers whose requests to their API can time out
+type RequestTimeoutSetter interface {
+	// SetRequestTimeout makes the requests to the API fail after timeout
+	SetRequestTimeout(timeout time.Duration)
+}
+
+// HostCacheInvalidator is implemented by cloud providers that cache host lookups
+type HostCacheInvalidator interface {
+	// InvalidateHostCache evicts the cached hosts matching key, or all hosts if key is empty,
+	// and returns the number of evicted hosts
+	InvalidateHostCache(key string) int
+}
+
+// HostnameLister is implemented by cloud providers that can list the hostnames of all their hosts
+type HostnameLister interface {
+	// HostnamesByUUID returns the hostnames of the hosts keyed by their UUID
+	HostnamesByUUID() (map[string]string, error)
+}
+
+// HostEventSource is implemented by cloud providers notified of the changes of their hosts
+type HostEventSource interface {
+	// WatchHostEvents calls changed with the name of every host that changed until stop is closed.
+	// It returns right away if the cloud provider isn't configured to watch its hosts.
+	WatchHostEvents(stop <-chan struct{}, changed func(name string))
+}
+
+// Pinger is implemented by cloud providers that can check the connectivity to their API
+type Pinger interface {
+	// Ping returns an error if the API of the cloud provider can't be reached with the configured
+	// credentials
+	Ping() error
+}
+
+type CloudNodeController struct {
+	nodeInformer coreinformers.NodeInformer
+	kubeClient   clientset.Interface
+	recorder     record.EventRecorder
+	// eventWatches deliver the recorded events, they're stopped when Run returns
+	eventWatches []watch.Interface
+
+	cloud cloudprovider.Interface
+
+	// Calls to the cloud fail after cloudCallTimeout, unless it's zero, or once ctx is canceled
+	// when the controller stops
+	cloudCallTimeout time.Duration
+	ctx              context.Context
+	cancel           context.CancelFunc
+
+	// Value controlling NodeController monitoring period, i.e. how often does NodeController
+	// check node status posted from kubelet. This value should be lower than nodeMonitorGracePeriod
+	// set in controller-manager
+	nodeMonitorPeriod time.Duration
+
+	// How often the addresses of all nodes are updated
+	nodeStatusUpdateFrequency time.Duration
+
+	// If false, nodes whose host is missing from the cloud are reported but never deleted
+	enableNodeDeletion bool
+
+	// Nodes younger than this are never deleted
+	nodeDeletionMinimumAge time.Duration
+
+	// Nodes are deleted once their host was missing from the cloud nodeDeletionMissingChecks
+	// monitor periods in a row. missing holds the number of periods each host has been missing for.
+	nodeDeletionMissingChecks int
+	missingLock               sync.Mutex
+	missing                   map[string]int
+
+	// If true, node addresses are replaced with the cloud addresses instead of merged with them
+	replaceAddresses bool
+
+	// providedNodeIPKey replaces the keys of the provided node IP annotation and label, unless empty
+	providedNodeIPKey string
+	// invalidIPs holds the invalid provided IP entries last reported for each node, to record
+	// the warning event only when they change
+	invalidIPsLock sync.Mutex
+	invalidIPs     map[string]string
+
+	// If true, the cloud taint is kept until the cloud reports an IP address for the node
+	waitForNodeAddresses bool
+
+	// If true, nodes registered without the cloud taint are initialized too, once
+	initializeUntaintedNodes bool
+
+	// If true, the addresses and labels of unschedulable nodes are not updated.
+	// skipped holds the nodes skipped for that reason, to log only when they start or stop being skipped.
+	skipCordonedNodes bool
+	skippedLock       sync.Mutex
+	skipped           map[string]bool
+
+	// topologyLabels tells which families of zone and region labels are written
+	topologyLabels TopologyLabelPolicy
+
+	// metadataLabels are the instance metadata fields labelled on nodes when they're initialized
+	metadataLabels []string
+
+	// hostLabels are the patterns of the host labels synced onto nodes, with hostLabelPrefix
+	// prepended to their keys
+	hostLabels      []string
+	hostLabelPrefix string
+
+	// Nodes created before manageNodesCreatedAfter are left alone, unless it's zero.
+	// ignored holds the nodes left alone for that reason, to log them once.
+	manageNodesCreatedAfter time.Time
+	ignoredLock             sync.Mutex
+	ignored                 map[string]bool
+
+	// loopJitter is the jitter factor of the periods of the node status and monitor loops
+	loopJitter float64
+
+	// waiting holds the nodes the cloud has not reported IP addresses for yet.
+	// Their lookups are retried with addressBackoff.
+	waitingLock    sync.Mutex
+	waiting        map[string]bool
+	addressBackoff *flowcontrol.Backoff
+
+	// failing holds the nodes whose addresses the cloud failed to look up, e.g. because their
+	// host is gone while the node lingers. Their lookups are retried with lookupBackoff.
+	failingLock   sync.Mutex
+	failing       map[string]bool
+	lookupBackoff *flowcontrol.Backoff
+
+	// queue holds the names of the nodes to update, concurrentNodeSyncs workers update them
+	queue               workqueue.RateLimitingInterface
+	concurrentNodeSyncs int
+
+	// deletionQueue holds the nodes to delete because their host is gone from the cloud
+	deletionQueue workqueue.RateLimitingInterface
+
+	// If true, the nodes to delete are cordoned and their pods evicted first, with drainGracePeriod
+	// to terminate
+	drainNodes       bool
+	drainGracePeriod time.Duration
+
+	// running is set while Run updates the nodes, if not nil
+	running *int32
+}
+
+// nodeDeletion identifies a node to delete. A node registered again with the same name is a
+// different node, and is left alone.
+type nodeDeletion struct {
+	name       string
+	uid        types.UID
+	providerID string
+}
+
+const (
+	//Taint denoting that a node needs to be processed by external cloudprovider
+	CloudTaintKey = "ExternalCloudProvider"
+
+	// ShutdownTaintKey is the key of the taint keeping pods off nodes whose instance is shut down
+	ShutdownTaintKey = "node.cloudprovider.kubernetes.io/shutdown"
+
+	// concurrentNodeDeletions is the number of workers deleting nodes. It bounds the deletions sent
+	// to the API server when many hosts seem to be gone at once, e.g. during a cloud outage.
+	concurrentNodeDeletions = 2
+
+	// maxNodeSyncRetries is how many times a failed node update is retried before the next pass
+	maxNodeSyncRetries = 5
+
+	// Backoff of the address lookups of nodes the cloud has not reported IP addresses for yet
+	initialAddressBackoff = 10 * time.Second
+	maxAddressBackoff     = 5 * time.Minute
+
+	// Backoff of the address lookups of nodes the cloud keeps failing to look up
+	initialLookupBackoff = 10 * time.Second
+	maxLookupBackoff     = 5 * time.Minute
+
+	// LabelProvidedIPAddr is the provided node IP label of older kubelets and provisioning tools. It's
+	// consulted when the annotation is absent.
+	LabelProvidedIPAddr = "beta.kubernetes.io/provided-node-ip"
+
+	// AnnotationProvidedIPAddr is the provided node IP annotation kubelets publish. It takes precedence
+	// over the label and, unlike it, can hold a comma-separated list of IPv4 and IPv6 addresses.
+	AnnotationProvidedIPAddr = "alpha.kubernetes.io/provided-node-ip"
+
+	// LabelEnvironment is the name of the environment of the node, for selecting and joining on
+	LabelEnvironment = "rancher.io/environment"
+	// AnnotationEnvironmentID is the ID of the environment of the node
+	AnnotationEnvironmentID = "rancher.io/environment-id"
+
+	// AnnotationInitialized marks nodes registered without the cloud taint that have been initialized
+	AnnotationInitialized = "rancher.io/cloud-node-initialized"
+
+	// AnnotationSkipNodeDeletion set to "true" keeps a node from being deleted when it's missing from
+	// the cloud, e.g. for machines the cloud doesn't know about
+	AnnotationSkipNodeDeletion = "rancher.io/skip-node-deletion"
+
+	// AnnotationManagedAddresses records the addresses the cloud last reported for a node, as a JSON
+	// list. Only these are ever replaced or removed by the controller, the other addresses of the
+	// node are kept, whatever their type.
+	AnnotationManagedAddresses = "rancher.io/managed-addresses"
+)
+
+// errNoProviderID is returned when initializing a node the kubelet hasn't set the providerID of yet, and
+// the cloud can't find the instance of
+var errNoProviderID = fmt.Errorf("Node does not have providerID set. Cannot continue processing node.")
+
+// cloudTaintKeys are the keys of the taints marking nodes that wait to be initialized by the controller
+var cloudTaintKeys = []string{CloudTaintKey}
+
+// labelValueChars matches the characters not allowed in label values
+var labelValueChars = regexp.MustCompile("[^-A-Za-z0-9_.]+")
+
+// NewCloudNodeController creates a CloudNodeController object. It returns an error if the options
+// are invalid.
+func NewCloudNodeController(
+	nodeInformer coreinformers.NodeInformer,
+	kubeClient clientset.Interface,
+	cloud cloudprovider.Interface,
+	options CloudNodeControllerOptions) (*CloudNodeController, error) {
+
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	recorder := eventBroadcaster.NewRecorder(api.Scheme, clientv1.EventSource{Component: "cloudcontrollermanager"})
+	eventWatches := []watch.Interface{eventBroadcaster.StartLogging(glog.Infof)}
+	if kubeClient != nil {
+		glog.V(0).Infof("Sending events to api server.")
+		eventWatches = append(eventWatches,
+			eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: v1core.New(kubeClient.Core().RESTClient()).Events("")}))
+	} else {
+		glog.V(0).Infof("No api server defined - no events will be sent to API server.")
+	}
+
+	// The requests of the cloud time out too, calls timing out don't pile up in the background
+	if setter, ok := cloud.(RequestTimeoutSetter); ok && options.CloudCallTimeout > 0 {
+		setter.SetRequestTimeout(options.CloudCallTimeout)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cnc := &CloudNodeController{
+		nodeInformer:              nodeInformer,
+		kubeClient:                kubeClient,
+		recorder:                  recorder,
+		eventWatches:              eventWatches,
+		cloud:                     cloud,
+		cloudCallTimeout:          options.CloudCallTimeout,
+		ctx:                       ctx,
+		cancel:                    cancel,
+		nodeMonitorPeriod:         options.NodeMonitorPeriod,
+		nodeStatusUpdateFrequency: options.NodeStatusUpdateFrequency,
+		enableNodeDeletion:        options.EnableNodeDeletion,
+		nodeDeletionMinimumAge:    options.NodeDeletionMinimumAge,
+		nodeDeletionMissingChecks: options.NodeDeletionMissingChecks,
+		missing:                   map[string]int{},
+		replaceAddresses:          options.ReplaceAddresses,
+		providedNodeIPKey:         options.ProvidedNodeIPKey,
+		invalidIPs:                map[string]string{},
+		waitForNodeAddresses:      options.WaitForNodeAddresses,
+		initializeUntaintedNodes:  options.InitializeUntaintedNodes,
+		skipCordonedNodes:         options.SkipCordonedNodes,
+		topologyLabels:            options.TopologyLabels,
+		metadataLabels:            options.MetadataLabels,
+		hostLabels:                options.HostLabels,
+		hostLabelPrefix:           options.HostLabelPrefix,
+		manageNodesCreatedAfter:   options.ManageNodesCreatedAfter,
+		ignored:                   map[string]bool{},
+		loopJitter:                options.LoopJitter,
+		skipped:                   map[string]bool{},
+		waiting:                   map[string]bool{},
+		addressBackoff:            flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+		failing:                   map[string]bool{},
+		lookupBackoff:             flowcontrol.NewBackOff(initialLookupBackoff, maxLookupBackoff),
+		queue:                     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "node"),
+		concurrentNodeSyncs:       options.ConcurrentNodeSyncs,
+		deletionQueue:             workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "node-deletion"),
+		drainNodes:                options.DrainNodes,
+		drainGracePeriod:          options.DrainGracePeriod,
+		running:                   options.Running,
+	}
+
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    cnc.AddCloudNode,
+		UpdateFunc: cnc.UpdateCloudNode,
+		DeleteFunc: cnc.DeleteCloudNode,
+	})
+
+	return cnc, nil
+}
+
+// This controller deletes a node if kubelet is not reporting
+// and the node is gone from the cloud provider. It runs until stopCh is closed, then cancels the
+// calls to the cloud in flight and waits for the node updates and deletions to complete.
+func (cnc *CloudNodeController) Run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	defer cnc.stopEvents()
+
+	glog.Infof("Starting cloud node controller")
+	defer glog.Infof("Shutting down cloud node controller")
+
+	// Both loops read the nodes from the informer cache
+	if !cache.WaitForCacheSync(stopCh, cnc.nodeInformer.Informer().HasSynced) {
+		utilruntime.HandleError(fmt.Errorf("timed out waiting for the node cache to sync"))
+		return
+	}
+
+	// Start the workers updating the node addresses obtained from the cloud, and a loop to
+	// periodically queue all nodes for them
+	workersDone := []<-chan struct{}{}
+	for i := 0; i < cnc.concurrentNodeSyncs; i++ {
+		workersDone = append(workersDone, supervisor.Default.Go(fmt.Sprintf("node-sync-%d", i), cnc.runWorker))
+	}
+	statusDone := supervisor.Default.JitterUntil("node-status", cnc.enqueueNodes, cnc.nodeStatusUpdateFrequency, cnc.loopJitter, stopCh)
+
+	// Start a loop to periodically check if any nodes have been deleted from cloudprovider, and the
+	// workers deleting them
+	monitorDone := supervisor.Default.JitterUntil("node-monitor", cnc.MonitorNode, cnc.nodeMonitorPeriod, cnc.loopJitter, stopCh)
+	deletionsDone := []<-chan struct{}{}
+	for i := 0; i < concurrentNodeDeletions; i++ {
+		deletionsDone = append(deletionsDone, supervisor.Default.Go(fmt.Sprintf("node-deletion-%d", i), cnc.runDeletionWorker))
+	}
+	cnc.setRunning(true)
+
+	<-stopCh
+	cnc.setRunning(false)
+	// Don't leave nodes half patched. The workers finish the updates they started, the outstanding
+	// calls to the cloud fail right away.
+	if cnc.cancel != nil {
+		cnc.cancel()
+	}
+	cnc.queue.ShutDown()
+	<-statusDone
+	for _, done := range workersDone {
+		<-done
+	}
+	<-monitorDone
+	cnc.deletionQueue.ShutDown()
+	for _, done := range deletionsDone {
+		<-done
+	}
+}
+
+// setRunning updates the running flag, if any
+func (cnc *CloudNodeController) setRunning(running bool) {
+	if cnc.running == nil {
+		return
+	}
+	var value int32
+	if running {
+		value = 1
+	}
+	atomic.StoreInt32(cnc.running, value)
+}
+
+// stopEvents stops delivering the recorded events
+func (cnc *CloudNodeController) stopEvents() {
+	for _, w := range cnc.eventWatches {
+		w.Stop()
+	}
+}
+
+// UpdateNodeStatus updates the node addresses of all nodes with the addresses obtained from the cloud,
+// in the calling goroutine. It forces an immediate pass, Run queues the nodes for its workers instead.
+func (cnc *CloudNodeController) UpdateNodeStatus() {
+	instances, ok := cnc.instances()
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("failed to get instances from cloud provider"))
+		return
+	}
+
+	nodes, err := cnc.nodeInformer.Lister().List(labels.Everything())
+	if err != nil {
+		glog.Errorf("Error monitoring node status: %v", err)
+		return
+	}
+
+	for _, node := range nodes {
+		if err := cnc.updateNode(instances, node); err != nil {
+			glog.Errorf("Error updating node %s: %v", node.Name, err)
+		}
+	}
+}
+
+// enqueueNodes queues all nodes for an update of their addresses by the workers. The tainted nodes
+// are initialized instead.
+func (cnc *CloudNodeController) enqueueNodes() {
+	nodes, err := cnc.nodeInformer.Lister().List(labels.Everything())
+	if err != nil {
+		glog.Errorf("Error monitoring node status: %v", err)
+		return
+	}
+	for _, node := range nodes {
+		cnc.queue.Add(node.Name)
+	}
+}
+
+// runWorker updates the nodes taken from the queue until it's shut down
+func (cnc *CloudNodeController) runWorker() {
+	for cnc.processNextNode() {
+	}
+}
+
+// processNextNode updates the next node of the queue. Failed updates are retried with backoff up
+// to maxNodeSyncRetries times, then the node waits for the next pass. Nodes without a providerID
+// are retried until they have one. It returns false once the queue is shut down.
+func (cnc *CloudNodeController) processNextNode() bool {
+	key, quit := cnc.queue.Get()
+	if quit {
+		return false
+	}
+	defer cnc.queue.Done(key)
+
+	err := cnc.syncNode(key.(string))
+	if err == nil {
+		cnc.queue.Forget(key)
+		return true
+	}
+	// The kubelet sets the providerID some time after registering, wait for it as long as it takes
+	if err == errNoProviderID {
+		glog.V(2).Infof("Node %s has no providerID yet, retrying", key)
+		cnc.queue.AddRateLimited(key)
+		return true
+	}
+	if cnc.queue.NumRequeues(key) < maxNodeSyncRetries {
+		glog.V(2).Infof("Error updating node %s, retrying: %v", key, err)
+		cnc.queue.AddRateLimited(key)
+		return true
+	}
+	glog.Errorf("Error updating node %s, giving up until the next pass: %v", key, err)
+	if _, ok := err.(*lookupError); ok {
+		cnc.lookupFailed(key.(string))
+	}
+	if initErr, ok := err.(*initializationError); ok {
+		cnc.recorder.Eventf(nodeRef(initErr.node.Name, initErr.node.UID), v1.EventTypeWarning, EventCloudNodeInitializationFailed,
+			"Failed to initialize node %s after %d retries: %v", initErr.node.Name, maxNodeSyncRetries, initErr.err)
+	}
+	cnc.queue.Forget(key)
+	return true
+}
+
+// syncNode updates the node with the given name, read from the informer cache
+func (cnc *CloudNodeController) syncNode(name string) error {
+	instances, ok := cnc.instances()
+	if !ok {
+		return fmt.Errorf("failed to get instances from cloud provider")
+	}
+	node, err := cnc.nodeInformer.Lister().Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return cnc.updateNode(instances, node)
+}
+
+// UpdateNode updates the addresses and labels of a single node right away, outside the periodic pass.
+// It returns a NotFound error if the node doesn't exist.
+func (cnc *CloudNodeController) UpdateNode(name string) error {
+	instances, ok := cnc.instances()
+	if !ok {
+		return fmt.Errorf("failed to get instances from cloud provider")
+	}
+
+	node, err := cnc.kubeClient.Core().Nodes().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	// A resync is requested when the host changed, don't make it wait for the backoffs
+	cnc.addressBackoff.Reset(name)
+	cnc.lookupSucceeded(name)
+	return cnc.updateNode(instances, node)
+}
+
+// HostChanged updates the node named name right away after its host changed in the cloud
+// provider: its addresses, and its deletion or shutdown taint if it's not reporting. Hosts without
+// a node are ignored.
+func (cnc *CloudNodeController) HostChanged(name string) error {
+	instances, ok := cnc.instances()
+	if !ok {
+		return fmt.Errorf("failed to get instances from cloud provider")
+	}
+
+	node, err := cnc.nodeInformer.Lister().Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cnc.addressBackoff.Reset(name)
+	cnc.lookupSucceeded(name)
+	cnc.monitorNode(instances, node)
+	err = cnc.updateNode(instances, node)
+	if lookup, ok := err.(*lookupError); ok && lookup.err == cloudprovider.InstanceNotFound {
+		// the host was removed, its node is left to the monitoring above
+		return nil
+	}
+	return err
+}
+
+// updateNode updates the addresses of node, or initializes it if its initialization is pending.
+// It returns the errors worth retrying the update for.
+func (cnc *CloudNodeController) updateNode(instances cloudprovider.Instances, node *v1.Node) error {
+	if !cnc.manages(node) {
+		return nil
+	}
+
+	// Do not process nodes that are still tainted
+	cloudTaint, err := getCloudTaint(node)
+	if err != nil {
+		glog.Errorf("could not get taints from node %s", node.Name)
+		return nil
+	}
+
+	// Nodes still tainted are initialized from here, unless they wait for addresses. Their
+	// initialization failed or was never attempted since they were added.
+	if cloudTaint != nil {
+		if cnc.waitingForAddresses(node.Name) && cnc.inAddressBackoff(node.Name) {
+			glog.V(5).Infof("Node %s is still tainted, waiting for its addresses", node.Name)
+			return nil
+		}
+		return cnc.initializeNode(node)
+	}
+	if cnc.needsUntaintedInitialization(node) && !cnc.inAddressBackoff(node.Name) {
+		return cnc.initializeNode(node)
+	}
+	if cnc.skipCordoned(node) {
+		return nil
+	}
+	if cnc.inAddressBackoff(node.Name) || cnc.inLookupBackoff(node.Name) {
+		return nil
+	}
+
+	err = cnc.syncNodeAddresses(instances, node)
+	nodeAddressSyncs.WithLabelValues(resultLabel(err)).Inc()
+	return err
+}
+
+// syncNodeAddresses patches the addresses of node with those reported by the cloud
+func (cnc *CloudNodeController) syncNodeAddresses(instances cloudprovider.Instances, node *v1.Node) error {
+	nodeAddresses, err := instances.NodeAddressesByProviderID(node.Spec.ProviderID)
+	if err != nil {
+		if _, ok := err.(InvalidProviderIDError); ok {
+			glog.V(4).Infof("Looking up the addresses of node %s by name: %v", node.Name, err)
+		} else {
+			glog.V(2).Infof("failed to get addresses of node %s by providerID, looking them up by name: %v", node.Name, err)
+		}
+		nodeAddresses, err = instances.NodeAddresses(types.NodeName(node.Name))
+		if err != nil {
+			return &lookupError{err: err}
+		}
+	}
+	cnc.lookupSucceeded(node.Name)
+	if !hasIPAddress(nodeAddresses) {
+		cnc.waitForAddresses(node)
+		return nil
+	}
+	cnc.addressesReported(node.Name)
+
+	if err := cnc.patchNodeAddresses(node, nodeAddresses); err != nil {
+		return fmt.Errorf("failed to patch node with cloud ip addresses: %v", err)
+	}
+	return nil
+}
+
+// patchNodeAddresses patches the addresses of node to match the addresses reported by the cloud.
+// If the patch conflicts with another update the addresses are recomputed against a fresh copy
+// of the node and patched again.
+func (cnc *CloudNodeController) patchNodeAddresses(node *v1.Node, cloudAddresses []v1.NodeAddress) error {
+	first := true
+	return clientretry.RetryOnConflict(UpdateNodeStatusBackoff, func() error {
+		if !first {
+			var err error
+			node, err = cnc.kubeClient.Core().Nodes().Get(node.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+		}
+		first = false
+
+		nodeAddresses, err := cnc.desiredNodeAddresses(node, cloudAddresses)
+		if err != nil {
+			return err
+		}
+		nodeCopy, err := api.Scheme.DeepCopy(node)
+		if err != nil {
+			return fmt.Errorf("failed to copy node to a new object")
+		}
+		newNode := nodeCopy.(*v1.Node)
+		newNode.Status.Addresses = nodeAddresses
+		recordManagedAddresses(newNode, cloudAddresses)
+		if labels, err := cnc.cloudLabels(newNode); err != nil {
+			glog.Errorf("failed to get labels of node %s from cloud provider: %v", node.Name, err)
+		} else {
+			cnc.syncLabels(newNode, labels)
+		}
+		addressesChanged := !nodeAddressesEqual(node.Status.Addresses, newNode.Status.Addresses)
+		metadataChanged := !stringMapsEqual(node.Labels, newNode.Labels) || !stringMapsEqual(node.Annotations, newNode.Annotations)
+		// Don't churn the resource version of nodes that are up to date
+		if !addressesChanged && !metadataChanged {
+			glog.V(5).Infof("Addresses and labels of node %s are up to date, not patching it", node.Name)
+			nodeStatusPatchesSkipped.Inc()
+			return nil
+		}
+
+		// The labels and their bookkeeping annotation are written to the node object, the status
+		// subresource ignores them
+		if metadataChanged {
+			err = patchNode(cnc.kubeClient, node, newNode)
+			if errors.IsConflict(err) {
+				glog.V(2).Infof("Conflict patching labels of node %s, retrying with a fresh copy", node.Name)
+				nodeStatusPatchConflicts.Inc()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if !addressesChanged {
+			return nil
+		}
+
+		statusNode := *node
+		statusNode.Status.Addresses = newNode.Status.Addresses
+		err = patchNodeStatus(cnc.kubeClient, node, &statusNode)
+		if errors.IsConflict(err) {
+			glog.V(2).Infof("Conflict patching addresses of node %s, retrying with a fresh copy", node.Name)
+			nodeStatusPatchConflicts.Inc()
+		}
+		if err == nil {
+			cnc.recorder.Eventf(nodeRef(node.Name, node.UID), v1.EventTypeNormal, EventNodeAddressesChanged,
+				"Node addresses changed from %s to %s", addressStrings(node), addressStrings(&statusNode))
+		}
+		return err
+	})
+}
+
+// patchNodeStatus patches the status of oldNode to that of newNode. Unlike nodeutil.PatchNodeStatus
+// it returns the error of the API call as is, so callers can tell conflicts apart.
+func patchNodeStatus(c clientset.Interface, oldNode, newNode *v1.Node) error {
+	oldData, err := json.Marshal(oldNode)
+	if err != nil {
+		return fmt.Errorf("failed to marshal old node %#v for node %q: %v", oldNode, oldNode.Name, err)
+	}
+
+	// Reset spec to make sure only patch for Status or ObjectMeta is generated.
+	newNode.Spec = oldNode.Spec
+	newData, err := json.Marshal(newNode)
+	if err != nil {
+		return fmt.Errorf("failed to marshal new node %#v for node %q: %v", newNode, oldNode.Name, err)
+	}
+
+	patchBytes, err := strategicpatch.CreateTwoWayMergePatch(oldData, newData, v1.Node{})
+	if err != nil {
+		return fmt.Errorf("failed to create patch for node %q: %v", oldNode.Name, err)
+	}
+	if glog.V(5) {
+		glog.Infof("Patching node %s (%d bytes): %s", oldNode.Name, len(patchBytes), nodeDiff(oldNode, newNode))
+	}
+
+	nodePatches.WithLabelValues("status").Inc()
+	_, err = c.Core().Nodes().Patch(oldNode.Name, types.StrategicMergePatchType, patchBytes, "status")
+	return err
+}
+
+// desiredNodeAddresses returns the addresses node should have given the addresses reported by the cloud
+func (cnc *CloudNodeController) desiredNodeAddresses(node *v1.Node, nodeAddresses []v1.NodeAddress) ([]v1.NodeAddress, error) {
+	// The provider owns the addresses it reports, even those dropped for a provided IP below, and
+	// those it reported before. Nodes it didn't record them for yet lose all the addresses of the
+	// types it reports instead.
+	owned, recorded := managedAddresses(node)
+	owned = append(owned, nodeAddresses...)
+	managedTypes := map[v1.NodeAddressType]bool{}
+	if !recorded {
+		managedTypes = addressTypes(nodeAddresses)
+	}
+	nodeIPs := cnc.providedNodeIPs(node)
+	// Check if a hostname address exists in the cloud provided addresses
+	hostnameExists := false
+	for i := range nodeAddresses {
+		if nodeAddresses[i].Type == v1.NodeHostName {
+			hostnameExists = true
+		}
+	}
+	// If hostname was not present in cloud provided addresses, use the hostname
+	// from the existing node (populated by kubelet)
+	var hostnameAddress *v1.NodeAddress
+	if !hostnameExists {
+		// index the slice, the address of the range variable would change with each address
+		for i := range node.Status.Addresses {
+			if node.Status.Addresses[i].Type == v1.NodeHostName {
+				hostnameAddress = &node.Status.Addresses[i]
+				break
+			}
+		}
+	}
+	// If node IPs were suggested by user, ensure that
+	// they can be found in the cloud as well (consistent with the behaviour in kubelet)
+	if len(nodeIPs) > 0 {
+		providedIPs, err := matchProvidedIPs(nodeIPs, nodeAddresses)
+		if err != nil {
+			return nil, err
+		}
+		// Only the other IP addresses are dropped, the hostname and DNS names still apply
+		for _, addr := range nodeAddresses {
+			if !isIPAddress(addr) {
+				providedIPs = append(providedIPs, addr)
+			}
+		}
+		nodeAddresses = providedIPs
+	}
+	if hostnameAddress != nil {
+		nodeAddresses = append(nodeAddresses, *hostnameAddress)
+		if !recorded {
+			managedTypes[v1.NodeHostName] = true
+		}
+	}
+	if !cnc.replaceAddresses {
+		nodeAddresses = mergeNodeAddresses(node.Status.Addresses, nodeAddresses, owned, managedTypes)
+	}
+	return nodeAddresses, nil
+}
+
+// hasIPAddress tells whether addresses has an IP address, the cloud reports at least a hostname
+// for hosts that don't have networking configured yet
+func hasIPAddress(addresses []v1.NodeAddress) bool {
+	for _, addr := range addresses {
+		if isIPAddress(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIPAddress tells whether addr is an IP address rather than a hostname or a DNS name
+func isIPAddress(addr v1.NodeAddress) bool {
+	switch addr.Type {
+	case v1.NodeInternalIP, v1.NodeExternalIP, v1.NodeLegacyHostIP:
+		return true
+	}
+	return false
+}
+
+// waitForAddresses backs off the address lookups of node, which has no IP addresses yet.
+// An event is recorded the first time only, so waiting nodes don't flood the log.
+func (cnc *CloudNodeController) waitForAddresses(node *v1.Node) {
+	cnc.waitingLock.Lock()
+	first := !cnc.waiting[node.Name]
+	cnc.waiting[node.Name] = true
+	cnc.waitingLock.Unlock()
+	cnc.addressBackoff.Next(node.Name, time.Now())
+
+	if first {
+		glog.V(2).Infof("Cloud provider reported no addresses for node %s yet", node.Name)
+		ref := nodeRef(node.Name, node.UID)
+		cnc.recorder.Eventf(ref, v1.EventTypeNormal, EventWaitingForAddresses, "waiting for cloud provider to report addresses")
+	}
+}
+
+// addressesReported clears the waiting state of a node the cloud reported IP addresses for
+func (cnc *CloudNodeController) addressesReported(nodeName string) {
+	cnc.waitingLock.Lock()
+	defer cnc.waitingLock.Unlock()
+	if cnc.waiting[nodeName] {
+		glog.V(2).Infof("Cloud provider reported addresses for node %s", nodeName)
+		delete(cnc.waiting, nodeName)
+		cnc.addressBackoff.Reset(nodeName)
+	}
+}
+
+func (cnc *CloudNodeController) waitingForAddresses(nodeName string) bool {
+	cnc.waitingLock.Lock()
+	defer cnc.waitingLock.Unlock()
+	return cnc.waiting[nodeName]
+}
+
+// inAddressBackoff tells whether the addresses of a waiting node should not be looked up yet
+func (cnc *CloudNodeController) inAddressBackoff(nodeName string) bool {
+	return cnc.waitingForAddresses(nodeName) && cnc.addressBackoff.IsInBackOffSinceUpdate(nodeName, time.Now())
+}
+
+// lookupError is the error of a failed lookup of the addresses of a node
+type lookupError struct {
+	err error
+}
+
+func (e *lookupError) Error() string {
+	return fmt.Sprintf("failed to get node address from cloud provider: %v", e.err)
+}
+
+// lookupFailed backs off the address lookups of a node the cloud failed to look up, after the
+// retries of a sync. Each time the backoff expires and the lookups still fail, it doubles.
+func (cnc *CloudNodeController) lookupFailed(nodeName string) {
+	cnc.failingLock.Lock()
+	defer cnc.failingLock.Unlock()
+	if !cnc.failing[nodeName] {
+		glog.V(2).Infof("Backing off the address lookups of node %s until they succeed", nodeName)
+		cnc.failing[nodeName] = true
+		nodesInLookupBackoff.Set(float64(len(cnc.failing)))
+	}
+	cnc.lookupBackoff.Next(nodeName, time.Now())
+}
+
+// lookupSucceeded clears the backoff of the address lookups of a node
+func (cnc *CloudNodeController) lookupSucceeded(nodeName string) {
+	cnc.failingLock.Lock()
+	defer cnc.failingLock.Unlock()
+	if cnc.failing[nodeName] {
+		glog.V(2).Infof("Address lookup of node %s succeeded again", nodeName)
+		delete(cnc.failing, nodeName)
+		nodesInLookupBackoff.Set(float64(len(cnc.failing)))
+		cnc.lookupBackoff.Reset(nodeName)
+	}
+}
+
+// inLookupBackoff tells whether the addresses of a node the cloud failed to look up should not
+// be looked up yet
+func (cnc *CloudNodeController) inLookupBackoff(nodeName string) bool {
+	cnc.failingLock.Lock()
+	defer cnc.failingLock.Unlock()
+	return cnc.failing[nodeName] && cnc.lookupBackoff.IsInBackOffSinceUpdate(nodeName, time.Now())
+}
+
+// addressTypes returns the set of types of addresses
+func addressTypes(addresses []v1.NodeAddress) map[v1.NodeAddressType]bool {
+	types := map[v1.NodeAddressType]bool{}
+	for _, addr := range addresses {
+		types[addr.Type] = true
+	}
+	return types
+}
+
+// mergeNodeAddresses returns the cloud addresses followed by the current addresses the cloud
+// doesn't own, neither by value nor by type, e.g. an InternalIP or the DNS names reported by
+// kubelet, so addresses published by other components are kept. Both keep their order, so the
+// result only changes with its inputs.
+func mergeNodeAddresses(current, cloud, owned []v1.NodeAddress, ownedTypes map[v1.NodeAddressType]bool) []v1.NodeAddress {
+	merged := append([]v1.NodeAddress{}, cloud...)
+	for _, addr := range current {
+		if ownedTypes[addr.Type] || hasNodeAddress(owned, addr) || hasNodeAddress(merged, addr) {
+			continue
+		}
+		merged = append(merged, addr)
+	}
+	return merged
+}
+
+// hasNodeAddress tells whether addresses has addr
+func hasNodeAddress(addresses []v1.NodeAddress, addr v1.NodeAddress) bool {
+	for _, a := range addresses {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// managedAddresses returns the addresses the cloud last reported for node, and false if they
+// weren't recorded
+func managedAddresses(node *v1.Node) ([]v1.NodeAddress, bool) {
+	data, ok := node.Annotations[AnnotationManagedAddresses]
+	if !ok {
+		return nil, false
+	}
+	addresses := []v1.NodeAddress{}
+	if err := json.Unmarshal([]byte(data), &addresses); err != nil {
+		glog.Errorf("Ignoring invalid %s annotation of node %s: %v", AnnotationManagedAddresses, node.Name, err)
+		return nil, false
+	}
+	return addresses, true
+}
+
+// recordManagedAddresses records addresses as the addresses the cloud reported for node, keeping
+// the record as is if only their order changed
+func recordManagedAddresses(node *v1.Node, addresses []v1.NodeAddress) {
+	if recorded, ok := managedAddresses(node); ok && nodeAddressesEqual(recorded, addresses) {
+		return
+	}
+	data, err := json.Marshal(addresses)
+	if err != nil {
+		glog.Errorf("failed to record the addresses of node %s: %v", node.Name, err)
+		return
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[AnnotationManagedAddresses] = string(data)
+}
+
+// nodeAddressesEqual tells whether a and b hold the same addresses, in any order
+func nodeAddressesEqual(a, b []v1.NodeAddress) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := map[v1.NodeAddress]int{}
+	for _, addr := range a {
+		counts[addr]++
+	}
+	for _, addr := range b {
+		if counts[addr] == 0 {
+			return false
+		}
+		counts[addr]--
+	}
+	return true
+}
+
+// MonitorNode deletes nodes that are not reporting and are gone from the cloud provider
+func (cnc *CloudNodeController) MonitorNode() {
+	instances, ok := cnc.instances()
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("failed to get instances from cloud provider"))
+		return
+	}
+
+	nodes, err := cnc.nodeInformer.Lister().List(labels.Everything())
+	if err != nil {
+		glog.Errorf("Error monitoring node status: %v", err)
+		return
+	}
+
+	observeUninitializedNodes(nodes, time.Now())
+	cnc.observeIgnoredNodes(nodes)
+
+	for _, node := range nodes {
+		cnc.monitorNode(instances, node)
+	}
+}
+
+// monitorNode queues the deletion of node if it's not reporting and its host is gone from the
+// cloud provider, and taints it if its host is shut down
+func (cnc *CloudNodeController) monitorNode(instances cloudprovider.Instances, node *v1.Node) {
+	if !cnc.manages(node) {
+		return
+	}
+	// If node status is empty, then kubelet has not posted ready status yet. The node is left
+	// alone until a later pass finds its ready condition in the informer cache.
+	_, currentReadyCondition := v1.GetNodeCondition(&node.Status, v1.NodeReady)
+	if currentReadyCondition == nil {
+		glog.V(4).Infof("Node %s has no ready condition yet, skipping it this pass", node.Name)
+		return
+	}
+	// If the known node status says that Node is NotReady, then check if the node has been removed
+	// from the cloud provider. If node cannot be found in cloudprovider for long enough, then delete the node
+	if currentReadyCondition.Status == v1.ConditionTrue {
+		cnc.hostFound(node.Name)
+		cnc.setShutdownTaint(node, false)
+		return
+	}
+	if cnc.tooYoungForDeletion(node) || cnc.exemptFromDeletion(node) {
+		return
+	}
+	// Check with the cloud provider to see if the node still exists. If it
+	// doesn't, delete the node once it has been missing for enough checks.
+	exists, err := cnc.instanceExists(instances, node.Name, node.Spec.ProviderID)
+	if _, invalid := err.(InvalidProviderIDError); invalid {
+		glog.V(2).Infof("Not checking whether node %s is missing from the cloud, its providerID isn't one of the cloud: %v", node.Name, err)
+		return
+	}
+	if err != nil {
+		if ambiguous, ok := err.(AmbiguousInstanceError); ok {
+			ref := nodeRef(node.Name, node.UID)
+			cnc.recorder.Eventf(ref, v1.EventTypeWarning, EventAmbiguousInstance,
+				"Not deleting node %s, it matches several instances in the cloud provider: %s",
+				node.Name, strings.Join(ambiguous.Candidates(), ", "))
+			glog.Warningf("Not deleting node %s: %v", node.Name, err)
+			return
+		}
+		glog.Errorf("Error getting node data from cloud: %v", err)
+		return
+	}
+	if exists {
+		cnc.hostFound(node.Name)
+		// Hosts that are shut down may come back, their nodes are tainted instead of deleted
+		if shutdown, err := cnc.instanceShutdown(node); err != nil {
+			glog.Errorf("Error checking whether the host of node %s is shut down: %v", node.Name, err)
+		} else {
+			cnc.setShutdownTaint(node, shutdown)
+		}
+		return
+	}
+	if !cnc.hostMissing(node) {
+		return
+	}
+	cnc.deletionQueue.Add(nodeDeletion{name: node.Name, uid: node.UID, providerID: node.Spec.ProviderID})
+}
+
+// runDeletionWorker deletes the nodes taken from the deletion queue until it's shut down
+func (cnc *CloudNodeController) runDeletionWorker() {
+	for cnc.processNextDeletion() {
+	}
+}
+
+// processNextDeletion deletes the next node of the deletion queue. Failed deletions are retried
+// with backoff up to maxNodeSyncRetries times, then the node waits for the next monitor pass to
+// find its host missing again. It returns false once the queue is shut down.
+func (cnc *CloudNodeController) processNextDeletion() bool {
+	item, quit := cnc.deletionQueue.Get()
+	if quit {
+		return false
+	}
+	defer cnc.deletionQueue.Done(item)
+
+	deletion := item.(nodeDeletion)
+	err := cnc.deleteNode(deletion)
+	if err == nil {
+		cnc.deletionQueue.Forget(item)
+		return true
+	}
+	if cnc.deletionQueue.NumRequeues(item) < maxNodeSyncRetries {
+		glog.V(2).Infof("Error deleting node %s, retrying: %v", deletion.name, err)
+		cnc.deletionQueue.AddRateLimited(item)
+		return true
+	}
+	glog.Errorf("Error deleting node %s, giving up until the next pass: %v", deletion.name, err)
+	cnc.deletionQueue.Forget(item)
+	return true
+}
+
+// deleteNode deletes a node whose host is gone from the cloud provider, draining it first if
+// enabled. It's left alone if its host came back in the meantime, or if it was deleted and
+// registered again.
+func (cnc *CloudNodeController) deleteNode(deletion nodeDeletion) error {
+	instances, ok := cnc.instances()
+	if !ok {
+		return fmt.Errorf("failed to get instances from cloud provider")
+	}
+	if exists, err := cnc.instanceExists(instances, deletion.name, deletion.providerID); exists || err != nil {
+		glog.Infof("Not deleting node %s, its host can be found in the cloud provider again: %v", deletion.name, err)
+		return nil
+	}
+
+	ref := nodeRef(deletion.name, deletion.uid)
+	if cnc.drainNodes {
+		drained, err := cnc.drainNode(ref, deletion)
+		if err != nil {
+			return err
+		}
+		if !drained {
+			glog.Infof("Not deleting node %s, it was deleted or registered again", deletion.name)
+			return nil
+		}
+	}
+
+	glog.V(2).Infof("Deleting node no longer present in cloud provider: %s", deletion.name)
+	cnc.recorder.Eventf(ref, v1.EventTypeNormal, EventDeletingNode, "Deleting node %s because it's not present according to cloud provider", deletion.name)
+
+	uid := deletion.uid
+	err := cnc.kubeClient.Core().Nodes().Delete(deletion.name, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+	switch {
+	case errors.IsConflict(err):
+		glog.Infof("Not deleting node %s, it registered again", deletion.name)
+	case errors.IsNotFound(err):
+	case err != nil:
+		return fmt.Errorf("unable to delete node %q: %v", deletion.name, err)
+	default:
+		nodeDeletions.Inc()
+	}
+	return nil
+}
+
+// instanceExists tells whether the instance of a node still exists in the cloud provider, by its
+// providerID if the cloud can tell, by node name otherwise. Errors other than
+// cloudprovider.InstanceNotFound never mean the instance is gone, and an InvalidProviderIDError
+// means the node isn't an instance of the cloud.
+func (cnc *CloudNodeController) instanceExists(instances cloudprovider.Instances, name, providerID string) (bool, error) {
+	if existence, ok := cnc.cloud.(InstanceExistenceProvider); ok && providerID != "" {
+		result, err := cnc.callCloud("InstanceExistsByProviderID", func() (interface{}, error) {
+			return existence.InstanceExistsByProviderID(providerID)
+		})
+		exists, _ := result.(bool)
+		return exists, err
+	}
+
+	_, err := instances.ExternalID(types.NodeName(name))
+	switch err {
+	case nil:
+		return true, nil
+	case cloudprovider.InstanceNotFound:
+		return false, nil
+	}
+	return false, err
+}
+
+// instanceShutdown tells whether the instance of node is shut down, if the cloud can tell
+func (cnc *CloudNodeController) instanceShutdown(node *v1.Node) (bool, error) {
+	shutdowns, ok := cnc.cloud.(InstanceShutdownProvider)
+	if !ok || node.Spec.ProviderID == "" {
+		return false, nil
+	}
+	result, err := cnc.callCloud("InstanceShutdownByProviderID", func() (interface{}, error) {
+		return shutdowns.InstanceShutdownByProviderID(node.Spec.ProviderID)
+	})
+	shutdown, _ := result.(bool)
+	return shutdown, err
+}
+
+// setShutdownTaint adds the shutdown taint to node, or removes it, unless node is already tainted
+// accordingly
+func (cnc *CloudNodeController) setShutdownTaint(node *v1.Node, shutdown bool) {
+	shutdownTaint := &v1.Taint{Key: ShutdownTaintKey, Effect: v1.TaintEffectNoSchedule}
+	if v1.TaintExists(node.Spec.Taints, shutdownTaint) == shutdown {
+		return
+	}
+
+	err := clientretry.RetryOnConflict(UpdateNodeSpecBackoff, func() error {
+		curNode, err := cnc.kubeClient.Core().Nodes().Get(node.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		taints, changed := v1.DeleteTaint(curNode.Spec.Taints, shutdownTaint)
+		if shutdown {
+			taints, changed = append(taints, *shutdownTaint), !changed
+		}
+		if !changed {
+			return nil
+		}
+		newNode := *curNode
+		newNode.Spec.Taints = taints
+		return patchNode(cnc.kubeClient, curNode, &newNode)
+	})
+	if err != nil {
+		glog.Errorf("Error updating the shutdown taint of node %s: %v", node.Name, err)
+		return
+	}
+	if shutdown {
+		glog.Infof("Host of node %s is shut down, tainted the node", node.Name)
+	} else {
+		glog.Infof("Host of node %s is running again, removed the shutdown taint", node.Name)
+	}
+}
+
+// hostMissing records that the host of node is missing from the cloud provider, and tells whether
+// it has been missing for enough consecutive checks for the node to be deleted. The first miss is
+// recorded as a warning event on the node.
+func (cnc *CloudNodeController) hostMissing(node *v1.Node) bool {
+	cnc.missingLock.Lock()
+	cnc.missing[node.Name]++
+	misses := cnc.missing[node.Name]
+	cnc.missingLock.Unlock()
+
+	if !cnc.enableNodeDeletion {
+		// Tell once the node would have been deleted
+		if misses == cnc.nodeDeletionMissingChecks {
+			cnc.recorder.Eventf(nodeRef(node.Name, node.UID), v1.EventTypeWarning, EventInstanceMissing,
+				"The host of node %s is missing from the cloud provider, not deleting the node because node deletion is disabled",
+				node.Name)
+		}
+		glog.Warningf("Host of node %s is missing from the cloud provider, not deleting the node because node deletion is disabled", node.Name)
+		return false
+	}
+	if misses == 1 && cnc.nodeDeletionMissingChecks > 1 {
+		ref := nodeRef(node.Name, node.UID)
+		cnc.recorder.Eventf(ref, v1.EventTypeWarning, EventInstanceMissing,
+			"The host of node %s is missing from the cloud provider, the node is deleted if it's still missing after %d checks",
+			node.Name, cnc.nodeDeletionMissingChecks)
+	}
+	if misses < cnc.nodeDeletionMissingChecks {
+		glog.Warningf("Host of node %s is missing from the cloud provider, %d of %d checks before deleting the node",
+			node.Name, misses, cnc.nodeDeletionMissingChecks)
+		return false
+	}
+	return true
+}
+
+// hostFound forgets the checks the host of the node with the given name was missing for
+func (cnc *CloudNodeController) hostFound(nodeName string) {
+	cnc.missingLock.Lock()
+	defer cnc.missingLock.Unlock()
+	if misses, ok := cnc.missing[nodeName]; ok {
+		glog.Infof("Host of node %s is back after missing from the cloud provider for %d checks", nodeName, misses)
+		delete(cnc.missing, nodeName)
+	}
+}
+
+// exemptFromDeletion tells whether node is never deleted because it's annotated so, or because its
+// providerID shows it's not an instance of the cloud provider
+func (cnc *CloudNodeController) exemptFromDeletion(node *v1.Node) bool {
+	if node.Annotations[AnnotationSkipNodeDeletion] == "true" {
+		glog.V(4).Infof("Node %s has the %s annotation, not checking whether it's missing from the cloud", node.Name, AnnotationSkipNodeDeletion)
+		return true
+	}
+	if node.Spec.ProviderID != "" && !cnc.ownsProviderID(node.Spec.ProviderID) {
+		glog.V(4).Infof("Node %s has the providerID %s of another cloud, not checking whether it's missing from the cloud", node.Name, node.Spec.ProviderID)
+		return true
+	}
+	return false
+}
+
+// ownsProviderID tells whether providerID identifies an instance of the cloud. Unless the cloud
+// can tell, it must start with the name of the cloud provider as scheme.
+func (cnc *CloudNodeController) ownsProviderID(providerID string) bool {
+	if owner, ok := cnc.cloud.(ProviderIDOwner); ok {
+		return owner.OwnsProviderID(providerID)
+	}
+	return strings.HasPrefix(providerID, cnc.cloud.ProviderName()+"://")
+}
+
+// tooYoungForDeletion tells whether node registered too recently to be deleted. Kubelets that
+// are still starting and hosts not yet visible in the cloud must not get new nodes deleted.
+func (cnc *CloudNodeController) tooYoungForDeletion(node *v1.Node) bool {
+	age := time.Since(node.CreationTimestamp.Time)
+	if age >= cnc.nodeDeletionMinimumAge {
+		return false
+	}
+	glog.V(4).Infof("Node %s is only %v old, it can't be deleted before it is %v old", node.Name, age, cnc.nodeDeletionMinimumAge)
+	return true
+}
+
+// AddCloudNode initializes the nodes added to the cluster. The nodes it fails to initialize are
+// retried when they're updated, and by the periodic pass.
+func (cnc *CloudNodeController) AddCloudNode(obj interface{}) {
+	if err := cnc.initializeNode(obj.(*v1.Node)); err != nil {
+		utilruntime.HandleError(err)
+	}
+}
+
+// UpdateCloudNode queues the updated nodes still carrying the cloud taint for initialization
+func (cnc *CloudNodeController) UpdateCloudNode(oldObj, newObj interface{}) {
+	node := newObj.(*v1.Node)
+	if cloudTaint, err := getCloudTaint(node); err != nil || cloudTaint == nil {
+		return
+	}
+	cnc.queue.Add(node.Name)
+}
+
+// initializeNode sets the cloud labels of node and removes its cloud taint
+func (cnc *CloudNodeController) initializeNode(node *v1.Node) error {
+	if !cnc.manages(node) {
+		return nil
+	}
+	instances, ok := cnc.instances()
+	if !ok {
+		return fmt.Errorf("cloudprovider does not support instances")
+	}
+
+	// This initializes nodes with cloud info
+	// Only initializes nodes that were created with the "ExternalCloudProvider" taint,
+	// unless untainted nodes are initialized too
+	cloudTaint, err := getCloudTaint(node)
+	if err != nil {
+		return fmt.Errorf("could not get taints from node %s", node.Name)
+	}
+
+	if cloudTaint == nil {
+		if !cnc.needsUntaintedInitialization(node) {
+			glog.V(2).Infof("This node is registered without the cloud taint. Will not process.")
+			return nil
+		}
+		glog.V(2).Infof("Node %s is registered without the cloud taint, initializing it", node.Name)
+	}
+
+	initialized := false
+	err = clientretry.RetryOnConflict(UpdateNodeSpecBackoff, func() error {
+		curNode, err := cnc.kubeClient.Core().Nodes().Get(node.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		providerID := curNode.Spec.ProviderID
+		if providerID == "" {
+			// Older agents run kubelet without --provider-id
+			providerID, err = cnc.providerID(instances, curNode)
+			if err != nil {
+				return err
+			}
+		}
+
+		// If user provided IP addresses, ensure that they are all found
+		// in the cloud provider before removing the taint on the node
+		nodeIPs := cnc.providedNodeIPs(node)
+		if len(nodeIPs) > 0 || cnc.waitForNodeAddresses {
+			nodeAddresses, err := instances.NodeAddressesByProviderID(providerID)
+			if err != nil {
+				nodeAddresses, err = instances.NodeAddresses(types.NodeName(node.Name))
+				if err != nil {
+					glog.Errorf("failed to get node address from cloud provider: %v", err)
+					return nil
+				}
+			}
+			// Keep the taint until the host has networking, UpdateNodeStatus retries later
+			if cnc.waitForNodeAddresses && !hasIPAddress(nodeAddresses) {
+				cnc.waitForAddresses(node)
+				return nil
+			}
+			if _, err := matchProvidedIPs(nodeIPs, nodeAddresses); err != nil {
+				glog.Errorf("failed to get node addresses for node %s from cloudprovider: %v", node.Name, err)
+				return nil
+			}
+			cnc.addressesReported(node.Name)
+		}
+
+		nodeCopy, err := api.Scheme.DeepCopy(curNode)
+		if err != nil {
+			return fmt.Errorf("failed to copy node to a new object")
+		}
+		newNode := nodeCopy.(*v1.Node)
+		newNode.Spec.ProviderID = providerID
+
+		labels, err := cnc.cloudLabels(newNode)
+		if err != nil {
+			return err
+		}
+		cnc.syncLabels(newNode, labels)
+		cnc.setMetadataLabels(newNode)
+
+		if cloudTaint == nil {
+			newNode.Annotations[AnnotationInitialized] = "true"
+		} else if err := deleteCloudTaint(newNode); err != nil {
+			return err
+		}
+
+		// The labels and the taint are written to the node object, the status subresource ignores them
+		if err := patchNode(cnc.kubeClient, curNode, newNode); err != nil {
+			return err
+		}
+		initialized = true
+
+		// Since there are node taints, do we still need this?
+		// This condition marks the node as unusable until routes are initialized in the cloud provider
+		if cnc.cloud.ProviderName() == "gce" {
+			statusNode := *curNode
+			statusNode.Status.Conditions = append(curNode.Status.Conditions, v1.NodeCondition{
+				Type:               v1.NodeNetworkUnavailable,
+				Status:             v1.ConditionTrue,
+				Reason:             "NoRouteCreated",
+				Message:            "Node created without a route",
+				LastTransitionTime: metav1.Now(),
+			})
+			if err := patchNodeStatus(cnc.kubeClient, curNode, &statusNode); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	// Nodes waiting for their providerID or their addresses are neither initialized nor failed
+	if initialized || (err != nil && err != errNoProviderID) {
+		nodeInitializations.WithLabelValues(resultLabel(err)).Inc()
+	}
+	if err != nil && err != errNoProviderID {
+		return &initializationError{node: node, err: err}
+	}
+	if initialized {
+		cnc.recorder.Eventf(nodeRef(node.Name, node.UID), v1.EventTypeNormal, EventCloudNodeInitialized,
+			"Initialized node %s with the addresses and labels of the cloud provider", node.Name)
+	}
+	return err
+}
+
+// initializationError is the error of a failed initialization of node
+type initializationError struct {
+	node *v1.Node
+	err  error
+}
+
+func (e *initializationError) Error() string {
+	return fmt.Sprintf("failed to initialize node %s: %v", e.node.Name, e.err)
+}
+
+// providerID returns the providerID of the instance of a node whose kubelet didn't set one. It
+// returns errNoProviderID if the cloud can't find the instance, the node is retried until it can
+// or the kubelet sets the providerID.
+func (cnc *CloudNodeController) providerID(instances cloudprovider.Instances, node *v1.Node) (string, error) {
+	var providerID string
+	var err error
+	if providers, ok := cnc.cloud.(ProviderIDProvider); ok {
+		providerID, err = cnc.callCloudString("ProviderIDByNodeName", func() (string, error) {
+			return providers.ProviderIDByNodeName(types.NodeName(node.Name))
+		})
+	} else {
+		var instanceID string
+		instanceID, err = instances.InstanceID(types.NodeName(node.Name))
+		providerID = cnc.cloud.ProviderName() + "://" + instanceID
+	}
+	if err == cloudprovider.InstanceNotFound {
+		cnc.recorder.Eventf(nodeRef(node.Name, node.UID), v1.EventTypeWarning, EventProviderIDNotFound,
+			"Node %s has no providerID and no instance of the cloud provider is named after it, keeping it tainted", node.Name)
+		return "", errNoProviderID
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get providerID of node %s from cloud provider: %v", node.Name, err)
+	}
+	glog.Infof("Node %s has no providerID, setting it to %s", node.Name, providerID)
+	return providerID, nil
+}
+
+// deleteCloudTaint removes the cloud taint from the spec of node and from the taints annotation
+// of old kubelets.
+func deleteCloudTaint(node *v1.Node) error {
+	cloudTaint, err := getCloudTaint(node)
+	if err != nil || cloudTaint == nil {
+		return err
+	}
+	if taints, deleted := v1.DeleteTaint(node.Spec.Taints, cloudTaint); deleted {
+		node.Spec.Taints = taints
+	}
+	annotationTaints, err := v1.GetTaintsFromNodeAnnotations(node.Annotations)
+	if err != nil {
+		return err
+	}
+	if taints, deleted := v1.DeleteTaint(annotationTaints, cloudTaint); deleted {
+		if len(taints) == 0 {
+			delete(node.Annotations, v1.TaintsAnnotationKey)
+			return nil
+		}
+		data, err := json.Marshal(taints)
+		if err != nil {
+			return err
+		}
+		node.Annotations[v1.TaintsAnnotationKey] = string(data)
+	}
+	return nil
+}
+
+// patchNode patches the metadata and spec of oldNode to those of newNode. The patch is conditioned
+// on the resource version of oldNode, so it fails with a conflict if the node changed since.
+func patchNode(c clientset.Interface, oldNode, newNode *v1.Node) error {
+	// Leave the status out, and the resource version out of the original so it's in the patch
+	original, modified := *oldNode, *newNode
+	original.ResourceVersion = ""
+	original.Status, modified.Status = v1.NodeStatus{}, v1.NodeStatus{}
+	modified.ResourceVersion = oldNode.ResourceVersion
+
+	oldData, err := json.Marshal(original)
+	if err != nil {
+		return fmt.Errorf("failed to marshal old node %#v for node %q: %v", oldNode, oldNode.Name, err)
+	}
+	newData, err := json.Marshal(modified)
+	if err != nil {
+		return fmt.Errorf("failed to marshal new node %#v for node %q: %v", newNode, oldNode.Name, err)
+	}
+	patchBytes, err := strategicpatch.CreateTwoWayMergePatch(oldData, newData, v1.Node{})
+	if err != nil {
+		return fmt.Errorf("failed to create patch for node %q: %v", oldNode.Name, err)
+	}
+	if glog.V(5) {
+		glog.Infof("Patching node %s (%d bytes): %s", oldNode.Name, len(patchBytes), nodeDiff(oldNode, newNode))
+	}
+
+	nodePatches.WithLabelValues("node").Inc()
+	_, err = c.Core().Nodes().Patch(oldNode.Name, types.StrategicMergePatchType, patchBytes)
+	return err
+}
+
+// skipCordoned tells whether the addresses and labels of node are not updated because it's unschedulable
+func (cnc *CloudNodeController) skipCordoned(node *v1.Node) bool {
+	if !cnc.skipCordonedNodes {
+		return false
+	}
+
+	cnc.skippedLock.Lock()
+	defer cnc.skippedLock.Unlock()
+	skip := node.Spec.Unschedulable
+	if skip != cnc.skipped[node.Name] {
+		if skip {
+			glog.Infof("Node %s is unschedulable, not updating its addresses and labels until it is schedulable again", node.Name)
+			cnc.skipped[node.Name] = true
+		} else {
+			glog.Infof("Node %s is schedulable again, updating its addresses and labels", node.Name)
+			delete(cnc.skipped, node.Name)
+		}
+	}
+	return skip
+}
+
+// manages tells whether node is initialized, updated and deleted by the controller, which
+// leaves alone the nodes created before manageNodesCreatedAfter
+func (cnc *CloudNodeController) manages(node *v1.Node) bool {
+	if cnc.manageNodesCreatedAfter.IsZero() || !node.CreationTimestamp.Time.Before(cnc.manageNodesCreatedAfter) {
+		return true
+	}
+
+	cnc.ignoredLock.Lock()
+	defer cnc.ignoredLock.Unlock()
+	if !cnc.ignored[node.Name] {
+		glog.Infof("Node %s was created at %v, before %v, not managing it",
+			node.Name, node.CreationTimestamp.Time, cnc.manageNodesCreatedAfter)
+		cnc.ignored[node.Name] = true
+	}
+	return false
+}
+
+// observeIgnoredNodes updates the metric of the nodes left alone because they are too old
+func (cnc *CloudNodeController) observeIgnoredNodes(nodes []*v1.Node) {
+	if cnc.manageNodesCreatedAfter.IsZero() {
+		return
+	}
+	count := 0
+	for i := range nodes {
+		if nodes[i].CreationTimestamp.Time.Before(cnc.manageNodesCreatedAfter) {
+			count++
+		}
+	}
+	ignoredNodes.Set(float64(count))
+}
+
+// getCloudTaint returns the cloud taint of node, or nil if node isn't waiting to be initialized.
+// Kubelets register their taints in the spec, old ones in the taints annotation.
+func getCloudTaint(node *v1.Node) (*v1.Taint, error) {
+	if taint := findCloudTaint(node.Spec.Taints); taint != nil {
+		return taint, nil
+	}
+	taints, err := v1.GetTaintsFromNodeAnnotations(node.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	return findCloudTaint(taints), nil
+}
+
+// findCloudTaint returns the cloud taint among taints, or nil
+func findCloudTaint(taints []v1.Taint) *v1.Taint {
+	for i := range taints {
+		for _, key := range cloudTaintKeys {
+			if taints[i].Key == key {
+				return &taints[i]
+			}
+		}
+	}
+	return nil
+}
+
+// observeUninitializedNodes updates the metrics of the nodes still carrying a cloud taint
+func observeUninitializedNodes(nodes []*v1.Node, now time.Time) {
+	count := 0
+	var oldest time.Duration
+	for i := range nodes {
+		taint, err := getCloudTaint(nodes[i])
+		if err != nil || taint == nil {
+			continue
+		}
+		count++
+		if age := now.Sub(nodes[i].CreationTimestamp.Time); age > oldest {
+			oldest = age
+		}
+	}
+	uninitializedNodes.Set(float64(count))
+	oldestUninitializedNodeAge.Set(oldest.Seconds())
+}
+
+// needsUntaintedInitialization returns true for nodes registered without the cloud taint that
+// should be, but have not been, initialized
+func (cnc *CloudNodeController) needsUntaintedInitialization(node *v1.Node) bool {
+	if !cnc.initializeUntaintedNodes {
+		return false
+	}
+	_, initialized := node.Annotations[AnnotationInitialized]
+	return !initialized
+}
+
+// setEnvironment adds the environment label to labels and sets the environment annotation of node,
+// if the cloud groups instances into environments
+func (cnc *CloudNodeController) setEnvironment(node *v1.Node, labels map[string]string) error {
+	environments, ok := cnc.cloud.(EnvironmentProvider)
+	if !ok {
+		return nil
+	}
+	result, err := cnc.callCloud("InstanceEnvironment", func() (interface{}, error) {
+		id, name, err := environments.InstanceEnvironment(types.NodeName(node.Name))
+		return [2]string{id, name}, err
+	})
+	if err != nil {
+		return err
+	}
+	environment := result.([2]string)
+	id, name := environment[0], environment[1]
+
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	labels[LabelEnvironment] = sanitizeLabelValue(name)
+	node.Annotations[AnnotationEnvironmentID] = id
+	return nil
+}
+
+// sanitizeLabelValue turns s into a valid label value: at most 63 letters, digits, '-', '_' or '.',
+// starting and ending with a letter or digit
+func sanitizeLabelValue(s string) string {
+	value := labelValueChars.ReplaceAllString(s, "-")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.Trim(value, "-_.")
+}
+
+// getProvidedNodeIPs returns the IPs the user asked the node to be addressed by, a comma-separated
+// list read from the provided node IP annotation, or from the label when the annotation is absent.
+// Label values can't hold commas nor IPv6 addresses, so several IPs can only be provided by the
+// annotation. A non-empty key replaces the keys of both the annotation and the label. The entries
+// that aren't IPs are returned as invalid.
+func getProvidedNodeIPs(node *v1.Node, key string) (ips []net.IP, invalid []string) {
+	annotationKey, labelKey := AnnotationProvidedIPAddr, LabelProvidedIPAddr
+	if key != "" {
+		annotationKey, labelKey = key, key
+	}
+	value, ok := node.ObjectMeta.Annotations[annotationKey]
+	if !ok {
+		value, ok = node.ObjectMeta.Labels[labelKey]
+	} else if label, labelled := node.ObjectMeta.Labels[labelKey]; labelled && label != value {
+		glog.V(2).Infof("Provided IP label %s=%s of node %s disagrees with the annotation, using %s", labelKey, label, node.Name, value)
+	}
+	if !ok {
+		return nil, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if ip := net.ParseIP(entry); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			invalid = append(invalid, entry)
+		}
+	}
+	return ips, invalid
+}
+
+// providedNodeIPs returns the valid IPs provided for node, recording a warning event when some
+// entries aren't IPs. The event is recorded once per value, not on every sync.
+func (cnc *CloudNodeController) providedNodeIPs(node *v1.Node) []net.IP {
+	ips, invalid := getProvidedNodeIPs(node, cnc.providedNodeIPKey)
+	entries := strings.Join(invalid, ", ")
+
+	cnc.invalidIPsLock.Lock()
+	defer cnc.invalidIPsLock.Unlock()
+	if entries == cnc.invalidIPs[node.Name] {
+		return ips
+	}
+	if entries == "" {
+		delete(cnc.invalidIPs, node.Name)
+		return ips
+	}
+	cnc.invalidIPs[node.Name] = entries
+	glog.Warningf("Ignoring invalid provided IPs of node %s: %q", node.Name, invalid)
+	cnc.recorder.Eventf(nodeRef(node.Name, node.UID), v1.EventTypeWarning, EventInvalidProvidedIP,
+		"Ignoring provided node IPs that are not IP addresses: %s", entries)
+	return ips
+}
+
+// matchProvidedIPs returns the cloud addresses matching the provided IPs, in the order of the
+// cloud and with their types. It fails if an IP isn't reported by the cloud.
+func matchProvidedIPs(ips []net.IP, nodeAddresses []v1.NodeAddress) ([]v1.NodeAddress, error) {
+	matched := []v1.NodeAddress{}
+	found := make([]bool, len(ips))
+	for _, addr := range nodeAddresses {
+		if !isIPAddress(addr) {
+			continue
+		}
+		addrIP := net.ParseIP(addr.Address)
+		for i, ip := range ips {
+			if ip.Equal(addrIP) {
+				matched = append(matched, v1.NodeAddress{Type: addr.Type, Address: addr.Address})
+				found[i] = true
+				break
+			}
+		}
+	}
+	for i, ip := range ips {
+		if !found[i] {
+			return nil, fmt.Errorf("failed to get node address from cloudprovider that matches ip: %v", ip)
+		}
+	}
+	return matched, nil
+}
+
+// DeleteCloudNode cleans up cloud side state kept for a node that was deleted
+func (cnc *CloudNodeController) DeleteCloudNode(obj interface{}) {
+	node, ok := nodeFromDeleteEvent(obj)
+	if !ok {
+		return
+	}
+
+	cnc.ignoredLock.Lock()
+	delete(cnc.ignored, node.Name)
+	cnc.ignoredLock.Unlock()
+
+	cnc.skippedLock.Lock()
+	delete(cnc.skipped, node.Name)
+	cnc.skippedLock.Unlock()
+
+	cnc.missingLock.Lock()
+	delete(cnc.missing, node.Name)
+	cnc.missingLock.Unlock()
+
+	cnc.invalidIPsLock.Lock()
+	delete(cnc.invalidIPs, node.Name)
+	cnc.invalidIPsLock.Unlock()
+
+	// A node registering again under the name waits for its addresses afresh
+	cnc.waitingLock.Lock()
+	delete(cnc.waiting, node.Name)
+	cnc.addressBackoff.Reset(node.Name)
+	cnc.waitingLock.Unlock()
+
+	cnc.lookupSucceeded(node.Name)
+
+	// Don't serve a stale host if a node with the same name registers again
+	if invalidator, ok := cnc.cloud.(HostCacheInvalidator); ok {
+		evicted := invalidator.InvalidateHostCache(node.Name)
+		glog.V(4).Infof("Evicted %d cached hosts for deleted node %s", evicted, node.Name)
+	}
+}
+
+// nodeFromDeleteEvent returns the node passed to a DeleteFunc handler, unwrapping the
+// cache.DeletedFinalStateUnknown tombstone informers deliver when a delete was missed.
+func nodeFromDeleteEvent(obj interface{}) (*v1.Node, bool) {
+	if node, ok := obj.(*v1.Node); ok {
+		return node, true
+	}
+
+	tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("couldn't get object from tombstone %#v", obj))
+		return nil, false
+	}
+	node, ok := tombstone.Obj.(*v1.Node)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("tombstone %s contained object that is not a Node %#v", tombstone.Key, tombstone.Obj))
+		return nil, false
+	}
+	return node, true
+}
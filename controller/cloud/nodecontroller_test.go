@@ -0,0 +1,2394 @@
+package cloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/clientset"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
+	corelisters "k8s.io/kubernetes/pkg/client/listers/core/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+
+	"github.com/rancher/rancher-cloud-controller-manager/testutil"
+)
+
+// fakeClientset implements the parts of the clientset the node controller uses
+type fakeClientset struct {
+	clientset.Interface
+	nodes *fakeNodes
+	pods  *fakePods
+}
+
+func (f *fakeClientset) Core() corev1.CoreV1Interface {
+	return &fakeCore{nodes: f.nodes, pods: f.pods}
+}
+
+func (f *fakeClientset) CoreV1() corev1.CoreV1Interface {
+	return f.Core()
+}
+
+type fakeCore struct {
+	corev1.CoreV1Interface
+	nodes *fakeNodes
+	pods  *fakePods
+}
+
+func (f *fakeCore) Nodes() corev1.NodeInterface {
+	return f.nodes
+}
+
+func (f *fakeCore) Pods(namespace string) corev1.PodInterface {
+	return f.pods
+}
+
+// fakeNodes serves nodes from items. The first conflicts patches fail with a conflict.
+type fakeNodes struct {
+	corev1.NodeInterface
+	lock      sync.Mutex
+	items     map[string]*v1.Node
+	conflicts int
+	gets      int
+	patches   []string
+	lists     int
+	// cacheLists counts the lists served by fakeNodeInformer
+	cacheLists int
+	// subresources holds the subresource of each patch, "" for the node object
+	subresources []string
+
+	// deleted receives the names of deleted nodes
+	deleted chan string
+	// deleteCalls receives the names of the nodes deletions are tried for, if set
+	deleteCalls chan string
+	// deleteDelay is how long deletions take. deleting counts the deletions in flight, and
+	// maxDeleting the most there were at once.
+	deleteDelay time.Duration
+	deleting    int
+	maxDeleting int
+}
+
+// set replaces a node, like a kubelet registering it again
+func (f *fakeNodes) set(node *v1.Node) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.items[node.Name] = node
+}
+
+// fakeNodeInformer serves the nodes of a fakeNodes from its lister, like an informer cache.
+// It has synced once synced is closed, or right away if synced is nil.
+type fakeNodeInformer struct {
+	nodes  *fakeNodes
+	synced chan struct{}
+}
+
+func (f *fakeNodeInformer) Informer() cache.SharedIndexInformer {
+	return &fakeSharedInformer{synced: f.synced}
+}
+
+func (f *fakeNodeInformer) Lister() corelisters.NodeLister {
+	return &fakeNodeLister{nodes: f.nodes}
+}
+
+type fakeSharedInformer struct {
+	cache.SharedIndexInformer
+	synced chan struct{}
+}
+
+func (f *fakeSharedInformer) AddEventHandler(handler cache.ResourceEventHandler) {}
+
+func (f *fakeSharedInformer) HasSynced() bool {
+	if f.synced == nil {
+		return true
+	}
+	select {
+	case <-f.synced:
+		return true
+	default:
+		return false
+	}
+}
+
+type fakeNodeLister struct {
+	corelisters.NodeLister
+	nodes *fakeNodes
+}
+
+func (f *fakeNodeLister) List(selector labels.Selector) ([]*v1.Node, error) {
+	f.nodes.lock.Lock()
+	defer f.nodes.lock.Unlock()
+	f.nodes.cacheLists++
+	list := []*v1.Node{}
+	for _, node := range f.nodes.items {
+		list = append(list, node)
+	}
+	return list, nil
+}
+
+func (f *fakeNodeLister) Get(name string) (*v1.Node, error) {
+	f.nodes.lock.Lock()
+	defer f.nodes.lock.Unlock()
+	node, ok := f.nodes.items[name]
+	if !ok {
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "nodes"}, name)
+	}
+	return node, nil
+}
+
+func (f *fakeNodes) Get(name string, options metav1.GetOptions) (*v1.Node, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.gets++
+	node, ok := f.items[name]
+	if !ok {
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "nodes"}, name)
+	}
+	// like the API server, return a node the caller may modify
+	nodeCopy, err := api.Scheme.DeepCopy(node)
+	if err != nil {
+		return nil, err
+	}
+	return nodeCopy.(*v1.Node), nil
+}
+
+func (f *fakeNodes) Delete(name string, options *metav1.DeleteOptions) error {
+	if f.deleteCalls != nil {
+		defer func() { f.deleteCalls <- name }()
+	}
+	f.lock.Lock()
+	f.deleting++
+	if f.deleting > f.maxDeleting {
+		f.maxDeleting = f.deleting
+	}
+	f.lock.Unlock()
+	time.Sleep(f.deleteDelay)
+	defer func() {
+		f.lock.Lock()
+		f.deleting--
+		f.lock.Unlock()
+	}()
+
+	f.lock.Lock()
+	node, ok := f.items[name]
+	if !ok {
+		f.lock.Unlock()
+		return errors.NewNotFound(schema.GroupResource{Resource: "nodes"}, name)
+	}
+	if options != nil && options.Preconditions != nil && options.Preconditions.UID != nil && *options.Preconditions.UID != node.UID {
+		f.lock.Unlock()
+		return errors.NewConflict(schema.GroupResource{Resource: "nodes"}, name, fmt.Errorf("the UID in the precondition does not match"))
+	}
+	delete(f.items, name)
+	f.lock.Unlock()
+
+	f.deleted <- name
+	return nil
+}
+
+func (f *fakeNodes) List(options metav1.ListOptions) (*v1.NodeList, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.lists++
+	list := &v1.NodeList{}
+	for _, node := range f.items {
+		list.Items = append(list.Items, *node)
+	}
+	return list, nil
+}
+
+// statusPatches returns the number of patches of the status subresource
+func (f *fakeNodes) statusPatches() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	count := 0
+	for _, subresource := range f.subresources {
+		if subresource == "status" {
+			count++
+		}
+	}
+	return count
+}
+
+func (f *fakeNodes) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (*v1.Node, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.patches = append(f.patches, string(data))
+	f.subresources = append(f.subresources, strings.Join(subresources, "/"))
+	if f.conflicts > 0 {
+		f.conflicts--
+		return nil, errors.NewConflict(schema.GroupResource{Resource: "nodes"}, name, fmt.Errorf("the object has been modified"))
+	}
+	node, ok := f.items[name]
+	if !ok {
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "nodes"}, name)
+	}
+	var precondition struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &precondition); err != nil {
+		return nil, errors.NewBadRequest(err.Error())
+	}
+	if rv := precondition.Metadata.ResourceVersion; rv != "" && rv != node.ResourceVersion {
+		return nil, errors.NewConflict(schema.GroupResource{Resource: "nodes"}, name, fmt.Errorf("the object has been modified"))
+	}
+
+	// the status subresource only applies the status of the patch, like the API server
+	if len(subresources) == 1 && subresources[0] == "status" {
+		var patch map[string]interface{}
+		if err := json.Unmarshal(data, &patch); err != nil {
+			return nil, errors.NewBadRequest(err.Error())
+		}
+		statusPatch := map[string]interface{}{}
+		if status, ok := patch["status"]; ok {
+			statusPatch["status"] = status
+		}
+		status, err := json.Marshal(statusPatch)
+		if err != nil {
+			return nil, err
+		}
+		data = status
+	}
+
+	// apply the patch, so tests can check the stored node
+	original, err := json.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, data, v1.Node{})
+	if err != nil {
+		return nil, errors.NewBadRequest(err.Error())
+	}
+	newNode := &v1.Node{}
+	if err := json.Unmarshal(patched, newNode); err != nil {
+		return nil, err
+	}
+	f.items[name] = newNode
+	return newNode, nil
+}
+
+type fakeCloud struct {
+	lock        sync.Mutex
+	invalidated []string
+
+	// addresses are reported for every node if set
+	addresses    []v1.NodeAddress
+	instanceType string
+	lookups      int
+
+	// externalIDErr is returned by ExternalID if set
+	externalIDErr error
+	// externalID is returned by ExternalID if set, the host is missing otherwise
+	externalID string
+	// instanceID is returned by InstanceID if set, the host is missing otherwise
+	instanceID string
+
+	// zone is reported by Zones if set
+	zone *cloudprovider.Zone
+}
+
+func (f *fakeCloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
+	return nil, false
+}
+
+func (f *fakeCloud) Instances() (cloudprovider.Instances, bool) {
+	return f, true
+}
+
+func (f *fakeCloud) Zones() (cloudprovider.Zones, bool) {
+	return f, f.zone != nil
+}
+
+func (f *fakeCloud) GetZone() (cloudprovider.Zone, error) {
+	return *f.zone, nil
+}
+
+func (f *fakeCloud) Clusters() (cloudprovider.Clusters, bool) {
+	return nil, false
+}
+
+func (f *fakeCloud) Routes() (cloudprovider.Routes, bool) {
+	return nil, false
+}
+
+func (f *fakeCloud) ProviderName() string {
+	return "rancher"
+}
+
+func (f *fakeCloud) ScrubDNS(nameservers, searches []string) (nsOut, srchOut []string) {
+	return nameservers, searches
+}
+
+func (f *fakeCloud) NodeAddresses(name types.NodeName) ([]v1.NodeAddress, error) {
+	f.lock.Lock()
+	f.lookups++
+	f.lock.Unlock()
+	if f.addresses == nil {
+		return nil, cloudprovider.InstanceNotFound
+	}
+	return f.addresses, nil
+}
+
+func (f *fakeCloud) NodeAddressesByProviderID(providerID string) ([]v1.NodeAddress, error) {
+	return nil, cloudprovider.InstanceNotFound
+}
+
+func (f *fakeCloud) ExternalID(nodeName types.NodeName) (string, error) {
+	f.lock.Lock()
+	f.lookups++
+	f.lock.Unlock()
+	if f.externalIDErr != nil {
+		return "", f.externalIDErr
+	}
+	if f.externalID != "" {
+		return f.externalID, nil
+	}
+	return "", cloudprovider.InstanceNotFound
+}
+
+type ambiguousError []string
+
+func (e ambiguousError) Error() string {
+	return "multiple instances found"
+}
+
+func (e ambiguousError) Candidates() []string {
+	return e
+}
+
+func (f *fakeCloud) InstanceID(nodeName types.NodeName) (string, error) {
+	if f.instanceID != "" {
+		return f.instanceID, nil
+	}
+	return "", cloudprovider.InstanceNotFound
+}
+
+func (f *fakeCloud) InstanceType(name types.NodeName) (string, error) {
+	return "", cloudprovider.InstanceNotFound
+}
+
+func (f *fakeCloud) InstanceTypeByProviderID(providerID string) (string, error) {
+	if f.instanceType == "" {
+		return "", cloudprovider.InstanceNotFound
+	}
+	return f.instanceType, nil
+}
+
+func (f *fakeCloud) AddSSHKeyToAllInstances(user string, keyData []byte) error {
+	return fmt.Errorf("not implemented")
+}
+
+func (f *fakeCloud) CurrentNodeName(hostname string) (types.NodeName, error) {
+	return types.NodeName(hostname), nil
+}
+
+func (f *fakeCloud) InvalidateHostCache(key string) int {
+	f.invalidated = append(f.invalidated, key)
+	return 1
+}
+
+func TestDeleteCloudNode(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+
+	tests := []struct {
+		name        string
+		obj         interface{}
+		invalidated []string
+	}{
+		{
+			name:        "node",
+			obj:         node,
+			invalidated: []string{"node1"},
+		},
+		{
+			name:        "tombstone",
+			obj:         cache.DeletedFinalStateUnknown{Key: "node1", Obj: node},
+			invalidated: []string{"node1"},
+		},
+		{
+			name: "tombstone with unexpected object",
+			obj:  cache.DeletedFinalStateUnknown{Key: "default/pod1", Obj: &v1.Pod{}},
+		},
+		{
+			name: "unexpected object",
+			obj:  &v1.Pod{},
+		},
+	}
+
+	for _, test := range tests {
+		cloud := &fakeCloud{}
+		cnc := &CloudNodeController{
+			cloud:          cloud,
+			addressBackoff: flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+		}
+
+		cnc.DeleteCloudNode(test.obj)
+
+		if len(cloud.invalidated) != len(test.invalidated) {
+			t.Errorf("%s: expected hosts %v to be evicted, found %v", test.name, test.invalidated, cloud.invalidated)
+			continue
+		}
+		for i := range test.invalidated {
+			if cloud.invalidated[i] != test.invalidated[i] {
+				t.Errorf("%s: expected hosts %v to be evicted, found %v", test.name, test.invalidated, cloud.invalidated)
+			}
+		}
+	}
+}
+
+func TestDesiredNodeAddresses(t *testing.T) {
+	cloudAddresses := []v1.NodeAddress{
+		{Type: v1.NodeExternalIP, Address: "10.0.0.1"},
+		{Type: v1.NodeHostName, Address: "host1"},
+	}
+
+	tests := []struct {
+		name    string
+		replace bool
+		node    *v1.Node
+		// reported by the cloud, cloudAddresses if nil
+		cloud     []v1.NodeAddress
+		addresses []v1.NodeAddress
+	}{
+		{
+			name: "extra address types are kept",
+			node: &v1.Node{Status: v1.NodeStatus{Addresses: []v1.NodeAddress{
+				{Type: v1.NodeExternalIP, Address: "10.0.0.9"},
+				{Type: v1.NodeInternalIP, Address: "192.168.0.1"},
+				{Type: "ExternalDNS", Address: "host1.example.com"},
+			}}},
+			addresses: []v1.NodeAddress{
+				{Type: v1.NodeExternalIP, Address: "10.0.0.1"},
+				{Type: v1.NodeHostName, Address: "host1"},
+				{Type: v1.NodeInternalIP, Address: "192.168.0.1"},
+				{Type: "ExternalDNS", Address: "host1.example.com"},
+			},
+		},
+		{
+			name: "extra addresses of a managed type are kept",
+			cloud: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "192.168.0.2"},
+				{Type: v1.NodeExternalIP, Address: "10.0.0.1"},
+			},
+			node: &v1.Node{
+				// the cloud reported 192.168.0.1 before, 172.17.0.1 was reported by kubelet
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					AnnotationManagedAddresses: `[{"type":"InternalIP","address":"192.168.0.1"},{"type":"ExternalIP","address":"10.0.0.1"}]`,
+				}},
+				Status: v1.NodeStatus{Addresses: []v1.NodeAddress{
+					{Type: v1.NodeInternalIP, Address: "192.168.0.1"},
+					{Type: v1.NodeExternalIP, Address: "10.0.0.1"},
+					{Type: v1.NodeInternalIP, Address: "172.17.0.1"},
+				}},
+			},
+			addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "192.168.0.2"},
+				{Type: v1.NodeExternalIP, Address: "10.0.0.1"},
+				{Type: v1.NodeInternalIP, Address: "172.17.0.1"},
+			},
+		},
+		{
+			name:    "full replace",
+			replace: true,
+			node: &v1.Node{Status: v1.NodeStatus{Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "192.168.0.1"},
+				{Type: "ExternalDNS", Address: "host1.example.com"},
+			}}},
+			addresses: cloudAddresses,
+		},
+		{
+			name: "kubelet DNS names are kept",
+			node: &v1.Node{Status: v1.NodeStatus{Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalDNS, Address: "host1.internal"},
+				{Type: v1.NodeHostName, Address: "host1"},
+				{Type: v1.NodeExternalDNS, Address: "host1.example.com"},
+			}}},
+			addresses: []v1.NodeAddress{
+				{Type: v1.NodeExternalIP, Address: "10.0.0.1"},
+				{Type: v1.NodeHostName, Address: "host1"},
+				{Type: v1.NodeInternalDNS, Address: "host1.internal"},
+				{Type: v1.NodeExternalDNS, Address: "host1.example.com"},
+			},
+		},
+		{
+			name: "the hostname is kept for a provided IP",
+			node: &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{LabelProvidedIPAddr: "10.0.0.1"}},
+				Status: v1.NodeStatus{Addresses: []v1.NodeAddress{
+					{Type: v1.NodeHostName, Address: "old-name"},
+					{Type: v1.NodeInternalDNS, Address: "host1.internal"},
+				}},
+			},
+			addresses: []v1.NodeAddress{
+				{Type: v1.NodeExternalIP, Address: "10.0.0.1"},
+				{Type: v1.NodeHostName, Address: "host1"},
+				{Type: v1.NodeInternalDNS, Address: "host1.internal"},
+			},
+		},
+		{
+			name:  "the kubelet hostname is kept for a provided IP",
+			cloud: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.2"}, {Type: v1.NodeExternalIP, Address: "10.0.0.1"}},
+			node: &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{LabelProvidedIPAddr: "10.0.0.1"}},
+				Status: v1.NodeStatus{Addresses: []v1.NodeAddress{
+					{Type: v1.NodeExternalIP, Address: "10.0.0.2"},
+					{Type: v1.NodeHostName, Address: "node1"},
+				}},
+			},
+			addresses: []v1.NodeAddress{
+				{Type: v1.NodeExternalIP, Address: "10.0.0.1"},
+				{Type: v1.NodeHostName, Address: "node1"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if test.cloud == nil {
+			test.cloud = cloudAddresses
+		}
+		cnc := &CloudNodeController{replaceAddresses: test.replace}
+		addresses, err := cnc.desiredNodeAddresses(test.node, test.cloud)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(addresses, test.addresses) {
+			t.Errorf("%s: expected addresses %v, found %v", test.name, test.addresses, addresses)
+		}
+		// the next pass must not reorder them
+		node := *test.node
+		node.Status.Addresses = addresses
+		if again, _ := cnc.desiredNodeAddresses(&node, test.cloud); !reflect.DeepEqual(again, addresses) {
+			t.Errorf("%s: expected the addresses to be stable, found %v then %v", test.name, addresses, again)
+		}
+	}
+}
+
+func TestDesiredNodeAddressesProvidedIPs(t *testing.T) {
+	cloudAddresses := []v1.NodeAddress{
+		{Type: v1.NodeExternalIP, Address: "10.0.0.1"},
+		{Type: v1.NodeLegacyHostIP, Address: "10.0.0.1"},
+		{Type: v1.NodeInternalIP, Address: "192.168.0.1"},
+		{Type: v1.NodeExternalIP, Address: "2001:db8::1"},
+		{Type: v1.NodeHostName, Address: "host1"},
+	}
+	hostname := v1.NodeAddress{Type: v1.NodeHostName, Address: "host1"}
+
+	tests := []struct {
+		name       string
+		providedIP string
+		addresses  []v1.NodeAddress
+		events     []string
+		err        bool
+	}{
+		{
+			name:       "v4 only",
+			providedIP: "10.0.0.1",
+			addresses:  []v1.NodeAddress{cloudAddresses[0], cloudAddresses[1], hostname},
+		},
+		{
+			name:       "v6 only",
+			providedIP: "2001:db8:0::1",
+			addresses:  []v1.NodeAddress{cloudAddresses[3], hostname},
+		},
+		{
+			name:       "dual-stack",
+			providedIP: "2001:db8::1, 192.168.0.1",
+			addresses:  []v1.NodeAddress{cloudAddresses[2], cloudAddresses[3], hostname},
+		},
+		{
+			name:       "bogus entry",
+			providedIP: "192.168.0.1,bogus",
+			addresses:  []v1.NodeAddress{cloudAddresses[2], hostname},
+			events:     []string{EventInvalidProvidedIP},
+		},
+		{
+			name:       "IP not in the cloud",
+			providedIP: "192.168.0.1,192.168.0.2",
+			err:        true,
+		},
+	}
+
+	for _, test := range tests {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        "node1",
+			Annotations: map[string]string{AnnotationProvidedIPAddr: test.providedIP},
+		}}
+		recorder := record.NewFakeRecorder(10)
+		cnc := &CloudNodeController{recorder: recorder, invalidIPs: map[string]string{}}
+		addresses, err := cnc.desiredNodeAddresses(node, cloudAddresses)
+		if (err != nil) != test.err {
+			t.Errorf("%s: expected error to be %v, got %v", test.name, test.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(addresses, test.addresses) {
+			t.Errorf("%s: expected addresses %v, found %v", test.name, test.addresses, addresses)
+		}
+		if reasons := eventReasons(recorder); len(reasons) != len(test.events) || (len(reasons) > 0 && !reflect.DeepEqual(reasons, test.events)) {
+			t.Errorf("%s: expected events %v, found %v", test.name, test.events, reasons)
+		}
+	}
+}
+
+func TestProvidedNodeIPsWarnsOnce(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	cnc := &CloudNodeController{
+		recorder:       recorder,
+		invalidIPs:     map[string]string{},
+		addressBackoff: flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+	}
+	node := func(providedIP string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        "node1",
+			Annotations: map[string]string{AnnotationProvidedIPAddr: providedIP},
+		}}
+	}
+
+	tests := []struct {
+		name       string
+		providedIP string
+		events     int
+	}{
+		{name: "first invalid value", providedIP: "10.0.0.1,bogus", events: 1},
+		{name: "same invalid value", providedIP: "10.0.0.1,bogus", events: 0},
+		{name: "changed invalid value", providedIP: "10.0.0.1,other", events: 1},
+		{name: "fixed value", providedIP: "10.0.0.1", events: 0},
+		{name: "invalid again", providedIP: "10.0.0.1,other", events: 1},
+	}
+	for _, test := range tests {
+		cnc.providedNodeIPs(node(test.providedIP))
+		if reasons := eventReasons(recorder); len(reasons) != test.events {
+			t.Errorf("%s: expected %d events, found %v", test.name, test.events, reasons)
+		}
+	}
+
+	cnc.DeleteCloudNode(node("10.0.0.1,other"))
+	if _, ok := cnc.invalidIPs["node1"]; ok {
+		t.Errorf("expected the reported invalid IPs of a deleted node to be forgotten")
+	}
+}
+
+func TestDesiredNodeAddressesProvidedIPSource(t *testing.T) {
+	cloudAddresses := []v1.NodeAddress{
+		{Type: v1.NodeExternalIP, Address: "10.0.0.1"},
+		{Type: v1.NodeInternalIP, Address: "192.168.0.1"},
+		{Type: v1.NodeHostName, Address: "host1"},
+	}
+	// the node as left by a previous pass with the provided IP 10.0.0.1
+	narrowed := []v1.NodeAddress{cloudAddresses[0], cloudAddresses[2]}
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		addresses   []v1.NodeAddress
+	}{
+		{
+			name:      "label",
+			labels:    map[string]string{LabelProvidedIPAddr: "10.0.0.1"},
+			addresses: narrowed,
+		},
+		{
+			name:        "annotation without the label",
+			annotations: map[string]string{AnnotationProvidedIPAddr: "10.0.0.1"},
+			addresses:   narrowed,
+		},
+		{
+			name:      "annotation removed",
+			addresses: cloudAddresses,
+		},
+	}
+
+	for _, test := range tests {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: test.labels, Annotations: test.annotations},
+			Status:     v1.NodeStatus{Addresses: narrowed},
+		}
+		cnc := &CloudNodeController{recorder: record.NewFakeRecorder(10)}
+		addresses, err := cnc.desiredNodeAddresses(node, cloudAddresses)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(addresses, test.addresses) {
+			t.Errorf("%s: expected addresses %v, found %v", test.name, test.addresses, addresses)
+		}
+	}
+}
+
+func TestGetProvidedNodeIPs(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		labels      map[string]string
+		annotations map[string]string
+		ips         []string
+		invalid     []string
+	}{
+		{name: "none"},
+		{
+			name:   "label only",
+			labels: map[string]string{LabelProvidedIPAddr: "10.0.0.1"},
+			ips:    []string{"10.0.0.1"},
+		},
+		{
+			name:        "annotation only",
+			annotations: map[string]string{AnnotationProvidedIPAddr: "10.0.0.2"},
+			ips:         []string{"10.0.0.2"},
+		},
+		{
+			name:        "annotation and label disagreeing",
+			labels:      map[string]string{LabelProvidedIPAddr: "10.0.0.1"},
+			annotations: map[string]string{AnnotationProvidedIPAddr: "10.0.0.2"},
+			ips:         []string{"10.0.0.2"},
+		},
+		{
+			name:        "malformed",
+			annotations: map[string]string{AnnotationProvidedIPAddr: "10.0.0.300"},
+			invalid:     []string{"10.0.0.300"},
+		},
+		{
+			name:        "custom key",
+			key:         "example.com/node-ip",
+			labels:      map[string]string{"example.com/node-ip": "10.0.0.3"},
+			annotations: map[string]string{AnnotationProvidedIPAddr: "10.0.0.2"},
+			ips:         []string{"10.0.0.3"},
+		},
+		{
+			name:        "custom key annotation",
+			key:         "example.com/node-ip",
+			annotations: map[string]string{"example.com/node-ip": "10.0.0.4"},
+			ips:         []string{"10.0.0.4"},
+		},
+	}
+
+	for _, test := range tests {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: test.labels, Annotations: test.annotations}}
+		ips, invalid := getProvidedNodeIPs(node, test.key)
+		found := []string{}
+		for _, ip := range ips {
+			found = append(found, ip.String())
+		}
+		if len(found) != len(test.ips) || (len(found) > 0 && !reflect.DeepEqual(found, test.ips)) {
+			t.Errorf("%s: expected IPs %v, found %v", test.name, test.ips, found)
+		}
+		if !reflect.DeepEqual(invalid, test.invalid) {
+			t.Errorf("%s: expected invalid entries %v, found %v", test.name, test.invalid, invalid)
+		}
+	}
+}
+
+func TestDesiredNodeAddressesKeepsKubeletHostname(t *testing.T) {
+	hostname := v1.NodeAddress{Type: v1.NodeHostName, Address: "node1"}
+	internal := v1.NodeAddress{Type: v1.NodeInternalIP, Address: "192.168.0.1"}
+	external := v1.NodeAddress{Type: v1.NodeExternalIP, Address: "10.0.0.9"}
+	tests := []struct {
+		name    string
+		current []v1.NodeAddress
+	}{
+		{name: "only address", current: []v1.NodeAddress{hostname}},
+		{name: "first address", current: []v1.NodeAddress{hostname, internal, external}},
+		{name: "middle address", current: []v1.NodeAddress{internal, hostname, external}},
+		{name: "last address", current: []v1.NodeAddress{internal, external, hostname}},
+	}
+
+	for _, test := range tests {
+		node := &v1.Node{Status: v1.NodeStatus{Addresses: test.current}}
+		// the addresses are replaced, so only the hostname is kept
+		cnc := &CloudNodeController{replaceAddresses: true}
+
+		addresses, err := cnc.desiredNodeAddresses(node, []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		expected := []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}, hostname}
+		if !reflect.DeepEqual(addresses, expected) {
+			t.Errorf("%s: expected addresses %v, found %v", test.name, expected, addresses)
+		}
+	}
+}
+
+func TestGetCloudTaint(t *testing.T) {
+	cloudTaint := v1.Taint{Key: CloudTaintKey, Value: "true", Effect: v1.TaintEffectNoSchedule}
+	dedicated := v1.Taint{Key: "dedicated", Value: "db", Effect: v1.TaintEffectNoSchedule}
+	unreachable := v1.Taint{Key: "node.alpha.kubernetes.io/unreachable", Effect: v1.TaintEffectNoExecute}
+	annotation := `[{"key":"dedicated","value":"db","effect":"NoSchedule"},{"key":"ExternalCloudProvider","value":"true","effect":"NoSchedule"},{"key":"other","effect":"NoExecute"}]`
+	tests := []struct {
+		name        string
+		taints      []v1.Taint
+		annotations map[string]string
+		expected    *v1.Taint
+	}{
+		{name: "no taints"},
+		{name: "other taints", taints: []v1.Taint{dedicated, unreachable}},
+		{name: "first taint in spec", taints: []v1.Taint{cloudTaint, dedicated, unreachable}, expected: &cloudTaint},
+		{name: "middle taint in spec", taints: []v1.Taint{dedicated, cloudTaint, unreachable}, expected: &cloudTaint},
+		{name: "last taint in spec", taints: []v1.Taint{dedicated, unreachable, cloudTaint}, expected: &cloudTaint},
+		{
+			name:        "middle taint in annotation",
+			annotations: map[string]string{v1.TaintsAnnotationKey: annotation},
+			expected:    &cloudTaint,
+		},
+		{
+			name:        "taint in spec and annotation",
+			taints:      []v1.Taint{dedicated, cloudTaint},
+			annotations: map[string]string{v1.TaintsAnnotationKey: annotation},
+			expected:    &cloudTaint,
+		},
+	}
+
+	for _, test := range tests {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations},
+			Spec:       v1.NodeSpec{Taints: test.taints},
+		}
+		taint, err := getCloudTaint(node)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(taint, test.expected) {
+			t.Errorf("%s: expected taint %v, found %v", test.name, test.expected, taint)
+		}
+	}
+}
+
+func TestPatchNodeAddressesRetriesConflicts(t *testing.T) {
+	stale := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	// someone else already set the address in the meantime
+	fresh := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: v1.NodeStatus{Addresses: []v1.NodeAddress{
+			{Type: v1.NodeExternalIP, Address: "10.0.0.1"},
+		}},
+	}
+	recordManagedAddresses(fresh, fresh.Status.Addresses)
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": fresh}, conflicts: 1}
+	cnc := &CloudNodeController{kubeClient: &fakeClientset{nodes: nodes}, cloud: &fakeCloud{}, recorder: record.NewFakeRecorder(10)}
+
+	before := conflictCount(t)
+	skippedBefore := skippedPatchCount(t)
+	err := cnc.patchNodeAddresses(stale, []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the retry is computed against the fresh node, which needs no patch
+	if len(nodes.patches) != 1 || nodes.gets != 1 {
+		t.Fatalf("expected the patch to be retried once with a fresh node, found %d patches and %d gets", len(nodes.patches), nodes.gets)
+	}
+	if after := skippedPatchCount(t); after != skippedBefore+1 {
+		t.Errorf("expected the retried patch to be skipped, count went from %v to %v", skippedBefore, after)
+	}
+	if after := conflictCount(t); after != before+1 {
+		t.Errorf("expected the conflict to be counted, count went from %v to %v", before, after)
+	}
+}
+
+func TestPatchNodeAddressesGivesUp(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}, conflicts: 100}
+	cnc := &CloudNodeController{kubeClient: &fakeClientset{nodes: nodes}, cloud: &fakeCloud{}, recorder: record.NewFakeRecorder(10)}
+
+	err := cnc.patchNodeAddresses(node, []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}})
+	if !errors.IsConflict(err) {
+		t.Errorf("expected conflict error, found %v", err)
+	}
+	if len(nodes.patches) != UpdateNodeStatusBackoff.Steps {
+		t.Errorf("expected %d attempts, found %d", UpdateNodeStatusBackoff.Steps, len(nodes.patches))
+	}
+}
+
+func TestPatchNodeAddressesWritesLabelsToNode(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+	cnc := &CloudNodeController{kubeClient: &fakeClientset{nodes: nodes}, cloud: &fakeCloud{instanceType: "rancher"}, recorder: record.NewFakeRecorder(10)}
+
+	err := cnc.patchNodeAddresses(node, []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the status subresource ignores labels, they have to go out in a patch of the node object
+	if len(nodes.patches) != 2 || nodes.subresources[0] != "" || nodes.subresources[1] != "status" {
+		t.Fatalf("expected a node patch and a status patch, found %v to %v", nodes.patches, nodes.subresources)
+	}
+	if !strings.Contains(nodes.patches[0], metav1.LabelInstanceType) || strings.Contains(nodes.patches[0], `"addresses"`) {
+		t.Errorf("expected the node patch to hold the labels only, found %s", nodes.patches[0])
+	}
+	// along with the record of the addresses the cloud owns
+	if !strings.Contains(nodes.patches[0], AnnotationManagedAddresses) {
+		t.Errorf("expected the node patch to record the cloud addresses, found %s", nodes.patches[0])
+	}
+	if !strings.Contains(nodes.patches[1], "10.0.0.1") || strings.Contains(nodes.patches[1], metav1.LabelInstanceType) {
+		t.Errorf("expected the status patch to hold the addresses only, found %s", nodes.patches[1])
+	}
+}
+
+func conflictCount(t *testing.T) float64 {
+	m := &dto.Metric{}
+	if err := nodeStatusPatchConflicts.Write(m); err != nil {
+		t.Fatalf("Couldn't read metric: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func skippedPatchCount(t *testing.T) float64 {
+	m := &dto.Metric{}
+	if err := nodeStatusPatchesSkipped.Write(m); err != nil {
+		t.Fatalf("Couldn't read metric: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestPatchNodeAddressesSkipsUpToDateNodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		current []v1.NodeAddress
+		cloud   []v1.NodeAddress
+		patched bool
+	}{
+		{
+			name:    "same addresses",
+			current: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}, {Type: v1.NodeInternalIP, Address: "192.168.0.1"}},
+			cloud:   []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}, {Type: v1.NodeInternalIP, Address: "192.168.0.1"}},
+		},
+		{
+			name:    "reordered addresses",
+			current: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.168.0.1"}, {Type: v1.NodeExternalIP, Address: "10.0.0.1"}},
+			cloud:   []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}, {Type: v1.NodeInternalIP, Address: "192.168.0.1"}},
+		},
+		{
+			name:    "changed address",
+			current: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}, {Type: v1.NodeInternalIP, Address: "192.168.0.1"}},
+			cloud:   []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.2"}, {Type: v1.NodeInternalIP, Address: "192.168.0.1"}},
+			patched: true,
+		},
+		{
+			name:    "new address",
+			current: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}},
+			cloud:   []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}, {Type: v1.NodeInternalIP, Address: "192.168.0.1"}},
+			patched: true,
+		},
+	}
+
+	for _, test := range tests {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+			Status:     v1.NodeStatus{Addresses: test.current},
+		}
+		// the previous pass recorded the current addresses
+		recordManagedAddresses(node, test.current)
+		nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+		cnc := &CloudNodeController{kubeClient: &fakeClientset{nodes: nodes}, cloud: &fakeCloud{}, recorder: record.NewFakeRecorder(10)}
+
+		before := skippedPatchCount(t)
+		if err := cnc.patchNodeAddresses(node, test.cloud); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if patched := nodes.statusPatches() == 1; patched != test.patched {
+			t.Errorf("%s: expected patched to be %v, found %d patches", test.name, test.patched, nodes.statusPatches())
+		}
+		if skipped := skippedPatchCount(t) == before+1; skipped == test.patched {
+			t.Errorf("%s: expected the skipped patch to be counted only if not patched", test.name)
+		}
+	}
+}
+
+func TestUpdateNodeStatusWaitsForAddresses(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+	cloud := &fakeCloud{addresses: []v1.NodeAddress{{Type: v1.NodeHostName, Address: "node1"}}}
+	recorder := record.NewFakeRecorder(10)
+	cnc := &CloudNodeController{
+		kubeClient:     &fakeClientset{nodes: nodes},
+		nodeInformer:   &fakeNodeInformer{nodes: nodes},
+		cloud:          cloud,
+		recorder:       recorder,
+		waiting:        map[string]bool{},
+		addressBackoff: flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+		failing:        map[string]bool{},
+		lookupBackoff:  flowcontrol.NewBackOff(initialLookupBackoff, maxLookupBackoff),
+	}
+
+	cnc.UpdateNodeStatus()
+	cnc.UpdateNodeStatus()
+
+	if len(nodes.patches) != 0 {
+		t.Errorf("expected node without addresses not to be patched, found %v", nodes.patches)
+	}
+	if cloud.lookups != 1 {
+		t.Errorf("expected lookups to back off, found %d lookups", cloud.lookups)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected a single event, found %d", len(recorder.Events))
+	}
+
+	// the backoff expires and the host got networking
+	cnc.addressBackoff.Reset("node1")
+	cloud.addresses = append(cloud.addresses, v1.NodeAddress{Type: v1.NodeExternalIP, Address: "10.0.0.1"})
+	cnc.UpdateNodeStatus()
+
+	if nodes.statusPatches() != 1 {
+		t.Errorf("expected node to be patched once addresses are reported, found %d patches", nodes.statusPatches())
+	}
+	if cnc.waitingForAddresses("node1") {
+		t.Errorf("expected node to no longer wait for addresses")
+	}
+}
+
+func TestDeleteCloudNodeForgetsWaitingNode(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+	cloud := &fakeCloud{addresses: []v1.NodeAddress{{Type: v1.NodeHostName, Address: "node1"}}}
+	recorder := record.NewFakeRecorder(10)
+	cnc := &CloudNodeController{
+		kubeClient:     &fakeClientset{nodes: nodes},
+		nodeInformer:   &fakeNodeInformer{nodes: nodes},
+		cloud:          cloud,
+		recorder:       recorder,
+		waiting:        map[string]bool{},
+		addressBackoff: flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+		failing:        map[string]bool{},
+		lookupBackoff:  flowcontrol.NewBackOff(initialLookupBackoff, maxLookupBackoff),
+	}
+
+	cnc.UpdateNodeStatus()
+	if !cnc.waitingForAddresses("node1") || len(recorder.Events) != 1 {
+		t.Fatalf("expected node to wait for addresses with an event, found %d events", len(recorder.Events))
+	}
+	<-recorder.Events
+
+	// the node is deleted and registers again while its lookups were backing off
+	cnc.DeleteCloudNode(node)
+	if cnc.waitingForAddresses("node1") {
+		t.Errorf("expected the deleted node to no longer wait for addresses")
+	}
+	cnc.UpdateNodeStatus()
+
+	if cloud.lookups != 2 {
+		t.Errorf("expected the new node to be looked up right away, found %d lookups", cloud.lookups)
+	}
+	if reasons := eventReasons(recorder); len(reasons) != 1 || reasons[0] != EventWaitingForAddresses {
+		t.Errorf("expected the new node to wait for addresses with an event, found %v", reasons)
+	}
+}
+
+func TestMonitorNodeMinimumAge(t *testing.T) {
+	tests := []struct {
+		name    string
+		age     time.Duration
+		deleted bool
+	}{
+		{name: "new node", age: time.Minute},
+		{name: "old node", age: 10 * time.Minute, deleted: true},
+	}
+
+	for _, test := range tests {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "node1",
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-test.age)),
+			},
+			Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionFalse}}},
+		}
+		nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}, deleted: make(chan string, 1)}
+		cloud := &fakeCloud{}
+		cnc := &CloudNodeController{
+			kubeClient:             &fakeClientset{nodes: nodes},
+			nodeInformer:           &fakeNodeInformer{nodes: nodes},
+			cloud:                  cloud,
+			recorder:               record.NewFakeRecorder(10),
+			nodeDeletionMinimumAge: 5 * time.Minute,
+			missing:                map[string]int{},
+			enableNodeDeletion:     true,
+			deletionQueue:          workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		}
+
+		cnc.MonitorNode()
+		processDeletions(cnc)
+
+		if !test.deleted {
+			if cloud.lookups != 0 {
+				t.Errorf("%s: expected node not to be looked up in the cloud", test.name)
+			}
+			continue
+		}
+		select {
+		case <-nodes.deleted:
+		case <-time.After(wait.ForeverTestTimeout):
+			t.Errorf("%s: expected node to be deleted", test.name)
+		}
+	}
+}
+
+func TestMonitorNodeDeletesAfterMissingChecks(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node1",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+		},
+		Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionFalse}}},
+	}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}, deleted: make(chan string, 1)}
+	cloud := &fakeCloud{}
+	recorder := record.NewFakeRecorder(10)
+	cnc := &CloudNodeController{
+		kubeClient:                &fakeClientset{nodes: nodes},
+		nodeInformer:              &fakeNodeInformer{nodes: nodes},
+		cloud:                     cloud,
+		recorder:                  recorder,
+		nodeDeletionMissingChecks: 3,
+		missing:                   map[string]int{},
+		enableNodeDeletion:        true,
+		deletionQueue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	expectNotDeleted := func(step string) {
+		select {
+		case <-nodes.deleted:
+			t.Fatalf("%s: expected node not to be deleted", step)
+		default:
+		}
+	}
+
+	// the host is missing, then found again before the node is deleted
+	cnc.MonitorNode()
+	processDeletions(cnc)
+	cnc.MonitorNode()
+	expectNotDeleted("missing twice")
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "InstanceMissing") {
+			t.Errorf("expected a warning on the first miss, found %q", event)
+		}
+	default:
+		t.Errorf("expected a warning on the first miss")
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("expected a single warning, found %d more events", len(recorder.Events))
+	}
+	cloud.externalID = "1h1"
+	cnc.MonitorNode()
+	processDeletions(cnc)
+	expectNotDeleted("found again")
+
+	// the misses are counted again from the start
+	cloud.externalID = ""
+	cnc.MonitorNode()
+	processDeletions(cnc)
+	cnc.MonitorNode()
+	expectNotDeleted("missing twice again")
+	cnc.MonitorNode()
+	processDeletions(cnc)
+	select {
+	case <-nodes.deleted:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Errorf("expected node to be deleted after 3 misses in a row")
+	}
+}
+
+func TestMonitorNodeWithNodeDeletionDisabled(t *testing.T) {
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": notReadyNode("1")}, deleteCalls: make(chan string, 1)}
+	recorder := record.NewFakeRecorder(10)
+	cnc := &CloudNodeController{
+		kubeClient:                &fakeClientset{nodes: nodes},
+		nodeInformer:              &fakeNodeInformer{nodes: nodes},
+		cloud:                     &fakeCloud{},
+		recorder:                  recorder,
+		nodeDeletionMissingChecks: 2,
+		missing:                   map[string]int{},
+		deletionQueue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	defer cnc.deletionQueue.ShutDown()
+
+	for i := 0; i < 4; i++ {
+		cnc.MonitorNode()
+		processDeletions(cnc)
+	}
+
+	select {
+	case name := <-nodes.deleteCalls:
+		t.Errorf("expected node %s not to be deleted with node deletion disabled", name)
+	default:
+	}
+	events := []string{}
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	if len(events) != 1 || !strings.Contains(events[0], EventInstanceMissing) || !strings.Contains(events[0], "node deletion is disabled") {
+		t.Errorf("expected a single warning that the node isn't deleted, found %q", events)
+	}
+}
+
+func TestMonitorNodeSkipsNodesWithoutReadyCondition(t *testing.T) {
+	const count = 100
+	nodes := &fakeNodes{items: map[string]*v1.Node{}}
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("node%d", i)
+		nodes.items[name] = &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour))},
+		}
+	}
+	cnc := &CloudNodeController{
+		kubeClient:                &fakeClientset{nodes: nodes},
+		nodeInformer:              &fakeNodeInformer{nodes: nodes},
+		cloud:                     &fakeCloud{},
+		recorder:                  record.NewFakeRecorder(10),
+		nodeDeletionMissingChecks: 1,
+		missing:                   map[string]int{},
+		enableNodeDeletion:        true,
+		deletionQueue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	defer cnc.deletionQueue.ShutDown()
+
+	start := time.Now()
+	cnc.MonitorNode()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the monitor pass to complete promptly, took %v", elapsed)
+	}
+	if nodes.gets != 0 {
+		t.Errorf("expected nodes without a ready condition not to be read again, found %d gets", nodes.gets)
+	}
+	if queued := cnc.deletionQueue.Len(); queued != 0 {
+		t.Errorf("expected nodes without a ready condition not to be deleted, found %d queued", queued)
+	}
+}
+
+func TestMassDeletionConcurrencyIsBounded(t *testing.T) {
+	const count = 100
+	nodes := &fakeNodes{items: map[string]*v1.Node{}, deleted: make(chan string, count), deleteDelay: time.Millisecond}
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("node%d", i)
+		nodes.items[name] = &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID(name), CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour))},
+			Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionFalse}}},
+		}
+	}
+	cnc := &CloudNodeController{
+		kubeClient:                &fakeClientset{nodes: nodes},
+		nodeInformer:              &fakeNodeInformer{nodes: nodes},
+		cloud:                     &fakeCloud{},
+		recorder:                  record.NewFakeRecorder(3 * count),
+		nodeDeletionMissingChecks: 1,
+		missing:                   map[string]int{},
+		enableNodeDeletion:        true,
+		deletionQueue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	// every host is missing at once
+	cnc.MonitorNode()
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentNodeDeletions; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cnc.runDeletionWorker()
+		}()
+	}
+	for i := 0; i < count; i++ {
+		select {
+		case <-nodes.deleted:
+		case <-time.After(wait.ForeverTestTimeout):
+			t.Fatalf("expected %d nodes to be deleted, found %d", count, i)
+		}
+	}
+	cnc.deletionQueue.ShutDown()
+	wg.Wait()
+
+	if nodes.maxDeleting > concurrentNodeDeletions {
+		t.Errorf("expected at most %d deletions at once, found %d", concurrentNodeDeletions, nodes.maxDeleting)
+	}
+}
+
+func TestMonitorNodeReadyResetsMissingChecks(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1", CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour))},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}},
+	}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+	cnc := &CloudNodeController{
+		kubeClient:                &fakeClientset{nodes: nodes},
+		nodeInformer:              &fakeNodeInformer{nodes: nodes},
+		cloud:                     &fakeCloud{},
+		recorder:                  record.NewFakeRecorder(10),
+		nodeDeletionMissingChecks: 3,
+		missing:                   map[string]int{"node1": 2},
+		enableNodeDeletion:        true,
+		deletionQueue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	cnc.MonitorNode()
+	processDeletions(cnc)
+
+	if misses, ok := cnc.missing["node1"]; ok {
+		t.Errorf("expected the misses of a ready node to be forgotten, found %d", misses)
+	}
+}
+
+// fakeShutdownCloud reports every instance as shut down while shutdown is set
+type fakeShutdownCloud struct {
+	*fakeCloud
+	shutdown bool
+}
+
+func (f *fakeShutdownCloud) InstanceShutdownByProviderID(providerID string) (bool, error) {
+	return f.shutdown, nil
+}
+
+func TestMonitorNodeTaintsShutdownHosts(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node1",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+		},
+		Spec:   v1.NodeSpec{ProviderID: "rancher://1h1"},
+		Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionFalse}}},
+	}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}, deleteCalls: make(chan string, 1)}
+	cloud := &fakeShutdownCloud{fakeCloud: &fakeCloud{externalID: "1h1"}, shutdown: true}
+	cnc := &CloudNodeController{
+		kubeClient:                &fakeClientset{nodes: nodes},
+		nodeInformer:              &fakeNodeInformer{nodes: nodes},
+		cloud:                     cloud,
+		recorder:                  record.NewFakeRecorder(10),
+		nodeDeletionMissingChecks: 1,
+		missing:                   map[string]int{},
+		enableNodeDeletion:        true,
+		deletionQueue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	shutdownTaint := v1.Taint{Key: ShutdownTaintKey, Effect: v1.TaintEffectNoSchedule}
+
+	// the host is stopped
+	cnc.MonitorNode()
+	processDeletions(cnc)
+	cnc.MonitorNode()
+	stored, _ := nodes.Get("node1", metav1.GetOptions{})
+	if !reflect.DeepEqual(stored.Spec.Taints, []v1.Taint{shutdownTaint}) {
+		t.Errorf("expected the node of a stopped host to be tainted once, found %v", stored.Spec.Taints)
+	}
+	if len(nodes.patches) != 1 {
+		t.Errorf("expected a single patch, found %v", nodes.patches)
+	}
+
+	// the host is started
+	cloud.shutdown = false
+	cnc.MonitorNode()
+	processDeletions(cnc)
+	stored, _ = nodes.Get("node1", metav1.GetOptions{})
+	if len(stored.Spec.Taints) != 0 {
+		t.Errorf("expected the shutdown taint to be removed once the host runs, found %v", stored.Spec.Taints)
+	}
+
+	select {
+	case <-nodes.deleteCalls:
+		t.Errorf("expected the node of a stopped host not to be deleted")
+	default:
+	}
+}
+
+// fakeExistenceCloud tells whether instances exist by providerID, failing with err if set
+type fakeExistenceCloud struct {
+	*fakeCloud
+	exists bool
+	err    error
+}
+
+func (f *fakeExistenceCloud) InstanceExistsByProviderID(providerID string) (bool, error) {
+	return f.exists, f.err
+}
+
+type fakeInvalidProviderIDError struct {
+	providerID string
+}
+
+func (e *fakeInvalidProviderIDError) Error() string {
+	return fmt.Sprintf("invalid providerID %q", e.providerID)
+}
+
+func (e *fakeInvalidProviderIDError) InvalidProviderID() string {
+	return e.providerID
+}
+
+func TestMonitorNodePrefersInstanceExistsByProviderID(t *testing.T) {
+	tests := []struct {
+		name        string
+		externalID  string
+		exists      bool
+		err         error
+		expectQueue bool
+	}{
+		{name: "removed host found by name", externalID: "1h1", expectQueue: true},
+		{name: "existing host missing by name", exists: true},
+		{name: "API error", err: fmt.Errorf("connection refused")},
+		{name: "invalid providerID, host missing by name", err: &fakeInvalidProviderIDError{"rancher://1h1"}},
+		{name: "invalid providerID, host found by name", externalID: "1h1", err: &fakeInvalidProviderIDError{"rancher://1h1"}},
+	}
+
+	for _, test := range tests {
+		node := notReadyNode("1")
+		node.Spec.ProviderID = "rancher://1h1"
+		nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+		cnc := &CloudNodeController{
+			kubeClient:                &fakeClientset{nodes: nodes},
+			nodeInformer:              &fakeNodeInformer{nodes: nodes},
+			cloud:                     &fakeExistenceCloud{fakeCloud: &fakeCloud{externalID: test.externalID}, exists: test.exists, err: test.err},
+			recorder:                  record.NewFakeRecorder(10),
+			nodeDeletionMissingChecks: 1,
+			missing:                   map[string]int{},
+			enableNodeDeletion:        true,
+			deletionQueue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		}
+
+		cnc.MonitorNode()
+		if queued := cnc.deletionQueue.Len() == 1; queued != test.expectQueue {
+			t.Errorf("%s: expected node to be queued for deletion: %v, found %v", test.name, test.expectQueue, queued)
+		}
+		cnc.deletionQueue.ShutDown()
+	}
+}
+
+func TestHostChanged(t *testing.T) {
+	node := notReadyNode("1")
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+	cnc := &CloudNodeController{
+		kubeClient:                &fakeClientset{nodes: nodes},
+		nodeInformer:              &fakeNodeInformer{nodes: nodes},
+		cloud:                     &fakeCloud{},
+		recorder:                  record.NewFakeRecorder(10),
+		nodeDeletionMissingChecks: 1,
+		missing:                   map[string]int{},
+		enableNodeDeletion:        true,
+		addressBackoff:            flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+		deletionQueue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	defer cnc.deletionQueue.ShutDown()
+
+	if err := cnc.HostChanged("node2"); err != nil {
+		t.Errorf("expected a host without a node to be ignored, err: %v", err)
+	}
+	if cnc.deletionQueue.Len() != 0 {
+		t.Errorf("expected no node to be queued for deletion")
+	}
+	if err := cnc.HostChanged("node1"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if cnc.deletionQueue.Len() != 1 {
+		t.Errorf("expected the node of the removed host to be queued for deletion")
+	}
+}
+
+func TestMonitorNodeSkipsExemptNodes(t *testing.T) {
+	tests := []struct {
+		name        string
+		providerID  string
+		annotations map[string]string
+		deleted     bool
+	}{
+		{name: "rancher node", providerID: "rancher://1h1", deleted: true},
+		{name: "node without providerID", deleted: true},
+		{name: "annotated node", providerID: "rancher://1h1", annotations: map[string]string{AnnotationSkipNodeDeletion: "true"}},
+		{name: "bare metal node", providerID: "baremetal://rack1-3"},
+	}
+
+	for _, test := range tests {
+		node := notReadyNode("1")
+		node.Annotations = test.annotations
+		node.Spec.ProviderID = test.providerID
+		nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}, deleted: make(chan string, 1)}
+		cloud := &fakeCloud{}
+		cnc := &CloudNodeController{
+			kubeClient:         &fakeClientset{nodes: nodes},
+			nodeInformer:       &fakeNodeInformer{nodes: nodes},
+			cloud:              cloud,
+			recorder:           record.NewFakeRecorder(10),
+			missing:            map[string]int{},
+			enableNodeDeletion: true,
+			deletionQueue:      workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		}
+
+		cnc.MonitorNode()
+		processDeletions(cnc)
+
+		select {
+		case <-nodes.deleted:
+			if !test.deleted {
+				t.Errorf("%s: expected node not to be deleted", test.name)
+			}
+		default:
+			if test.deleted {
+				t.Errorf("%s: expected node to be deleted", test.name)
+			}
+		}
+		if !test.deleted && cloud.lookups != 0 {
+			t.Errorf("%s: expected node not to be looked up in the cloud", test.name)
+		}
+		cnc.deletionQueue.ShutDown()
+	}
+}
+
+func TestAddCloudNodeUntainted(t *testing.T) {
+	tests := []struct {
+		name        string
+		initialize  bool
+		annotations map[string]string
+		patched     bool
+	}{
+		{name: "taint only", initialize: false},
+		{name: "untainted node", initialize: true, patched: true},
+		{name: "initialized node", initialize: true, annotations: map[string]string{AnnotationInitialized: "true"}},
+	}
+
+	for _, test := range tests {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", Annotations: test.annotations},
+			Spec:       v1.NodeSpec{ProviderID: "rancher://1h1"},
+		}
+		nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+		cnc := &CloudNodeController{
+			kubeClient:               &fakeClientset{nodes: nodes},
+			recorder:                 record.NewFakeRecorder(10),
+			nodeInformer:             &fakeNodeInformer{nodes: nodes},
+			cloud:                    &fakeCloud{instanceType: "rancher"},
+			initializeUntaintedNodes: test.initialize,
+		}
+
+		cnc.AddCloudNode(node)
+
+		if !test.patched {
+			if len(nodes.patches) != 0 {
+				t.Errorf("%s: expected node not to be patched, found %v", test.name, nodes.patches)
+			}
+			continue
+		}
+		if len(nodes.patches) != 1 {
+			t.Fatalf("%s: expected node to be patched once, found %v", test.name, nodes.patches)
+		}
+		if patch := nodes.patches[0]; !strings.Contains(patch, AnnotationInitialized) || !strings.Contains(patch, metav1.LabelInstanceType) {
+			t.Errorf("%s: expected patch to label the node and mark it initialized, found %s", test.name, patch)
+		}
+	}
+}
+
+func TestAddCloudNodeProvidedIP(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		initialized bool
+	}{
+		{name: "no provided IP", initialized: true},
+		{name: "annotation in the cloud", annotations: map[string]string{AnnotationProvidedIPAddr: "10.0.0.1"}, initialized: true},
+		{name: "annotation not in the cloud", annotations: map[string]string{AnnotationProvidedIPAddr: "10.0.0.2"}},
+		{name: "label not in the cloud", labels: map[string]string{LabelProvidedIPAddr: "10.0.0.2"}},
+	}
+
+	for _, test := range tests {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: test.labels, Annotations: test.annotations},
+			Spec:       v1.NodeSpec{ProviderID: "rancher://1h1"},
+		}
+		nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+		cnc := &CloudNodeController{
+			kubeClient:               &fakeClientset{nodes: nodes},
+			recorder:                 record.NewFakeRecorder(10),
+			nodeInformer:             &fakeNodeInformer{nodes: nodes},
+			cloud:                    &fakeCloud{instanceType: "rancher", addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}}},
+			initializeUntaintedNodes: true,
+		}
+
+		cnc.AddCloudNode(node)
+
+		// a provided IP the cloud doesn't report keeps the node uninitialized
+		initialized := nodes.items["node1"].Annotations[AnnotationInitialized] == "true"
+		if initialized != test.initialized {
+			t.Errorf("%s: expected the node to be initialized %v, found %v", test.name, test.initialized, initialized)
+		}
+	}
+}
+
+func TestAddCloudNodeRemovesCloudTaint(t *testing.T) {
+	cloudTaint := v1.Taint{Key: CloudTaintKey, Value: "true", Effect: v1.TaintEffectNoSchedule}
+	otherTaint := v1.Taint{Key: "dedicated", Value: "db", Effect: v1.TaintEffectNoSchedule}
+	annotation := `[{"key":"ExternalCloudProvider","value":"true","effect":"NoSchedule"}]`
+	tests := []struct {
+		name        string
+		taints      []v1.Taint
+		annotations map[string]string
+		// taints left in the spec
+		expected []v1.Taint
+	}{
+		{
+			name:     "taint in spec",
+			taints:   []v1.Taint{otherTaint, cloudTaint},
+			expected: []v1.Taint{otherTaint},
+		},
+		{
+			name:        "taint in annotation",
+			annotations: map[string]string{v1.TaintsAnnotationKey: annotation},
+		},
+		{
+			name:        "taint in both",
+			taints:      []v1.Taint{cloudTaint},
+			annotations: map[string]string{v1.TaintsAnnotationKey: annotation},
+		},
+	}
+
+	for _, test := range tests {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", ResourceVersion: "7", Annotations: test.annotations},
+			Spec:       v1.NodeSpec{ProviderID: "rancher://1h1", Taints: test.taints},
+		}
+		nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+		cnc := &CloudNodeController{
+			kubeClient:     &fakeClientset{nodes: nodes},
+			recorder:       record.NewFakeRecorder(10),
+			nodeInformer:   &fakeNodeInformer{nodes: nodes},
+			cloud:          &fakeCloud{instanceType: "rancher", zone: &cloudprovider.Zone{FailureDomain: "eu-1a", Region: "eu"}},
+			topologyLabels: TopologyLabelsBeta,
+		}
+
+		cnc.AddCloudNode(node)
+
+		if len(nodes.patches) != 1 {
+			t.Errorf("%s: expected the node to be patched once, found patches %v", test.name, nodes.patches)
+			continue
+		}
+		if patch := nodes.patches[0]; !strings.Contains(patch, `"resourceVersion":"7"`) {
+			t.Errorf("%s: expected the patch to be conditioned on the resource version, found %s", test.name, patch)
+		}
+		stored, _ := nodes.Get("node1", metav1.GetOptions{})
+		expectedLabels := map[string]string{
+			metav1.LabelInstanceType:      "rancher",
+			metav1.LabelZoneFailureDomain: "eu-1a",
+			metav1.LabelZoneRegion:        "eu",
+		}
+		if !reflect.DeepEqual(stored.Labels, expectedLabels) {
+			t.Errorf("%s: expected labels %v, found %v", test.name, expectedLabels, stored.Labels)
+		}
+		if !reflect.DeepEqual(stored.Spec.Taints, test.expected) {
+			t.Errorf("%s: expected taints %v, found %v", test.name, test.expected, stored.Spec.Taints)
+		}
+		if _, ok := stored.Annotations[v1.TaintsAnnotationKey]; ok {
+			t.Errorf("%s: expected the taints annotation to be removed, found %v", test.name, stored.Annotations)
+		}
+	}
+}
+
+func TestAddCloudNodeRetriesConflicts(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1", ResourceVersion: "1"},
+		Spec: v1.NodeSpec{
+			ProviderID: "rancher://1h1",
+			Taints:     []v1.Taint{{Key: CloudTaintKey, Value: "true", Effect: v1.TaintEffectNoSchedule}},
+		},
+	}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}, conflicts: 1}
+	cnc := &CloudNodeController{
+		kubeClient:   &fakeClientset{nodes: nodes},
+		recorder:     record.NewFakeRecorder(10),
+		nodeInformer: &fakeNodeInformer{nodes: nodes},
+		cloud:        &fakeCloud{instanceType: "rancher"},
+	}
+
+	cnc.AddCloudNode(node)
+
+	if len(nodes.patches) != 2 || nodes.gets != 2 {
+		t.Errorf("expected the patch to be retried with a fresh node, found %d patches and %d gets", len(nodes.patches), nodes.gets)
+	}
+	stored, _ := nodes.Get("node1", metav1.GetOptions{})
+	if len(stored.Spec.Taints) != 0 || stored.Labels[metav1.LabelInstanceType] != "rancher" {
+		t.Errorf("expected the node to be labeled and untainted, found %#v", stored)
+	}
+}
+
+type fakeEnvironmentCloud struct {
+	*fakeCloud
+	id, name string
+}
+
+func (f *fakeEnvironmentCloud) InstanceEnvironment(nodeName types.NodeName) (string, string, error) {
+	return f.id, f.name, nil
+}
+
+func TestSetEnvironment(t *testing.T) {
+	cnc := &CloudNodeController{cloud: &fakeEnvironmentCloud{fakeCloud: &fakeCloud{}, id: "1a5", name: "QA / Staging (eu)"}}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node0"}}
+	labels := map[string]string{}
+
+	if err := cnc.setEnvironment(node, labels); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value := labels[LabelEnvironment]; value != "QA-Staging-eu" {
+		t.Errorf("expected label QA-Staging-eu, found %q", value)
+	}
+	if id := node.Annotations[AnnotationEnvironmentID]; id != "1a5" {
+		t.Errorf("expected annotation 1a5, found %q", id)
+	}
+
+	cnc.cloud = &fakeCloud{}
+	node = &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node0"}}
+	labels = map[string]string{}
+	if err := cnc.setEnvironment(node, labels); err != nil || len(labels) != 0 {
+		t.Errorf("expected no labels without environment support, found %v, err: %v", labels, err)
+	}
+}
+
+func TestSanitizeLabelValue(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	for in, want := range map[string]string{
+		"Default":      "Default",
+		"my env!":      "my-env",
+		"-_.dev._-":    "dev",
+		long:           long[:63],
+		"":             "",
+		"α-production": "production",
+	} {
+		if got := sanitizeLabelValue(in); got != want {
+			t.Errorf("sanitizeLabelValue(%q) = %q, expected %q", in, got, want)
+		}
+	}
+}
+
+func TestObserveUninitializedNodes(t *testing.T) {
+	now := time.Now()
+	node := func(name string, age time.Duration, taints string) *v1.Node {
+		n := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(now.Add(-age))}}
+		if taints != "" {
+			n.Annotations = map[string]string{v1.TaintsAnnotationKey: taints}
+		}
+		return n
+	}
+	cloudTaint := `[{"key": "ExternalCloudProvider", "value": "true", "effect": "NoSchedule"}]`
+
+	observeUninitializedNodes([]*v1.Node{
+		node("initialized", time.Hour, ""),
+		node("other taint", time.Hour, `[{"key": "dedicated", "value": "db", "effect": "NoSchedule"}]`),
+		node("new", time.Minute, cloudTaint),
+		node("stuck", 10*time.Minute, cloudTaint),
+	}, now)
+
+	m := &dto.Metric{}
+	if err := uninitializedNodes.Write(m); err != nil {
+		t.Fatalf("Couldn't read metric: %v", err)
+	}
+	if count := m.GetGauge().GetValue(); count != 2 {
+		t.Errorf("expected 2 uninitialized nodes, found %v", count)
+	}
+	if err := oldestUninitializedNodeAge.Write(m); err != nil {
+		t.Fatalf("Couldn't read metric: %v", err)
+	}
+	if age := m.GetGauge().GetValue(); age != 600 {
+		t.Errorf("expected the oldest uninitialized node to be 600s old, found %v", age)
+	}
+}
+
+func TestMonitorNodeAmbiguousInstance(t *testing.T) {
+	// the host was reinstalled and both hosts are active
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node1",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+		},
+		Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionFalse}}},
+	}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}, deleted: make(chan string, 1)}
+	recorder := record.NewFakeRecorder(10)
+	cnc := &CloudNodeController{
+		kubeClient:         &fakeClientset{nodes: nodes},
+		nodeInformer:       &fakeNodeInformer{nodes: nodes},
+		cloud:              &fakeCloud{externalIDErr: ambiguousError{"1h1", "1h2"}},
+		recorder:           recorder,
+		missing:            map[string]int{},
+		enableNodeDeletion: true,
+		deletionQueue:      workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	cnc.MonitorNode()
+	processDeletions(cnc)
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "AmbiguousInstance") || !strings.Contains(event, "1h1, 1h2") {
+			t.Errorf("expected an event naming the candidates, found %q", event)
+		}
+	default:
+		t.Errorf("expected a warning event")
+	}
+	select {
+	case <-nodes.deleted:
+		t.Errorf("expected node not to be deleted")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestUpdateNodeStatusSkipsCordonedNodes(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec:       v1.NodeSpec{Unschedulable: true},
+	}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+	cloud := &fakeCloud{addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}}}
+	cnc := &CloudNodeController{
+		kubeClient:        &fakeClientset{nodes: nodes},
+		recorder:          record.NewFakeRecorder(10),
+		nodeInformer:      &fakeNodeInformer{nodes: nodes},
+		cloud:             cloud,
+		waiting:           map[string]bool{},
+		addressBackoff:    flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+		failing:           map[string]bool{},
+		lookupBackoff:     flowcontrol.NewBackOff(initialLookupBackoff, maxLookupBackoff),
+		skipCordonedNodes: true,
+		skipped:           map[string]bool{},
+	}
+
+	cnc.UpdateNodeStatus()
+	cnc.UpdateNodeStatus()
+	if cloud.lookups != 0 || len(nodes.patches) != 0 {
+		t.Errorf("expected cordoned node to be skipped, found %d lookups and %d patches", cloud.lookups, len(nodes.patches))
+	}
+	if !cnc.skipped["node1"] {
+		t.Errorf("expected node to be recorded as skipped")
+	}
+
+	node.Spec.Unschedulable = false
+	cnc.UpdateNodeStatus()
+	if cloud.lookups != 1 || nodes.statusPatches() != 1 {
+		t.Errorf("expected uncordoned node to be updated, found %d lookups and %d patches", cloud.lookups, nodes.statusPatches())
+	}
+	if cnc.skipped["node1"] {
+		t.Errorf("expected node to no longer be recorded as skipped")
+	}
+
+	// a node deleted while cordoned is forgotten
+	node.Spec.Unschedulable = true
+	cnc.UpdateNodeStatus()
+	cnc.DeleteCloudNode(node)
+	if cnc.skipped["node1"] {
+		t.Errorf("expected deleted node to no longer be recorded as skipped")
+	}
+}
+
+func TestManageNodesCreatedAfter(t *testing.T) {
+	created := time.Now().Add(-time.Hour)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node1",
+			CreationTimestamp: metav1.NewTime(created),
+			Annotations: map[string]string{
+				v1.TaintsAnnotationKey: `[{"key":"ExternalCloudProvider","value":"true","effect":"NoSchedule"}]`,
+			},
+		},
+		Spec:   v1.NodeSpec{ProviderID: "rancher://1h1"},
+		Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionFalse}}},
+	}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}, deleted: make(chan string, 1)}
+	cloud := &fakeCloud{
+		addresses:    []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}},
+		instanceType: "rancher",
+	}
+	cnc := &CloudNodeController{
+		kubeClient:              &fakeClientset{nodes: nodes},
+		nodeInformer:            &fakeNodeInformer{nodes: nodes},
+		cloud:                   cloud,
+		recorder:                record.NewFakeRecorder(10),
+		waiting:                 map[string]bool{},
+		addressBackoff:          flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+		failing:                 map[string]bool{},
+		lookupBackoff:           flowcontrol.NewBackOff(initialLookupBackoff, maxLookupBackoff),
+		manageNodesCreatedAfter: created.Add(time.Minute),
+		ignored:                 map[string]bool{},
+		missing:                 map[string]int{},
+		enableNodeDeletion:      true,
+		deletionQueue:           workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	cnc.AddCloudNode(node)
+	cnc.UpdateNodeStatus()
+	cnc.MonitorNode()
+	processDeletions(cnc)
+	if nodes.gets != 0 || len(nodes.patches) != 0 || cloud.lookups != 0 {
+		t.Errorf("expected old node to be left alone, found %d gets, %d patches and %d lookups",
+			nodes.gets, len(nodes.patches), cloud.lookups)
+	}
+	select {
+	case <-nodes.deleted:
+		t.Errorf("expected old node not to be deleted")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if !cnc.ignored["node1"] {
+		t.Errorf("expected node to be recorded as ignored")
+	}
+
+	cnc.manageNodesCreatedAfter = created
+	cnc.AddCloudNode(node)
+	if len(nodes.patches) != 1 {
+		t.Errorf("expected node created at the time to be initialized, found %d patches", len(nodes.patches))
+	}
+}
+
+func TestRunWaitsForCacheSync(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}},
+	}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+	synced := make(chan struct{})
+	running := int32(0)
+	cnc := &CloudNodeController{
+		kubeClient:                &fakeClientset{nodes: nodes},
+		recorder:                  record.NewFakeRecorder(10),
+		nodeInformer:              &fakeNodeInformer{nodes: nodes, synced: synced},
+		cloud:                     &fakeCloud{},
+		waiting:                   map[string]bool{},
+		addressBackoff:            flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+		failing:                   map[string]bool{},
+		lookupBackoff:             flowcontrol.NewBackOff(initialLookupBackoff, maxLookupBackoff),
+		nodeMonitorPeriod:         time.Minute,
+		nodeStatusUpdateFrequency: time.Minute,
+		queue:                     workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		enableNodeDeletion:        true,
+		deletionQueue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		concurrentNodeSyncs:       1,
+		running:                   &running,
+	}
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cnc.Run(stopCh)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	nodes.lock.Lock()
+	if nodes.cacheLists != 0 {
+		t.Errorf("expected nodes not to be listed before the cache synced, found %d lists", nodes.cacheLists)
+	}
+	nodes.lock.Unlock()
+	if atomic.LoadInt32(&running) != 0 {
+		t.Errorf("expected the controller not to be running before the cache synced")
+	}
+
+	close(synced)
+	err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		nodes.lock.Lock()
+		defer nodes.lock.Unlock()
+		return nodes.cacheLists >= 2, nil
+	})
+	if err != nil {
+		t.Errorf("expected both loops to list the nodes from the cache once it synced")
+	}
+	if atomic.LoadInt32(&running) != 1 {
+		t.Errorf("expected the controller to be running once the cache synced")
+	}
+
+	close(stopCh)
+	select {
+	case <-done:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("expected Run to return once stopped")
+	}
+	if atomic.LoadInt32(&running) != 0 {
+		t.Errorf("expected the controller not to be running once stopped")
+	}
+	if nodes.lists != 0 {
+		t.Errorf("expected nodes not to be listed from the API server, found %d lists", nodes.lists)
+	}
+}
+
+func TestRunStopsGracefully(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec:       v1.NodeSpec{ProviderID: "rancher://1h1"},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}},
+	}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+	cloud := testutil.NewFaultyCloud(&fakeCloud{
+		addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}},
+	}, 1)
+	options := DefaultCloudNodeControllerOptions()
+	options.LoopJitter = 0
+	cnc, err := NewCloudNodeController(&fakeNodeInformer{nodes: nodes}, nil, cloud, options)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cnc.kubeClient = &fakeClientset{nodes: nodes}
+	// The event broadcaster of this client-go can't be shut down, its idle loop is left running.
+	// The watches delivering its events are stopped by Run.
+	goroutines := runtime.NumGoroutine()
+
+	// hold the address lookup of the first status pass
+	cloud.Pause("NodeAddresses")
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cnc.Run(stopCh)
+	}()
+	if err := cloud.WaitForPaused("NodeAddresses", 1, wait.ForeverTestTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cloud call in flight is canceled, the node update fails without patching the node
+	close(stopCh)
+	select {
+	case <-done:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("expected Run to cancel the cloud call in flight and return once stopped")
+	}
+	nodes.lock.Lock()
+	if len(nodes.patches) != 0 {
+		t.Errorf("expected the canceled node update not to patch the node, found %d patches", len(nodes.patches))
+	}
+	nodes.lock.Unlock()
+	cloud.Resume("NodeAddresses")
+
+	err = wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return runtime.NumGoroutine() <= goroutines, nil
+	})
+	if err != nil {
+		t.Errorf("expected no goroutines to be left, found %d more than before", runtime.NumGoroutine()-goroutines)
+	}
+}
+
+func TestFailedNodeSyncIsRetried(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+	cloud := testutil.NewFaultyCloud(&fakeCloud{
+		addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}},
+	}, 1)
+	cnc := &CloudNodeController{
+		kubeClient:     &fakeClientset{nodes: nodes},
+		recorder:       record.NewFakeRecorder(10),
+		nodeInformer:   &fakeNodeInformer{nodes: nodes},
+		cloud:          cloud,
+		waiting:        map[string]bool{},
+		addressBackoff: flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+		failing:        map[string]bool{},
+		lookupBackoff:  flowcontrol.NewBackOff(initialLookupBackoff, maxLookupBackoff),
+		queue:          workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond)),
+	}
+	defer cnc.queue.ShutDown()
+
+	// the Rancher API fails every lookup
+	cloud.SetFaults("NodeAddresses", testutil.Faults{ErrorRate: 1})
+	cnc.enqueueNodes()
+	for i := 0; i < maxNodeSyncRetries; i++ {
+		cnc.processNextNode()
+		if requeues := cnc.queue.NumRequeues("node1"); requeues != i+1 {
+			t.Fatalf("expected failed node to be requeued %d times, found %d", i+1, requeues)
+		}
+	}
+	cnc.processNextNode()
+	if cnc.queue.Len() != 0 || cnc.queue.NumRequeues("node1") != 0 {
+		t.Errorf("expected node to wait for the next pass after %d retries", maxNodeSyncRetries)
+	}
+
+	// the next pass doesn't look the node up while it's backed off
+	cnc.enqueueNodes()
+	cnc.processNextNode()
+	if !cnc.inLookupBackoff("node1") || cnc.queue.NumRequeues("node1") != 0 {
+		t.Errorf("expected the lookups of the node to be backed off after %d retries", maxNodeSyncRetries)
+	}
+
+	// the retry of the next pass after the backoff succeeds
+	cnc.lookupBackoff.Reset("node1")
+	cnc.enqueueNodes()
+	cnc.processNextNode()
+	cloud.SetFaults("NodeAddresses", testutil.Faults{})
+	cnc.processNextNode()
+	if nodes.statusPatches() != 1 || cnc.queue.NumRequeues("node1") != 0 {
+		t.Errorf("expected node to be updated once the lookup succeeds, found %d patches", nodes.statusPatches())
+	}
+	if cnc.failing["node1"] {
+		t.Errorf("expected the backoff to be cleared once the lookup succeeds")
+	}
+}
+
+func TestUpdateCloudNodeQueuesTaintedNodes(t *testing.T) {
+	tainted := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "tainted"},
+		Spec:       v1.NodeSpec{Taints: []v1.Taint{{Key: CloudTaintKey, Value: "true", Effect: v1.TaintEffectNoSchedule}}},
+	}
+	untainted := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "untainted"}}
+	cnc := &CloudNodeController{queue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())}
+	defer cnc.queue.ShutDown()
+
+	cnc.UpdateCloudNode(tainted, tainted)
+	cnc.UpdateCloudNode(untainted, untainted)
+
+	if cnc.queue.Len() != 1 {
+		t.Fatalf("expected only the tainted node to be queued, found %d nodes", cnc.queue.Len())
+	}
+	if key, _ := cnc.queue.Get(); key != "tainted" {
+		t.Errorf("expected the tainted node to be queued, found %v", key)
+	}
+}
+
+// fakeProviderIDCloud builds providerIDs of its own
+type fakeProviderIDCloud struct {
+	*fakeCloud
+	providerID string
+}
+
+func (f *fakeProviderIDCloud) ProviderIDByNodeName(nodeName types.NodeName) (string, error) {
+	return f.providerID, nil
+}
+
+func TestInitializeNodeProviderID(t *testing.T) {
+	tests := []struct {
+		name       string
+		providerID string
+		cloud      cloudprovider.Interface
+		// providerID of the initialized node, the node stays tainted if empty
+		expected string
+		events   []string
+	}{
+		{
+			name:       "set by kubelet",
+			providerID: "rancher://1h1",
+			cloud:      &fakeProviderIDCloud{fakeCloud: &fakeCloud{instanceType: "rancher"}, providerID: "rancher://1h2"},
+			expected:   "rancher://1h1",
+			events:     []string{EventCloudNodeInitialized},
+		},
+		{
+			name:     "built by the cloud",
+			cloud:    &fakeProviderIDCloud{fakeCloud: &fakeCloud{instanceType: "rancher"}, providerID: "rancher://1h2"},
+			expected: "rancher://1h2",
+			events:   []string{EventCloudNodeInitialized},
+		},
+		{
+			name:     "from the instance ID",
+			cloud:    &fakeCloud{instanceType: "rancher", instanceID: "1h3"},
+			expected: "rancher://1h3",
+			events:   []string{EventCloudNodeInitialized},
+		},
+		{
+			name:   "instance not found",
+			cloud:  &fakeCloud{instanceType: "rancher"},
+			events: []string{EventProviderIDNotFound},
+		},
+	}
+
+	for _, test := range tests {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", ResourceVersion: "1"},
+			Spec: v1.NodeSpec{
+				ProviderID: test.providerID,
+				Taints:     []v1.Taint{{Key: CloudTaintKey, Value: "true", Effect: v1.TaintEffectNoSchedule}},
+			},
+		}
+		nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+		recorder := record.NewFakeRecorder(10)
+		cnc := &CloudNodeController{
+			kubeClient:   &fakeClientset{nodes: nodes},
+			recorder:     recorder,
+			nodeInformer: &fakeNodeInformer{nodes: nodes},
+			cloud:        test.cloud,
+		}
+
+		err := cnc.initializeNode(node)
+		stored, _ := nodes.Get("node1", metav1.GetOptions{})
+		if test.expected == "" {
+			if err != errNoProviderID || len(stored.Spec.Taints) != 1 {
+				t.Errorf("%s: expected the node to stay tainted until it has a providerID, got %v", test.name, err)
+			}
+		} else if err != nil || len(stored.Spec.Taints) != 0 || stored.Spec.ProviderID != test.expected {
+			t.Errorf("%s: expected the node to be initialized with providerID %s, found %q (%v)", test.name, test.expected, stored.Spec.ProviderID, err)
+		}
+		if reasons := eventReasons(recorder); !reflect.DeepEqual(reasons, test.events) {
+			t.Errorf("%s: expected events %v, found %v", test.name, test.events, reasons)
+		}
+	}
+}
+
+func TestNodeWithoutProviderIDIsRetried(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec:       v1.NodeSpec{Taints: []v1.Taint{{Key: CloudTaintKey, Value: "true", Effect: v1.TaintEffectNoSchedule}}},
+	}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+	cnc := &CloudNodeController{
+		kubeClient:     &fakeClientset{nodes: nodes},
+		recorder:       record.NewFakeRecorder(2*maxNodeSyncRetries + 1),
+		nodeInformer:   &fakeNodeInformer{nodes: nodes},
+		cloud:          &fakeCloud{instanceType: "rancher"},
+		waiting:        map[string]bool{},
+		addressBackoff: flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+		failing:        map[string]bool{},
+		lookupBackoff:  flowcontrol.NewBackOff(initialLookupBackoff, maxLookupBackoff),
+		queue:          workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond)),
+	}
+	defer cnc.queue.ShutDown()
+
+	cnc.enqueueNodes()
+	for i := 0; i < 2*maxNodeSyncRetries; i++ {
+		cnc.processNextNode()
+	}
+	if requeues := cnc.queue.NumRequeues("node1"); requeues != 2*maxNodeSyncRetries {
+		t.Fatalf("expected the node to be retried until it has a providerID, found %d requeues", requeues)
+	}
+
+	// the kubelet sets the providerID
+	withProviderID := *node
+	withProviderID.Spec.ProviderID = "rancher://1h1"
+	nodes.set(&withProviderID)
+	cnc.processNextNode()
+
+	stored, _ := nodes.Get("node1", metav1.GetOptions{})
+	if len(stored.Spec.Taints) != 0 || cnc.queue.NumRequeues("node1") != 0 {
+		t.Errorf("expected the node to be initialized once it has a providerID, found %#v", stored)
+	}
+}
+
+func TestInitializationEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		cloud  *fakeCloud
+		reason string
+	}{
+		{name: "initialized", cloud: &fakeCloud{instanceType: "rancher"}, reason: EventCloudNodeInitialized},
+		// the instance type lookup keeps failing
+		{name: "failed", cloud: &fakeCloud{}, reason: EventCloudNodeInitializationFailed},
+	}
+
+	for _, test := range tests {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid1"},
+			Spec: v1.NodeSpec{
+				ProviderID: "rancher://1h1",
+				Taints:     []v1.Taint{{Key: CloudTaintKey, Value: "true", Effect: v1.TaintEffectNoSchedule}},
+			},
+		}
+		nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+		recorder := record.NewFakeRecorder(10)
+		cnc := &CloudNodeController{
+			kubeClient:     &fakeClientset{nodes: nodes},
+			recorder:       recorder,
+			nodeInformer:   &fakeNodeInformer{nodes: nodes},
+			cloud:          test.cloud,
+			waiting:        map[string]bool{},
+			addressBackoff: flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+			failing:        map[string]bool{},
+			lookupBackoff:  flowcontrol.NewBackOff(initialLookupBackoff, maxLookupBackoff),
+			queue:          workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond)),
+		}
+
+		cnc.enqueueNodes()
+		// until the node is initialized or given up on
+		for i := 0; i <= maxNodeSyncRetries && cnc.queue.Len()+cnc.queue.NumRequeues("node1") > 0; i++ {
+			cnc.processNextNode()
+		}
+		cnc.queue.ShutDown()
+
+		if reasons := eventReasons(recorder); !reflect.DeepEqual(reasons, []string{test.reason}) {
+			t.Errorf("%s: expected a single %s event, got %v", test.name, test.reason, reasons)
+		}
+	}
+}
+
+func TestPatchNodeAddressesRecordsChanges(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.168.0.1"}}},
+	}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+	recorder := record.NewFakeRecorder(10)
+	cnc := &CloudNodeController{kubeClient: &fakeClientset{nodes: nodes}, recorder: recorder, cloud: &fakeCloud{}}
+
+	if err := cnc.patchNodeAddresses(node, []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.168.0.2"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("expected a single event, found %d", len(recorder.Events))
+	}
+	expected := "Normal NodeAddressesChanged Node addresses changed from [InternalIP:192.168.0.1] to [InternalIP:192.168.0.2]"
+	if event := <-recorder.Events; event != expected {
+		t.Errorf("expected event %q, got %q", expected, event)
+	}
+}
+
+// slowCloud holds the address lookups of the node named slow until release is closed
+type slowCloud struct {
+	*fakeCloud
+	release chan struct{}
+}
+
+func (f *slowCloud) Instances() (cloudprovider.Instances, bool) {
+	return f, true
+}
+
+func (f *slowCloud) NodeAddresses(name types.NodeName) ([]v1.NodeAddress, error) {
+	if name == "slow" {
+		<-f.release
+	}
+	return f.fakeCloud.NodeAddresses(name)
+}
+
+func TestSlowNodeDoesNotHoldOthersUp(t *testing.T) {
+	nodes := &fakeNodes{items: map[string]*v1.Node{
+		"slow": {ObjectMeta: metav1.ObjectMeta{Name: "slow"}},
+		"fast": {ObjectMeta: metav1.ObjectMeta{Name: "fast"}},
+	}}
+	cloud := &slowCloud{
+		fakeCloud: &fakeCloud{addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}}},
+		release:   make(chan struct{}),
+	}
+	cnc := &CloudNodeController{
+		kubeClient:                &fakeClientset{nodes: nodes},
+		recorder:                  record.NewFakeRecorder(10),
+		nodeInformer:              &fakeNodeInformer{nodes: nodes},
+		cloud:                     cloud,
+		waiting:                   map[string]bool{},
+		addressBackoff:            flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+		failing:                   map[string]bool{},
+		lookupBackoff:             flowcontrol.NewBackOff(initialLookupBackoff, maxLookupBackoff),
+		nodeMonitorPeriod:         time.Minute,
+		nodeStatusUpdateFrequency: time.Minute,
+		queue:                     workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		enableNodeDeletion:        true,
+		deletionQueue:             workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		concurrentNodeSyncs:       2,
+	}
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cnc.Run(stopCh)
+	}()
+
+	err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return nodes.statusPatches() == 1, nil
+	})
+	if err != nil {
+		t.Errorf("expected the other node to be updated while the lookup of the slow one is held")
+	}
+
+	close(cloud.release)
+	close(stopCh)
+	<-done
+	if nodes.statusPatches() != 2 {
+		t.Errorf("expected both nodes to be updated, found %d patches", nodes.statusPatches())
+	}
+}
@@ -0,0 +1,145 @@
+package cloud
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// CloudNodeControllerOptions configures a CloudNodeController
+type CloudNodeControllerOptions struct {
+	// NodeMonitorPeriod is how often nodes are checked for deletion. It must be lower than
+	// NodeMonitorGracePeriod, so nodes are checked before the node lifecycle controller gives up
+	// on them.
+	NodeMonitorPeriod      time.Duration
+	NodeMonitorGracePeriod time.Duration
+
+	// NodeStatusUpdateFrequency is how often the addresses of all nodes are updated
+	NodeStatusUpdateFrequency time.Duration
+
+	// EnableNodeDeletion deletes the nodes whose host is missing from the cloud. If false they're only
+	// reported, by logs and warning events.
+	EnableNodeDeletion bool
+	// Nodes younger than NodeDeletionMinimumAge are never deleted
+	NodeDeletionMinimumAge time.Duration
+	// NodeDeletionMissingChecks is how many consecutive node monitor periods the host of a node that
+	// is not ready must be missing from the cloud before the node is deleted
+	NodeDeletionMissingChecks int
+	// DrainNodes cordons the nodes to delete and evicts their pods first, giving the pods
+	// DrainGracePeriod to terminate
+	DrainNodes       bool
+	DrainGracePeriod time.Duration
+
+	// ReplaceAddresses replaces the node addresses with the cloud addresses instead of merging them
+	ReplaceAddresses bool
+	// ProvidedNodeIPKey is the annotation and label key the IPs provided for nodes are read from. The
+	// AnnotationProvidedIPAddr annotation and the LabelProvidedIPAddr label are read if it's empty.
+	ProvidedNodeIPKey string
+	// WaitForNodeAddresses keeps the cloud taint until the cloud reports an IP address for the node
+	WaitForNodeAddresses bool
+	// InitializeUntaintedNodes initializes the nodes registered without the cloud taint too, once
+	InitializeUntaintedNodes bool
+	// SkipCordonedNodes stops updating the addresses and labels of unschedulable nodes
+	SkipCordonedNodes bool
+
+	// TopologyLabels tells which families of zone and region labels are written
+	TopologyLabels TopologyLabelPolicy
+	// MetadataLabels are the instance metadata fields labelled on nodes when they're initialized, see
+	// MetadataLabelKey
+	MetadataLabels []string
+	// HostLabels are the patterns of the host labels synced onto nodes, see path.Match. None are
+	// synced if it's empty.
+	HostLabels []string
+	// HostLabelPrefix is prepended to the keys of the host labels synced onto nodes
+	HostLabelPrefix string
+	// Nodes created before ManageNodesCreatedAfter are left alone, unless it's zero
+	ManageNodesCreatedAfter time.Time
+
+	// CloudCallTimeout is how long each call to the cloud provider may take before it fails, 0 for
+	// no limit
+	CloudCallTimeout time.Duration
+
+	// LoopJitter is the jitter factor of the periods of the node status and monitor loops
+	LoopJitter float64
+	// ConcurrentNodeSyncs is set by --concurrent-node-syncs, see CloudControllerManagerServer.ConcurrentNodeSyncs
+	ConcurrentNodeSyncs int
+
+	// Running, if set, is set to 1 while Run updates the nodes and to 0 once it stops, e.g. for
+	// the requests of other components to be accepted only by the instance leading
+	Running *int32
+}
+
+// DefaultCloudNodeControllerOptions returns the default options of a CloudNodeController
+func DefaultCloudNodeControllerOptions() CloudNodeControllerOptions {
+	return CloudNodeControllerOptions{
+		NodeMonitorPeriod:         5 * time.Second,
+		NodeMonitorGracePeriod:    40 * time.Second,
+		NodeStatusUpdateFrequency: 10 * time.Second,
+		EnableNodeDeletion:        true,
+		NodeDeletionMinimumAge:    5 * time.Minute,
+		NodeDeletionMissingChecks: 3,
+		DrainGracePeriod:          30 * time.Second,
+		WaitForNodeAddresses:      true,
+		TopologyLabels:            TopologyLabelsBoth,
+		MetadataLabels:            []string{MetadataOS, MetadataArch},
+		CloudCallTimeout:          10 * time.Second,
+		LoopJitter:                0.1,
+		ConcurrentNodeSyncs:       5,
+	}
+}
+
+// Validate returns an error if the options can't be used
+func (o CloudNodeControllerOptions) Validate() error {
+	if o.NodeMonitorPeriod <= 0 {
+		return fmt.Errorf("node monitor period must be positive, found %v", o.NodeMonitorPeriod)
+	}
+	if o.NodeMonitorPeriod >= o.NodeMonitorGracePeriod {
+		return fmt.Errorf("node monitor period %v must be lower than the node monitor grace period %v",
+			o.NodeMonitorPeriod, o.NodeMonitorGracePeriod)
+	}
+	if o.NodeStatusUpdateFrequency <= 0 {
+		return fmt.Errorf("node status update frequency must be positive, found %v", o.NodeStatusUpdateFrequency)
+	}
+	if o.NodeDeletionMissingChecks < 1 {
+		return fmt.Errorf("node deletion missing checks must be at least 1, found %d", o.NodeDeletionMissingChecks)
+	}
+	if o.DrainGracePeriod < 0 {
+		return fmt.Errorf("drain grace period must not be negative, found %v", o.DrainGracePeriod)
+	}
+	if _, err := ParseTopologyLabelPolicy(string(o.TopologyLabels)); err != nil {
+		return err
+	}
+	if o.ProvidedNodeIPKey != "" {
+		if errs := validation.IsQualifiedName(o.ProvidedNodeIPKey); len(errs) > 0 {
+			return fmt.Errorf("invalid provided node IP key %q: %s", o.ProvidedNodeIPKey, strings.Join(errs, ", "))
+		}
+	}
+	for _, field := range o.MetadataLabels {
+		if errs := validation.IsQualifiedName(MetadataLabelKey(field)); field == "" || len(errs) > 0 {
+			return fmt.Errorf("invalid metadata label field %q: %s", field, strings.Join(errs, ", "))
+		}
+	}
+	for _, pattern := range o.HostLabels {
+		if _, err := path.Match(pattern, ""); pattern == "" || err != nil {
+			return fmt.Errorf("invalid host label pattern %q", pattern)
+		}
+	}
+	if o.HostLabelPrefix != "" {
+		if errs := validation.IsQualifiedName(o.HostLabelPrefix + "label"); len(errs) > 0 {
+			return fmt.Errorf("invalid host label prefix %q: %s", o.HostLabelPrefix, strings.Join(errs, ", "))
+		}
+	}
+	if o.CloudCallTimeout < 0 {
+		return fmt.Errorf("cloud call timeout must not be negative, found %v", o.CloudCallTimeout)
+	}
+	if o.LoopJitter < 0 {
+		return fmt.Errorf("loop jitter must not be negative, found %v", o.LoopJitter)
+	}
+	if o.ConcurrentNodeSyncs < 1 {
+		return fmt.Errorf("concurrent node syncs must be at least 1, found %d", o.ConcurrentNodeSyncs)
+	}
+	return nil
+}
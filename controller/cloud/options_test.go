@@ -0,0 +1,46 @@
+package cloud
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(o *CloudNodeControllerOptions)
+		valid  bool
+	}{
+		{name: "defaults", modify: func(o *CloudNodeControllerOptions) {}, valid: true},
+		{name: "slow status updates", modify: func(o *CloudNodeControllerOptions) { o.NodeStatusUpdateFrequency = time.Minute }, valid: true},
+		{name: "no status update frequency", modify: func(o *CloudNodeControllerOptions) { o.NodeStatusUpdateFrequency = 0 }},
+		{name: "monitor period above grace period", modify: func(o *CloudNodeControllerOptions) { o.NodeMonitorPeriod = time.Minute }},
+		{name: "monitor period equal to grace period", modify: func(o *CloudNodeControllerOptions) { o.NodeMonitorPeriod = o.NodeMonitorGracePeriod }},
+		{name: "no monitor period", modify: func(o *CloudNodeControllerOptions) { o.NodeMonitorPeriod = 0 }},
+		{name: "no missing checks", modify: func(o *CloudNodeControllerOptions) { o.NodeDeletionMissingChecks = 0 }},
+		{name: "negative drain grace period", modify: func(o *CloudNodeControllerOptions) { o.DrainGracePeriod = -time.Second }},
+		{name: "invalid topology labels", modify: func(o *CloudNodeControllerOptions) { o.TopologyLabels = "alpha" }},
+		{name: "custom provided node IP key", modify: func(o *CloudNodeControllerOptions) { o.ProvidedNodeIPKey = "example.com/node-ip" }, valid: true},
+		{name: "invalid provided node IP key", modify: func(o *CloudNodeControllerOptions) { o.ProvidedNodeIPKey = "node ip" }},
+		{name: "custom metadata labels", modify: func(o *CloudNodeControllerOptions) { o.MetadataLabels = []string{"os", "docker_version"} }, valid: true},
+		{name: "no metadata labels", modify: func(o *CloudNodeControllerOptions) { o.MetadataLabels = nil }, valid: true},
+		{name: "invalid metadata label", modify: func(o *CloudNodeControllerOptions) { o.MetadataLabels = []string{"docker version"} }},
+		{name: "empty metadata label", modify: func(o *CloudNodeControllerOptions) { o.MetadataLabels = []string{""} }},
+		{name: "host labels", modify: func(o *CloudNodeControllerOptions) { o.HostLabels = []string{"storage", "io.rancher.label/*"} }, valid: true},
+		{name: "invalid host label pattern", modify: func(o *CloudNodeControllerOptions) { o.HostLabels = []string{"gpu["} }},
+		{name: "host label prefix", modify: func(o *CloudNodeControllerOptions) { o.HostLabelPrefix = "host.rancher.io/" }, valid: true},
+		{name: "invalid host label prefix", modify: func(o *CloudNodeControllerOptions) { o.HostLabelPrefix = "host labels/" }},
+		{name: "no cloud call timeout", modify: func(o *CloudNodeControllerOptions) { o.CloudCallTimeout = 0 }, valid: true},
+		{name: "negative cloud call timeout", modify: func(o *CloudNodeControllerOptions) { o.CloudCallTimeout = -time.Second }},
+		{name: "negative jitter", modify: func(o *CloudNodeControllerOptions) { o.LoopJitter = -0.1 }},
+		{name: "no workers", modify: func(o *CloudNodeControllerOptions) { o.ConcurrentNodeSyncs = 0 }},
+	}
+
+	for _, test := range tests {
+		options := DefaultCloudNodeControllerOptions()
+		test.modify(&options)
+		if err := options.Validate(); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid to be %v, found error %v", test.name, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package cloud
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/kubernetes/pkg/api/v1"
+
+	"github.com/rancher/rancher-cloud-controller-manager/testutil"
+)
+
+func notReadyNode(uid string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node1",
+			UID:               types.UID(uid),
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+		},
+		Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionFalse}}},
+	}
+}
+
+// processDeletions deletes the nodes queued for deletion, in the calling goroutine
+func processDeletions(cnc *CloudNodeController) {
+	for cnc.deletionQueue.Len() > 0 {
+		cnc.processNextDeletion()
+	}
+}
+
+// expectNoDeletion waits for the deletion tried for node1 and checks it didn't happen
+func expectNoDeletion(t *testing.T, nodes *fakeNodes) {
+	select {
+	case <-nodes.deleteCalls:
+	case <-time.After(wait.ForeverTestTimeout):
+	}
+	select {
+	case <-nodes.deleted:
+		t.Errorf("expected node not to be deleted")
+	default:
+	}
+	if _, err := nodes.Get("node1", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected node to still exist: %v", err)
+	}
+}
+
+func TestNodeRecreatedDuringDelete(t *testing.T) {
+	nodes := &fakeNodes{
+		items:       map[string]*v1.Node{"node1": notReadyNode("old")},
+		deleted:     make(chan string, 1),
+		deleteCalls: make(chan string, 1),
+	}
+	cloud := testutil.NewFaultyCloud(&fakeCloud{}, 1)
+	cnc := &CloudNodeController{
+		kubeClient:         &fakeClientset{nodes: nodes},
+		nodeInformer:       &fakeNodeInformer{nodes: nodes},
+		cloud:              cloud,
+		recorder:           record.NewFakeRecorder(10),
+		missing:            map[string]int{},
+		enableNodeDeletion: true,
+		deletionQueue:      workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	// hold the host lookup until the kubelet registered the node again
+	cloud.Pause("ExternalID")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cnc.MonitorNode()
+		processDeletions(cnc)
+	}()
+	if err := cloud.WaitForPaused("ExternalID", 1, wait.ForeverTestTimeout); err != nil {
+		t.Fatal(err)
+	}
+	nodes.set(notReadyNode("new"))
+	cloud.Resume("ExternalID")
+	<-done
+
+	expectNoDeletion(t, nodes)
+	if node, _ := nodes.Get("node1", metav1.GetOptions{}); node != nil && node.UID != "new" {
+		t.Errorf("expected the registered node to be kept, found UID %s", node.UID)
+	}
+}
+
+func TestHostReappearsBeforeDelete(t *testing.T) {
+	nodes := &fakeNodes{
+		items:       map[string]*v1.Node{"node1": notReadyNode("1")},
+		deleted:     make(chan string, 1),
+		deleteCalls: make(chan string, 1),
+	}
+	cloud := testutil.NewFaultyCloud(&fakeCloud{}, 1)
+	// the host is back by the time the deletion is about to happen
+	cloud.SwitchAfter("ExternalID", 1, &fakeCloud{externalID: "1h1"})
+	cnc := &CloudNodeController{
+		kubeClient:         &fakeClientset{nodes: nodes},
+		nodeInformer:       &fakeNodeInformer{nodes: nodes},
+		cloud:              cloud,
+		recorder:           record.NewFakeRecorder(10),
+		missing:            map[string]int{},
+		enableNodeDeletion: true,
+		deletionQueue:      workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	cnc.MonitorNode()
+	processDeletions(cnc)
+
+	err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return cloud.Calls("ExternalID") == 2, nil
+	})
+	if err != nil {
+		t.Fatalf("expected the host to be looked up again before deleting the node")
+	}
+	select {
+	case <-nodes.deleteCalls:
+		t.Errorf("expected no deletion to be tried")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestConcurrentInitializationAndAddressSync(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+			Annotations: map[string]string{
+				v1.TaintsAnnotationKey: `[{"key":"ExternalCloudProvider","value":"true","effect":"NoSchedule"}]`,
+			},
+		},
+		Spec: v1.NodeSpec{ProviderID: "rancher://1h1"},
+	}
+	nodes := &fakeNodes{items: map[string]*v1.Node{"node1": node}}
+	cloud := testutil.NewFaultyCloud(&fakeCloud{
+		addresses:    []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}},
+		instanceType: "rancher",
+	}, 1)
+	cloud.SetFaults("NodeAddresses", testutil.Faults{Latency: testutil.UniformLatency(0, 10*time.Millisecond)})
+	cnc := &CloudNodeController{
+		kubeClient:           &fakeClientset{nodes: nodes},
+		nodeInformer:         &fakeNodeInformer{nodes: nodes},
+		cloud:                cloud,
+		recorder:             record.NewFakeRecorder(10),
+		waitForNodeAddresses: true,
+		waiting:              map[string]bool{"node1": true},
+		addressBackoff:       flowcontrol.NewBackOff(initialAddressBackoff, maxAddressBackoff),
+		failing:              map[string]bool{},
+		lookupBackoff:        flowcontrol.NewBackOff(initialLookupBackoff, maxLookupBackoff),
+	}
+
+	// the informer and the periodic sync both pick the node up while its addresses are looked up
+	cloud.Pause("NodeAddresses")
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		cnc.AddCloudNode(node)
+	}()
+	go func() {
+		defer wg.Done()
+		cnc.UpdateNodeStatus()
+	}()
+	if err := cloud.WaitForPaused("NodeAddresses", 2, wait.ForeverTestTimeout); err != nil {
+		t.Fatal(err)
+	}
+	cloud.Resume("NodeAddresses")
+	wg.Wait()
+
+	if cnc.waitingForAddresses("node1") {
+		t.Errorf("expected node to no longer wait for addresses")
+	}
+	nodes.lock.Lock()
+	defer nodes.lock.Unlock()
+	if len(nodes.patches) == 0 {
+		t.Errorf("expected node to be initialized")
+	}
+}
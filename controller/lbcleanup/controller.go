@@ -0,0 +1,217 @@
+package lbcleanup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/v1"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
+	coreinformers "k8s.io/kubernetes/pkg/client/informers/informers_generated/externalversions/core/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+
+	"github.com/rancher/rancher-cloud-controller-manager/controller/supervisor"
+)
+
+// Finalizer is put on the services of type LoadBalancer, and removed once their load balancer is
+// deleted. A service deleted while the controller manager is down keeps existing until then.
+const Finalizer = "rancher.io/loadbalancer-cleanup"
+
+// maxSyncRetries is how many times a failed finalizer update or load balancer deletion is retried
+// before waiting for the service to change again
+const maxSyncRetries = 5
+
+// Collector is implemented by cloud providers that find the load balancers they created for the
+// services that no longer exist
+type Collector interface {
+	// CollectLoadBalancers deletes the load balancers created for the services of the cluster
+	// named clusterName that are not among the UIDs returned by services
+	CollectLoadBalancers(clusterName string, services func() (map[types.UID]bool, error)) error
+}
+
+// Controller deletes the load balancers of the services deleted while the service controller
+// couldn't see it, with a finalizer on the services and by collecting the orphaned load balancers
+// periodically
+type Controller struct {
+	clusterName string
+	balancer    cloudprovider.LoadBalancer
+	// collector collects the orphaned load balancers every gcPeriod, if set
+	collector Collector
+	gcPeriod  time.Duration
+	client    corev1.ServicesGetter
+	services  coreinformers.ServiceInformer
+	queue     workqueue.RateLimitingInterface
+}
+
+// New returns a controller deleting the load balancers of balancer in the cluster named
+// clusterName. collector may be nil.
+func New(clusterName string, balancer cloudprovider.LoadBalancer, collector Collector, gcPeriod time.Duration,
+	client corev1.ServicesGetter, services coreinformers.ServiceInformer) *Controller {
+	c := &Controller{
+		clusterName: clusterName,
+		balancer:    balancer,
+		collector:   collector,
+		gcPeriod:    gcPeriod,
+		client:      client,
+		services:    services,
+		queue:       workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "load-balancer-cleanup"),
+	}
+	services.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(old, cur interface{}) {
+			c.enqueue(cur)
+		},
+	})
+	return c
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get the key of %#v: %v", obj, err))
+		return
+	}
+	c.queue.Add(key)
+}
+
+// Run updates the finalizers of the services and collects the orphaned load balancers until
+// stopCh is closed
+func (c *Controller) Run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+
+	glog.Infof("Starting load balancer cleanup controller")
+	defer glog.Infof("Shutting down load balancer cleanup controller")
+
+	if !cache.WaitForCacheSync(stopCh, c.services.Informer().HasSynced) {
+		utilruntime.HandleError(fmt.Errorf("timed out waiting for the load balancer cleanup caches to sync"))
+		return
+	}
+
+	done := supervisor.Default.Go("load-balancer-cleanup-sync", func() {
+		for c.processNextService() {
+		}
+	})
+	if c.collector != nil && c.gcPeriod > 0 {
+		supervisor.Default.Go("load-balancer-gc", func() {
+			wait.Until(c.collectLoadBalancers, c.gcPeriod, stopCh)
+		})
+	}
+	<-stopCh
+	c.queue.ShutDown()
+	<-done
+}
+
+// processNextService syncs the next service of the queue. It returns false once the queue is shut
+// down.
+func (c *Controller) processNextService() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	err := c.syncService(key.(string))
+	if err == nil {
+		c.queue.Forget(key)
+		return true
+	}
+	if c.queue.NumRequeues(key) < maxSyncRetries {
+		glog.V(2).Infof("Error cleaning up the load balancer of service %s, retrying: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	glog.Errorf("Error cleaning up the load balancer of service %s, giving up until it changes: %v", key, err)
+	c.queue.Forget(key)
+	return true
+}
+
+// syncService puts the finalizer on the service with the given key if it's of type LoadBalancer.
+// Once the service is deleted, or no longer of type LoadBalancer, its load balancer is deleted
+// and the finalizer removed.
+func (c *Controller) syncService(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	service, err := c.services.Lister().Services(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	wantsLB := service.DeletionTimestamp == nil && service.Spec.Type == v1.ServiceTypeLoadBalancer
+	switch {
+	case wantsLB && !hasFinalizer(service):
+		glog.V(2).Infof("Adding finalizer %s to service %s", Finalizer, key)
+		return c.updateFinalizers(service, append(service.Finalizers, Finalizer))
+	case !wantsLB && hasFinalizer(service):
+		glog.Infof("Deleting the load balancer of service %s", key)
+		if err := c.balancer.EnsureLoadBalancerDeleted(c.clusterName, service); err != nil {
+			return err
+		}
+		finalizers := []string{}
+		for _, f := range service.Finalizers {
+			if f != Finalizer {
+				finalizers = append(finalizers, f)
+			}
+		}
+		return c.updateFinalizers(service, finalizers)
+	}
+	return nil
+}
+
+func hasFinalizer(service *v1.Service) bool {
+	for _, f := range service.Finalizers {
+		if f == Finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// updateFinalizers replaces the finalizers of service. The informer brings the updated service
+// back, a conflict is retried with it.
+func (c *Controller) updateFinalizers(service *v1.Service, finalizers []string) error {
+	serviceCopy, err := api.Scheme.DeepCopy(service)
+	if err != nil {
+		return fmt.Errorf("failed to copy service to a new object")
+	}
+	updated := serviceCopy.(*v1.Service)
+	updated.Finalizers = finalizers
+	_, err = c.client.Services(service.Namespace).Update(updated)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// collectLoadBalancers deletes the load balancers of the services deleted without the finalizer,
+// e.g. before it existed
+func (c *Controller) collectLoadBalancers() {
+	err := c.collector.CollectLoadBalancers(c.clusterName, func() (map[types.UID]bool, error) {
+		// The API server rather than the cache, which may lag behind the load balancers
+		services, err := c.client.Services(metav1.NamespaceAll).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		uids := map[types.UID]bool{}
+		for _, service := range services.Items {
+			uids[service.UID] = true
+		}
+		return uids, nil
+	})
+	if err != nil {
+		glog.Errorf("Error collecting the orphaned load balancers: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package lbcleanup
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/kubernetes/pkg/api/v1"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+
+	"github.com/rancher/rancher-cloud-controller-manager/testutil"
+)
+
+// fakeServices stores the updated services back in the indexer of the informer, as the API server
+// and the informer would
+type fakeServices struct {
+	corev1.ServiceInterface
+	indexer cache.Indexer
+	updates int
+}
+
+func (f *fakeServices) Services(namespace string) corev1.ServiceInterface {
+	return f
+}
+
+func (f *fakeServices) Update(service *v1.Service) (*v1.Service, error) {
+	f.updates++
+	f.indexer.Update(service)
+	return service, nil
+}
+
+func (f *fakeServices) List(opts metav1.ListOptions) (*v1.ServiceList, error) {
+	list := &v1.ServiceList{}
+	for _, obj := range f.indexer.List() {
+		list.Items = append(list.Items, *obj.(*v1.Service))
+	}
+	return list, nil
+}
+
+// fakeBalancer records the services whose load balancer it's asked to delete
+type fakeBalancer struct {
+	cloudprovider.LoadBalancer
+	deleted []string
+	err     error
+}
+
+func (f *fakeBalancer) EnsureLoadBalancerDeleted(clusterName string, service *v1.Service) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.deleted = append(f.deleted, service.Name)
+	return nil
+}
+
+// fakeCollector records the service UIDs it's given
+type fakeCollector struct {
+	services map[types.UID]bool
+}
+
+func (f *fakeCollector) CollectLoadBalancers(clusterName string, services func() (map[types.UID]bool, error)) error {
+	var err error
+	f.services, err = services()
+	return err
+}
+
+func testService(name string, serviceType v1.ServiceType, finalizers ...string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name), Finalizers: finalizers},
+		Spec:       v1.ServiceSpec{Type: serviceType},
+	}
+}
+
+func newTestController() (*Controller, *testutil.FakeInformer, *fakeServices, *fakeBalancer) {
+	informer := testutil.NewFakeInformer()
+	services := &fakeServices{indexer: informer.Indexer}
+	balancer := &fakeBalancer{}
+	c := New("kubernetes", balancer, nil, 0, services, testutil.FakeServiceInformer{FakeInformer: informer})
+	return c, informer, services, balancer
+}
+
+// finalizers returns the finalizers of the service with the given name in informer
+func finalizers(informer *testutil.FakeInformer, name string) []string {
+	obj, exists, _ := informer.Indexer.GetByKey("default/" + name)
+	if !exists {
+		return nil
+	}
+	return obj.(*v1.Service).Finalizers
+}
+
+func TestFinalizerAdded(t *testing.T) {
+	c, informer, services, balancer := newTestController()
+	defer c.queue.ShutDown()
+
+	for _, service := range []*v1.Service{
+		testService("web", v1.ServiceTypeLoadBalancer),
+		testService("internal", v1.ServiceTypeClusterIP),
+		testService("done", v1.ServiceTypeLoadBalancer, Finalizer),
+	} {
+		informer.Indexer.Add(service)
+		informer.Handler.OnAdd(service)
+		c.processNextService()
+	}
+	if found := finalizers(informer, "web"); !reflect.DeepEqual(found, []string{Finalizer}) {
+		t.Errorf("expected the finalizer on the LoadBalancer service, found %v", found)
+	}
+	if found := finalizers(informer, "internal"); len(found) != 0 {
+		t.Errorf("expected the ClusterIP service to be left alone, found %v", found)
+	}
+	if services.updates != 1 || len(balancer.deleted) != 0 {
+		t.Errorf("expected a single update and no deletion, found %d updates and deleted %v", services.updates, balancer.deleted)
+	}
+
+	// the service is no longer a LoadBalancer
+	changed := testService("web", v1.ServiceTypeClusterIP, Finalizer)
+	informer.Indexer.Update(changed)
+	informer.Handler.OnUpdate(changed, changed)
+	c.processNextService()
+	if found := finalizers(informer, "web"); len(found) != 0 || !reflect.DeepEqual(balancer.deleted, []string{"web"}) {
+		t.Errorf("expected the load balancer deleted and the finalizer removed, found %v and deleted %v", found, balancer.deleted)
+	}
+}
+
+func TestDeletedWhileDown(t *testing.T) {
+	c, informer, services, balancer := newTestController()
+	defer c.queue.ShutDown()
+
+	// the service was deleted while the controller manager was down, the finalizer kept it
+	now := metav1.Now()
+	deleted := testService("web", v1.ServiceTypeLoadBalancer, "example.com/other", Finalizer)
+	deleted.DeletionTimestamp = &now
+	informer.Indexer.Add(deleted)
+
+	balancer.err = errors.New("unreachable")
+	informer.Handler.OnAdd(deleted)
+	c.processNextService()
+	if found := finalizers(informer, "web"); len(found) != 2 || services.updates != 0 {
+		t.Errorf("expected the finalizer to be kept until the load balancer is deleted, found %v", found)
+	}
+	if c.queue.NumRequeues("default/web") != 1 {
+		t.Errorf("expected a failed deletion to be retried, found %d retries", c.queue.NumRequeues("default/web"))
+	}
+
+	balancer.err = nil
+	c.processNextService()
+	if !reflect.DeepEqual(balancer.deleted, []string{"web"}) {
+		t.Errorf("expected the load balancer of the deleted service to be deleted, found %v", balancer.deleted)
+	}
+	if found := finalizers(informer, "web"); !reflect.DeepEqual(found, []string{"example.com/other"}) {
+		t.Errorf("expected only the finalizer of the controller to be removed, found %v", found)
+	}
+}
+
+func TestCollectLoadBalancers(t *testing.T) {
+	c, informer, _, _ := newTestController()
+	defer c.queue.ShutDown()
+	collector := &fakeCollector{}
+	c.collector = collector
+	informer.Indexer.Add(testService("web", v1.ServiceTypeLoadBalancer, Finalizer))
+	informer.Indexer.Add(testService("internal", v1.ServiceTypeClusterIP))
+
+	c.collectLoadBalancers()
+	if expected := map[types.UID]bool{"web": true, "internal": true}; !reflect.DeepEqual(collector.services, expected) {
+		t.Errorf("expected the collector to be given %v, found %v", expected, collector.services)
+	}
+}
@@ -0,0 +1,171 @@
+package localtraffic
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/kubernetes/pkg/api/v1"
+	v1service "k8s.io/kubernetes/pkg/api/v1/service"
+	coreinformers "k8s.io/kubernetes/pkg/client/informers/informers_generated/externalversions/core/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+
+	"github.com/rancher/rancher-cloud-controller-manager/controller/supervisor"
+)
+
+// maxSyncRetries is how many times a failed load balancer update is retried before waiting for
+// the endpoints to change again
+const maxSyncRetries = 5
+
+// Controller updates the load balancers of the services routing external traffic to their local
+// endpoints only when their endpoints move between nodes. The service controller only updates
+// load balancers when the nodes change.
+type Controller struct {
+	clusterName string
+	balancer    cloudprovider.LoadBalancer
+	services    coreinformers.ServiceInformer
+	nodes       coreinformers.NodeInformer
+	endpoints   coreinformers.EndpointsInformer
+	queue       workqueue.RateLimitingInterface
+}
+
+// New returns a controller updating the load balancers of balancer in the cluster named
+// clusterName
+func New(clusterName string, balancer cloudprovider.LoadBalancer, services coreinformers.ServiceInformer,
+	nodes coreinformers.NodeInformer, endpoints coreinformers.EndpointsInformer) *Controller {
+	c := &Controller{
+		clusterName: clusterName,
+		balancer:    balancer,
+		services:    services,
+		nodes:       nodes,
+		endpoints:   endpoints,
+		queue:       workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "local-traffic"),
+	}
+	endpoints.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(old, cur interface{}) {
+			if !reflect.DeepEqual(endpointNodes(old.(*v1.Endpoints)), endpointNodes(cur.(*v1.Endpoints))) {
+				c.enqueue(cur)
+			}
+		},
+		DeleteFunc: c.enqueue,
+	})
+	// The informers are run by the factory they come from once asked for
+	services.Informer()
+	nodes.Informer()
+	return c
+}
+
+// endpointNodes returns the names of the nodes running the ready endpoints of endpoints
+func endpointNodes(endpoints *v1.Endpoints) map[string]bool {
+	nodes := map[string]bool{}
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if address.NodeName != nil {
+				nodes[*address.NodeName] = true
+			}
+		}
+	}
+	return nodes
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get the key of %#v: %v", obj, err))
+		return
+	}
+	c.queue.Add(key)
+}
+
+// Run updates the load balancers until stopCh is closed
+func (c *Controller) Run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+
+	glog.Infof("Starting local traffic controller")
+	defer glog.Infof("Shutting down local traffic controller")
+
+	if !cache.WaitForCacheSync(stopCh, c.services.Informer().HasSynced, c.nodes.Informer().HasSynced,
+		c.endpoints.Informer().HasSynced) {
+		utilruntime.HandleError(fmt.Errorf("timed out waiting for the local traffic caches to sync"))
+		return
+	}
+
+	done := supervisor.Default.Go("local-traffic-sync", func() {
+		for c.processNextService() {
+		}
+	})
+	<-stopCh
+	c.queue.ShutDown()
+	<-done
+}
+
+// processNextService updates the load balancer of the next service of the queue. It returns false
+// once the queue is shut down.
+func (c *Controller) processNextService() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	err := c.syncService(key.(string))
+	if err == nil {
+		c.queue.Forget(key)
+		return true
+	}
+	if c.queue.NumRequeues(key) < maxSyncRetries {
+		glog.V(2).Infof("Error updating the load balancer of service %s, retrying: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	glog.Errorf("Error updating the load balancer of service %s, giving up until its endpoints change: %v", key, err)
+	c.queue.Forget(key)
+	return true
+}
+
+// syncService updates the load balancer of the service with the given key, if it routes external
+// traffic to its local endpoints only. Load balancers not created yet are left to the service
+// controller.
+func (c *Controller) syncService(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	service, err := c.services.Lister().Services(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if service.Spec.Type != v1.ServiceTypeLoadBalancer || !v1service.NeedsHealthCheck(service) ||
+		len(service.Status.LoadBalancer.Ingress) == 0 {
+		return nil
+	}
+
+	nodes, err := c.nodes.Lister().ListWithPredicate(nodeReady)
+	if err != nil {
+		return err
+	}
+	glog.V(2).Infof("Updating the load balancer of service %s, its endpoints moved", key)
+	return c.balancer.UpdateLoadBalancer(c.clusterName, service, nodes)
+}
+
+// nodeReady selects the nodes the service controller balances to, the schedulable ready ones
+func nodeReady(node *v1.Node) bool {
+	if node.Spec.Unschedulable || len(node.Status.Conditions) == 0 {
+		return false
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady && cond.Status != v1.ConditionTrue {
+			return false
+		}
+	}
+	return true
+}
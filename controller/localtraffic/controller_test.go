@@ -0,0 +1,110 @@
+package localtraffic
+
+import (
+	"errors"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/api/v1"
+	v1service "k8s.io/kubernetes/pkg/api/v1/service"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+
+	"github.com/rancher/rancher-cloud-controller-manager/testutil"
+)
+
+// fakeBalancer records the nodes of the load balancers it's asked to update
+type fakeBalancer struct {
+	cloudprovider.LoadBalancer
+	updates map[string][]string
+	err     error
+}
+
+func (f *fakeBalancer) UpdateLoadBalancer(clusterName string, service *v1.Service, nodes []*v1.Node) error {
+	names := []string{}
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	f.updates[service.Name] = names
+	return f.err
+}
+
+func readyNode(name string, ready bool) *v1.Node {
+	status := v1.ConditionTrue
+	if !ready {
+		status = v1.ConditionFalse
+	}
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: status}}},
+	}
+}
+
+func endpointsOn(name string, nodes ...string) *v1.Endpoints {
+	addresses := []v1.EndpointAddress{}
+	for i := range nodes {
+		addresses = append(addresses, v1.EndpointAddress{IP: "10.42.0.1", NodeName: &nodes[i]})
+	}
+	return &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Subsets:    []v1.EndpointSubset{{Addresses: addresses}},
+	}
+}
+
+func lbService(name string, local bool, ingress string) *v1.Service {
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: map[string]string{}},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+	}
+	if local {
+		service.Annotations[v1service.BetaAnnotationExternalTraffic] = v1service.AnnotationValueExternalTrafficLocal
+	}
+	if ingress != "" {
+		service.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: ingress}}
+	}
+	return service
+}
+
+func TestEndpointsMoved(t *testing.T) {
+	services, nodes, endpoints := testutil.NewFakeInformer(), testutil.NewFakeInformer(), testutil.NewFakeInformer()
+	balancer := &fakeBalancer{updates: map[string][]string{}}
+	c := New("kubernetes", balancer, testutil.FakeServiceInformer{FakeInformer: services},
+		testutil.FakeNodeInformer{FakeInformer: nodes}, testutil.FakeEndpointsInformer{FakeInformer: endpoints})
+	defer c.queue.ShutDown()
+
+	for _, node := range []*v1.Node{readyNode("node1", true), readyNode("node2", true), readyNode("node3", false)} {
+		nodes.Indexer.Add(node)
+	}
+	for _, service := range []*v1.Service{
+		lbService("local", true, "203.0.113.10"),
+		lbService("cluster", false, "203.0.113.11"),
+		lbService("pending", true, ""),
+	} {
+		services.Indexer.Add(service)
+	}
+
+	// endpoints changing on the same nodes don't move
+	old, cur := endpointsOn("local", "node1"), endpointsOn("local", "node1")
+	cur.Subsets[0].Addresses[0].IP = "10.42.0.2"
+	endpoints.Handler.OnUpdate(old, cur)
+	if c.queue.Len() != 0 {
+		t.Errorf("expected endpoints staying on their nodes to be ignored, found %d queued", c.queue.Len())
+	}
+
+	for _, name := range []string{"local", "cluster", "pending", "deleted"} {
+		endpoints.Handler.OnUpdate(endpointsOn(name, "node1"), endpointsOn(name, "node2"))
+		c.processNextService()
+	}
+	if len(balancer.updates) != 1 {
+		t.Errorf("expected only the load balancer of the local service to be updated, found %v", balancer.updates)
+	}
+	if nodes := balancer.updates["local"]; len(nodes) != 2 || nodes[0] == "node3" || nodes[1] == "node3" {
+		t.Errorf("expected the load balancer to be updated with the ready nodes, found %v", nodes)
+	}
+
+	balancer.err = errors.New("unreachable")
+	endpoints.Handler.OnAdd(endpointsOn("local", "node1"))
+	c.processNextService()
+	if c.queue.NumRequeues("default/local") != 1 {
+		t.Errorf("expected a failed update to be retried, found %d retries", c.queue.NumRequeues("default/local"))
+	}
+}
@@ -0,0 +1,166 @@
+// Package supervisor runs long-running loops, restarting them when they panic
+package supervisor
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/server/healthz"
+	"k8s.io/client-go/util/clock"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// DefaultMaxPanics panics of a loop within DefaultPanicWindow make the process unhealthy
+	DefaultMaxPanics   = 5
+	DefaultPanicWindow = 10 * time.Minute
+
+	// Backoff of restarting loops that keep panicking
+	initialRestartBackoff = time.Second
+	maxRestartBackoff     = time.Minute
+)
+
+var (
+	loopPanics = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rancher_ccm",
+			Name:      "loop_panics_total",
+			Help:      "Number of times a long-running loop panicked and was restarted, partitioned by loop.",
+		},
+		[]string{"loop"},
+	)
+
+	// Default supervises the loops of the controller manager
+	Default = New(DefaultMaxPanics, DefaultPanicWindow)
+)
+
+func init() {
+	prometheus.MustRegister(loopPanics)
+}
+
+// Supervisor restarts loops that panic after a backoff. It reports unhealthy once a loop
+// panicked maxPanics times within window, so the process gets restarted cleanly.
+type Supervisor struct {
+	maxPanics int
+	window    time.Duration
+	backoff   *flowcontrol.Backoff
+	// clock times the periods of the loops run with JitterUntil
+	clock clock.Clock
+
+	lock sync.Mutex
+	// panics holds the times of the recent panics of each loop
+	panics map[string][]time.Time
+}
+
+// New creates a Supervisor
+func New(maxPanics int, window time.Duration) *Supervisor {
+	return &Supervisor{
+		maxPanics: maxPanics,
+		window:    window,
+		backoff:   flowcontrol.NewBackOff(initialRestartBackoff, maxRestartBackoff),
+		clock:     clock.RealClock{},
+		panics:    map[string][]time.Time{},
+	}
+}
+
+// Go runs loop in a goroutine and restarts it whenever it panics. It isn't restarted once it returns.
+// The returned channel is closed then.
+func (s *Supervisor) Go(name string, loop func()) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for s.run(name, loop) {
+			s.backoff.Next(name, s.backoff.Clock.Now())
+			delay := s.backoff.Get(name)
+			glog.Infof("Restarting loop %s in %v", name, delay)
+			time.Sleep(delay)
+		}
+	}()
+	return done
+}
+
+// JitterUntil runs f every period until stopCh is closed, like wait.JitterUntil, in a supervised
+// loop. Each period is extended by up to jitterFactor times period, and the first run is delayed
+// by up to as much, so loops started together don't stay in step. There is no jitter if
+// jitterFactor is 0. The returned channel is closed once the loop stopped and its last run of f
+// returned.
+func (s *Supervisor) JitterUntil(name string, f func(), period time.Duration, jitterFactor float64, stopCh <-chan struct{}) <-chan struct{} {
+	initialDelay := time.Duration(0)
+	if jitterFactor > 0 {
+		initialDelay = time.Duration(rand.Float64() * jitterFactor * float64(period))
+	}
+	return s.Go(name, func() {
+		delay := initialDelay
+		// A restarted loop doesn't wait for the initial delay again
+		initialDelay = 0
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-s.clock.After(delay):
+			}
+			f()
+			delay = period
+			if jitterFactor > 0 {
+				delay = wait.Jitter(period, jitterFactor)
+			}
+		}
+	})
+}
+
+// run runs loop and tells whether it panicked
+func (s *Supervisor) run(name string, loop func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			s.recordPanic(name)
+			glog.Errorf("Loop %s panicked: %v\n%s", name, r, debug.Stack())
+		}
+	}()
+	loop()
+	return false
+}
+
+func (s *Supervisor) recordPanic(name string) {
+	loopPanics.WithLabelValues(name).Inc()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.panics[name] = s.recent(append(s.panics[name], time.Now()))
+}
+
+// recent returns the times within the window
+func (s *Supervisor) recent(times []time.Time) []time.Time {
+	oldest := time.Now().Add(-s.window)
+	for len(times) > 0 && times[0].Before(oldest) {
+		times = times[1:]
+	}
+	return times
+}
+
+// Healthy returns an error if a loop panicked too often recently
+func (s *Supervisor) Healthy() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for name, times := range s.panics {
+		if count := len(s.recent(times)); count >= s.maxPanics {
+			return fmt.Errorf("loop %s panicked %d times in the last %v", name, count, s.window)
+		}
+	}
+	return nil
+}
+
+// HealthzCheck returns a /healthz check failing while s is unhealthy
+func (s *Supervisor) HealthzCheck() healthz.HealthzChecker {
+	return healthz.NamedCheck("loops", func(*http.Request) error {
+		return s.Healthy()
+	})
+}
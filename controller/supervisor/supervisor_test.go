@@ -0,0 +1,105 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/clock"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+func TestGoRestartsPanickingLoop(t *testing.T) {
+	s := New(3, time.Minute)
+	s.backoff = flowcontrol.NewBackOff(time.Millisecond, time.Millisecond)
+
+	runs := make(chan int, 10)
+	count := 0
+	done := make(chan struct{})
+	s.Go("test", func() {
+		count++
+		runs <- count
+		if count < 3 {
+			panic("failed")
+		}
+		close(done)
+	})
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("loop wasn't restarted, ran %d times", len(runs))
+	}
+	if len(runs) != 3 {
+		t.Errorf("expected 3 runs, got %d", len(runs))
+	}
+	if err := s.Healthy(); err != nil {
+		t.Errorf("expected healthy after 2 panics, got %v", err)
+	}
+
+	s.recordPanic("test")
+	if err := s.Healthy(); err == nil {
+		t.Errorf("expected unhealthy after 3 panics")
+	}
+}
+
+func TestHealthyForgetsOldPanics(t *testing.T) {
+	s := New(1, time.Minute)
+	s.panics["test"] = []time.Time{time.Now().Add(-2 * time.Minute)}
+	if err := s.Healthy(); err != nil {
+		t.Errorf("expected panics outside the window to be ignored, got %v", err)
+	}
+
+	s.recordPanic("test")
+	if err := s.Healthy(); err == nil {
+		t.Errorf("expected unhealthy after a recent panic")
+	}
+	if len(s.panics["test"]) != 1 {
+		t.Errorf("expected old panics to be pruned, got %v", s.panics["test"])
+	}
+}
+
+func TestJitterUntilPeriods(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	s := New(DefaultMaxPanics, DefaultPanicWindow)
+	s.clock = fakeClock
+
+	period, jitter := 10*time.Second, 0.5
+	start := fakeClock.Now()
+	runs := make(chan time.Time)
+	stop := make(chan struct{})
+	defer close(stop)
+	s.JitterUntil("test", func() { runs <- fakeClock.Now() }, period, jitter, stop)
+
+	// waitForRun steps the clock until the loop runs and returns the time it ran
+	waitForRun := func() time.Time {
+		for {
+			select {
+			case ran := <-runs:
+				return ran
+			case <-time.After(time.Millisecond):
+			}
+			if fakeClock.HasWaiters() {
+				fakeClock.Step(200 * time.Millisecond)
+			}
+		}
+	}
+
+	last := waitForRun()
+	if delay := last.Sub(start); delay > time.Duration(jitter*float64(period)) {
+		t.Errorf("expected an initial delay of at most %v, found %v", time.Duration(jitter*float64(period)), delay)
+	}
+	periods := map[time.Duration]bool{}
+	for i := 0; i < 10; i++ {
+		ran := waitForRun()
+		found := ran.Sub(last)
+		// the clock moves in steps of 200ms
+		if found < period || found > period+time.Duration(jitter*float64(period))+200*time.Millisecond {
+			t.Errorf("expected a period between %v and %v, found %v", period, period+time.Duration(jitter*float64(period)), found)
+		}
+		periods[found] = true
+		last = ran
+	}
+	if len(periods) < 2 {
+		t.Errorf("expected the periods to vary, found %v", periods)
+	}
+}
@@ -7,15 +7,15 @@ import (
 	"k8s.io/apiserver/pkg/server/healthz"
 	"k8s.io/apiserver/pkg/util/flag"
 	"k8s.io/apiserver/pkg/util/logs"
-	"k8s.io/kubernetes/cmd/cloud-controller-manager/app"
-	"k8s.io/kubernetes/cmd/cloud-controller-manager/app/options"
 	_ "k8s.io/kubernetes/pkg/client/metrics/prometheus" // for client metric registration
 	"k8s.io/kubernetes/pkg/cloudprovider"
 	_ "k8s.io/kubernetes/pkg/cloudprovider/providers"
 	_ "k8s.io/kubernetes/pkg/version/prometheus" // for version metric registration
 	"k8s.io/kubernetes/pkg/version/verflag"
 
-	_ "github.com/rancher/rancher-cloud-controller-manager/rancher"
+	"github.com/rancher/rancher-cloud-controller-manager/app"
+	"github.com/rancher/rancher-cloud-controller-manager/app/options"
+	"github.com/rancher/rancher-cloud-controller-manager/rancher"
 
 	"github.com/golang/glog"
 	"github.com/spf13/pflag"
@@ -27,7 +27,7 @@ func init() {
 
 func main() {
 	s := options.NewCloudControllerManagerServer()
-	s.AddFlags(pflag.CommandLine)
+	s.AddFlags(pflag.CommandLine, app.KnownControllers())
 
 	flag.InitFlags()
 	logs.InitLogs()
@@ -35,6 +35,14 @@ func main() {
 
 	verflag.PrintAndExitIfRequested()
 
+	if s.CloudCredentialsSecret != "" {
+		data, err := app.ReadCloudCredentials(s)
+		if err != nil {
+			glog.Fatalf("Cloud credentials could not be read: %v", err)
+		}
+		rancher.SetCredentials(data)
+	}
+
 	cloud, err := cloudprovider.InitCloudProvider("rancher", s.CloudConfigFile)
 	if err != nil {
 		glog.Fatalf("Cloud provider could not be initialized: %v", err)
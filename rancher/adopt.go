@@ -0,0 +1,265 @@
+package rancher
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/rancher/go-rancher/client"
+
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+const (
+	// annotationExistingLBID makes a service drive an existing Rancher LB instead of creating one
+	annotationExistingLBID = "rancher.io/existing-lb-id"
+
+	// Metadata the provider keeps on adopted LBs: the service owning it, and the ports and
+	// service links the provider added, so they can be told apart from the LB's own ones
+	lbOwnerMetadata = "io.rancher.k8s.owner"
+	lbPortsMetadata = "io.rancher.k8s.ports"
+	lbLinksMetadata = "io.rancher.k8s.service-links"
+)
+
+func serviceKey(service *api.Service) string {
+	return service.Namespace + "/" + service.Name
+}
+
+// getAdoptedLB returns the existing LB service adopts, or nil if it doesn't adopt one.
+// It fails if the LB is owned by another service.
+func (r *CloudProvider) getAdoptedLB(service *api.Service) (*client.LoadBalancerService, error) {
+	id := service.Annotations[annotationExistingLBID]
+	if id == "" {
+		return nil, nil
+	}
+
+	lb, err := r.client.LoadBalancerService.ById(id)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get LB %s of service %s. Error: %#v", id, serviceKey(service), err)
+	}
+	if lb == nil {
+		return nil, fmt.Errorf("LB %s in annotation %s of service %s doesn't exist", id, annotationExistingLBID, serviceKey(service))
+	}
+
+	if owner := metadataString(lb, lbOwnerMetadata); owner != "" && owner != serviceKey(service) {
+		return nil, fmt.Errorf("LB %s in annotation %s of service %s is already managed by service %s",
+			id, annotationExistingLBID, serviceKey(service), owner)
+	}
+	return lb, nil
+}
+
+// ensureAdoptedLB adopts lb for service and returns its status once it has a public endpoint
+func (r *CloudProvider) ensureAdoptedLB(lb *client.LoadBalancerService, service *api.Service, lbPorts []string, hosts []string) (*api.LoadBalancerStatus, error) {
+	lb, err := r.adoptLB(lb, service, lbPorts, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	epChannel := r.waitForLBPublicEndpoints(1, lb)
+	_, ok := <-epChannel
+	if !ok {
+		return nil, fmt.Errorf("Couldn't get publicEndpoints for LB %s", lb.Name)
+	}
+
+	lb, err = r.reloadLBService(lb)
+	if err != nil {
+		return nil, err
+	}
+	lb, err = r.waitForLBReady(lb)
+	if err != nil {
+		return nil, err
+	}
+	status, _, err := r.toLBStatus(lb)
+	return status, err
+}
+
+// adoptLB makes an existing LB serve service. The ports and hosts of the service are added next
+// to the ports and service links the LB had before, which are left alone.
+func (r *CloudProvider) adoptLB(lb *client.LoadBalancerService, service *api.Service, lbPorts []string, hosts []string) (*client.LoadBalancerService, error) {
+	glog.Infof("Adopting LB %s for service %s", lb.Id, serviceKey(service))
+
+	ports := append(withoutStrings(launchConfigPorts(lb), metadataStrings(lb, lbPortsMetadata)), lbPorts...)
+	if portsChanged(ports, launchConfigPorts(lb)) {
+		var err error
+		lb, err = r.upgradeLBPorts(lb, ports)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	links, err := r.linkAdoptedLBHosts(lb, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.updateLBMetadata(lb, map[string]string{
+		lbOwnerMetadata: serviceKey(service),
+		lbPortsMetadata: strings.Join(lbPorts, ","),
+		lbLinksMetadata: strings.Join(links, ","),
+	})
+}
+
+// releaseAdoptedLB removes the ports and service links the provider added to the LB service
+// adopted and gives up its ownership. The LB itself is kept.
+func (r *CloudProvider) releaseAdoptedLB(service *api.Service) error {
+	id := service.Annotations[annotationExistingLBID]
+	lb, err := r.client.LoadBalancerService.ById(id)
+	if err != nil {
+		return fmt.Errorf("Couldn't get LB %s of service %s. Error: %#v", id, serviceKey(service), err)
+	}
+	if lb == nil || metadataString(lb, lbOwnerMetadata) != serviceKey(service) {
+		glog.Infof("LB %s isn't managed by service %s. Nothing to do.", id, serviceKey(service))
+		return nil
+	}
+	glog.Infof("Releasing LB %s adopted by service %s", lb.Id, serviceKey(service))
+
+	if managed := metadataStrings(lb, lbPortsMetadata); len(managed) > 0 {
+		lb, err = r.upgradeLBPorts(lb, withoutStrings(launchConfigPorts(lb), managed))
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := r.linkAdoptedLBHosts(lb, nil); err != nil {
+		return err
+	}
+
+	_, err = r.updateLBMetadata(lb, map[string]string{
+		lbOwnerMetadata: "",
+		lbPortsMetadata: "",
+		lbLinksMetadata: "",
+	})
+	return err
+}
+
+// linkAdoptedLBHosts links the external services of hosts to an adopted LB and unlinks the ones
+// the provider linked before that are no longer needed. It returns the IDs of the linked services.
+func (r *CloudProvider) linkAdoptedLBHosts(lb *client.LoadBalancerService, hosts []string) ([]string, error) {
+	r.externalServicesLock.Lock()
+	defer r.externalServicesLock.Unlock()
+
+	serviceIDs, err := r.hostExternalServices(lb, hosts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	linked := metadataStrings(lb, lbLinksMetadata)
+	for _, id := range withoutStrings(serviceIDs, linked) {
+		lbInterface, ok := <-r.waitForLBAction("addservicelink", lb)
+		if !ok {
+			return nil, fmt.Errorf("Couldn't call addservicelink on LB %s", lb.Name)
+		}
+		lb = convertLB(lbInterface)
+		input := &client.AddRemoveLoadBalancerServiceLinkInput{ServiceLink: client.LoadBalancerServiceLink{ServiceId: id}}
+		if _, err := r.client.LoadBalancerService.ActionAddservicelink(lb, input); err != nil {
+			return nil, fmt.Errorf("Couldn't link service %s to LB %s. Error: %#v", id, lb.Name, err)
+		}
+	}
+	for _, id := range withoutStrings(linked, serviceIDs) {
+		lbInterface, ok := <-r.waitForLBAction("removeservicelink", lb)
+		if !ok {
+			return nil, fmt.Errorf("Couldn't call removeservicelink on LB %s", lb.Name)
+		}
+		lb = convertLB(lbInterface)
+		input := &client.AddRemoveLoadBalancerServiceLinkInput{ServiceLink: client.LoadBalancerServiceLink{ServiceId: id}}
+		if _, err := r.client.LoadBalancerService.ActionRemoveservicelink(lb, input); err != nil {
+			return nil, fmt.Errorf("Couldn't unlink service %s from LB %s. Error: %#v", id, lb.Name, err)
+		}
+	}
+	return serviceIDs, nil
+}
+
+// upgradeLBPorts changes the ports of an LB. Ports are part of the launch config, so they can
+// only be changed by upgrading the LB.
+func (r *CloudProvider) upgradeLBPorts(lb *client.LoadBalancerService, ports []string) (*client.LoadBalancerService, error) {
+	launchConfig := client.LaunchConfig{}
+	if lb.LaunchConfig != nil {
+		launchConfig = *lb.LaunchConfig
+	}
+	launchConfig.Ports = ports
+	return r.upgradeLBLaunchConfig(lb, &launchConfig)
+}
+
+// upgradeLBLaunchConfig replaces the launch config of an LB, the only way to change it
+func (r *CloudProvider) upgradeLBLaunchConfig(lb *client.LoadBalancerService, launchConfig *client.LaunchConfig) (*client.LoadBalancerService, error) {
+	lbInterface, ok := <-r.waitForLBAction("upgrade", lb)
+	if !ok {
+		return nil, fmt.Errorf("Couldn't call upgrade on LB %s", lb.Name)
+	}
+	lb = convertLB(lbInterface)
+	upgrade := &client.ServiceUpgrade{
+		InServiceStrategy: &client.InServiceUpgradeStrategy{
+			BatchSize:    1,
+			LaunchConfig: launchConfig,
+		},
+	}
+	if _, err := r.client.LoadBalancerService.ActionUpgrade(lb, upgrade); err != nil {
+		return nil, fmt.Errorf("Couldn't upgrade LB %s. Error: %#v", lb.Name, err)
+	}
+
+	lbInterface, ok = <-r.waitForLBAction("finishupgrade", lb)
+	if !ok {
+		return nil, fmt.Errorf("Couldn't call finishupgrade on LB %s", lb.Name)
+	}
+	lb = convertLB(lbInterface)
+	if _, err := r.client.LoadBalancerService.ActionFinishupgrade(lb); err != nil {
+		return nil, fmt.Errorf("Couldn't finish upgrading LB %s. Error: %#v", lb.Name, err)
+	}
+	return r.reloadLBService(lb)
+}
+
+// updateLBMetadata sets the given metadata of an LB, removing the keys with empty values
+func (r *CloudProvider) updateLBMetadata(lb *client.LoadBalancerService, values map[string]string) (*client.LoadBalancerService, error) {
+	metadata := map[string]interface{}{}
+	for k, v := range lb.Metadata {
+		metadata[k] = v
+	}
+	for k, v := range values {
+		if v == "" {
+			delete(metadata, k)
+		} else {
+			metadata[k] = v
+		}
+	}
+
+	lb, err := r.updateLB(lb, map[string]interface{}{"metadata": metadata})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't update metadata of LB. Error: %#v", err)
+	}
+	return lb, nil
+}
+
+func launchConfigPorts(lb *client.LoadBalancerService) []string {
+	if lb.LaunchConfig == nil {
+		return nil
+	}
+	return lb.LaunchConfig.Ports
+}
+
+func metadataString(lb *client.LoadBalancerService, key string) string {
+	value, _ := lb.Metadata[key].(string)
+	return value
+}
+
+func metadataStrings(lb *client.LoadBalancerService, key string) []string {
+	value := metadataString(lb, key)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// withoutStrings returns the strings of all that are not in remove
+func withoutStrings(all, remove []string) []string {
+	removed := map[string]bool{}
+	for _, s := range remove {
+		removed[s] = true
+	}
+	result := []string{}
+	for _, s := range all {
+		if !removed[s] {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,116 @@
+package rancher
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+func adoptingService(lbID string) *api.Service {
+	return &api.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			UID:         "web",
+			Annotations: map[string]string{annotationExistingLBID: lbID},
+		},
+	}
+}
+
+func TestAdoptLoadBalancer(t *testing.T) {
+	lbTestSerializer.Lock()
+	defer lbTestSerializer.Unlock()
+	hostList = &client.HostCollection{}
+	externalServiceList = &client.ExternalServiceCollection{
+		Data: []client.ExternalService{
+			client.ExternalService{
+				Resource:      client.Resource{Id: "1s2"},
+				EnvironmentId: "1e5",
+				Name:          "host1",
+				State:         "active",
+			},
+		},
+	}
+	loadBalancerServiceList = &client.LoadBalancerServiceCollection{
+		Data: []client.LoadBalancerService{
+			client.LoadBalancerService{
+				Resource: client.Resource{
+					Id: "1lb5",
+					Actions: map[string]string{
+						"addservicelink":    "addservicelink",
+						"removeservicelink": "removeservicelink",
+					},
+				},
+				Name:          "hand-tuned",
+				EnvironmentId: "1e5",
+				LaunchConfig:  &client.LaunchConfig{Ports: []string{"443:443/tcp"}},
+			},
+		},
+	}
+	service := adoptingService("1lb5")
+
+	err := cloudProvider.UpdateLoadBalancer("", service, []*api.Node{&api.Node{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}})
+	if err != nil {
+		t.Fatalf("Error adopting load balancer: %v", err)
+	}
+
+	if links := lbAddedServiceLinks["1lb5"]; !reflect.DeepEqual(links, []string{"1s2"}) {
+		t.Errorf("expected host service to be linked, found links %v", links)
+	}
+	if _, ok := lbServiceLinks["1lb5"]; ok {
+		t.Errorf("expected the service links of an adopted LB not to be replaced")
+	}
+	lb := loadBalancerServiceList.Data[0]
+	if owner := metadataString(&lb, lbOwnerMetadata); owner != "default/web" {
+		t.Errorf("expected LB to be owned by default/web, found %s", owner)
+	}
+
+	err = cloudProvider.EnsureLoadBalancerDeleted("", service)
+	if err != nil {
+		t.Fatalf("Error releasing load balancer: %v", err)
+	}
+
+	if len(loadBalancerServiceList.Data) != 1 {
+		t.Fatalf("expected adopted LB not to be deleted")
+	}
+	if links := lbRemovedServiceLinks["1lb5"]; !reflect.DeepEqual(links, []string{"1s2"}) {
+		t.Errorf("expected host service to be unlinked, found unlinked %v", links)
+	}
+	lb = loadBalancerServiceList.Data[0]
+	if owner := metadataString(&lb, lbOwnerMetadata); owner != "" {
+		t.Errorf("expected LB ownership to be released, found owner %s", owner)
+	}
+}
+
+func TestAdoptLoadBalancerOwnedByOtherService(t *testing.T) {
+	lbTestSerializer.Lock()
+	defer lbTestSerializer.Unlock()
+	loadBalancerServiceList = &client.LoadBalancerServiceCollection{
+		Data: []client.LoadBalancerService{
+			client.LoadBalancerService{
+				Resource: client.Resource{Id: "1lb6"},
+				Name:     "hand-tuned",
+				Metadata: map[string]interface{}{lbOwnerMetadata: "default/api"},
+			},
+		},
+	}
+	service := adoptingService("1lb6")
+
+	if _, err := cloudProvider.EnsureLoadBalancer("", service, nil); err == nil {
+		t.Errorf("expected adopting an LB owned by another service to fail")
+	}
+
+	if err := cloudProvider.EnsureLoadBalancerDeleted("", service); err != nil {
+		t.Errorf("unexpected error deleting service: %v", err)
+	}
+	if len(loadBalancerServiceList.Data) != 1 {
+		t.Errorf("expected LB owned by another service to be left alone")
+	}
+	if owner := metadataString(&loadBalancerServiceList.Data[0], lbOwnerMetadata); owner != "default/api" {
+		t.Errorf("expected LB to stay owned by default/api, found %s", owner)
+	}
+}
@@ -0,0 +1,231 @@
+package rancher
+
+import (
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/rancher/go-rancher/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "k8s.io/kubernetes/pkg/api/v1"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
+)
+
+const (
+	// annotationLBTLSSecret names the kubernetes.io/tls Secret, as namespace/name or as the name of
+	// a Secret in the namespace of the service, whose certificate the LB of a service terminates
+	// TLS with
+	annotationLBTLSSecret = "rancher.io/lb-tls-secret"
+
+	// annotationLBTLSPorts lists the comma separated ports of a service the LB terminates TLS on,
+	// 443 if it's missing
+	annotationLBTLSPorts = "rancher.io/lb-tls-ports"
+
+	defaultTLSPort = "443"
+
+	// lbSSLPortsLabel lists the ports a Rancher LB terminates TLS on with its default certificate
+	lbSSLPortsLabel = "io.rancher.loadbalancer.ssl.ports"
+
+	// certificateOwner prefixes the description of the Rancher certificates the provider creates,
+	// the certificates described otherwise are never changed
+	certificateOwner = "kubernetes-loadbalancers://"
+)
+
+// lbTLS is the TLS termination a service asks of its LB
+type lbTLS struct {
+	namespace, name string
+	ports           []string
+}
+
+// serviceLBTLS returns the TLS termination service asks for, or nil if it asks for none
+func serviceLBTLS(service *api.Service) (*lbTLS, error) {
+	secret, hasSecret := service.Annotations[annotationLBTLSSecret]
+	ports, hasPorts := service.Annotations[annotationLBTLSPorts]
+	if !hasSecret {
+		if hasPorts {
+			return nil, fmt.Errorf("Annotation %s of service %s requires %s", annotationLBTLSPorts, serviceKey(service), annotationLBTLSSecret)
+		}
+		return nil, nil
+	}
+
+	tls := &lbTLS{namespace: service.Namespace, name: secret}
+	if parts := strings.Split(secret, "/"); len(parts) == 2 {
+		tls.namespace, tls.name = parts[0], parts[1]
+	}
+	if tls.namespace == "" || tls.name == "" || strings.Contains(tls.name, "/") {
+		return nil, fmt.Errorf("Invalid value %q of annotation %s of service %s, must be namespace/name or name",
+			secret, annotationLBTLSSecret, serviceKey(service))
+	}
+
+	if !hasPorts {
+		ports = defaultTLSPort
+	}
+	for _, port := range strings.Split(ports, ",") {
+		port = strings.TrimSpace(port)
+		if !servicePort(service, port) {
+			return nil, fmt.Errorf("Invalid value %q of annotation %s of service %s, must list ports of the service",
+				ports, annotationLBTLSPorts, serviceKey(service))
+		}
+		tls.ports = append(tls.ports, port)
+	}
+	return tls, nil
+}
+
+// servicePort tells whether port is one of the ports of service
+func servicePort(service *api.Service, port string) bool {
+	for _, p := range service.Spec.Ports {
+		if strconv.Itoa(int(p.Port)) == port {
+			return true
+		}
+	}
+	return false
+}
+
+// SetSecretsClient gives the provider a client to read the TLS Secrets of services with
+func (r *CloudProvider) SetSecretsClient(secrets corev1.SecretsGetter) {
+	r.secrets = secrets
+}
+
+// readTLSSecret returns the certificate, the chain of intermediate certificates and the key of
+// the TLS Secret of tls
+func (r *CloudProvider) readTLSSecret(tls *lbTLS) (string, string, string, error) {
+	if r.secrets == nil {
+		return "", "", "", fmt.Errorf("Couldn't read Secret %s/%s: no Secrets client", tls.namespace, tls.name)
+	}
+	secret, err := r.secrets.Secrets(tls.namespace).Get(tls.name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", "", fmt.Errorf("Couldn't read Secret %s/%s: %v", tls.namespace, tls.name, err)
+	}
+	if secret.Type != api.SecretTypeTLS {
+		return "", "", "", fmt.Errorf("Secret %s/%s is of type %s, must be %s", tls.namespace, tls.name, secret.Type, api.SecretTypeTLS)
+	}
+	key := strings.TrimSpace(string(secret.Data[api.TLSPrivateKeyKey]))
+	block, rest := pem.Decode(secret.Data[api.TLSCertKey])
+	if block == nil || key == "" {
+		return "", "", "", fmt.Errorf("Secret %s/%s has no PEM certificate and key", tls.namespace, tls.name)
+	}
+	cert := strings.TrimSpace(string(pem.EncodeToMemory(block)))
+	return cert, strings.TrimSpace(string(rest)), key, nil
+}
+
+// ensureLBCertificate creates or updates the Rancher certificate of the LB named lbName with the
+// certificate of the Secret of tls, and returns its ID. Updating the certificate in place rotates
+// it without recreating the LB.
+func (r *CloudProvider) ensureLBCertificate(lbName string, tls *lbTLS) (string, error) {
+	cert, chain, key, err := r.readTLSSecret(tls)
+	if err != nil {
+		return "", err
+	}
+
+	existing, err := r.getLBCertificate(lbName)
+	if err != nil {
+		return "", err
+	}
+	if existing == nil {
+		glog.Infof("Creating certificate %s from Secret %s/%s", lbName, tls.namespace, tls.name)
+		created, err := r.client.Certificate.Create(&client.Certificate{
+			Name:        lbName,
+			Description: certificateOwner + lbName,
+			Cert:        cert,
+			CertChain:   chain,
+			Key:         key,
+		})
+		if err != nil {
+			return "", fmt.Errorf("Couldn't create certificate %s. Error: %#v", lbName, err)
+		}
+		return created.Id, nil
+	}
+
+	if existing.Description != certificateOwner+lbName {
+		return "", fmt.Errorf("Certificate %s exists and wasn't created for LB %s", existing.Id, lbName)
+	}
+	if strings.TrimSpace(existing.Cert) != cert || strings.TrimSpace(existing.CertChain) != chain {
+		glog.Infof("Updating certificate %s from Secret %s/%s", lbName, tls.namespace, tls.name)
+		updates := map[string]interface{}{"cert": cert, "certChain": chain, "key": key}
+		if _, err := r.client.Certificate.Update(existing, updates); err != nil {
+			return "", fmt.Errorf("Couldn't update certificate %s. Error: %#v", lbName, err)
+		}
+	}
+	return existing.Id, nil
+}
+
+// getLBCertificate returns the Rancher certificate of the LB named lbName, if any
+func (r *CloudProvider) getLBCertificate(lbName string) (*client.Certificate, error) {
+	opts := client.NewListOpts()
+	opts.Filters["name"] = lbName
+	opts.Filters["removed_null"] = "1"
+	certs, err := r.client.Certificate.List(opts)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get certificate %s. Error: %#v", lbName, err)
+	}
+	if len(certs.Data) == 0 {
+		return nil, nil
+	}
+	return &certs.Data[0], nil
+}
+
+// deleteLBCertificate deletes the Rancher certificate of the LB named lbName, if the provider
+// created it
+func (r *CloudProvider) deleteLBCertificate(lbName string) error {
+	cert, err := r.getLBCertificate(lbName)
+	if err != nil || cert == nil {
+		return err
+	}
+	if cert.Description != certificateOwner+lbName {
+		glog.Infof("Keeping certificate %s, it wasn't created for LB %s", cert.Id, lbName)
+		return nil
+	}
+	glog.Infof("Deleting certificate %s of LB %s", cert.Id, lbName)
+	if err := r.client.Certificate.Delete(cert); err != nil {
+		return fmt.Errorf("Couldn't delete certificate %s. Error: %#v", cert.Id, err)
+	}
+	return nil
+}
+
+// lbSSLPorts returns the ports lb terminates TLS on
+func lbSSLPorts(lb *client.LoadBalancerService) string {
+	if lb.LaunchConfig == nil {
+		return ""
+	}
+	ports, _ := lb.LaunchConfig.Labels[lbSSLPortsLabel].(string)
+	return ports
+}
+
+func setLBSSLPortsLabel(launchConfig *client.LaunchConfig, ports string) {
+	labels := map[string]interface{}{}
+	for k, v := range launchConfig.Labels {
+		labels[k] = v
+	}
+	if ports == "" {
+		delete(labels, lbSSLPortsLabel)
+	} else {
+		labels[lbSSLPortsLabel] = ports
+	}
+	launchConfig.Labels = labels
+}
+
+// ensureLBTLS makes lb terminate TLS on ports with the certificate with the given ID, or stop
+// terminating TLS if certID is ""
+func (r *CloudProvider) ensureLBTLS(lb *client.LoadBalancerService, certID, ports string) (*client.LoadBalancerService, error) {
+	if lb.DefaultCertificateId != certID {
+		glog.Infof("Setting the certificate of LB %s to [%s]", lb.Name, certID)
+		updated, err := r.updateLB(lb, map[string]interface{}{"defaultCertificateId": certID})
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't set certificate of LB %s. Error: %#v", lb.Name, err)
+		}
+		lb = updated
+	}
+	if lbSSLPorts(lb) == ports {
+		return lb, nil
+	}
+	launchConfig := client.LaunchConfig{}
+	if lb.LaunchConfig != nil {
+		launchConfig = *lb.LaunchConfig
+	}
+	setLBSSLPortsLabel(&launchConfig, ports)
+	glog.Infof("Terminating TLS on ports [%s] of LB %s", ports, lb.Name)
+	return r.upgradeLBLaunchConfig(lb, &launchConfig)
+}
@@ -0,0 +1,190 @@
+package rancher
+
+import (
+	"encoding/pem"
+	"reflect"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "k8s.io/kubernetes/pkg/api/v1"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
+)
+
+// fakeKubeSecrets serves the Secrets in secrets by namespace/name
+type fakeKubeSecrets struct {
+	corev1.SecretInterface
+	namespace string
+	secrets   map[string]*api.Secret
+}
+
+func (f *fakeKubeSecrets) Secrets(namespace string) corev1.SecretInterface {
+	return &fakeKubeSecrets{namespace: namespace, secrets: f.secrets}
+}
+
+func (f *fakeKubeSecrets) Get(name string, options metav1.GetOptions) (*api.Secret, error) {
+	secret, ok := f.secrets[f.namespace+"/"+name]
+	if !ok {
+		return nil, apierrors.NewNotFound(api.Resource("secrets"), name)
+	}
+	return secret, nil
+}
+
+// testPEM returns PEM blocks of the given type holding each of contents
+func testPEM(blockType string, contents ...string) []byte {
+	data := []byte{}
+	for _, content := range contents {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: []byte(content)})...)
+	}
+	return data
+}
+
+func tlsSecret(certs ...string) *api.Secret {
+	return &api.Secret{
+		Type: api.SecretTypeTLS,
+		Data: map[string][]byte{
+			api.TLSCertKey:       testPEM("CERTIFICATE", certs...),
+			api.TLSPrivateKeyKey: testPEM("EC PRIVATE KEY", "key of "+certs[0]),
+		},
+	}
+}
+
+func TestServiceLBTLS(t *testing.T) {
+	ports := []api.ServicePort{{Port: 80}, {Port: 443}, {Port: 8443}}
+	tests := []struct {
+		annotations map[string]string
+		tls         *lbTLS
+		invalid     bool
+	}{
+		{annotations: nil},
+		{
+			annotations: map[string]string{annotationLBTLSSecret: "web-tls"},
+			tls:         &lbTLS{namespace: "default", name: "web-tls", ports: []string{"443"}},
+		},
+		{
+			annotations: map[string]string{annotationLBTLSSecret: "certs/web-tls", annotationLBTLSPorts: "443, 8443"},
+			tls:         &lbTLS{namespace: "certs", name: "web-tls", ports: []string{"443", "8443"}},
+		},
+		{annotations: map[string]string{annotationLBTLSPorts: "443"}, invalid: true},
+		{annotations: map[string]string{annotationLBTLSSecret: ""}, invalid: true},
+		{annotations: map[string]string{annotationLBTLSSecret: "/web-tls"}, invalid: true},
+		{annotations: map[string]string{annotationLBTLSSecret: "a/b/c"}, invalid: true},
+		{annotations: map[string]string{annotationLBTLSSecret: "web-tls", annotationLBTLSPorts: "9443"}, invalid: true},
+		{annotations: map[string]string{annotationLBTLSSecret: "web-tls", annotationLBTLSPorts: "https"}, invalid: true},
+	}
+	for _, test := range tests {
+		service := &api.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: test.annotations},
+			Spec:       api.ServiceSpec{Ports: ports},
+		}
+		tls, err := serviceLBTLS(service)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%v: expected an error, found %#v", test.annotations, tls)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.annotations, err)
+		} else if !reflect.DeepEqual(tls, test.tls) {
+			t.Errorf("%v: expected %#v, found %#v", test.annotations, test.tls, tls)
+		}
+	}
+}
+
+func TestLBCertificateLifecycle(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	secrets := &fakeKubeSecrets{secrets: map[string]*api.Secret{"certs/web-tls": tlsSecret("cert-1", "intermediate")}}
+	r.SetSecretsClient(secrets)
+
+	service := newLBService(map[string]string{annotationLBTLSSecret: "certs/web-tls"})
+	service.Spec.Ports = append(service.Spec.Ports, api.ServicePort{Port: 443, NodePort: 30443, Protocol: api.ProtocolTCP})
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}}
+	name := lbName("kubernetes", service)
+
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't ensure the LB: %v", err)
+	}
+	cert, err := r.getLBCertificate(name)
+	if err != nil || cert == nil {
+		t.Fatalf("expected certificate %s to be created, found %#v, %v", name, cert, err)
+	}
+	if cert.Cert != strings.TrimSpace(string(testPEM("CERTIFICATE", "cert-1"))) || cert.CertChain == "" || cert.Key == "" {
+		t.Errorf("expected the certificate, chain and key of the Secret, found %#v", cert)
+	}
+	lb, err := r.getLBByName(name)
+	if err != nil || lb == nil {
+		t.Fatalf("expected LB %s to exist, found %#v, %v", name, lb, err)
+	}
+	if lb.DefaultCertificateId != cert.Id || lbSSLPorts(lb) != "443" {
+		t.Errorf("expected the LB to terminate TLS on 443 with certificate %s, found %s on [%s]", cert.Id, lb.DefaultCertificateId, lbSSLPorts(lb))
+	}
+
+	// rotating the Secret updates the certificate in place
+	secrets.secrets["certs/web-tls"] = tlsSecret("cert-2")
+	if err := r.UpdateLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't update the LB: %v", err)
+	}
+	rotated, err := r.getLBCertificate(name)
+	if err != nil || rotated == nil || rotated.Id != cert.Id || rotated.Cert == cert.Cert || rotated.CertChain != "" {
+		t.Errorf("expected certificate %s to be rotated, found %#v, %v", cert.Id, rotated, err)
+	}
+	if current, err := r.getLBByName(name); err != nil || current == nil || current.Id != lb.Id || current.DefaultCertificateId != cert.Id {
+		t.Errorf("expected LB %s to keep certificate %s, found %#v, %v", lb.Id, cert.Id, current, err)
+	}
+	mutations := cattle.mutationCount()
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't ensure the LB: %v", err)
+	}
+	if cattle.mutationCount() != mutations {
+		t.Errorf("expected an unchanged certificate to be left alone, found %d changes", cattle.mutationCount()-mutations)
+	}
+
+	if err := r.EnsureLoadBalancerDeleted("kubernetes", service); err != nil {
+		t.Fatalf("Couldn't delete the LB: %v", err)
+	}
+	if count := cattle.count("certificates"); count != 0 {
+		t.Errorf("expected the certificate to be deleted, found %d", count)
+	}
+}
+
+func TestLBCertificateNotOwned(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	r.SetSecretsClient(&fakeKubeSecrets{secrets: map[string]*api.Secret{
+		"default/web-tls": tlsSecret("cert-1"),
+		"default/opaque":  {Type: api.SecretTypeOpaque, Data: tlsSecret("cert-1").Data},
+	}})
+	service := newLBService(map[string]string{annotationLBTLSSecret: "web-tls", annotationLBTLSPorts: "80"})
+	name := lbName("kubernetes", service)
+
+	cattle.Lock()
+	cattle.add("certificates", map[string]interface{}{"name": name, "description": "uploaded by hand", "state": "active"})
+	cattle.Unlock()
+	if _, err := r.ensureLBCertificate(name, &lbTLS{namespace: "default", name: "web-tls"}); err == nil {
+		t.Errorf("expected a certificate created by someone else not to be replaced")
+	}
+	if err := r.deleteLBCertificate(name); err != nil || cattle.count("certificates") != 1 {
+		t.Errorf("expected a certificate created by someone else to be kept, found %d, %v", cattle.count("certificates"), err)
+	}
+
+	if _, err := r.ensureLBCertificate(name, &lbTLS{namespace: "default", name: "opaque"}); err == nil {
+		t.Errorf("expected a Secret of another type to be rejected")
+	}
+	if _, err := r.ensureLBCertificate(name, &lbTLS{namespace: "default", name: "missing"}); err == nil {
+		t.Errorf("expected a missing Secret to fail")
+	}
+	r.SetSecretsClient(nil)
+	if _, err := r.ensureLBCertificate(name, &lbTLS{namespace: "default", name: "web-tls"}); err == nil {
+		t.Errorf("expected reading Secrets without a client to fail")
+	}
+
+	// the LB isn't created when its certificate can't be
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nil); err == nil || cattle.count("loadbalancerservices") != 0 {
+		t.Errorf("expected the LB not to be created, found %d LBs, %v", cattle.count("loadbalancerservices"), err)
+	}
+}
@@ -0,0 +1,122 @@
+package rancher
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/golang/glog"
+	"github.com/rancher/go-rancher/client"
+
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// kubernetesOrchestration is the orchestration of the Rancher environments running Kubernetes
+const kubernetesOrchestration = "kubernetes"
+
+// environmentProject is a Rancher environment, with the orchestration of the v2-beta API
+type environmentProject struct {
+	client.Project
+	Orchestration string `json:"orchestration,omitempty"`
+}
+
+// environmentPage is a page of a listing of environments
+type environmentPage struct {
+	client.Collection
+	Data []environmentProject `json:"data,omitempty"`
+}
+
+func (c *environmentPage) nextPage() string {
+	if c.Pagination == nil {
+		return ""
+	}
+	return c.Pagination.Next
+}
+
+// Clusters returns the clusters of the Rancher environments if the API key has access to more
+// than one environment
+func (r *CloudProvider) Clusters() (cloudprovider.Clusters, bool) {
+	if r.conf != nil && r.conf.apiVersion == apiVersionV3 {
+		return nil, false
+	}
+	envs, err := r.listEnvironments()
+	if err != nil {
+		glog.Warningf("Couldn't list the Rancher environments, clusters aren't available. Error: %v", err)
+		return nil, false
+	}
+	return r, len(envs) > 1
+}
+
+// listEnvironments lists the environments the API key has access to
+func (r *CloudProvider) listEnvironments() ([]environmentProject, error) {
+	opts := client.NewListOpts()
+	opts.Filters["removed_null"] = "1"
+	opts.Filters["limit"] = hostListPageSize
+	envs := []environmentProject{}
+	err := listPages(r.client, client.PROJECT_TYPE, opts, func() pagedCollection {
+		return &environmentPage{}
+	}, func(page pagedCollection) {
+		envs = append(envs, page.(*environmentPage).Data...)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't list environments. Error: %#v", err)
+	}
+	return envs, nil
+}
+
+// kubernetesClusters returns the Kubernetes environments by cluster name. Clusters are named
+// after their environments, environments sharing a name are told apart by their ID.
+func (r *CloudProvider) kubernetesClusters() (map[string]*environmentProject, error) {
+	envs, err := r.listEnvironments()
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]int{}
+	for _, env := range envs {
+		names[env.Name]++
+	}
+	clusters := map[string]*environmentProject{}
+	for i := range envs {
+		env := &envs[i]
+		if !env.Kubernetes && env.Orchestration != kubernetesOrchestration {
+			continue
+		}
+		name := env.Name
+		if names[name] > 1 || name == "" {
+			name = fmt.Sprintf("%s-%s", name, env.Id)
+		}
+		clusters[name] = env
+	}
+	return clusters, nil
+}
+
+// ListClusters is an implementation of Clusters.ListClusters.
+func (r *CloudProvider) ListClusters() ([]string, error) {
+	clusters, err := r.kubernetesClusters()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range clusters {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Master is an implementation of Clusters.Master. It returns the URL of the Kubernetes API of the
+// environment, proxied by Rancher.
+func (r *CloudProvider) Master(clusterName string) (string, error) {
+	clusters, err := r.kubernetesClusters()
+	if err != nil {
+		return "", err
+	}
+	env, ok := clusters[clusterName]
+	if !ok {
+		return "", fmt.Errorf("Cluster %s doesn't exist", clusterName)
+	}
+	u, err := url.Parse(r.conf.Global.CattleURLs[0])
+	if err != nil {
+		return "", fmt.Errorf("Couldn't parse cattle-url [%s]. Error: %v", r.conf.Global.CattleURLs[0], err)
+	}
+	master := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/r/projects/" + env.Id + "/kubernetes:6443"}
+	return master.String(), nil
+}
@@ -0,0 +1,49 @@
+package rancher
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestClusters(t *testing.T) {
+	cattle := newFakeLBCattle(nil)
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+
+	cattle.Lock()
+	production := cattle.add("projects", map[string]interface{}{"name": "production", "orchestration": "kubernetes"})
+	cattle.Unlock()
+	if _, ok := r.Clusters(); ok {
+		t.Errorf("expected no clusters with access to a single environment")
+	}
+
+	cattle.Lock()
+	staging := cattle.add("projects", map[string]interface{}{"name": "staging", "orchestration": "kubernetes"})
+	other := cattle.add("projects", map[string]interface{}{"name": "staging", "kubernetes": true})
+	cattle.add("projects", map[string]interface{}{"name": "swarm", "orchestration": "swarm"})
+	cattle.Unlock()
+	clusters, ok := r.Clusters()
+	if !ok {
+		t.Fatalf("expected clusters with access to several environments")
+	}
+
+	names, err := clusters.ListClusters()
+	sort.Strings(names)
+	expected := []string{"production", "staging-" + staging["id"].(string), "staging-" + other["id"].(string)}
+	sort.Strings(expected)
+	if err != nil || !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected clusters %v, found %v, %v", expected, names, err)
+	}
+
+	master, err := clusters.Master("production")
+	if expected := cattle.URL + "/r/projects/" + production["id"].(string) + "/kubernetes:6443"; err != nil || master != expected {
+		t.Errorf("expected master %s, found %s, %v", expected, master, err)
+	}
+	if master, err := clusters.Master("staging"); err == nil {
+		t.Errorf("expected the ambiguous name not to resolve, found %s", master)
+	}
+	if master, err := clusters.Master("swarm"); err == nil {
+		t.Errorf("expected an environment without Kubernetes to have no master, found %s", master)
+	}
+}
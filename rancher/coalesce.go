@@ -0,0 +1,60 @@
+package rancher
+
+import (
+	"sync"
+)
+
+// lbUpdate is an update of the hosts of an LB waiting for the update running for the LB
+type lbUpdate struct {
+	sync func() error
+	done chan struct{}
+	err  error
+}
+
+// lbUpdates coalesces the updates of the hosts of LBs. An update requested while another one
+// runs for the same LB waits for it, and the updates requested meanwhile run once, with the
+// latest hosts. A scale-up of several nodes reconfigures the LB twice at most.
+type lbUpdates struct {
+	sync.Mutex
+	running map[string]bool
+	pending map[string]*lbUpdate
+}
+
+// run runs sync for the LB with the given name, or coalesces it with the updates waiting for
+// the one running for the LB
+func (u *lbUpdates) run(name string, sync func() error) error {
+	u.Lock()
+	if u.running == nil {
+		u.running = map[string]bool{}
+		u.pending = map[string]*lbUpdate{}
+	}
+	if u.running[name] {
+		update, ok := u.pending[name]
+		if !ok {
+			update = &lbUpdate{done: make(chan struct{})}
+			u.pending[name] = update
+		}
+		// The latest update supersedes the ones waiting
+		update.sync = sync
+		u.Unlock()
+		<-update.done
+		return update.err
+	}
+	u.running[name] = true
+	u.Unlock()
+
+	err := sync()
+	for {
+		u.Lock()
+		update, ok := u.pending[name]
+		delete(u.pending, name)
+		if !ok {
+			delete(u.running, name)
+			u.Unlock()
+			return err
+		}
+		u.Unlock()
+		update.err = update.sync()
+		close(update.done)
+	}
+}
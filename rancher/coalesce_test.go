@@ -0,0 +1,60 @@
+package rancher
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLBUpdatesCoalesced(t *testing.T) {
+	updates := &lbUpdates{}
+	release := make(chan struct{})
+	started := make(chan struct{})
+	runs := map[int]int{}
+	var runsLock sync.Mutex
+	update := func(n int) func() error {
+		return func() error {
+			if n == 0 {
+				close(started)
+				<-release
+			}
+			runsLock.Lock()
+			defer runsLock.Unlock()
+			runs[n]++
+			return nil
+		}
+	}
+
+	go updates.run("lb", update(0))
+	<-started
+	// nine nodes join while the LB is updated for the first one
+	var wg sync.WaitGroup
+	for n := 1; n < 10; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			if err := updates.run("lb", update(n)); err != nil {
+				t.Errorf("update %d: unexpected error: %v", n, err)
+			}
+		}(n)
+	}
+	// an update of another LB doesn't wait
+	if err := updates.run("other", func() error { return nil }); err != nil {
+		t.Errorf("unexpected error updating another LB: %v", err)
+	}
+	for waiting := false; !waiting; {
+		time.Sleep(10 * time.Millisecond)
+		updates.Lock()
+		waiting = updates.pending["lb"] != nil
+		updates.Unlock()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	runsLock.Lock()
+	defer runsLock.Unlock()
+	if len(runs) != 2 || runs[0] != 1 {
+		t.Errorf("expected the first update and one coalesced update to run, found %v", runs)
+	}
+}
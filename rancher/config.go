@@ -0,0 +1,267 @@
+package rancher
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/gcfg.v1"
+
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+const (
+	// bareProviderIDScheme configures providerIDs without a scheme, i.e. just the host ID
+	bareProviderIDScheme = "none"
+)
+
+// uriScheme matches the characters RFC 3986 allows in a URI scheme
+var uriScheme = regexp.MustCompile("^[a-zA-Z][a-zA-Z0-9+.-]*$")
+
+type configGlobal struct {
+	// CattleURLs are the endpoints of the Rancher API in order of preference
+	CattleURLs      []string `gcfg:"cattle-url"`
+	CattleAccessKey string   `gcfg:"cattle-access-key"`
+	CattleSecretKey string   `gcfg:"cattle-secret-key"`
+	// Token is a bearer token used instead of the access and secret key
+	Token string `gcfg:"token"`
+	// APIVersion is the version of the Rancher API, v2-beta for Rancher 1.x or v3 for Rancher 2.x,
+	// detected from the server if empty
+	APIVersion string `gcfg:"api-version"`
+	// EnvironmentID scopes the requests to a Rancher environment, e.g. 1a5, when the credentials
+	// aren't those of an environment API key. With the v3 API it's the ID of the cluster whose
+	// nodes are read, e.g. c-abc12.
+	EnvironmentID string `gcfg:"environment-id"`
+	// HTTPTimeout is how long the go-rancher client waits for the responses of the Rancher API
+	HTTPTimeout string `gcfg:"http-timeout"`
+
+	// CAFile is a PEM file, and CAData a base64 encoded PEM blob, of the CA certificates the
+	// Rancher API is verified with besides the system ones
+	CAFile string `gcfg:"ca-file"`
+	CAData string `gcfg:"ca-data"`
+	// ClientCertFile and ClientKeyFile are the PEM certificate and key presented to the Rancher
+	// API for mutual TLS
+	ClientCertFile string `gcfg:"client-cert-file"`
+	ClientKeyFile  string `gcfg:"client-key-file"`
+	// InsecureSkipVerify disables the verification of the certificate of the Rancher API
+	InsecureSkipVerify bool `gcfg:"insecure-skip-verify"`
+
+	// ProviderIDScheme is the scheme of the providerIDs the provider writes, e.g. rancher or cattle,
+	// or "none" for bare host IDs. Canonical rancher:// and bare providerIDs are always accepted.
+	ProviderIDScheme string `gcfg:"provider-id-scheme"`
+
+	// LoadBalancerMode is how services are load balanced unless they set rancher.io/load-balancer-mode:
+	// with a Rancher LB ("rancher"), or by publishing the addresses of the nodes ("nodeport")
+	LoadBalancerMode string `gcfg:"load-balancer-mode"`
+	// NodePortAddressType is the type of the node addresses published in nodeport mode,
+	// ExternalIP or InternalIP
+	NodePortAddressType string `gcfg:"nodeport-address-type"`
+
+	// ExcludeLBNodeLabel is the key of the node label excluding nodes from the backends of LBs,
+	// whatever its value, or empty to only exclude masters and unschedulable nodes
+	ExcludeLBNodeLabel string `gcfg:"exclude-lb-node-label"`
+
+	// ManageRoutes makes the provider route the pod CIDRs of the nodes, for pod networks whose
+	// agents program the routes the pod-cidr labels of the hosts tell
+	ManageRoutes bool `gcfg:"manage-routes"`
+
+	// WaitForLBReady makes EnsureLoadBalancer return the status of an LB only once it is active and
+	// healthy, failing if that takes longer than LBReadyTimeout
+	WaitForLBReady bool   `gcfg:"wait-for-lb-ready"`
+	LBReadyTimeout string `gcfg:"lb-ready-timeout"`
+
+	// DisconnectedGracePeriod is how long the agent of a host must stay disconnected before the
+	// host is reported shut down, so that agent restarts don't taint its node
+	DisconnectedGracePeriod string `gcfg:"disconnected-grace-period"`
+
+	// HostCacheTTL is how long host lookups are served from a listing of all the hosts before it's
+	// listed again, 0 to look up every host on its own
+	HostCacheTTL string `gcfg:"host-cache-ttl"`
+
+	// ZoneLabel and RegionLabel are the keys of the host labels telling the zone and the region of
+	// hosts, io.rancher.host.zone and io.rancher.host.region if empty. The metadata fields of hosts
+	// are their io.rancher.host.<field> labels.
+	ZoneLabel   string `gcfg:"zone-label"`
+	RegionLabel string `gcfg:"region-label"`
+
+	// SubscribeHostEvents makes the provider subscribe to the host change events of the Rancher
+	// API, so that host changes show up before the next poll
+	SubscribeHostEvents bool `gcfg:"subscribe-host-events"`
+
+	// MaxRequestAttempts is how many times GET requests failing with 429 or a 5xx status are sent
+	// to the Rancher API, 1 to never retry them
+	MaxRequestAttempts int `gcfg:"max-request-attempts"`
+}
+
+type rConfig struct {
+	Global configGlobal
+
+	// lbReadyTimeout is the parsed LBReadyTimeout
+	lbReadyTimeout time.Duration
+	// disconnectedGracePeriod is the parsed DisconnectedGracePeriod
+	disconnectedGracePeriod time.Duration
+	// hostCacheTTL is the parsed HostCacheTTL
+	hostCacheTTL time.Duration
+	// httpTimeout is the parsed HTTPTimeout
+	httpTimeout time.Duration
+	// tls is the TLS configuration of the connections to the Rancher API, nil for the defaults
+	tls *tls.Config
+	// apiVersion is the configured or detected APIVersion, set once the client is created
+	apiVersion string
+}
+
+// readConfig reads the cloud-config file, falling back to the CATTLE_URL, CATTLE_ACCESS_KEY,
+// CATTLE_SECRET_KEY, CATTLE_TOKEN and CATTLE_ENVIRONMENT_ID environment variables for settings the
+// file doesn't have. cattle-url may be given several times, CATTLE_URL takes a comma separated
+// list. Credentials given to SetCredentials take precedence over both.
+func readConfig(config io.Reader) (*rConfig, error) {
+	conf := &rConfig{
+		Global: configGlobal{
+			CattleAccessKey:         os.Getenv("CATTLE_ACCESS_KEY"),
+			CattleSecretKey:         os.Getenv("CATTLE_SECRET_KEY"),
+			Token:                   os.Getenv("CATTLE_TOKEN"),
+			EnvironmentID:           os.Getenv("CATTLE_ENVIRONMENT_ID"),
+			HTTPTimeout:             "10s",
+			ProviderIDScheme:        providerName,
+			LoadBalancerMode:        rancherLBMode,
+			NodePortAddressType:     string(api.NodeExternalIP),
+			ExcludeLBNodeLabel:      defaultExcludeLBNodeLabel,
+			WaitForLBReady:          true,
+			LBReadyTimeout:          "5m",
+			DisconnectedGracePeriod: "2m",
+			HostCacheTTL:            "15s",
+			MaxRequestAttempts:      3,
+		},
+	}
+
+	if config != nil {
+		if err := gcfg.ReadInto(conf, config); err != nil {
+			return nil, fmt.Errorf("Couldn't read cloud config. Error: %v", err)
+		}
+	}
+
+	if len(conf.Global.CattleURLs) == 0 && os.Getenv("CATTLE_URL") != "" {
+		conf.Global.CattleURLs = strings.Split(os.Getenv("CATTLE_URL"), ",")
+	}
+
+	if data := credentials(); data != nil {
+		if err := applyCredentials(&conf.Global, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func (c *rConfig) validate() error {
+	if len(c.Global.CattleURLs) == 0 {
+		return fmt.Errorf("Invalid cloud config: cattle-url or CATTLE_URL must be set")
+	}
+	for _, apiURL := range c.Global.CattleURLs {
+		u, err := url.Parse(apiURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Invalid cattle-url [%s]: must be an http or https URL, e.g. https://rancher.example.com/v2-beta", apiURL)
+		}
+	}
+	if c.Global.Token != "" && (c.Global.CattleAccessKey != "" || c.Global.CattleSecretKey != "") {
+		return fmt.Errorf("Invalid cloud config: token can't be combined with cattle-access-key or cattle-secret-key")
+	}
+	if (c.Global.CattleAccessKey == "") != (c.Global.CattleSecretKey == "") {
+		return fmt.Errorf("Invalid cloud config: cattle-access-key and cattle-secret-key must be set together")
+	}
+	switch c.Global.APIVersion {
+	case "", apiVersionV2Beta, apiVersionV3:
+	default:
+		return fmt.Errorf("Invalid api-version [%s]: must be %s or %s, or empty to detect it", c.Global.APIVersion, apiVersionV2Beta, apiVersionV3)
+	}
+	if strings.Contains(c.Global.EnvironmentID, "/") {
+		return fmt.Errorf("Invalid environment-id [%s]: must be the ID of an environment, e.g. 1a5", c.Global.EnvironmentID)
+	}
+	httpTimeout, err := time.ParseDuration(c.Global.HTTPTimeout)
+	if err != nil || httpTimeout <= 0 {
+		return fmt.Errorf("Invalid http-timeout [%s]: must be a positive duration, e.g. 10s", c.Global.HTTPTimeout)
+	}
+	c.httpTimeout = httpTimeout
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return err
+	}
+	c.tls = tlsConfig
+	if c.Global.ProviderIDScheme != bareProviderIDScheme && !uriScheme.MatchString(c.Global.ProviderIDScheme) {
+		return fmt.Errorf("Invalid provider-id-scheme [%s]: must start with a letter followed by letters, digits, '+', '-' or '.'",
+			c.Global.ProviderIDScheme)
+	}
+	if c.Global.LoadBalancerMode != rancherLBMode && c.Global.LoadBalancerMode != nodePortLBMode {
+		return fmt.Errorf("Invalid load-balancer-mode [%s]: must be %s or %s",
+			c.Global.LoadBalancerMode, rancherLBMode, nodePortLBMode)
+	}
+	timeout, err := time.ParseDuration(c.Global.LBReadyTimeout)
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("Invalid lb-ready-timeout [%s]: must be a positive duration, e.g. 5m", c.Global.LBReadyTimeout)
+	}
+	c.lbReadyTimeout = timeout
+	gracePeriod, err := time.ParseDuration(c.Global.DisconnectedGracePeriod)
+	if err != nil || gracePeriod < 0 {
+		return fmt.Errorf("Invalid disconnected-grace-period [%s]: must be a duration, e.g. 2m", c.Global.DisconnectedGracePeriod)
+	}
+	c.disconnectedGracePeriod = gracePeriod
+	hostCacheTTL, err := time.ParseDuration(c.Global.HostCacheTTL)
+	if err != nil || hostCacheTTL < 0 {
+		return fmt.Errorf("Invalid host-cache-ttl [%s]: must be a duration, e.g. 15s, or 0 to disable the cache", c.Global.HostCacheTTL)
+	}
+	c.hostCacheTTL = hostCacheTTL
+	if c.Global.MaxRequestAttempts < 1 {
+		return fmt.Errorf("Invalid max-request-attempts [%d]: must be at least 1", c.Global.MaxRequestAttempts)
+	}
+	switch api.NodeAddressType(c.Global.NodePortAddressType) {
+	case api.NodeExternalIP, api.NodeInternalIP:
+	default:
+		return fmt.Errorf("Invalid nodeport-address-type [%s]: must be %s or %s",
+			c.Global.NodePortAddressType, api.NodeExternalIP, api.NodeInternalIP)
+	}
+	return nil
+}
+
+// apiURL returns the URL of the Rancher API the go-rancher client is created with: the v3 API of
+// Rancher 2.x, or the v2-beta API of Rancher 1.x scoped to the environment if one is configured
+func (c *rConfig) apiURL() string {
+	base := strings.TrimSuffix(c.Global.CattleURLs[0], "/")
+	if c.apiVersion == apiVersionV3 {
+		if !strings.HasSuffix(base, "/"+apiVersionV3) {
+			base += "/" + apiVersionV3
+		}
+		return base
+	}
+	if c.Global.EnvironmentID == "" {
+		return c.Global.CattleURLs[0]
+	}
+	return base + "/projects/" + c.Global.EnvironmentID
+}
+
+// zoneLabels returns the keys of the host labels telling the zone and the region of hosts
+func (c *rConfig) zoneLabels() (string, string) {
+	zoneLabel, regionLabel := hostZoneLabel, hostRegionLabel
+	if c != nil && c.Global.ZoneLabel != "" {
+		zoneLabel = c.Global.ZoneLabel
+	}
+	if c != nil && c.Global.RegionLabel != "" {
+		regionLabel = c.Global.RegionLabel
+	}
+	return zoneLabel, regionLabel
+}
+
+// authorization returns the Authorization header value for requests to the Rancher API
+func (c *rConfig) authorization() string {
+	if c.Global.Token != "" {
+		return "Bearer " + c.Global.Token
+	}
+	return basicAuth(c.Global.CattleAccessKey, c.Global.CattleSecretKey)
+}
@@ -0,0 +1,243 @@
+package rancher
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+func TestReadConfig(t *testing.T) {
+	defer os.Setenv("CATTLE_URL", os.Getenv("CATTLE_URL"))
+	os.Setenv("CATTLE_URL", "http://rancher:8080/v2-beta")
+
+	tests := []struct {
+		name   string
+		config string
+		scheme string
+		valid  bool
+	}{
+		{name: "default", config: "", scheme: "rancher", valid: true},
+		{name: "custom", config: "[Global]\nprovider-id-scheme = cattle\n", scheme: "cattle", valid: true},
+		{name: "with punctuation", config: "[Global]\nprovider-id-scheme = rancher+v2.beta-1\n", scheme: "rancher+v2.beta-1", valid: true},
+		{name: "bare", config: "[Global]\nprovider-id-scheme = none\n", scheme: "none", valid: true},
+		{name: "leading digit", config: "[Global]\nprovider-id-scheme = 1rancher\n"},
+		{name: "illegal character", config: "[Global]\nprovider-id-scheme = ran_cher\n"},
+		{name: "separator", config: "[Global]\nprovider-id-scheme = \"rancher://\"\n"},
+		{name: "empty", config: "[Global]\nprovider-id-scheme = \"\"\n"},
+		{name: "token", config: "[Global]\ntoken = t0k3n\n", scheme: "rancher", valid: true},
+		{name: "token and keys", config: "[Global]\ntoken = t0k3n\ncattle-access-key = key\ncattle-secret-key = secret\n"},
+		{name: "nodeport mode", config: "[Global]\nload-balancer-mode = nodeport\nnodeport-address-type = InternalIP\n", scheme: "rancher", valid: true},
+		{name: "unknown mode", config: "[Global]\nload-balancer-mode = elb\n"},
+		{name: "unknown address type", config: "[Global]\nnodeport-address-type = Hostname\n"},
+		{name: "lb ready timeout", config: "[Global]\nlb-ready-timeout = 90s\n", scheme: "rancher", valid: true},
+		{name: "invalid lb ready timeout", config: "[Global]\nlb-ready-timeout = 5\n"},
+		{name: "disconnected grace period", config: "[Global]\ndisconnected-grace-period = 0s\n", scheme: "rancher", valid: true},
+		{name: "negative disconnected grace period", config: "[Global]\ndisconnected-grace-period = -1m\n"},
+		{name: "host cache disabled", config: "[Global]\nhost-cache-ttl = 0\n", scheme: "rancher", valid: true},
+		{name: "invalid host cache ttl", config: "[Global]\nhost-cache-ttl = soon\n"},
+		{name: "no retries", config: "[Global]\nmax-request-attempts = 1\n", scheme: "rancher", valid: true},
+		{name: "no attempts", config: "[Global]\nmax-request-attempts = 0\n"},
+		{name: "url", config: "[Global]\ncattle-url = https://rancher.example.com/v2-beta\n", scheme: "rancher", valid: true},
+		{name: "url without scheme", config: "[Global]\ncattle-url = rancher:8080/v2-beta\n"},
+		{name: "url without host", config: "[Global]\ncattle-url = http:///v2-beta\n"},
+		{name: "access key without secret key", config: "[Global]\ncattle-access-key = key\n"},
+		{name: "environment", config: "[Global]\nenvironment-id = 1a5\n", scheme: "rancher", valid: true},
+		{name: "environment path", config: "[Global]\nenvironment-id = projects/1a5\n"},
+		{name: "http timeout", config: "[Global]\nhttp-timeout = 30s\n", scheme: "rancher", valid: true},
+		{name: "no http timeout", config: "[Global]\nhttp-timeout = 0\n"},
+	}
+
+	for _, test := range tests {
+		conf, err := readConfig(strings.NewReader(test.config))
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: expected config to be rejected", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if conf.Global.ProviderIDScheme != test.scheme {
+			t.Errorf("%s: expected scheme %s, found %s", test.name, test.scheme, conf.Global.ProviderIDScheme)
+		}
+	}
+}
+
+func TestReadConfigSettings(t *testing.T) {
+	for _, env := range []string{"CATTLE_URL", "CATTLE_ACCESS_KEY", "CATTLE_SECRET_KEY", "CATTLE_TOKEN", "CATTLE_ENVIRONMENT_ID"} {
+		defer os.Setenv(env, os.Getenv(env))
+		os.Unsetenv(env)
+	}
+
+	tests := []struct {
+		name          string
+		config        string
+		env           map[string]string
+		urls          []string
+		accessKey     string
+		environmentID string
+		httpTimeout   time.Duration
+		hostCacheTTL  time.Duration
+	}{
+		{
+			name:         "defaults",
+			config:       "[Global]\ncattle-url = http://rancher:8080/v2-beta\n",
+			urls:         []string{"http://rancher:8080/v2-beta"},
+			httpTimeout:  10 * time.Second,
+			hostCacheTTL: 15 * time.Second,
+		},
+		{
+			name: "file",
+			config: "[Global]\ncattle-url = http://rancher-1:8080/v2-beta\ncattle-url = http://rancher-2:8080/v2-beta\n" +
+				"cattle-access-key = key\ncattle-secret-key = secret\nenvironment-id = 1a5\nhttp-timeout = 1m\nhost-cache-ttl = 0\n",
+			urls:          []string{"http://rancher-1:8080/v2-beta", "http://rancher-2:8080/v2-beta"},
+			accessKey:     "key",
+			environmentID: "1a5",
+			httpTimeout:   time.Minute,
+		},
+		{
+			name: "environment",
+			env: map[string]string{"CATTLE_URL": "http://rancher-1:8080/v2-beta,http://rancher-2:8080/v2-beta",
+				"CATTLE_ACCESS_KEY": "env", "CATTLE_SECRET_KEY": "env-secret", "CATTLE_ENVIRONMENT_ID": "1a7"},
+			urls:          []string{"http://rancher-1:8080/v2-beta", "http://rancher-2:8080/v2-beta"},
+			accessKey:     "env",
+			environmentID: "1a7",
+			httpTimeout:   10 * time.Second,
+			hostCacheTTL:  15 * time.Second,
+		},
+		{
+			name:          "file over environment",
+			config:        "[Global]\ncattle-url = http://rancher:8080/v2-beta\nenvironment-id = 1a5\n",
+			env:           map[string]string{"CATTLE_URL": "http://other:8080/v2-beta", "CATTLE_ENVIRONMENT_ID": "1a7"},
+			urls:          []string{"http://rancher:8080/v2-beta"},
+			environmentID: "1a5",
+			httpTimeout:   10 * time.Second,
+			hostCacheTTL:  15 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		for env, value := range test.env {
+			os.Setenv(env, value)
+		}
+		conf, err := readConfig(strings.NewReader(test.config))
+		for env := range test.env {
+			os.Unsetenv(env)
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if strings.Join(conf.Global.CattleURLs, ",") != strings.Join(test.urls, ",") {
+			t.Errorf("%s: expected urls %v, found %v", test.name, test.urls, conf.Global.CattleURLs)
+		}
+		if conf.Global.CattleAccessKey != test.accessKey {
+			t.Errorf("%s: expected access key %q, found %q", test.name, test.accessKey, conf.Global.CattleAccessKey)
+		}
+		if conf.Global.EnvironmentID != test.environmentID {
+			t.Errorf("%s: expected environment %q, found %q", test.name, test.environmentID, conf.Global.EnvironmentID)
+		}
+		if conf.httpTimeout != test.httpTimeout || conf.hostCacheTTL != test.hostCacheTTL {
+			t.Errorf("%s: expected http timeout %v and host cache ttl %v, found %v and %v",
+				test.name, test.httpTimeout, test.hostCacheTTL, conf.httpTimeout, conf.hostCacheTTL)
+		}
+	}
+
+	if _, err := readConfig(strings.NewReader("")); err == nil || !strings.Contains(err.Error(), "cattle-url") {
+		t.Errorf("expected a config without url to be rejected, err: %v", err)
+	}
+}
+
+func TestNewRancherCloud(t *testing.T) {
+	cattle := newFakeCattle()
+	defer cattle.Close()
+
+	config := "[Global]\ncattle-url = " + cattle.URL + "/v2-beta\ntoken = t0k3n\nenvironment-id = 1a5\nhttp-timeout = 45s\nhost-cache-ttl = 1m\n"
+	cloud, err := newRancherCloud(strings.NewReader(config))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := cloud.(*CloudProvider)
+	opts := r.client.RancherBaseClient.(*client.RancherBaseClientImpl).Opts
+
+	if url := opts.Url; url != cattle.URL+"/v2-beta/projects/1a5" {
+		t.Errorf("expected the client to use the environment API, found %s", url)
+	}
+	if opts.Timeout != 45*time.Second {
+		t.Errorf("expected the client to time out after 45s, found %v", opts.Timeout)
+	}
+	if r.hosts.ttl != time.Minute {
+		t.Errorf("expected host listings to be cached for 1m, found %v", r.hosts.ttl)
+	}
+	if _, err := r.client.Host.List(nil); err != nil {
+		t.Errorf("unexpected error listing hosts: %v", err)
+	}
+	if auth := cattle.lastAuthorization(); auth != "Bearer t0k3n" {
+		t.Errorf("expected requests to authenticate with the token, found %s", auth)
+	}
+	if path := cattle.lastPath(); path != "/v2-beta/projects/1a5/hosts" {
+		t.Errorf("expected hosts to be listed in the environment, found %s", path)
+	}
+
+	if _, err := newRancherCloud(strings.NewReader("[Global]\ncattle-url = " + cattle.URL + "\ncattle-access-key = key\n")); err == nil {
+		t.Errorf("expected an incomplete config to fail")
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	cattle := newFakeCattle()
+	defer cattle.Close()
+
+	tests := []struct {
+		conf          configGlobal
+		authorization string
+	}{
+		{
+			conf:          configGlobal{CattleAccessKey: "key", CattleSecretKey: "secret"},
+			authorization: basicAuth("key", "secret"),
+		},
+		{
+			conf:          configGlobal{Token: "t0k3n"},
+			authorization: "Bearer t0k3n",
+		},
+	}
+
+	for _, test := range tests {
+		c := cattle.clientWithConfig(t, test.conf)
+		if _, err := c.Host.List(nil); err != nil {
+			t.Errorf("Error listing hosts: %v", err)
+		}
+		if auth := cattle.lastAuthorization(); auth != test.authorization {
+			t.Errorf("expected Authorization header %s, found %s", test.authorization, auth)
+		}
+		if auth := (&rConfig{Global: test.conf}).authorization(); auth != test.authorization {
+			t.Errorf("expected authorization %s for direct requests, found %s", test.authorization, auth)
+		}
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	for _, auth := range []string{basicAuth("key", "secret"), "Bearer t0k3n"} {
+		h := http.Header{}
+		h.Set("Authorization", auth)
+		h.Set("Accept", "application/json")
+
+		redacted := redactHeader(h)
+		scheme := strings.SplitN(auth, " ", 2)[0]
+		if got := redacted.Get("Authorization"); got != scheme+" <redacted>" {
+			t.Errorf("expected %s credentials to be redacted, found %s", scheme, got)
+		}
+		if h.Get("Authorization") != auth {
+			t.Errorf("expected original header to be left untouched")
+		}
+		if redacted.Get("Accept") != "application/json" {
+			t.Errorf("expected other headers to be kept")
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package rancher
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// validContentLength tells whether the client can send a body of the given length. The client
+// sets the Content-Length header of its requests to the character whose code is the length of
+// the body, and Go rejects header values with control characters other than the tab.
+func validContentLength(length int) bool {
+	return length == '\t' || (length >= 0x20 && length != 0x7f)
+}
+
+// padUpdates returns updates, along with the unchanged name or description of the resource they
+// update if the body of updates alone has a length the client can't send
+func padUpdates(updates map[string]interface{}, name, description string) (map[string]interface{}, error) {
+	for _, padding := range []map[string]interface{}{
+		{},
+		{"name": name},
+		{"description": description},
+		{"name": name, "description": description},
+	} {
+		for k, v := range updates {
+			padding[k] = v
+		}
+		body, err := json.Marshal(padding)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't marshal the updates %v. Error: %v", updates, err)
+		}
+		if validContentLength(len(body)) {
+			return padding, nil
+		}
+	}
+	return nil, fmt.Errorf("Couldn't pad the updates %v to a length the Rancher client can send", updates)
+}
@@ -0,0 +1,66 @@
+package rancher
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestValidContentLength(t *testing.T) {
+	for length := 0; length < 0x100; length++ {
+		valid := length == 0x09 || (length >= 0x20 && length != 0x7f)
+		if found := validContentLength(length); found != valid {
+			t.Errorf("length %#x: expected valid %v, found %v", length, valid, found)
+		}
+	}
+}
+
+func TestPadUpdates(t *testing.T) {
+	tests := []struct {
+		// length is the length of the body of the updates alone, at least 8
+		length      int
+		name        string
+		description string
+		padded      []string
+	}{
+		{length: 0x08, padded: []string{"description", "name"}},
+		{length: 0x09},
+		{length: 0x0a, name: "lb", padded: []string{"description", "name"}},
+		{length: 0x0a, name: strings.Repeat("n", 0x20-0x0a-len(`,"name":""`)), padded: []string{"name"}},
+		{length: 0x1f, name: "lb", padded: []string{"name"}},
+		{length: 0x1f, name: strings.Repeat("n", 0x7f-0x1f-len(`,"name":""`)), padded: []string{"description"}},
+		{length: 0x20},
+		{length: 0x7e},
+		{length: 0x7f, name: "lb", padded: []string{"name"}},
+		{length: 0x80},
+		{length: 0xff},
+	}
+	for _, test := range tests {
+		// the body of {"a":""} is 8 long
+		updates := map[string]interface{}{"a": strings.Repeat("x", test.length-8)}
+		padded, err := padUpdates(updates, test.name, test.description)
+		if err != nil {
+			t.Errorf("length %#x: Couldn't pad the updates: %v", test.length, err)
+			continue
+		}
+		body, _ := json.Marshal(padded)
+		if !validContentLength(len(body)) {
+			t.Errorf("length %#x: expected a body the client can send, found length %#x", test.length, len(body))
+		}
+		if padded["a"] != updates["a"] {
+			t.Errorf("length %#x: expected the updates to be kept, found %v", test.length, padded)
+		}
+		fields := []string{}
+		for k := range padded {
+			if k != "a" {
+				fields = append(fields, k)
+			}
+		}
+		sort.Strings(fields)
+		if expected := append([]string{}, test.padded...); !reflect.DeepEqual(fields, expected) {
+			t.Errorf("length %#x: expected padding %v, found %v", test.length, expected, fields)
+		}
+	}
+}
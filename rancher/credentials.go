@@ -0,0 +1,123 @@
+package rancher
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// Keys of the Rancher API credentials given to SetCredentials and UpdateCredentials, named
+	// after the cloud config settings
+	credentialsAccessKey = "cattle-access-key"
+	credentialsSecretKey = "cattle-secret-key"
+	credentialsToken     = "token"
+
+	// credentialsCheckTimeout bounds the request checking new credentials
+	credentialsCheckTimeout = 30 * time.Second
+)
+
+var (
+	// credentialsOverride are the credentials given to SetCredentials, guarded by credentialsLock
+	credentialsLock     sync.Mutex
+	credentialsOverride map[string]string
+)
+
+// SetCredentials makes the providers created from now on authenticate to the Rancher API with
+// the credentials in data, cattle-access-key and cattle-secret-key or token, rather than with
+// those of the cloud config file or of the environment. It's meant for credentials kept in a
+// Kubernetes Secret, which later changes are given to UpdateCredentials.
+func SetCredentials(data map[string]string) {
+	credentialsLock.Lock()
+	defer credentialsLock.Unlock()
+	credentialsOverride = data
+}
+
+// credentials returns the credentials given to SetCredentials, if any
+func credentials() map[string]string {
+	credentialsLock.Lock()
+	defer credentialsLock.Unlock()
+	return credentialsOverride
+}
+
+// applyCredentials replaces the credentials of global with the ones in data
+func applyCredentials(global *configGlobal, data map[string]string) error {
+	accessKey, secretKey, token := data[credentialsAccessKey], data[credentialsSecretKey], data[credentialsToken]
+	switch {
+	case token != "" && (accessKey != "" || secretKey != ""):
+		return fmt.Errorf("Invalid credentials: %s can't be combined with %s or %s", credentialsToken, credentialsAccessKey, credentialsSecretKey)
+	case token != "":
+		global.Token, global.CattleAccessKey, global.CattleSecretKey = token, "", ""
+	case accessKey != "" && secretKey != "":
+		global.Token, global.CattleAccessKey, global.CattleSecretKey = "", accessKey, secretKey
+	default:
+		return fmt.Errorf("Invalid credentials: must have %s, or both %s and %s", credentialsToken, credentialsAccessKey, credentialsSecretKey)
+	}
+	return nil
+}
+
+// UpdateCredentials makes the requests to the Rancher API authenticate with the credentials in
+// data, cattle-access-key and cattle-secret-key or token, once the API accepted them. The
+// current credentials are kept if the new ones are invalid or rejected.
+func (r *CloudProvider) UpdateCredentials(data map[string]string) error {
+	global := r.conf.Global
+	if err := applyCredentials(&global, data); err != nil {
+		return err
+	}
+	authorization := (&rConfig{Global: global}).authorization()
+	if err := checkCredentials(global.CattleURLs, authorization); err != nil {
+		return err
+	}
+
+	for _, apiURL := range global.CattleURLs {
+		u, err := url.Parse(apiURL)
+		if err != nil || apiTransport == nil {
+			continue
+		}
+		if group := apiTransport.group(u.Host); group != nil {
+			group.setAuthorization(authorization)
+		}
+	}
+	glog.Infof("Updated the Rancher API credentials")
+	return nil
+}
+
+// checkCredentials makes sure the Rancher API at apiURLs accepts authorization, trying the
+// endpoints in order until one answers
+func checkCredentials(apiURLs []string, authorization string) error {
+	var lastErr error
+	for _, apiURL := range apiURLs {
+		req, err := http.NewRequest("GET", apiURL, nil)
+		if err != nil {
+			return fmt.Errorf("Couldn't check credentials against %s. Error: %v", apiURL, err)
+		}
+		req.Header.Set("Authorization", authorization)
+
+		transport := http.DefaultTransport
+		if apiTransport != nil {
+			// the rancherTransport would replace the credentials being checked
+			transport = apiTransport.roundTripper(apiTransport.group(req.URL.Host))
+		}
+		c := &http.Client{Transport: transport, Timeout: credentialsCheckTimeout}
+		resp, err := c.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		discard(resp.Body)
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+			return fmt.Errorf("Credentials rejected by the Rancher API at %s: %s", apiURL, resp.Status)
+		case resp.StatusCode >= 500:
+			lastErr = fmt.Errorf("%s answered %s", apiURL, resp.Status)
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("Couldn't check credentials, no Rancher API endpoint answered. Error: %v", lastErr)
+}
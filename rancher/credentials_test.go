@@ -0,0 +1,93 @@
+package rancher
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestUpdateCredentials(t *testing.T) {
+	cattle := newFakeCattle()
+	defer cattle.Close()
+	c := cattle.clientWithConfig(t, configGlobal{CattleAccessKey: "old", CattleSecretKey: "secret"})
+	r := &CloudProvider{client: c, conf: &rConfig{Global: configGlobal{
+		CattleURLs:      []string{cattle.URL + "/v2-beta"},
+		CattleAccessKey: "old",
+		CattleSecretKey: "secret",
+	}}}
+	cattle.Lock()
+	cattle.rejected = map[string]bool{basicAuth("revoked", "secret"): true}
+	cattle.Unlock()
+
+	tests := []struct {
+		name          string
+		data          map[string]string
+		valid         bool
+		authorization string
+	}{
+		{name: "rejected", data: map[string]string{"cattle-access-key": "revoked", "cattle-secret-key": "secret"}, authorization: basicAuth("old", "secret")},
+		{name: "missing secret key", data: map[string]string{"cattle-access-key": "new"}, authorization: basicAuth("old", "secret")},
+		{name: "token and keys", data: map[string]string{"token": "t0k3n", "cattle-access-key": "new", "cattle-secret-key": "secret"}, authorization: basicAuth("old", "secret")},
+		{name: "empty", data: map[string]string{}, authorization: basicAuth("old", "secret")},
+		{name: "rotated keys", data: map[string]string{"cattle-access-key": "new", "cattle-secret-key": "secret"}, valid: true, authorization: basicAuth("new", "secret")},
+		{name: "token", data: map[string]string{"token": "t0k3n"}, valid: true, authorization: "Bearer t0k3n"},
+	}
+
+	for _, test := range tests {
+		err := r.UpdateCredentials(test.data)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected the credentials to be refused", test.name)
+		}
+		if _, err := c.Host.List(nil); err != nil {
+			t.Errorf("%s: unexpected error listing hosts: %v", test.name, err)
+		}
+		if auth := cattle.lastAuthorization(); auth != test.authorization {
+			t.Errorf("%s: expected requests to authenticate with %s, found %s", test.name, test.authorization, auth)
+		}
+	}
+}
+
+func TestReadConfigCredentialsPrecedence(t *testing.T) {
+	defer os.Setenv("CATTLE_URL", os.Getenv("CATTLE_URL"))
+	defer os.Setenv("CATTLE_ACCESS_KEY", os.Getenv("CATTLE_ACCESS_KEY"))
+	defer os.Setenv("CATTLE_SECRET_KEY", os.Getenv("CATTLE_SECRET_KEY"))
+	defer SetCredentials(nil)
+	os.Setenv("CATTLE_URL", "http://rancher:8080/v2-beta")
+	os.Setenv("CATTLE_ACCESS_KEY", "env")
+	os.Setenv("CATTLE_SECRET_KEY", "env-secret")
+	file := "[Global]\ncattle-access-key = file\ncattle-secret-key = file-secret\n"
+
+	tests := []struct {
+		name      string
+		config    string
+		secret    map[string]string
+		accessKey string
+		token     string
+	}{
+		{name: "environment", accessKey: "env"},
+		{name: "file over environment", config: file, accessKey: "file"},
+		{name: "secret over file", config: file, secret: map[string]string{"cattle-access-key": "secret", "cattle-secret-key": "secret-secret"}, accessKey: "secret"},
+		{name: "secret token over file keys", config: file, secret: map[string]string{"token": "t0k3n"}, token: "t0k3n"},
+	}
+
+	for _, test := range tests {
+		SetCredentials(test.secret)
+		conf, err := readConfig(strings.NewReader(test.config))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if conf.Global.CattleAccessKey != test.accessKey || conf.Global.Token != test.token {
+			t.Errorf("%s: expected access key %q and token %q, found %q and %q",
+				test.name, test.accessKey, test.token, conf.Global.CattleAccessKey, conf.Global.Token)
+		}
+	}
+
+	SetCredentials(map[string]string{"cattle-access-key": "secret"})
+	if _, err := readConfig(strings.NewReader(file)); err == nil {
+		t.Errorf("expected incomplete credentials to be rejected")
+	}
+}
@@ -0,0 +1,116 @@
+package rancher
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/rancher/go-rancher/client"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+const (
+	// annotationDNSName asks Rancher's external DNS service to publish a name for the LB of a service
+	annotationDNSName = "rancher.io/dns-name"
+
+	// Labels of Rancher services that external DNS publishes names for
+	externalDNSLabel             = "io.rancher.service.external_dns"
+	externalDNSNameTemplateLabel = "io.rancher.service.external_dns_name_template"
+)
+
+// serviceDNSName returns the DNS name service asks for, or "" if it doesn't ask for one
+func serviceDNSName(service *api.Service) (string, error) {
+	name := strings.TrimSuffix(service.Annotations[annotationDNSName], ".")
+	if name == "" {
+		return "", nil
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return "", fmt.Errorf("Invalid %s annotation [%s] on service %s: %s",
+			annotationDNSName, name, serviceKey(service), strings.Join(errs, ", "))
+	}
+	return name, nil
+}
+
+// lbDNSName returns the DNS name external DNS publishes for lb, or "" if it publishes none
+func lbDNSName(lb *client.LoadBalancerService) string {
+	if lb.LaunchConfig == nil {
+		return ""
+	}
+	name, _ := lb.LaunchConfig.Labels[externalDNSNameTemplateLabel].(string)
+	return name
+}
+
+// checkDNSNameConflict fails if an LB other than the one named lbName already has dnsName
+func (r *CloudProvider) checkDNSNameConflict(dnsName, lbName string) error {
+	if dnsName == "" {
+		return nil
+	}
+
+	opts := client.NewListOpts()
+	opts.Filters["removed_null"] = "1"
+	lbs, err := r.client.LoadBalancerService.List(opts)
+	if err != nil {
+		return fmt.Errorf("Couldn't list LBs to check DNS name [%s]. Error: %#v", dnsName, err)
+	}
+	for i := range lbs.Data {
+		lb := &lbs.Data[i]
+		if lb.Name != lbName && strings.EqualFold(lbDNSName(lb), dnsName) {
+			return fmt.Errorf("DNS name [%s] is already used by LB %s", dnsName, lb.Name)
+		}
+	}
+	return nil
+}
+
+// setLBDNSLabels sets the external DNS labels of a launch config for dnsName, removing them if it's ""
+func setLBDNSLabels(launchConfig *client.LaunchConfig, dnsName string) {
+	labels := map[string]interface{}{}
+	for k, v := range launchConfig.Labels {
+		labels[k] = v
+	}
+	if dnsName == "" {
+		delete(labels, externalDNSLabel)
+		delete(labels, externalDNSNameTemplateLabel)
+	} else {
+		labels[externalDNSLabel] = "always"
+		labels[externalDNSNameTemplateLabel] = dnsName
+	}
+	launchConfig.Labels = labels
+}
+
+// ensureLBDNSName registers dnsName for lb with external DNS, or deregisters the name of lb
+// if dnsName is ""
+func (r *CloudProvider) ensureLBDNSName(lb *client.LoadBalancerService, dnsName string) (*client.LoadBalancerService, error) {
+	if lbDNSName(lb) == dnsName {
+		return lb, nil
+	}
+
+	launchConfig := client.LaunchConfig{}
+	if lb.LaunchConfig != nil {
+		launchConfig = *lb.LaunchConfig
+	}
+	setLBDNSLabels(&launchConfig, dnsName)
+	if dnsName == "" {
+		glog.Infof("Deregistering the DNS name of LB %s", lb.Name)
+	} else {
+		glog.Infof("Registering DNS name [%s] for LB %s", dnsName, lb.Name)
+	}
+	return r.upgradeLBLaunchConfig(lb, &launchConfig)
+}
+
+// waitForLBFqdn waits for external DNS to report the FQDN it published for lb
+func (r *CloudProvider) waitForLBFqdn(lb *client.LoadBalancerService) <-chan interface{} {
+	cb := func(result chan<- interface{}) (bool, error) {
+		lb, err := r.reloadLBService(lb)
+		if err != nil {
+			return false, err
+		}
+		if lb.Fqdn != "" {
+			result <- lb
+			return true, nil
+		}
+		return false, nil
+	}
+	return r.waitForAction("fqdn", cb)
+}
@@ -0,0 +1,79 @@
+package rancher
+
+import (
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+func TestServiceDNSName(t *testing.T) {
+	tests := []struct {
+		annotation string
+		name       string
+		valid      bool
+	}{
+		{annotation: "", name: "", valid: true},
+		{annotation: "app.example.com", name: "app.example.com", valid: true},
+		{annotation: "app.example.com.", name: "app.example.com", valid: true},
+		{annotation: "App_1.example.com"},
+	}
+
+	for _, test := range tests {
+		service := &api.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{annotationDNSName: test.annotation}}}
+		name, err := serviceDNSName(service)
+		if test.valid != (err == nil) || name != test.name {
+			t.Errorf("%q: expected name %q, valid %v, found %q, err: %v", test.annotation, test.name, test.valid, name, err)
+		}
+	}
+}
+
+func TestDNSNameConflict(t *testing.T) {
+	lbTestSerializer.Lock()
+	defer lbTestSerializer.Unlock()
+
+	other := client.LaunchConfig{}
+	setLBDNSLabels(&other, "app.example.com")
+	loadBalancerServiceList = &client.LoadBalancerServiceCollection{
+		Data: []client.LoadBalancerService{
+			{Name: "lb-other", LaunchConfig: &other},
+			{Name: "lb-plain", LaunchConfig: &client.LaunchConfig{}},
+		},
+	}
+
+	if err := cloudProvider.checkDNSNameConflict("app.example.com", "lb-mine"); err == nil {
+		t.Errorf("expected conflict with the DNS name of lb-other")
+	}
+	if err := cloudProvider.checkDNSNameConflict("app.example.com", "lb-other"); err != nil {
+		t.Errorf("unexpected conflict of an LB with itself: %v", err)
+	}
+	if err := cloudProvider.checkDNSNameConflict("web.example.com", "lb-mine"); err != nil {
+		t.Errorf("unexpected conflict: %v", err)
+	}
+}
+
+func TestDNSNameStatus(t *testing.T) {
+	launchConfig := client.LaunchConfig{}
+	setLBDNSLabels(&launchConfig, "app.example.com")
+	lb := &client.LoadBalancerService{LaunchConfig: &launchConfig, Fqdn: "app.example.com."}
+
+	status, _, err := cloudProvider.toLBStatus(lb)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(status.Ingress) != 1 || status.Ingress[0].Hostname != "app.example.com" {
+		t.Errorf("expected ingress hostname app.example.com, found %v", status.Ingress)
+	}
+
+	// deregistered
+	setLBDNSLabels(&launchConfig, "")
+	if _, ok := launchConfig.Labels[externalDNSLabel]; ok {
+		t.Errorf("expected external DNS labels to be removed, found %v", launchConfig.Labels)
+	}
+	status, _, _ = cloudProvider.toLBStatus(lb)
+	if len(status.Ingress) != 0 {
+		t.Errorf("expected no ingress once the DNS name is removed, found %v", status.Ingress)
+	}
+}
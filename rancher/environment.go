@@ -0,0 +1,58 @@
+package rancher
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// environmentNameTTL is how long environment names are cached, renames show up after at most this long
+const environmentNameTTL = 10 * time.Minute
+
+type environment struct {
+	id   string
+	name string
+}
+
+func newEnvironmentCache() cache.Store {
+	return cache.NewTTLStore(func(obj interface{}) (string, error) {
+		return obj.(*environment).id, nil
+	}, environmentNameTTL)
+}
+
+// InstanceEnvironment returns the ID and name of the Rancher environment of the host of a node
+func (r *CloudProvider) InstanceEnvironment(nodeName types.NodeName) (string, string, error) {
+	host, err := r.hostGetOrFetchFromCache(string(nodeName))
+	if err != nil {
+		return "", "", err
+	}
+
+	id := host.RancherHost.AccountId
+	name, err := r.environmentName(id)
+	if err != nil {
+		return "", "", err
+	}
+	return id, name, nil
+}
+
+func (r *CloudProvider) environmentName(id string) (string, error) {
+	if obj, ok, _ := r.environmentCache.GetByKey(id); ok {
+		return obj.(*environment).name, nil
+	}
+
+	project, err := r.client.Project.ById(id)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't get environment %s. Error: %#v", id, err)
+	}
+	if project == nil {
+		return "", fmt.Errorf("Environment %s doesn't exist", id)
+	}
+
+	glog.V(4).Infof("Environment %s is named %s", id, project.Name)
+	r.environmentCache.Add(&environment{id: id, name: project.Name})
+	return project.Name, nil
+}
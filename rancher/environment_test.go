@@ -0,0 +1,42 @@
+package rancher
+
+import (
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+func TestInstanceEnvironment(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	hostList = &client.HostCollection{
+		Data: []client.Host{
+			client.Host{
+				Resource:  client.Resource{Id: "1h7"},
+				Hostname:  "envhost",
+				AccountId: "1a5",
+			},
+		},
+	}
+	ipAddressLinks["1h7"] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "10.0.0.7"}}}
+	projects["1a5"] = &client.Project{Resource: client.Resource{Id: "1a5"}, Name: "Production"}
+	lookups := projectLookups
+
+	for i := 0; i < 2; i++ {
+		id, name, err := cloudProvider.InstanceEnvironment("envhost")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if id != "1a5" || name != "Production" {
+			t.Errorf("expected environment [1a5 Production], found [%s %s]", id, name)
+		}
+	}
+	if projectLookups-lookups != 1 {
+		t.Errorf("expected the environment name to be looked up once, found %d lookups", projectLookups-lookups)
+	}
+
+	delete(projects, "1a5")
+	if _, err := cloudProvider.environmentName("1a6"); err == nil {
+		t.Errorf("expected an error for a missing environment")
+	}
+}
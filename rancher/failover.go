@@ -0,0 +1,151 @@
+package rancher
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// endpointFailbackInterval is how often requests probe the preferred endpoint after a failover
+	endpointFailbackInterval = time.Minute
+)
+
+// endpointGroup is a list of Rancher API endpoints serving the same API, in order of preference.
+// Requests stick to the last endpoint that answered; while that isn't the preferred one,
+// a request is sent to the preferred endpoint every endpointFailbackInterval to fail back.
+type endpointGroup struct {
+	endpoints []*url.URL
+	now       func() time.Time
+	// transport connects to the endpoints if set, e.g. with a custom TLS configuration
+	transport http.RoundTripper
+
+	sync.Mutex
+	// authorization replaces the Authorization header of the requests if set, e.g. with the
+	// bearer token of the endpoints or with rotated credentials
+	authorization string
+	active        int
+	lastProbe     time.Time
+	// timeout is how long each request to an endpoint may take, 0 for no limit
+	timeout time.Duration
+	// maxAttempts is how many times requests failing with a transient status are sent
+	maxAttempts int
+}
+
+func newEndpointGroup(endpoints []*url.URL, token string, now func() time.Time) *endpointGroup {
+	g := &endpointGroup{
+		endpoints: endpoints,
+		now:       now,
+	}
+	if token != "" {
+		g.authorization = "Bearer " + token
+	}
+	return g
+}
+
+// setAuthorization makes the requests to the endpoints authenticate with authorization
+func (g *endpointGroup) setAuthorization(authorization string) {
+	g.Lock()
+	defer g.Unlock()
+	g.authorization = authorization
+}
+
+// requestAuthorization returns the Authorization header of the requests, "" to leave it as is
+func (g *endpointGroup) requestAuthorization() string {
+	g.Lock()
+	defer g.Unlock()
+	return g.authorization
+}
+
+// setTimeout makes each request to an endpoint fail after timeout
+func (g *endpointGroup) setTimeout(timeout time.Duration) {
+	g.Lock()
+	defer g.Unlock()
+	g.timeout = timeout
+}
+
+// requestTimeout returns how long each request to an endpoint may take, 0 for no limit
+func (g *endpointGroup) requestTimeout() time.Duration {
+	g.Lock()
+	defer g.Unlock()
+	return g.timeout
+}
+
+// setMaxAttempts makes requests failing with a transient status be sent up to attempts times
+func (g *endpointGroup) setMaxAttempts(attempts int) {
+	g.Lock()
+	defer g.Unlock()
+	g.maxAttempts = attempts
+}
+
+// attempts returns how many times requests failing with a transient status are sent, at least once
+func (g *endpointGroup) attempts() int {
+	g.Lock()
+	defer g.Unlock()
+	if g.maxAttempts < 1 {
+		return 1
+	}
+	return g.maxAttempts
+}
+
+// candidates returns the indexes of the endpoints in the order the next request should try them
+func (g *endpointGroup) candidates() []int {
+	g.Lock()
+	defer g.Unlock()
+
+	order := []int{g.active}
+	if g.active != 0 && g.now().Sub(g.lastProbe) >= endpointFailbackInterval {
+		g.lastProbe = g.now()
+		order = []int{0, g.active}
+	}
+	for i := range g.endpoints {
+		if i != 0 && i != g.active {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// succeeded makes the endpoint at index i the one requests are sent to
+func (g *endpointGroup) succeeded(i int) {
+	g.Lock()
+	defer g.Unlock()
+
+	if i == g.active {
+		return
+	}
+	from, to := g.endpoints[g.active], g.endpoints[i]
+	if i == 0 {
+		glog.Infof("Preferred Rancher API endpoint %s is reachable again, failing back from %s", to.Host, from.Host)
+	} else {
+		glog.Warningf("Rancher API endpoint %s is unreachable, failing over to %s", from.Host, to.Host)
+		g.lastProbe = g.now()
+	}
+	g.active = i
+	apiFailovers.WithLabelValues(to.Host).Inc()
+}
+
+// retriable tells whether a request that failed with err may be sent again. Requests that
+// change state are only retried if they never reached the server, so they aren't applied twice.
+func retriable(method string, err error) bool {
+	if idempotent(method) {
+		return true
+	}
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return false
+}
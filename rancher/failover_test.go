@@ -0,0 +1,82 @@
+package rancher
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFailover(t *testing.T) {
+	down := newFakeCattle()
+	down.Close()
+	cattle := newFakeCattle()
+	defer cattle.Close()
+
+	before := counterValue(t, apiFailovers, cattle.Listener.Addr().String())
+
+	c, err := getRancherClient(&rConfig{Global: configGlobal{CattleURLs: []string{down.URL + "/v2-beta", cattle.URL + "/v2-beta"}}})
+	if err != nil {
+		t.Fatalf("expected client to fail over to the second endpoint: %v", err)
+	}
+	if _, err := c.Host.List(nil); err != nil {
+		t.Errorf("Error listing hosts: %v", err)
+	}
+
+	if after := counterValue(t, apiFailovers, cattle.Listener.Addr().String()); after != before+1 {
+		t.Errorf("expected a single failover to be counted, count went from %v to %v", before, after)
+	}
+}
+
+func TestEndpointGroupCandidates(t *testing.T) {
+	now := time.Date(2017, 5, 1, 12, 0, 0, 0, time.UTC)
+	g := newEndpointGroup([]*url.URL{{Host: "primary"}, {Host: "secondary"}, {Host: "tertiary"}}, "", func() time.Time { return now })
+
+	if order := g.candidates(); !reflect.DeepEqual(order, []int{0, 1, 2}) {
+		t.Errorf("expected endpoints in order of preference, found %v", order)
+	}
+
+	g.succeeded(1)
+	if order := g.candidates(); !reflect.DeepEqual(order, []int{1, 2}) {
+		t.Errorf("expected requests to stick to the active endpoint, found %v", order)
+	}
+
+	now = now.Add(endpointFailbackInterval)
+	if order := g.candidates(); !reflect.DeepEqual(order, []int{0, 1, 2}) {
+		t.Errorf("expected the preferred endpoint to be probed, found %v", order)
+	}
+	if order := g.candidates(); !reflect.DeepEqual(order, []int{1, 2}) {
+		t.Errorf("expected the preferred endpoint to be probed only once per interval, found %v", order)
+	}
+
+	g.succeeded(0)
+	if order := g.candidates(); !reflect.DeepEqual(order, []int{0, 1, 2}) {
+		t.Errorf("expected requests to fail back to the preferred endpoint, found %v", order)
+	}
+}
+
+func TestRetriable(t *testing.T) {
+	dialErr := &url.Error{Op: "Post", URL: "http://rancher", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	readErr := &url.Error{Op: "Post", URL: "http://rancher", Err: &net.OpError{Op: "read", Err: errors.New("connection reset")}}
+
+	tests := []struct {
+		method    string
+		err       error
+		retriable bool
+	}{
+		{method: "GET", err: readErr, retriable: true},
+		{method: "GET", err: dialErr, retriable: true},
+		{method: "POST", err: dialErr, retriable: true},
+		{method: "POST", err: readErr, retriable: false},
+		{method: "PUT", err: errors.New("EOF"), retriable: false},
+		{method: "DELETE", err: dialErr.Err, retriable: true},
+	}
+
+	for _, test := range tests {
+		if retriable := retriable(test.method, test.err); retriable != test.retriable {
+			t.Errorf("expected %s failing with %v to be retriable: %v", test.method, test.err, test.retriable)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package rancher
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/rancher/go-rancher/client"
+
+	"k8s.io/apimachinery/pkg/types"
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+const (
+	// Metadata the provider writes on the LBs it creates: the cluster, and the service the LB was
+	// created for. Only the LBs carrying it are garbage collected.
+	lbClusterMetadata    = "io.rancher.k8s.cluster"
+	lbServiceMetadata    = "io.rancher.k8s.service"
+	lbServiceUIDMetadata = "io.rancher.k8s.service-uid"
+)
+
+// lbOwnershipMetadata returns the metadata marking an LB as created for service in the cluster
+// named clusterName
+func lbOwnershipMetadata(clusterName string, service *api.Service) map[string]string {
+	return map[string]string{
+		lbClusterMetadata:    lbClusterID(clusterName),
+		lbServiceMetadata:    serviceKey(service),
+		lbServiceUIDMetadata: string(service.UID),
+	}
+}
+
+// lbOwnerUID returns the UID of the service lb was created for in the cluster named clusterName,
+// or "" if lb lacks the ownership metadata of the cluster
+func lbOwnerUID(lb *client.LoadBalancerService, clusterName string) types.UID {
+	if metadataString(lb, lbClusterMetadata) != lbClusterID(clusterName) {
+		return ""
+	}
+	return types.UID(metadataString(lb, lbServiceUIDMetadata))
+}
+
+// ensureLBOwnership marks lb as created for service, the LBs created before the ownership
+// metadata existed are marked when they are ensured
+func (r *CloudProvider) ensureLBOwnership(lb *client.LoadBalancerService, clusterName string, service *api.Service) (*client.LoadBalancerService, error) {
+	if lbOwnerUID(lb, clusterName) == service.UID {
+		return lb, nil
+	}
+	return r.updateLBMetadata(lb, lbOwnershipMetadata(clusterName, service))
+}
+
+// lbServicePage is a page of a listing of LBs
+type lbServicePage struct {
+	client.LoadBalancerServiceCollection
+}
+
+func (c *lbServicePage) nextPage() string {
+	if c.Pagination == nil {
+		return ""
+	}
+	return c.Pagination.Next
+}
+
+// CollectLoadBalancers deletes the LBs created for the services of the cluster named clusterName
+// that no longer exist, e.g. deleted while the controller manager was down. services returns the
+// UIDs of the services of the cluster, it's called once the LBs are listed so the LBs created
+// meanwhile aren't mistaken for orphans. LBs without the ownership metadata of the cluster, such
+// as adopted LBs and the LBs created by older versions, are never deleted.
+func (r *CloudProvider) CollectLoadBalancers(clusterName string, services func() (map[types.UID]bool, error)) error {
+	env, err := r.getEnvironment(clusterName)
+	if err != nil || env == nil {
+		return err
+	}
+
+	opts := client.NewListOpts()
+	opts.Filters["environmentId"] = env.Id
+	opts.Filters["removed_null"] = "1"
+	opts.Filters["limit"] = hostListPageSize
+	lbs := []client.LoadBalancerService{}
+	err = listPages(r.client, client.LOAD_BALANCER_SERVICE_TYPE, opts, func() pagedCollection {
+		return &lbServicePage{}
+	}, func(page pagedCollection) {
+		lbs = append(lbs, page.(*lbServicePage).Data...)
+	})
+	if err != nil {
+		return fmt.Errorf("Couldn't list the LBs of environment %s. Error: %#v", env.Name, err)
+	}
+
+	live, err := services()
+	if err != nil {
+		return err
+	}
+	for i := range lbs {
+		lb := &lbs[i]
+		uid := lbOwnerUID(lb, clusterName)
+		if uid == "" || live[uid] || serviceRemoved(lb.State) {
+			continue
+		}
+		glog.Infof("Deleting LB %s, service %s it was created for no longer exists", lb.Name, metadataString(lb, lbServiceMetadata))
+		if err := r.deleteLB(lb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package rancher
+
+import (
+	"errors"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+func TestCollectLoadBalancers(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}}
+
+	live, orphaned := newLBService(nil), newLBService(nil)
+	orphaned.Name, orphaned.UID = "deleted", types.UID("3b1f7e0c-7d4c-11e7-bb31-be2e44b06b34")
+	for _, service := range []*api.Service{live, orphaned} {
+		if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err != nil {
+			t.Fatalf("Couldn't ensure the LB of %s: %v", service.Name, err)
+		}
+	}
+	lb, err := r.getLBByName(lbName("kubernetes", live))
+	if err != nil || lb == nil || lbOwnerUID(lb, "kubernetes") != live.UID {
+		t.Fatalf("expected the LB to be marked as created for %s, found %#v, %v", live.Name, lb, err)
+	}
+	// LBs created by hand or by older versions in the stack, and the LB of another cluster
+	cattle.Lock()
+	cattle.add("loadbalancerservices", map[string]interface{}{"name": "by-hand", "environmentId": lb.EnvironmentId, "state": "active"})
+	cattle.add("loadbalancerservices", map[string]interface{}{
+		"name": "other-cluster", "environmentId": lb.EnvironmentId, "state": "active",
+		"metadata": map[string]interface{}{lbClusterMetadata: "other", lbServiceUIDMetadata: "other"},
+	})
+	cattle.Unlock()
+
+	listed := false
+	err = r.CollectLoadBalancers("kubernetes", func() (map[types.UID]bool, error) {
+		listed = true
+		return map[types.UID]bool{live.UID: true}, nil
+	})
+	if err != nil || !listed {
+		t.Fatalf("Couldn't collect the LBs: %v, services listed: %v", err, listed)
+	}
+	for _, name := range []string{lbName("kubernetes", live), "by-hand", "other-cluster"} {
+		if lb, err := r.getLBByName(name); err != nil || lb == nil {
+			t.Errorf("expected LB %s to be left alone, found %#v, %v", name, lb, err)
+		}
+	}
+	if lb, err := r.getLBByName(lbName("kubernetes", orphaned)); err != nil || lb != nil {
+		t.Errorf("expected the LB of the deleted service to be deleted, found %#v, %v", lb, err)
+	}
+
+	// the stack is left to the remaining LBs, and nothing is deleted if the services can't be listed
+	mutations := cattle.mutationCount()
+	err = r.CollectLoadBalancers("kubernetes", func() (map[types.UID]bool, error) {
+		return nil, errors.New("forbidden")
+	})
+	if err == nil || cattle.mutationCount() != mutations || cattle.count("environments") != 1 {
+		t.Errorf("expected the collection to fail without changes, found %v, %d changes", err, cattle.mutationCount()-mutations)
+	}
+}
+
+func TestLBOwnershipMarked(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	service := newLBService(nil)
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}}
+	name := lbName("kubernetes", service)
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't ensure the LB: %v", err)
+	}
+
+	// an LB created before the ownership metadata is marked when it's ensured
+	lb, err := r.getLBByName(name)
+	if err != nil || lb == nil {
+		t.Fatalf("expected LB %s to exist, found %#v, %v", name, lb, err)
+	}
+	cattle.Lock()
+	cattle.resources["loadbalancerservices"][lb.Id]["metadata"] = map[string]interface{}{}
+	cattle.Unlock()
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't ensure the LB again: %v", err)
+	}
+	if lb, err := r.getLBByName(name); err != nil || lb == nil || lbOwnerUID(lb, "kubernetes") != service.UID ||
+		metadataString(lb, lbServiceMetadata) != "default/web" {
+		t.Errorf("expected the LB to be marked as created for default/web, found %#v, %v", lb, err)
+	}
+
+	now := metav1.Now()
+	service.DeletionTimestamp = &now
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err == nil {
+		t.Errorf("expected the LB of a service being deleted not to be ensured")
+	}
+}
@@ -0,0 +1,14 @@
+package rancher
+
+import (
+	"fmt"
+)
+
+// Ping checks that the Rancher API is reachable and accepts the configured credentials, by
+// listing a single host
+func (r *CloudProvider) Ping() error {
+	if err := r.hostAPI().ping(); err != nil {
+		return fmt.Errorf("Couldn't reach the Rancher API. Error: %#v", err)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package rancher
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPing(t *testing.T) {
+	cattle := newFakeCattle()
+	defer cattle.Close()
+	r := &CloudProvider{client: cattle.client(t)}
+
+	if err := r.Ping(); err != nil {
+		t.Errorf("expected ping to succeed, got %v", err)
+	}
+
+	cattle.setStatus(http.StatusUnauthorized)
+	if err := r.Ping(); err == nil {
+		t.Errorf("expected ping with rejected credentials to fail")
+	}
+}
@@ -0,0 +1,152 @@
+package rancher
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+
+	api "k8s.io/kubernetes/pkg/api/v1"
+	v1service "k8s.io/kubernetes/pkg/api/v1/service"
+)
+
+// The annotations configuring how the LB of a service checks the health of its hosts. The LB
+// only checks its hosts if one of them is set, or if the service routes external traffic to its
+// local endpoints only. The interval and the timeout are durations, e.g. 5s.
+const (
+	annotationHealthCheckInterval           = "rancher.io/lb-healthcheck-interval"
+	annotationHealthCheckTimeout            = "rancher.io/lb-healthcheck-timeout"
+	annotationHealthCheckHealthyThreshold   = "rancher.io/lb-healthcheck-healthy-threshold"
+	annotationHealthCheckUnhealthyThreshold = "rancher.io/lb-healthcheck-unhealthy-threshold"
+
+	// annotationHealthCheckPath makes the check an HTTP GET of the path, a TCP connection if it's
+	// missing
+	annotationHealthCheckPath = "rancher.io/lb-healthcheck-path"
+
+	// annotationHealthCheckPort is the host port checked, the node port of the first port of the
+	// service if it's missing
+	annotationHealthCheckPort = "rancher.io/lb-healthcheck-port"
+
+	defaultHealthCheckInterval           = 2 * time.Second
+	defaultHealthCheckTimeout            = 2 * time.Second
+	defaultHealthCheckHealthyThreshold   = 2
+	defaultHealthCheckUnhealthyThreshold = 3
+)
+
+var healthCheckAnnotations = []string{
+	annotationHealthCheckInterval,
+	annotationHealthCheckTimeout,
+	annotationHealthCheckHealthyThreshold,
+	annotationHealthCheckUnhealthyThreshold,
+	annotationHealthCheckPath,
+	annotationHealthCheckPort,
+}
+
+// hasHealthCheckAnnotations tells whether one of the health check annotations is set on service
+func hasHealthCheckAnnotations(service *api.Service) bool {
+	for _, annotation := range healthCheckAnnotations {
+		if _, ok := service.Annotations[annotation]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceHealthCheck returns the health check the LB of service runs on its hosts, or nil if it
+// runs none. Services routing external traffic to their local endpoints only are checked on
+// their health check node port by default, so the hosts without endpoints get no traffic.
+func serviceHealthCheck(service *api.Service) (*client.InstanceHealthCheck, error) {
+	localPath, localPort := v1service.GetServiceHealthCheckPathPort(service)
+	if localPort == 0 && !hasHealthCheckAnnotations(service) {
+		return nil, nil
+	}
+
+	check := &client.InstanceHealthCheck{
+		Interval:           int64(defaultHealthCheckInterval / time.Millisecond),
+		ResponseTimeout:    int64(defaultHealthCheckTimeout / time.Millisecond),
+		HealthyThreshold:   defaultHealthCheckHealthyThreshold,
+		UnhealthyThreshold: defaultHealthCheckUnhealthyThreshold,
+	}
+	path := localPath
+	if localPort != 0 {
+		check.Port = int64(localPort)
+	} else if len(service.Spec.Ports) > 0 {
+		check.Port = int64(service.Spec.Ports[0].NodePort)
+	}
+
+	invalid := func(annotation, value, expected string) error {
+		return fmt.Errorf("Invalid value %q of annotation %s of service %s, must be %s",
+			value, annotation, serviceKey(service), expected)
+	}
+	for _, annotation := range []string{annotationHealthCheckInterval, annotationHealthCheckTimeout} {
+		value, ok := service.Annotations[annotation]
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < time.Millisecond {
+			return nil, invalid(annotation, value, "a positive duration, e.g. 5s")
+		}
+		if annotation == annotationHealthCheckInterval {
+			check.Interval = int64(d / time.Millisecond)
+		} else {
+			check.ResponseTimeout = int64(d / time.Millisecond)
+		}
+	}
+	for _, annotation := range []string{annotationHealthCheckHealthyThreshold, annotationHealthCheckUnhealthyThreshold} {
+		value, ok := service.Annotations[annotation]
+		if !ok {
+			continue
+		}
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold < 1 {
+			return nil, invalid(annotation, value, "a number of checks, at least 1")
+		}
+		if annotation == annotationHealthCheckHealthyThreshold {
+			check.HealthyThreshold = int64(threshold)
+		} else {
+			check.UnhealthyThreshold = int64(threshold)
+		}
+	}
+	if value, ok := service.Annotations[annotationHealthCheckPath]; ok {
+		if !strings.HasPrefix(value, "/") || strings.ContainsAny(value, " \t\r\n") {
+			return nil, invalid(annotationHealthCheckPath, value, "an absolute path, e.g. /healthz")
+		}
+		path = value
+	}
+	if value, ok := service.Annotations[annotationHealthCheckPort]; ok {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, invalid(annotationHealthCheckPort, value, "a port number")
+		}
+		check.Port = int64(port)
+	}
+	if check.Port == 0 {
+		return nil, fmt.Errorf("Service %s has no port to check the health of its hosts on, %s is required",
+			serviceKey(service), annotationHealthCheckPort)
+	}
+	if path != "" {
+		check.RequestLine = fmt.Sprintf("GET %s HTTP/1.0", path)
+	}
+	return check, nil
+}
+
+// hostExternalServiceName returns the name of the external service of hostname checked with check.
+// The LBs checking their hosts the same way share their external services.
+func hostExternalServiceName(hostname string, check *client.InstanceHealthCheck) string {
+	name := buildExternalServiceName(hostname)
+	if check == nil {
+		return name
+	}
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d/%d/%d/%d/%d/%s", check.Interval, check.ResponseTimeout, check.HealthyThreshold,
+		check.UnhealthyThreshold, check.Port, check.RequestLine)
+	suffix := fmt.Sprintf("-hc%08x", h.Sum32())
+	if len(name) > 63-len(suffix) {
+		name = strings.TrimRight(name[:63-len(suffix)], "-")
+	}
+	return name + suffix
+}
@@ -0,0 +1,173 @@
+package rancher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "k8s.io/kubernetes/pkg/api/v1"
+	v1service "k8s.io/kubernetes/pkg/api/v1/service"
+)
+
+// localTraffic are the annotations of a service routing external traffic to its local endpoints
+// only, checked on node port 32000
+var localTraffic = map[string]string{
+	v1service.BetaAnnotationExternalTraffic:     v1service.AnnotationValueExternalTrafficLocal,
+	v1service.BetaAnnotationHealthCheckNodePort: "32000",
+}
+
+func TestServiceHealthCheck(t *testing.T) {
+	defaults := client.InstanceHealthCheck{Interval: 2000, ResponseTimeout: 2000, HealthyThreshold: 2, UnhealthyThreshold: 3, Port: 30080}
+	withCheck := func(change func(check *client.InstanceHealthCheck)) *client.InstanceHealthCheck {
+		check := defaults
+		change(&check)
+		return &check
+	}
+	merge := func(a, b map[string]string) map[string]string {
+		merged := map[string]string{}
+		for _, m := range []map[string]string{a, b} {
+			for k, v := range m {
+				merged[k] = v
+			}
+		}
+		return merged
+	}
+	tests := []struct {
+		annotations map[string]string
+		check       *client.InstanceHealthCheck
+		invalid     bool
+	}{
+		{annotations: nil},
+		{
+			annotations: map[string]string{annotationHealthCheckHealthyThreshold: "2"},
+			check:       withCheck(func(*client.InstanceHealthCheck) {}),
+		},
+		{
+			annotations: map[string]string{
+				annotationHealthCheckInterval:           "5s",
+				annotationHealthCheckTimeout:            "500ms",
+				annotationHealthCheckHealthyThreshold:   "1",
+				annotationHealthCheckUnhealthyThreshold: "5",
+				annotationHealthCheckPath:               "/ready",
+				annotationHealthCheckPort:               "8080",
+			},
+			check: &client.InstanceHealthCheck{Interval: 5000, ResponseTimeout: 500, HealthyThreshold: 1,
+				UnhealthyThreshold: 5, Port: 8080, RequestLine: "GET /ready HTTP/1.0"},
+		},
+		{
+			annotations: localTraffic,
+			check: withCheck(func(check *client.InstanceHealthCheck) {
+				check.Port = 32000
+				check.RequestLine = "GET /healthz HTTP/1.0"
+			}),
+		},
+		{
+			annotations: merge(localTraffic, map[string]string{annotationHealthCheckInterval: "10s", annotationHealthCheckPath: "/live"}),
+			check: withCheck(func(check *client.InstanceHealthCheck) {
+				check.Interval = 10000
+				check.Port = 32000
+				check.RequestLine = "GET /live HTTP/1.0"
+			}),
+		},
+		{annotations: map[string]string{v1service.BetaAnnotationExternalTraffic: v1service.AnnotationValueExternalTrafficGlobal}},
+		{annotations: map[string]string{annotationHealthCheckInterval: "0s"}, invalid: true},
+		{annotations: map[string]string{annotationHealthCheckInterval: "5"}, invalid: true},
+		{annotations: map[string]string{annotationHealthCheckTimeout: "-1s"}, invalid: true},
+		{annotations: map[string]string{annotationHealthCheckHealthyThreshold: "0"}, invalid: true},
+		{annotations: map[string]string{annotationHealthCheckUnhealthyThreshold: "many"}, invalid: true},
+		{annotations: map[string]string{annotationHealthCheckPath: "healthz"}, invalid: true},
+		{annotations: map[string]string{annotationHealthCheckPath: "/health z"}, invalid: true},
+		{annotations: map[string]string{annotationHealthCheckPort: "70000"}, invalid: true},
+	}
+	for _, test := range tests {
+		service := &api.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: test.annotations},
+			Spec:       api.ServiceSpec{Ports: []api.ServicePort{{Port: 80, NodePort: 30080}}},
+		}
+		check, err := serviceHealthCheck(service)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%v: expected an error, found %#v", test.annotations, check)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.annotations, err)
+		} else if !reflect.DeepEqual(check, test.check) {
+			t.Errorf("%v: expected %#v, found %#v", test.annotations, test.check, check)
+		}
+	}
+}
+
+func TestHostExternalServiceName(t *testing.T) {
+	check := &client.InstanceHealthCheck{Interval: 2000, Port: 30080}
+	if name := hostExternalServiceName("host1.example.com", nil); name != "host1-example-com" {
+		t.Errorf("expected the external services without health check to keep their names, found %s", name)
+	}
+	name := hostExternalServiceName("host1.example.com", check)
+	if !strings.HasPrefix(name, "host1-example-com-hc") || name != hostExternalServiceName("host1.example.com", check) {
+		t.Errorf("expected a stable name for the health check, found %s", name)
+	}
+	if name == hostExternalServiceName("host1.example.com", &client.InstanceHealthCheck{Interval: 2000, Port: 30081}) {
+		t.Errorf("expected another health check to get another name, found %s", name)
+	}
+	if long := hostExternalServiceName(strings.Repeat("a", 70), check); len(long) > 63 {
+		t.Errorf("expected the name to be truncated to 63 characters, found %s", long)
+	}
+}
+
+func TestLBHealthCheck(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	service := newLBService(map[string]string{annotationHealthCheckPath: "/ready"})
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}}
+
+	steps := []struct {
+		annotations map[string]string
+		update      bool
+		requestLine string
+		port        int64
+	}{
+		{annotations: map[string]string{annotationHealthCheckPath: "/ready"}, requestLine: "GET /ready HTTP/1.0", port: 30080},
+		{annotations: localTraffic, requestLine: "GET /healthz HTTP/1.0", port: 32000},
+		{annotations: map[string]string{annotationHealthCheckPort: "8080"}, update: true, port: 8080},
+		{annotations: map[string]string{}},
+	}
+	for i, step := range steps {
+		service.Annotations = step.annotations
+		var err error
+		if step.update {
+			err = r.UpdateLoadBalancer("kubernetes", service, nodes)
+		} else {
+			_, err = r.EnsureLoadBalancer("kubernetes", service, nodes)
+		}
+		if err != nil {
+			t.Fatalf("step %d: couldn't sync the LB: %v", i, err)
+		}
+		services, err := r.client.ExternalService.List(client.NewListOpts())
+		if err != nil || len(services.Data) != 1 {
+			t.Fatalf("step %d: expected a single external service, found %#v, %v", i, services, err)
+		}
+		check := services.Data[0].HealthCheck
+		if step.port == 0 {
+			if check != nil {
+				t.Errorf("step %d: expected no health check, found %#v", i, check)
+			}
+			continue
+		}
+		if check == nil || check.Port != step.port || check.RequestLine != step.requestLine {
+			t.Errorf("step %d: expected a check of %q on port %d, found %#v", i, step.requestLine, step.port, check)
+		}
+	}
+
+	service.Annotations = map[string]string{annotationHealthCheckUnhealthyThreshold: "0"}
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err == nil {
+		t.Errorf("expected an invalid threshold to fail the sync")
+	}
+	if err := r.UpdateLoadBalancer("kubernetes", service, nodes); err == nil {
+		t.Errorf("expected an invalid threshold to fail the update")
+	}
+}
@@ -0,0 +1,155 @@
+package rancher
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/golang/glog"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	// apiVersionV2Beta is the API of Rancher 1.x, where the nodes are hosts
+	apiVersionV2Beta = "v2-beta"
+	// apiVersionV3 is the API of Rancher 2.x, where the nodes are nodes of a cluster
+	apiVersionV3 = "v3"
+)
+
+// hostAPI reads the hosts from the Rancher API, whichever its version. Hosts are returned as
+// Rancher 1.x hosts, whose states the rest of the provider knows.
+type hostAPI interface {
+	// listHosts lists the hosts that aren't removed, with their IP addresses if the API includes them
+	listHosts() ([]listedHost, error)
+	// hostByID returns the host with the given ID, or nil if there is none
+	hostByID(id string) (*listedHost, error)
+	// hostIPAddresses returns the IP addresses of a host
+	hostIPAddresses(host *listedHost) ([]client.IpAddress, error)
+	// ping lists a single host
+	ping() error
+}
+
+// hostAPI returns the hostAPI of the version of the Rancher API
+func (r *CloudProvider) hostAPI() hostAPI {
+	if r.conf != nil && r.conf.apiVersion == apiVersionV3 {
+		return &v3HostAPI{client: r.client, clusterID: r.conf.Global.EnvironmentID}
+	}
+	return &v2HostAPI{client: r.client}
+}
+
+// detectAPIVersion returns the version of the Rancher API at apiURL: the version its path ends
+// with, or v3 if the server answers the v3 discovery at /v3, or v2-beta otherwise
+func detectAPIVersion(apiURL string) string {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return apiVersionV2Beta
+	}
+	switch path.Base(u.Path) {
+	case apiVersionV3:
+		return apiVersionV3
+	case apiVersionV2Beta, "v1", "v2":
+		return apiVersionV2Beta
+	}
+
+	discovery := *u
+	discovery.Path = path.Join(u.Path, apiVersionV3)
+	resp, err := http.Get(discovery.String())
+	if err != nil {
+		glog.Warningf("Couldn't detect the version of the Rancher API at %s, using %s. Error: %v", apiURL, apiVersionV2Beta, err)
+		return apiVersionV2Beta
+	}
+	discard(resp.Body)
+	// the v3 discovery links the schemas of the API, and requires authentication unless the
+	// credentials are accepted
+	if (resp.StatusCode == http.StatusOK && resp.Header.Get("X-API-Schemas") != "") || resp.StatusCode == http.StatusUnauthorized {
+		return apiVersionV3
+	}
+	return apiVersionV2Beta
+}
+
+// pagedCollection is a page of a listing
+type pagedCollection interface {
+	nextPage() string
+}
+
+func (c *listedHostCollection) nextPage() string {
+	if c.Pagination == nil {
+		return ""
+	}
+	return c.Pagination.Next
+}
+
+// listPages lists schemaType with opts, following the pages of the listing. Every page is created
+// by newPage and given to add once filled. It fails rather than list part of the resources if there
+// are more than maxHostListPages pages.
+func listPages(c *client.RancherClient, schemaType string, opts *client.ListOpts, newPage func() pagedCollection, add func(pagedCollection)) error {
+	page := newPage()
+	if err := c.List(schemaType, opts, page); err != nil {
+		return err
+	}
+	add(page)
+	for pages := 1; page.nextPage() != ""; pages++ {
+		if pages == maxHostListPages {
+			return fmt.Errorf("Couldn't list %s: there are more than %d pages of %s", schemaType, maxHostListPages, hostListPageSize)
+		}
+		next := client.Resource{Links: map[string]string{"next": page.nextPage()}}
+		page = newPage()
+		if err := c.GetLink(next, "next", page); err != nil {
+			return err
+		}
+		add(page)
+	}
+	return nil
+}
+
+// v2HostAPI reads the hosts of the Rancher 1.x API
+type v2HostAPI struct {
+	client *client.RancherClient
+}
+
+func (a *v2HostAPI) listHosts() ([]listedHost, error) {
+	opts := client.NewListOpts()
+	opts.Filters["removed_null"] = "1"
+	opts.Filters["include"] = "ipAddresses"
+	opts.Filters["limit"] = hostListPageSize
+
+	hosts := []listedHost{}
+	err := listPages(a.client, client.HOST_TYPE, opts, func() pagedCollection {
+		return &listedHostCollection{}
+	}, func(page pagedCollection) {
+		hosts = append(hosts, page.(*listedHostCollection).Data...)
+	})
+	return hosts, err
+}
+
+func (a *v2HostAPI) hostByID(id string) (*listedHost, error) {
+	host, err := a.client.Host.ById(id)
+	if apiErr, ok := err.(*client.ApiError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil || host == nil {
+		return nil, err
+	}
+	return &listedHost{Host: *host}, nil
+}
+
+// hostIPAddresses returns the IP addresses included in the listing of a host, or gets them if
+// they weren't
+func (a *v2HostAPI) hostIPAddresses(host *listedHost) ([]client.IpAddress, error) {
+	if host.IPAddresses != nil {
+		return host.IPAddresses, nil
+	}
+	coll := &client.IpAddressCollection{}
+	if err := a.client.GetLink(host.Resource, "ipAddresses", coll); err != nil {
+		return nil, fmt.Errorf("Error getting ip addresses for host [%s]. Error: %#v", host.Id, err)
+	}
+	return coll.Data, nil
+}
+
+func (a *v2HostAPI) ping() error {
+	opts := client.NewListOpts()
+	opts.Filters["limit"] = "1"
+	_, err := a.client.Host.List(opts)
+	return err
+}
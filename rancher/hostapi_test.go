@@ -0,0 +1,236 @@
+package rancher
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+
+	"k8s.io/apimachinery/pkg/types"
+	api "k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// newFakeRancherV3 returns a Rancher 2.x API serving the nodes recorded in testdata/v3/nodes.json
+func newFakeRancherV3(t *testing.T) *httptest.Server {
+	recorded, err := ioutil.ReadFile("testdata/v3/nodes.json")
+	if err != nil {
+		t.Fatalf("Couldn't read the recorded nodes: %v", err)
+	}
+	nodes := &v3NodeCollection{}
+	if err := json.Unmarshal(recorded, nodes); err != nil {
+		t.Fatalf("Couldn't parse the recorded nodes: %v", err)
+	}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case req.URL.Path == "/v3":
+			w.Header().Set("X-API-Schemas", server.URL+"/v3/schemas")
+			w.Write([]byte("{}"))
+		case req.URL.Path == "/v3/schemas":
+			json.NewEncoder(w).Encode(client.Schemas{Data: []client.Schema{{
+				Resource:          client.Resource{Id: v3NodeType, Links: map[string]string{"collection": server.URL + "/v3/nodes"}},
+				PluralName:        "nodes",
+				CollectionMethods: []string{"GET"},
+				ResourceMethods:   []string{"GET"},
+			}}})
+		case req.URL.Path == "/v3/nodes":
+			page := &v3NodeCollection{}
+			for _, node := range nodes.Data {
+				if clusterID := req.URL.Query().Get("clusterId"); clusterID == "" || node.ClusterId == clusterID {
+					page.Data = append(page.Data, node)
+				}
+			}
+			json.NewEncoder(w).Encode(page)
+		case strings.HasPrefix(req.URL.Path, "/v3/nodes/"):
+			for _, node := range nodes.Data {
+				if node.Id == strings.TrimPrefix(req.URL.Path, "/v3/nodes/") {
+					json.NewEncoder(w).Encode(node)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"type":"error","status":404,"code":"NotFound"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+func newV3CloudProvider(t *testing.T, config string) *CloudProvider {
+	cloud, err := newRancherCloud(strings.NewReader("[Global]\nload-balancer-mode = nodeport\nhost-cache-ttl = 0\n" + config))
+	if err != nil {
+		t.Fatalf("Couldn't create the cloud provider: %v", err)
+	}
+	return cloud.(*CloudProvider)
+}
+
+func TestV3HostStates(t *testing.T) {
+	tests := []struct {
+		state      string
+		hostState  string
+		agentState string
+	}{
+		{state: "active", hostState: "active"},
+		{state: "cordoned", hostState: "active"},
+		{state: "draining", hostState: "active"},
+		{state: "drained", hostState: "active"},
+		{state: "registering", hostState: "activating"},
+		{state: "provisioning", hostState: "activating"},
+		{state: "unavailable", hostState: "active", agentState: "disconnected"},
+		{state: "removing", hostState: "removing"},
+		{state: "error", hostState: "error"},
+	}
+
+	for _, test := range tests {
+		hostState, agentState := v3HostStates(test.state)
+		if hostState != test.hostState || agentState != test.agentState {
+			t.Errorf("%s: expected host state %q and agent state %q, found %q and %q",
+				test.state, test.hostState, test.agentState, hostState, agentState)
+		}
+	}
+}
+
+func TestV3Hosts(t *testing.T) {
+	server := newFakeRancherV3(t)
+	defer server.Close()
+	r := newV3CloudProvider(t, "cattle-url = "+server.URL+"/v3\nenvironment-id = c-abc12\n")
+
+	if r.conf.apiVersion != apiVersionV3 {
+		t.Fatalf("expected the v3 API to be used, found %s", r.conf.apiVersion)
+	}
+
+	addresses, err := r.NodeAddresses("ip-10-0-0-11")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []api.NodeAddress{
+		{Type: api.NodeInternalIP, Address: "10.0.0.11"},
+		{Type: api.NodeExternalIP, Address: "203.0.113.11"},
+		{Type: api.NodeLegacyHostIP, Address: "10.0.0.11"},
+		{Type: api.NodeHostName, Address: "ip-10-0-0-11"},
+	}
+	if len(addresses) != len(expected) {
+		t.Fatalf("expected addresses %v, found %v", expected, addresses)
+	}
+	for i := range expected {
+		if addresses[i] != expected[i] {
+			t.Errorf("expected addresses %v, found %v", expected, addresses)
+			break
+		}
+	}
+
+	// the public IP label of a node is kept
+	addresses, err = r.NodeAddressesByProviderID("rancher://c-abc12:m-9fz4t")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if addresses[1] != (api.NodeAddress{Type: api.NodeExternalIP, Address: "198.51.100.12"}) {
+		t.Errorf("expected the labelled public IP to be the external IP, found %v", addresses)
+	}
+
+	// the providerIDs are built from the node IDs
+	if providerID, err := r.ProviderIDByNodeName("ip-10-0-0-12"); err != nil || providerID != "rancher://c-abc12:m-9fz4t" {
+		t.Errorf("unexpected providerID %s, err: %v", providerID, err)
+	}
+	if id, err := r.InstanceID("ip-10-0-0-11"); err != nil || id != "2ad3c1c4-2500-11e8-9a3c-0242ac110002" {
+		t.Errorf("unexpected instance ID %s, err: %v", id, err)
+	}
+
+	names, err := r.List(".*")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(names) != 3 {
+		t.Errorf("expected the nodes of the cluster to be listed, found %v", names)
+	}
+	if _, err := r.NodeAddresses(types.NodeName("worker-pool2")); err != cloudprovider.InstanceNotFound {
+		t.Errorf("expected a node of another cluster not to be found, err: %v", err)
+	}
+
+	tests := []struct {
+		providerID string
+		exists     bool
+		shutdown   bool
+		shutdownOK bool
+	}{
+		{providerID: "rancher://c-abc12:m-7k2lq", exists: true, shutdownOK: true},
+		{providerID: "rancher://c-abc12:m-9fz4t", exists: true, shutdownOK: true},
+		{providerID: "rancher://c-abc12:m-gone1"},
+		{providerID: "rancher://c-xyz98:m-5qw3p"},
+	}
+	for _, test := range tests {
+		exists, err := r.InstanceExistsByProviderID(test.providerID)
+		if err != nil || exists != test.exists {
+			t.Errorf("%s: expected exists %v, found %v, err: %v", test.providerID, test.exists, exists, err)
+		}
+		shutdown, err := r.InstanceShutdownByProviderID(test.providerID)
+		if (err == nil) != test.shutdownOK || shutdown != test.shutdown {
+			t.Errorf("%s: expected shutdown %v, found %v, err: %v", test.providerID, test.shutdown, shutdown, err)
+		}
+	}
+
+	// an unavailable node is disconnected, shut down once the grace period expired
+	r.conf.disconnectedGracePeriod = 0
+	if shutdown, err := r.InstanceShutdownByProviderID("rancher://c-abc12:m-2m8vx"); err != nil || !shutdown {
+		t.Errorf("expected an unavailable node to be shut down, found %v, err: %v", shutdown, err)
+	}
+	if err := r.Ping(); err != nil {
+		t.Errorf("unexpected ping error: %v", err)
+	}
+}
+
+func TestV3HostsOfAllClusters(t *testing.T) {
+	server := newFakeRancherV3(t)
+	defer server.Close()
+	r := newV3CloudProvider(t, "cattle-url = "+server.URL+"/v3\n")
+
+	// a provisioning node is transitioning
+	_, err := r.InstanceShutdownByProviderID("rancher://c-xyz98:m-5qw3p")
+	if _, ok := err.(*hostTransitioningError); !ok {
+		t.Errorf("expected a provisioning node to be transitioning, err: %v", err)
+	}
+	if names, err := r.List(".*"); err != nil || len(names) != 4 {
+		t.Errorf("expected the nodes of all clusters to be listed, found %v, err: %v", names, err)
+	}
+}
+
+func TestDetectAPIVersion(t *testing.T) {
+	v3 := newFakeRancherV3(t)
+	defer v3.Close()
+	v2 := newFakeCattle()
+	defer v2.Close()
+
+	tests := []struct {
+		url     string
+		version string
+	}{
+		{url: "http://rancher:8080/v2-beta", version: apiVersionV2Beta},
+		{url: "http://rancher:8080/v1", version: apiVersionV2Beta},
+		{url: "https://rancher.example.com/v3", version: apiVersionV3},
+		{url: v3.URL, version: apiVersionV3},
+		{url: v2.URL, version: apiVersionV2Beta},
+	}
+
+	for _, test := range tests {
+		if version := detectAPIVersion(test.url); version != test.version {
+			t.Errorf("%s: expected version %s, found %s", test.url, test.version, version)
+		}
+	}
+
+	// the v3 API is found under the bare URL of the server
+	r := newV3CloudProvider(t, "cattle-url = "+v3.URL+"\n")
+	if r.conf.apiVersion != apiVersionV3 || r.conf.apiURL() != v3.URL+"/v3" {
+		t.Errorf("expected the v3 API at %s/v3, found %s at %s", v3.URL, r.conf.apiVersion, r.conf.apiURL())
+	}
+	if _, err := newRancherCloud(strings.NewReader("[Global]\ncattle-url = " + v3.URL + "/v3\n")); err == nil {
+		t.Errorf("expected Rancher load balancers to be refused with the v3 API")
+	}
+}
@@ -0,0 +1,145 @@
+package rancher
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+// v3NodeType is the schema type of the nodes of the Rancher 2.x API
+const v3NodeType = "node"
+
+// v3Node is a node of the Rancher 2.x API
+type v3Node struct {
+	client.Resource
+	Name              string                 `json:"name,omitempty"`
+	NodeName          string                 `json:"nodeName,omitempty"`
+	Hostname          string                 `json:"hostname,omitempty"`
+	RequestedHostname string                 `json:"requestedHostname,omitempty"`
+	State             string                 `json:"state,omitempty"`
+	Uuid              string                 `json:"uuid,omitempty"`
+	ClusterId         string                 `json:"clusterId,omitempty"`
+	IpAddress         string                 `json:"ipAddress,omitempty"`
+	ExternalIpAddress string                 `json:"externalIpAddress,omitempty"`
+	Labels            map[string]interface{} `json:"labels,omitempty"`
+	Removed           string                 `json:"removed,omitempty"`
+}
+
+type v3NodeCollection struct {
+	client.Collection
+	Data []v3Node `json:"data,omitempty"`
+}
+
+func (c *v3NodeCollection) nextPage() string {
+	if c.Pagination == nil {
+		return ""
+	}
+	return c.Pagination.Next
+}
+
+// v3HostStates maps the state of a Rancher 2.x node to the states of a Rancher 1.x host and of
+// its agent
+func v3HostStates(state string) (string, string) {
+	switch strings.ToLower(state) {
+	case "active", "cordoned", "draining", "drained":
+		// cordoning and draining don't stop the machine
+		return "active", ""
+	case "registering", "provisioning", "updating", "waiting":
+		return "activating", ""
+	case "unavailable":
+		// the node agent isn't connected
+		return "active", "disconnected"
+	}
+	return strings.ToLower(state), ""
+}
+
+// listedHost returns the node as a Rancher 1.x host. The Kubernetes name of the node is its
+// hostname, its IP address its only IP address, and its external IP address its public IP.
+func (n *v3Node) listedHost() listedHost {
+	host := listedHost{
+		Host: client.Host{
+			Resource: n.Resource,
+			Name:     n.Name,
+			Uuid:     n.Uuid,
+			Removed:  n.Removed,
+			Labels:   map[string]interface{}{},
+		},
+		IPAddresses: []client.IpAddress{},
+	}
+	host.State, host.AgentState = v3HostStates(n.State)
+
+	for _, hostname := range []string{n.NodeName, n.Hostname, n.RequestedHostname} {
+		if hostname != "" {
+			host.Hostname = hostname
+			break
+		}
+	}
+	for key, value := range n.Labels {
+		host.Labels[key] = value
+	}
+	if n.IpAddress != "" {
+		host.IPAddresses = append(host.IPAddresses, client.IpAddress{Address: n.IpAddress})
+	}
+	if _, ok := host.Labels[hostPublicIPLabel]; !ok && n.ExternalIpAddress != "" && n.ExternalIpAddress != n.IpAddress {
+		host.Labels[hostPublicIPLabel] = n.ExternalIpAddress
+	}
+	return host
+}
+
+// v3HostAPI reads the nodes of the Rancher 2.x API, of the cluster with ID clusterID if set
+type v3HostAPI struct {
+	client    *client.RancherClient
+	clusterID string
+}
+
+func (a *v3HostAPI) listOpts() *client.ListOpts {
+	opts := client.NewListOpts()
+	opts.Filters["limit"] = hostListPageSize
+	if a.clusterID != "" {
+		opts.Filters["clusterId"] = a.clusterID
+	}
+	return opts
+}
+
+func (a *v3HostAPI) listHosts() ([]listedHost, error) {
+	hosts := []listedHost{}
+	err := listPages(a.client, v3NodeType, a.listOpts(), func() pagedCollection {
+		return &v3NodeCollection{}
+	}, func(page pagedCollection) {
+		for i := range page.(*v3NodeCollection).Data {
+			node := &page.(*v3NodeCollection).Data[i]
+			if node.Removed == "" {
+				hosts = append(hosts, node.listedHost())
+			}
+		}
+	})
+	return hosts, err
+}
+
+func (a *v3HostAPI) hostByID(id string) (*listedHost, error) {
+	node := &v3Node{}
+	err := a.client.ById(v3NodeType, id, node)
+	if apiErr, ok := err.(*client.ApiError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if node.Id == "" || (a.clusterID != "" && node.ClusterId != a.clusterID) {
+		return nil, nil
+	}
+	host := node.listedHost()
+	return &host, nil
+}
+
+// hostIPAddresses returns the IP addresses of a node, which are part of it
+func (a *v3HostAPI) hostIPAddresses(host *listedHost) ([]client.IpAddress, error) {
+	return host.IPAddresses, nil
+}
+
+func (a *v3HostAPI) ping() error {
+	opts := client.NewListOpts()
+	opts.Filters["limit"] = "1"
+	return a.client.List(v3NodeType, opts, &v3NodeCollection{})
+}
@@ -0,0 +1,220 @@
+package rancher
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/rancher/go-rancher/client"
+)
+
+const (
+	// hostListPageSize is the number of hosts requested per page when listing all the hosts
+	hostListPageSize = "1000"
+	// maxHostListPages caps the pages of a host listing, in case the API keeps linking more
+	maxHostListPages = 100
+)
+
+// listedHost is a host listed with its IP addresses included
+type listedHost struct {
+	client.Host
+	IPAddresses []client.IpAddress `json:"ipAddresses"`
+}
+
+type listedHostCollection struct {
+	client.Collection
+	Data []listedHost `json:"data,omitempty"`
+}
+
+// hostListCache serves host lookups from a listing of all the hosts, which is refreshed once it's
+// older than ttl. Lookups it can't serve fall back to the Rancher API, so that new hosts are found
+// right away.
+type hostListCache struct {
+	ttl  time.Duration
+	list func() ([]*Host, error)
+
+	// lock is held while refreshing so that concurrent lookups wait for a single listing
+	lock    sync.Mutex
+	byID    map[string]*Host
+	byName  map[string][]*Host
+	fetched time.Time
+}
+
+func newHostListCache(ttl time.Duration, list func() ([]*Host, error)) *hostListCache {
+	return &hostListCache{ttl: ttl, list: list}
+}
+
+// refresh lists the hosts again if the listing is stale. The caller must hold the lock.
+func (c *hostListCache) refresh() error {
+	if c.byID != nil && time.Since(c.fetched) < c.ttl {
+		return nil
+	}
+
+	hosts, err := c.list()
+	if err != nil {
+		hostCacheRefreshErrors.Inc()
+		c.byID, c.byName = nil, nil
+		return err
+	}
+	c.byID = map[string]*Host{}
+	c.byName = map[string][]*Host{}
+	for _, host := range hosts {
+		c.byID[host.RancherHost.Id] = host
+		name := strings.ToLower(host.RancherHost.Hostname)
+		c.byName[name] = append(c.byName[name], host)
+	}
+	c.fetched = time.Now()
+	return nil
+}
+
+// getByID returns the listed host with the given ID, if any
+func (c *hostListCache) getByID(id string) (*Host, bool) {
+	if c == nil || c.ttl <= 0 {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.refresh(); err != nil {
+		glog.Warningf("Couldn't refresh the host list cache, looking up host [%s] directly. Error: %v", id, err)
+		hostCacheLookups.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	host, ok := c.byID[id]
+	observeHostCacheLookup(ok)
+	return host, ok
+}
+
+// getByName returns the listed hosts with the given hostname, if any
+func (c *hostListCache) getByName(name string) ([]*Host, bool) {
+	if c == nil || c.ttl <= 0 {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.refresh(); err != nil {
+		glog.Warningf("Couldn't refresh the host list cache, looking up host [%s] directly. Error: %v", name, err)
+		hostCacheLookups.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	hosts, ok := c.byName[strings.ToLower(name)]
+	observeHostCacheLookup(ok)
+	return hosts, ok
+}
+
+// hostnamesByUUID returns the hostnames of the listed hosts keyed by their UUID, or false if
+// there's no listing to serve them from
+func (c *hostListCache) hostnamesByUUID() (map[string]string, bool) {
+	if c == nil || c.ttl <= 0 {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.refresh(); err != nil {
+		glog.Warningf("Couldn't refresh the host list cache, listing the hosts directly. Error: %v", err)
+		return nil, false
+	}
+	hostnames := make(map[string]string, len(c.byID))
+	for _, host := range c.byID {
+		hostnames[host.RancherHost.Uuid] = host.RancherHost.Hostname
+	}
+	return hostnames, true
+}
+
+// invalidate makes the next lookup list the hosts again
+func (c *hostListCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.byID, c.byName = nil, nil
+}
+
+// update replaces the listed host with the same ID with host, keeping its IP addresses, or forgets
+// it if host was removed. Hosts that weren't listed are left to the next listing.
+func (c *hostListCache) update(host *client.Host) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cached, ok := c.byID[host.Id]
+	if !ok {
+		return
+	}
+	name := strings.ToLower(cached.RancherHost.Hostname)
+	for i, named := range c.byName[name] {
+		if named == cached {
+			c.byName[name] = append(c.byName[name][:i:i], c.byName[name][i+1:]...)
+			break
+		}
+	}
+	if len(c.byName[name]) == 0 {
+		delete(c.byName, name)
+	}
+	delete(c.byID, host.Id)
+	if hostRemoved(host) {
+		return
+	}
+
+	updated := &Host{RancherHost: host, IPAddresses: cached.IPAddresses}
+	c.byID[host.Id] = updated
+	name = strings.ToLower(host.Hostname)
+	c.byName[name] = append(c.byName[name], updated)
+}
+
+func observeHostCacheLookup(hit bool) {
+	if hit {
+		hostCacheLookups.WithLabelValues("hit").Inc()
+	} else {
+		hostCacheLookups.WithLabelValues("miss").Inc()
+	}
+}
+
+// listHosts lists the hosts that aren't removed with their IP addresses
+func (r *CloudProvider) listHosts() ([]*Host, error) {
+	api := r.hostAPI()
+	listed, err := api.listHosts()
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't list hosts. Error: %#v", err)
+	}
+
+	hosts := []*Host{}
+	for i := range listed {
+		if hostRemoved(&listed[i].Host) {
+			continue
+		}
+		ips, err := api.hostIPAddresses(&listed[i])
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, &Host{RancherHost: &listed[i].Host, IPAddresses: ips})
+	}
+	return hosts, nil
+}
+
+// cachedHostByName returns the host with the given name from the host list cache. It returns nil
+// and no error if the lookup must go to the Rancher API.
+func (r *CloudProvider) cachedHostByName(name string) (*Host, error) {
+	hosts, ok := r.hosts.getByName(name)
+	if !ok {
+		return nil, nil
+	}
+	if len(hosts) > 1 {
+		candidates := []*client.Host{}
+		for _, host := range hosts {
+			candidates = append(candidates, host.RancherHost)
+		}
+		return nil, newAmbiguousHostError(name, candidates)
+	}
+	if len(hosts[0].IPAddresses) == 0 {
+		return nil, nil
+	}
+	return hosts[0], nil
+}
@@ -0,0 +1,303 @@
+package rancher
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+var (
+	// hostListPages is the number of host listing pages served, hostListPageLimit the number of
+	// hosts per page or 0 for a single page, and hostListErr is returned by listings if set
+	hostListPages     int
+	hostListPageLimit int
+	hostListErr       error
+	hostListLock      sync.Mutex
+)
+
+// List serves the pages of hostList with their IP addresses included
+func (f *fakeRancherBaseClient) List(schemaType string, opts *client.ListOpts, respObject interface{}) error {
+	hostListLock.Lock()
+	defer hostListLock.Unlock()
+	if schemaType != client.HOST_TYPE {
+		return fmt.Errorf("not implemented")
+	}
+	if hostListErr != nil {
+		return hostListErr
+	}
+	hostListPages++
+	return serveHostListPage(0, respObject.(*listedHostCollection))
+}
+
+// serveHostListPage fills page with the hosts of hostList from start on, hostListPageLimit at most
+func serveHostListPage(start int, page *listedHostCollection) error {
+	end := len(hostList.Data)
+	if hostListPageLimit > 0 && start+hostListPageLimit < end {
+		end = start + hostListPageLimit
+	}
+
+	for _, host := range hostList.Data[start:end] {
+		listed := listedHost{Host: host}
+		if ips, ok := ipAddressLinks[host.Id]; ok {
+			listed.IPAddresses = ips.Data
+		}
+		page.Data = append(page.Data, listed)
+	}
+	if end < len(hostList.Data) {
+		page.Pagination = &client.Pagination{Next: fmt.Sprintf("http://cattle/v1/hosts?limit=%d&marker=m%d", hostListPageLimit, end)}
+	}
+	return nil
+}
+
+// nextHostListPage serves the page of hostList a next link points to
+func nextHostListPage(next string, page *listedHostCollection) error {
+	hostListLock.Lock()
+	defer hostListLock.Unlock()
+	hostListPages++
+
+	marker := next[strings.LastIndex(next, "marker=m")+len("marker=m"):]
+	start, err := strconv.Atoi(marker)
+	if err != nil {
+		return err
+	}
+	return serveHostListPage(start, page)
+}
+
+// newCachingCloudProvider returns a cloud provider serving host lookups from a listing cached for ttl
+func newCachingCloudProvider(ttl time.Duration) *CloudProvider {
+	r := &CloudProvider{
+		client:           testClient,
+		conf:             &rConfig{},
+		hostCache:        cache.NewTTLStore(hostStoreKeyFunc, time.Duration(24)*time.Hour),
+		environmentCache: newEnvironmentCache(),
+	}
+	r.hosts = newHostListCache(ttl, r.listHosts)
+	return r
+}
+
+func TestHostListCache(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	defer func() { hostListPages, hostListPageLimit = 0, 0 }()
+
+	hostList = &client.HostCollection{}
+	for i := 1; i <= 5; i++ {
+		id := fmt.Sprintf("1h%d", i)
+		hostList.Data = append(hostList.Data, client.Host{Resource: client.Resource{Id: id}, Hostname: fmt.Sprintf("host%d", i), Uuid: "uuid-" + id})
+		ipAddressLinks[id] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: fmt.Sprintf("10.0.0.%d", i)}}}
+	}
+	hostListPages, hostListPageLimit = 0, 2
+	r := newCachingCloudProvider(time.Minute)
+
+	for i := 1; i <= 5; i++ {
+		name := types.NodeName(fmt.Sprintf("host%d", i))
+		providerID := fmt.Sprintf("rancher://1h%d", i)
+		if id, err := r.ExternalID(name); err != nil || id != fmt.Sprintf("uuid-1h%d", i) {
+			t.Errorf("unexpected external ID of %s: %s, err: %v", name, id, err)
+		}
+		if addresses, err := r.NodeAddresses(name); err != nil || len(addresses) == 0 {
+			t.Errorf("unexpected addresses of %s: %v, err: %v", name, addresses, err)
+		}
+		if addresses, err := r.NodeAddressesByProviderID(providerID); err != nil || len(addresses) == 0 {
+			t.Errorf("unexpected addresses of %s: %v, err: %v", providerID, addresses, err)
+		}
+		if _, err := r.InstanceTypeByProviderID(providerID); err != nil {
+			t.Errorf("unexpected error getting the type of %s: %v", providerID, err)
+		}
+		if exists, err := r.InstanceExistsByProviderID(providerID); err != nil || !exists {
+			t.Errorf("expected %s to exist, found %v, err: %v", providerID, exists, err)
+		}
+	}
+	if hostListPages != 3 {
+		t.Errorf("expected the hosts to be listed once in 3 pages, found %d pages", hostListPages)
+	}
+
+	// a new host isn't in the cached listing, but it's found by listing the hosts again by name or
+	// by getting it by ID
+	hostList.Data = append(hostList.Data, client.Host{Resource: client.Resource{Id: "1h6"}, Hostname: "host6"})
+	ipAddressLinks["1h6"] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "10.0.0.6"}}}
+	if _, err := r.NodeAddresses("host6"); err != nil {
+		t.Errorf("expected a new host to be found, err: %v", err)
+	}
+	if _, err := r.NodeAddressesByProviderID("rancher://1h6"); err != nil {
+		t.Errorf("expected a new host to be found by providerID, err: %v", err)
+	}
+	if hostListPages != 6 {
+		t.Errorf("expected the new host to be listed once by name, found %d pages", hostListPages)
+	}
+
+	// the hosts are listed again once invalidated
+	r.InvalidateHostCache("")
+	if _, err := r.NodeAddresses("host6"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if hostListPages != 9 {
+		t.Errorf("expected the hosts to be listed again, found %d pages", hostListPages)
+	}
+}
+
+func TestHostListCacheExpires(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	defer func() { hostListPages = 0 }()
+
+	hostList = &client.HostCollection{Data: []client.Host{{Resource: client.Resource{Id: "1h1"}, Hostname: "host1"}}}
+	ipAddressLinks["1h1"] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "10.0.0.1"}}}
+	hostListPages = 0
+	r := newCachingCloudProvider(time.Millisecond)
+
+	if _, err := r.NodeAddresses("host1"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	hostList.Data = nil
+	if _, err := r.NodeAddresses("host1"); err != cloudprovider.InstanceNotFound {
+		t.Errorf("expected a host gone once the listing expired not to be found, err: %v", err)
+	}
+	if hostListPages != 3 {
+		t.Errorf("expected the cached listing to be refreshed, then the host looked up by name, found %d listings", hostListPages)
+	}
+}
+
+func TestHostListCacheRefreshError(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	defer func() { hostListErr = nil }()
+
+	hostList = &client.HostCollection{Data: []client.Host{{Resource: client.Resource{Id: "1h1"}, Hostname: "host1"}}}
+	ipAddressLinks["1h1"] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "10.0.0.1"}}}
+	hostListErr = fmt.Errorf("429 Too Many Requests")
+	r := newCachingCloudProvider(time.Minute)
+
+	before := refreshErrorCount(t)
+	if _, err := r.NodeAddresses("host1"); err == nil {
+		t.Errorf("expected looking up a host by name to fail when the hosts can't be listed")
+	}
+	if _, err := r.NodeAddressesByProviderID("rancher://1h1"); err != nil {
+		t.Errorf("expected the host to be looked up directly by providerID when the hosts can't be listed, err: %v", err)
+	}
+	if errors := refreshErrorCount(t) - before; errors != 2 {
+		t.Errorf("expected 2 refresh errors, found %v", errors)
+	}
+}
+
+func TestHostListCacheConcurrentLookups(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	defer func() { hostListPages = 0 }()
+
+	hostList = &client.HostCollection{}
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("1h%d", i)
+		hostList.Data = append(hostList.Data, client.Host{Resource: client.Resource{Id: id}, Hostname: fmt.Sprintf("host%d", i)})
+		ipAddressLinks[id] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "10.0.0.1"}}}
+	}
+	hostListPages = 0
+	r := newCachingCloudProvider(time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := r.NodeAddresses(types.NodeName(fmt.Sprintf("host%d", i))); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if _, err := r.InstanceExistsByProviderID(fmt.Sprintf("rancher://1h%d", i)); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if hostListPages != 1 {
+		t.Errorf("expected concurrent lookups to share a single listing, found %d", hostListPages)
+	}
+}
+
+func TestHostnamesByUUID(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	defer func() { hostListPages = 0 }()
+
+	hostList = &client.HostCollection{Data: []client.Host{
+		{Resource: client.Resource{Id: "1h1"}, Hostname: "host1", Uuid: "uuid-1"},
+		{Resource: client.Resource{Id: "1h2"}, Hostname: "host2", Uuid: "uuid-2"},
+		{Resource: client.Resource{Id: "1h3"}, Hostname: "host3", Uuid: "uuid-3", Removed: "2017-01-01T00:00:00Z"},
+	}}
+	expected := map[string]string{"uuid-1": "host1", "uuid-2": "host2"}
+
+	for _, ttl := range []time.Duration{0, time.Minute} {
+		hostListPages = 0
+		r := newCachingCloudProvider(ttl)
+		for i := 0; i < 2; i++ {
+			hostnames, err := r.HostnamesByUUID()
+			if err != nil || !reflect.DeepEqual(hostnames, expected) {
+				t.Errorf("ttl %v: expected hostnames %v, found %v, err: %v", ttl, expected, hostnames, err)
+			}
+		}
+		listings := 2
+		if ttl > 0 {
+			listings = 1
+		}
+		if hostListPages != listings {
+			t.Errorf("ttl %v: expected %d listings, found %d", ttl, listings, hostListPages)
+		}
+	}
+}
+
+func refreshErrorCount(t *testing.T) float64 {
+	m := &dto.Metric{}
+	if err := hostCacheRefreshErrors.Write(m); err != nil {
+		t.Fatalf("Error reading metric: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestListHostsFollowsPages(t *testing.T) {
+	cattle := newFakeCattle()
+	defer cattle.Close()
+	cattle.pageSize = 100
+	for i := 0; i < 250; i++ {
+		cattle.hosts = append(cattle.hosts, listedHost{
+			Host:        client.Host{Resource: client.Resource{Id: fmt.Sprintf("1h%d", i)}, Hostname: fmt.Sprintf("host%d", i), Uuid: fmt.Sprintf("uuid%d", i)},
+			IPAddresses: []client.IpAddress{{Address: fmt.Sprintf("10.0.%d.%d", i/250, i%250)}},
+		})
+	}
+	r := &CloudProvider{
+		client:    cattle.client(t),
+		conf:      &rConfig{},
+		hostCache: cache.NewTTLStore(hostStoreKeyFunc, time.Duration(24)*time.Hour),
+	}
+
+	for _, i := range []int{0, 99, 100, 199, 200, 249} {
+		name := types.NodeName(fmt.Sprintf("host%d", i))
+		if addresses, err := r.NodeAddresses(name); err != nil || len(addresses) == 0 {
+			t.Errorf("expected addresses for %s, found %v, err: %v", name, addresses, err)
+		}
+		if id, err := r.ExternalID(name); err != nil || id != fmt.Sprintf("uuid%d", i) {
+			t.Errorf("expected the external ID of %s, found %s, err: %v", name, id, err)
+		}
+	}
+	if _, err := r.NodeAddresses("host250"); err != cloudprovider.InstanceNotFound {
+		t.Errorf("expected a host on no page not to be found, err: %v", err)
+	}
+
+	// the API keeps linking more pages
+	cattle.Lock()
+	cattle.pageSize = 1
+	cattle.Unlock()
+	if _, err := r.NodeAddresses("host150"); err == nil || !strings.Contains(err.Error(), "pages") {
+		t.Errorf("expected an error once the listing has too many pages, err: %v", err)
+	}
+}
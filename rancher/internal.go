@@ -0,0 +1,59 @@
+package rancher
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/rancher/go-rancher/client"
+
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+// annotationLBInternal makes the LB of a service reachable on the managed network only. Its ports
+// aren't published on the hosts, and the service resolves to the IP of the LB on the managed
+// network.
+const annotationLBInternal = "rancher.io/lb-internal"
+
+// serviceLBInternal tells whether service asks for an LB reachable on the managed network only
+func serviceLBInternal(service *api.Service) (bool, error) {
+	value, ok := service.Annotations[annotationLBInternal]
+	if !ok {
+		return false, nil
+	}
+	internal, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid value %q of annotation %s of service %s, must be true or false",
+			value, annotationLBInternal, serviceKey(service))
+	}
+	return internal, nil
+}
+
+// lbInternal tells whether lb exposes its ports on the managed network without publishing them
+func lbInternal(lb *client.LoadBalancerService) bool {
+	return lb.LaunchConfig != nil && len(lb.LaunchConfig.Ports) == 0 && len(lb.LaunchConfig.Expose) > 0
+}
+
+// lbForwardedPorts returns the ports lb forwards, published or only exposed
+func lbForwardedPorts(lb *client.LoadBalancerService) []string {
+	if lb.LaunchConfig == nil {
+		return nil
+	}
+	ports := append([]string{}, lb.LaunchConfig.Ports...)
+	return append(ports, lb.LaunchConfig.Expose...)
+}
+
+// waitForLBVip waits for Rancher to assign lb its IP on the managed network
+func (r *CloudProvider) waitForLBVip(lb *client.LoadBalancerService) <-chan interface{} {
+	cb := func(result chan<- interface{}) (bool, error) {
+		lb, err := r.reloadLBService(lb)
+		if err != nil {
+			return false, err
+		}
+		if lb.Vip != "" {
+			result <- lb
+			return true, nil
+		}
+		return false, nil
+	}
+	return r.waitForAction("vip", cb)
+}
@@ -0,0 +1,225 @@
+package rancher
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/rancher/go-rancher/client"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+const (
+	// annotationLBAlgorithm sets how the LB of a service balances connections between its hosts
+	annotationLBAlgorithm = "rancher.io/lb-algorithm"
+
+	// annotationLBStickyPolicy makes the LB of a service stick the clients to a host with a cookie,
+	// given as comma separated key=value pairs, e.g. cookie=SERVERID,mode=insert,domain=example.com
+	annotationLBStickyPolicy = "rancher.io/lb-sticky-policy"
+
+	defaultStickyMode = "insert"
+
+	// eventSourceRangesNotEnforced is the reason of the events of the services whose LB keeps
+	// accepting all clients although their source ranges restrict them
+	eventSourceRangesNotEnforced = "SourceRangesNotEnforced"
+)
+
+// clientIPAffinityDefaults are the haproxy defaults sticking the clients of services with ClientIP
+// session affinity to a host: their source address is hashed, consistently so that most clients
+// keep their host when hosts come and go. haproxy only allows stick tables in the frontends and
+// backends, which the LB config can't set, so the affinity doesn't expire.
+var clientIPAffinityDefaults = []string{"balance source", "hash-type consistent"}
+
+// lbAlgorithms are the haproxy balance algorithms allowed by annotationLBAlgorithm
+var lbAlgorithms = []string{"roundrobin", "leastconn", "source"}
+
+// stickyModes are the haproxy cookie modes allowed by annotationLBStickyPolicy
+var stickyModes = []string{"insert", "prefix", "rewrite"}
+
+// cookieName matches the names of cookies, the tokens of RFC 7230
+var cookieName = regexp.MustCompile("^[-!#$%&'*+.^_`|~0-9A-Za-z]+$")
+
+// serviceLBConfig returns the LB config the annotations and the session affinity of service ask
+// for, or nil if they ask for none. It fails for source ranges restricting the clients.
+func serviceLBConfig(service *api.Service) (*client.LoadBalancerConfig, error) {
+	algorithm, hasAlgorithm := service.Annotations[annotationLBAlgorithm]
+	policy, hasPolicy := service.Annotations[annotationLBStickyPolicy]
+	defaults := []string{}
+	if hasAlgorithm {
+		if !contains(lbAlgorithms, algorithm) {
+			return nil, fmt.Errorf("Invalid value %q of annotation %s of service %s, must be one of %s",
+				algorithm, annotationLBAlgorithm, serviceKey(service), strings.Join(lbAlgorithms, ", "))
+		}
+		defaults = append(defaults, "balance "+algorithm)
+	}
+	if service.Spec.SessionAffinity == api.ServiceAffinityClientIP {
+		// The annotations are explicit about the stickiness, they win
+		switch {
+		case hasPolicy:
+			glog.Warningf("Service %s has ClientIP session affinity and annotation %s, sticking clients with the cookie",
+				serviceKey(service), annotationLBStickyPolicy)
+		case hasAlgorithm:
+			glog.Warningf("Service %s has ClientIP session affinity and annotation %s, balancing with %s",
+				serviceKey(service), annotationLBAlgorithm, algorithm)
+		default:
+			defaults = append(defaults, clientIPAffinityDefaults...)
+		}
+	}
+	if err := checkSourceRanges(service); err != nil {
+		return nil, err
+	}
+	if len(defaults) == 0 && !hasPolicy {
+		return nil, nil
+	}
+
+	config := &client.LoadBalancerConfig{}
+	if len(defaults) > 0 {
+		config.HaproxyConfig = &client.HaproxyConfig{Defaults: strings.Join(defaults, "\n")}
+	}
+	if hasPolicy {
+		sticky, err := parseStickyPolicy(policy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value %q of annotation %s of service %s: %v",
+				policy, annotationLBStickyPolicy, serviceKey(service), err)
+		}
+		config.LbCookieStickinessPolicy = sticky
+	}
+	return config, nil
+}
+
+// checkSourceRanges returns an error if the source ranges of service restrict its clients. The
+// ranges would need an ACL and a tcp-request rule in the haproxy frontends, which the LB config
+// can't set: haproxy refuses both in the defaults section, the only one the config can set.
+func checkSourceRanges(service *api.Service) error {
+	restricted := false
+	for _, cidr := range service.Spec.LoadBalancerSourceRanges {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return fmt.Errorf("Invalid loadBalancerSourceRanges %q of service %s, must be CIDRs, e.g. 192.168.0.0/16",
+				cidr, serviceKey(service))
+		}
+		if ones, _ := ipNet.Mask.Size(); ones == 0 {
+			// an allow-all range makes the other ones moot
+			return nil
+		}
+		restricted = true
+	}
+	if restricted {
+		return &sourceRangesError{service: serviceKey(service)}
+	}
+	return nil
+}
+
+// sourceRangesError is returned for the source ranges restricting the clients of a service
+type sourceRangesError struct {
+	service string
+}
+
+func (e *sourceRangesError) Error() string {
+	return fmt.Sprintf("loadBalancerSourceRanges of service %s aren't supported by Rancher LBs, remove them or allow all clients with 0.0.0.0/0",
+		e.service)
+}
+
+// recordSourceRangesNotEnforced records a Warning event on service if err rejects its source ranges
+// while it has an LB: the LB isn't changed, so it keeps accepting all clients
+func (r *CloudProvider) recordSourceRangesNotEnforced(clusterName string, service *api.Service, err error) {
+	if _, ok := err.(*sourceRangesError); !ok || r.serviceRecorder == nil {
+		return
+	}
+	lb, lookupErr := r.getServiceLB(clusterName, service)
+	if lookupErr != nil || lb == nil {
+		return
+	}
+	r.serviceRecorder.Eventf(service, api.EventTypeWarning, eventSourceRangesNotEnforced,
+		"LB %s keeps accepting all clients: %v", lb.Name, err)
+}
+
+// parseStickyPolicy parses the cookie stickiness policy of annotationLBStickyPolicy
+func parseStickyPolicy(value string) (*client.LoadBalancerCookieStickinessPolicy, error) {
+	policy := &client.LoadBalancerCookieStickinessPolicy{Mode: defaultStickyMode}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q isn't a key=value pair", pair)
+		}
+		key, val := parts[0], parts[1]
+		switch key {
+		case "cookie":
+			if !cookieName.MatchString(val) {
+				return nil, fmt.Errorf("invalid cookie name %q", val)
+			}
+			policy.Cookie = val
+		case "mode":
+			if !contains(stickyModes, val) {
+				return nil, fmt.Errorf("mode must be one of %s", strings.Join(stickyModes, ", "))
+			}
+			policy.Mode = val
+		case "domain":
+			if errs := validation.IsDNS1123Subdomain(strings.TrimPrefix(val, ".")); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid domain %q: %s", val, strings.Join(errs, ", "))
+			}
+			policy.Domain = val
+		default:
+			return nil, fmt.Errorf("unknown key %q, keys are cookie, mode and domain", key)
+		}
+	}
+	if policy.Cookie == "" {
+		return nil, fmt.Errorf("the cookie name is required")
+	}
+	return policy, nil
+}
+
+// lbConfigChanged tells whether the config of lb differs from config
+func lbConfigChanged(lb *client.LoadBalancerService, config *client.LoadBalancerConfig) bool {
+	current := lb.LoadBalancerConfig
+	if current == nil {
+		current = &client.LoadBalancerConfig{}
+	}
+	if config == nil {
+		config = &client.LoadBalancerConfig{}
+	}
+	if haproxyDefaults(current) != haproxyDefaults(config) {
+		return true
+	}
+	a, b := current.LbCookieStickinessPolicy, config.LbCookieStickinessPolicy
+	if a == nil || b == nil {
+		return a != b
+	}
+	return a.Cookie != b.Cookie || a.Mode != b.Mode || a.Domain != b.Domain
+}
+
+func haproxyDefaults(config *client.LoadBalancerConfig) string {
+	if config == nil || config.HaproxyConfig == nil {
+		return ""
+	}
+	return config.HaproxyConfig.Defaults
+}
+
+// ensureLBConfig updates the config of lb in place if it differs from config
+func (r *CloudProvider) ensureLBConfig(lb *client.LoadBalancerService, config *client.LoadBalancerConfig) (*client.LoadBalancerService, error) {
+	if !lbConfigChanged(lb, config) {
+		return lb, nil
+	}
+	if config == nil {
+		config = &client.LoadBalancerConfig{}
+	}
+	glog.Infof("Updating the config of LB %s", lb.Name)
+	updated, err := r.updateLB(lb, map[string]interface{}{"loadBalancerConfig": config})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't update config of LB %s. Error: %#v", lb.Name, err)
+	}
+	return updated, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,269 @@
+package rancher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+func TestServiceLBConfig(t *testing.T) {
+	tests := []struct {
+		annotations  map[string]string
+		sourceRanges []string
+		affinity     api.ServiceAffinity
+		config       *client.LoadBalancerConfig
+		invalid      bool
+	}{
+		{annotations: nil},
+		{
+			annotations: map[string]string{annotationLBAlgorithm: "leastconn"},
+			config:      &client.LoadBalancerConfig{HaproxyConfig: &client.HaproxyConfig{Defaults: "balance leastconn"}},
+		},
+		{annotations: map[string]string{annotationLBAlgorithm: "random"}, invalid: true},
+		{annotations: map[string]string{annotationLBAlgorithm: ""}, invalid: true},
+		{
+			annotations: map[string]string{annotationLBStickyPolicy: "cookie=SERVERID"},
+			config: &client.LoadBalancerConfig{
+				LbCookieStickinessPolicy: &client.LoadBalancerCookieStickinessPolicy{Cookie: "SERVERID", Mode: "insert"},
+			},
+		},
+		{
+			annotations: map[string]string{
+				annotationLBAlgorithm:    "source",
+				annotationLBStickyPolicy: "cookie=SERVERID, mode=prefix, domain=.example.com",
+			},
+			config: &client.LoadBalancerConfig{
+				HaproxyConfig: &client.HaproxyConfig{Defaults: "balance source"},
+				LbCookieStickinessPolicy: &client.LoadBalancerCookieStickinessPolicy{
+					Cookie: "SERVERID", Mode: "prefix", Domain: ".example.com",
+				},
+			},
+		},
+		{annotations: map[string]string{annotationLBStickyPolicy: "mode=insert"}, invalid: true},
+		{annotations: map[string]string{annotationLBStickyPolicy: "cookie=a b"}, invalid: true},
+		{annotations: map[string]string{annotationLBStickyPolicy: "cookie=SERVERID,mode=append"}, invalid: true},
+		{annotations: map[string]string{annotationLBStickyPolicy: "cookie=SERVERID,domain=Example_com"}, invalid: true},
+		{annotations: map[string]string{annotationLBStickyPolicy: "cookie=SERVERID,path=/"}, invalid: true},
+		{annotations: map[string]string{annotationLBStickyPolicy: "SERVERID"}, invalid: true},
+		// haproxy can't restrict the clients from the defaults section, the only one the config sets
+		{sourceRanges: []string{"192.168.0.0/16"}, invalid: true},
+		{
+			annotations:  map[string]string{annotationLBAlgorithm: "leastconn"},
+			sourceRanges: []string{"10.1.2.3/8", " 2001:db8::/32"},
+			invalid:      true,
+		},
+		{
+			annotations:  map[string]string{annotationLBAlgorithm: "leastconn"},
+			sourceRanges: []string{"::/0"},
+			config:       &client.LoadBalancerConfig{HaproxyConfig: &client.HaproxyConfig{Defaults: "balance leastconn"}},
+		},
+		{
+			affinity: api.ServiceAffinityClientIP,
+			config: &client.LoadBalancerConfig{HaproxyConfig: &client.HaproxyConfig{
+				Defaults: "balance source\nhash-type consistent",
+			}},
+		},
+		{affinity: api.ServiceAffinityNone},
+		// the annotations win over the session affinity
+		{
+			annotations: map[string]string{annotationLBAlgorithm: "leastconn"},
+			affinity:    api.ServiceAffinityClientIP,
+			config:      &client.LoadBalancerConfig{HaproxyConfig: &client.HaproxyConfig{Defaults: "balance leastconn"}},
+		},
+		{
+			annotations: map[string]string{annotationLBStickyPolicy: "cookie=SERVERID"},
+			affinity:    api.ServiceAffinityClientIP,
+			config: &client.LoadBalancerConfig{
+				LbCookieStickinessPolicy: &client.LoadBalancerCookieStickinessPolicy{Cookie: "SERVERID", Mode: "insert"},
+			},
+		},
+		{sourceRanges: []string{"10.0.0.0/8", "0.0.0.0/0"}},
+		{sourceRanges: []string{}},
+		{sourceRanges: []string{"10.0.0.0"}, invalid: true},
+		{sourceRanges: []string{"10.0.0.0/33"}, invalid: true},
+	}
+	for _, test := range tests {
+		service := &api.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: test.annotations},
+			Spec:       api.ServiceSpec{LoadBalancerSourceRanges: test.sourceRanges, SessionAffinity: test.affinity},
+		}
+		config, err := serviceLBConfig(service)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%v %v: expected an error, found %#v", test.annotations, test.sourceRanges, config)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v %v: unexpected error: %v", test.annotations, test.sourceRanges, err)
+		} else if !reflect.DeepEqual(config, test.config) {
+			t.Errorf("%v %v %s: expected %#v, found %#v", test.annotations, test.sourceRanges, test.affinity, test.config, config)
+		}
+	}
+}
+
+func TestLBConfigUpdatedInPlace(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	service := newLBService(map[string]string{})
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}}
+	name := lbName("kubernetes", service)
+
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't ensure the LB: %v", err)
+	}
+	created, err := r.getLBByName(name)
+	if err != nil || created == nil {
+		t.Fatalf("expected LB %s to exist, found %#v, %v", name, created, err)
+	}
+	if created.LoadBalancerConfig != nil {
+		t.Errorf("expected the LB to have the default config, found %#v", created.LoadBalancerConfig)
+	}
+
+	service.Annotations[annotationLBAlgorithm] = "leastconn"
+	service.Annotations[annotationLBStickyPolicy] = "cookie=SERVERID,domain=example.com"
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't ensure the LB: %v", err)
+	}
+	lb, err := r.getLBByName(name)
+	if err != nil || lb == nil || lb.Id != created.Id {
+		t.Fatalf("expected LB %s to be updated in place, found %#v, %v", created.Id, lb, err)
+	}
+	if haproxyDefaults(lb.LoadBalancerConfig) != "balance leastconn" {
+		t.Errorf("expected the LB to balance by leastconn, found %#v", lb.LoadBalancerConfig.HaproxyConfig)
+	}
+	if sticky := lb.LoadBalancerConfig.LbCookieStickinessPolicy; sticky == nil || sticky.Cookie != "SERVERID" || sticky.Domain != "example.com" || sticky.Mode != "insert" {
+		t.Errorf("expected the LB to stick to the SERVERID cookie, found %#v", sticky)
+	}
+
+	delete(service.Annotations, annotationLBStickyPolicy)
+	service.Annotations[annotationLBAlgorithm] = "source"
+	if err := r.UpdateLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't update the LB: %v", err)
+	}
+	lb, err = r.getLBByName(name)
+	if err != nil || lb == nil || lb.Id != created.Id {
+		t.Fatalf("expected LB %s to be updated in place, found %#v, %v", created.Id, lb, err)
+	}
+	if haproxyDefaults(lb.LoadBalancerConfig) != "balance source" || lb.LoadBalancerConfig.LbCookieStickinessPolicy != nil {
+		t.Errorf("expected the LB to balance by source without stickiness, found %#v", lb.LoadBalancerConfig)
+	}
+
+	// invalid annotations fail the sync, leaving the LB alone
+	mutations := cattle.mutationCount()
+	service.Annotations[annotationLBAlgorithm] = "random"
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err == nil {
+		t.Errorf("expected an invalid algorithm to fail")
+	}
+	if err := r.UpdateLoadBalancer("kubernetes", service, nodes); err == nil {
+		t.Errorf("expected an invalid algorithm to fail")
+	}
+	if cattle.mutationCount() != mutations {
+		t.Errorf("expected the LB to be left alone, found %d changes", cattle.mutationCount()-mutations)
+	}
+}
+
+func TestLBSessionAffinity(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	service := newLBService(nil)
+	service.Spec.SessionAffinity = api.ServiceAffinityClientIP
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}}
+	name := lbName("kubernetes", service)
+
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't ensure the LB: %v", err)
+	}
+	lb, err := r.getLBByName(name)
+	if err != nil || lb == nil {
+		t.Fatalf("expected LB %s to exist, found %#v, %v", name, lb, err)
+	}
+	if defaults := haproxyDefaults(lb.LoadBalancerConfig); defaults != "balance source\nhash-type consistent" {
+		t.Errorf("expected the LB to stick clients by source address, found %q", defaults)
+	}
+
+	// switched back to None
+	service.Spec.SessionAffinity = api.ServiceAffinityNone
+	if err := r.UpdateLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't update the LB: %v", err)
+	}
+	updated, err := r.getLBByName(name)
+	if err != nil || updated == nil || updated.Id != lb.Id {
+		t.Fatalf("expected LB %s to be updated in place, found %#v, %v", lb.Id, updated, err)
+	}
+	if defaults := haproxyDefaults(updated.LoadBalancerConfig); defaults != "" {
+		t.Errorf("expected the stickiness to be removed, found %q", defaults)
+	}
+}
+
+func TestLBSourceRanges(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	service := newLBService(nil)
+	service.Spec.LoadBalancerSourceRanges = []string{"203.0.113.0/24", "198.51.100.0/24"}
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}}
+	name := lbName("kubernetes", service)
+	recorder := record.NewFakeRecorder(10)
+	r.SetServiceRecorder(recorder)
+
+	// restricting ranges fail the sync, rather than creating an LB open to all clients
+	_, err := r.EnsureLoadBalancer("kubernetes", service, nodes)
+	if err == nil || !strings.Contains(err.Error(), "loadBalancerSourceRanges") {
+		t.Errorf("expected restricting source ranges to fail, found %v", err)
+	}
+	if cattle.mutationCount() != 0 {
+		t.Errorf("expected no LB to be created, found %d changes", cattle.mutationCount())
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("expected no event without an LB, found %q", <-recorder.Events)
+	}
+
+	// so does an invalid range
+	service.Spec.LoadBalancerSourceRanges = []string{"203.0.113.0/24", "office"}
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err == nil {
+		t.Errorf("expected an invalid range to fail")
+	}
+
+	// ranges allowing all clients restrict nothing
+	service.Spec.LoadBalancerSourceRanges = []string{"203.0.113.0/24", "0.0.0.0/0"}
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't ensure the LB: %v", err)
+	}
+	created, err := r.getLBByName(name)
+	if err != nil || created == nil {
+		t.Fatalf("expected LB %s to exist, found %#v, %v", name, created, err)
+	}
+	if defaults := haproxyDefaults(created.LoadBalancerConfig); defaults != "" {
+		t.Errorf("expected no defaults, found %q", defaults)
+	}
+
+	// restricting the ranges of an existing LB fails its sync, leaving it alone and warning that it
+	// still accepts all clients
+	mutations := cattle.mutationCount()
+	service.Spec.LoadBalancerSourceRanges = []string{"203.0.113.0/24"}
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err == nil {
+		t.Errorf("expected restricting source ranges to fail")
+	}
+	if err := r.UpdateLoadBalancer("kubernetes", service, nodes); err == nil {
+		t.Errorf("expected restricting source ranges to fail")
+	}
+	if cattle.mutationCount() != mutations {
+		t.Errorf("expected the LB to be left alone, found %d changes", cattle.mutationCount()-mutations)
+	}
+	for i := 0; i < 2; i++ {
+		if len(recorder.Events) == 0 {
+			t.Fatalf("expected a warning event for each failed sync")
+		}
+		if event := <-recorder.Events; !strings.HasPrefix(event, "Warning "+eventSourceRangesNotEnforced) || !strings.Contains(event, created.Name) {
+			t.Errorf("expected a warning that LB %s accepts all clients, found %q", created.Name, event)
+		}
+	}
+}
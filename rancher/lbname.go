@@ -0,0 +1,70 @@
+package rancher
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/rancher/go-rancher/client"
+
+	api "k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// maxLBNameLength is the longest name of a Rancher service
+const maxLBNameLength = 63
+
+// lbName returns the name of the LB of service in the cluster named clusterName, so clusters
+// sharing a Rancher environment don't share LBs. Names too long for Rancher are truncated and
+// suffixed with a hash of the full name.
+func lbName(clusterName string, service *api.Service) string {
+	name := formatLBName(lbClusterID(clusterName) + "-" + cloudprovider.GetLoadBalancerName(service))
+	if len(name) <= maxLBNameLength {
+		return name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return fmt.Sprintf("%s-%08x", name[:maxLBNameLength-9], h.Sum32())
+}
+
+// lbClusterID returns the cluster named clusterName as it appears in the names and the metadata
+// of LBs. Unlike the name of the stack, it isn't truncated, so long names tell clusters apart.
+func lbClusterID(clusterName string) string {
+	cluster := strings.Trim(allowedChars.ReplaceAllString(clusterName, "-"), "-")
+	cluster = dupeHyphen.ReplaceAllString(cluster, "-")
+	if cluster == "" {
+		return defaultClusterName
+	}
+	return cluster
+}
+
+// legacyLBName returns the name of the LB of service created before the names included the
+// cluster
+func legacyLBName(service *api.Service) string {
+	return formatLBName(cloudprovider.GetLoadBalancerName(service))
+}
+
+// getServiceLB returns the LB of service in the cluster named clusterName, or nil if there is
+// none. The LBs created before the names included the cluster keep their name, they are found if
+// they are in the stack of the cluster and not marked as created by another cluster.
+func (r *CloudProvider) getServiceLB(clusterName string, service *api.Service) (*client.LoadBalancerService, error) {
+	lb, err := r.getLBByName(lbName(clusterName, service))
+	if err != nil || lb != nil {
+		return lb, err
+	}
+	lb, err = r.getLBByName(legacyLBName(service))
+	if err != nil || lb == nil {
+		return nil, err
+	}
+	if metadataString(lb, lbClusterMetadata) != "" && lbOwnerUID(lb, clusterName) == "" {
+		return nil, nil
+	}
+	env, err := r.getEnvironment(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	if env == nil || env.Id != lb.EnvironmentId {
+		return nil, nil
+	}
+	return lb, nil
+}
@@ -0,0 +1,107 @@
+package rancher
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+func TestLBName(t *testing.T) {
+	service := newLBService(nil)
+	long := strings.Repeat("production-", 6)
+	tests := []struct {
+		cluster string
+		name    string
+	}{
+		{cluster: "", name: "lb-kubernetes-a8f4a6c2e7d4b11e7bb31be2e44b06b3"},
+		{cluster: "kubernetes", name: "lb-kubernetes-a8f4a6c2e7d4b11e7bb31be2e44b06b3"},
+		{cluster: "East_1", name: "lb-East-1-a8f4a6c2e7d4b11e7bb31be2e44b06b3"},
+	}
+	for _, test := range tests {
+		if name := lbName(test.cluster, service); name != test.name {
+			t.Errorf("%q: expected %s, found %s", test.cluster, test.name, name)
+		}
+	}
+
+	name := lbName(long, service)
+	if len(name) != maxLBNameLength || name != lbName(long, service) {
+		t.Errorf("expected a long name to be truncated to %d characters deterministically, found %s", maxLBNameLength, name)
+	}
+	if other := lbName(long+"2", service); other == name {
+		t.Errorf("expected long names of different clusters to differ, found %s for both", name)
+	}
+}
+
+func TestClustersShareEnvironment(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}}
+
+	// identical services of two clusters
+	for _, cluster := range []string{"east", "west"} {
+		if _, err := r.EnsureLoadBalancer(cluster, newLBService(nil), nodes); err != nil {
+			t.Fatalf("%s: couldn't ensure the LB: %v", cluster, err)
+		}
+	}
+	if count := cattle.count("loadbalancerservices"); count != 2 {
+		t.Fatalf("expected an LB per cluster, found %d", count)
+	}
+	east, err := r.getLBByName(lbName("east", newLBService(nil)))
+	if err != nil || east == nil {
+		t.Fatalf("expected the LB of east to exist, found %#v, %v", east, err)
+	}
+	west, err := r.getLBByName(lbName("west", newLBService(nil)))
+	if err != nil || west == nil || west.Id == east.Id || west.EnvironmentId == east.EnvironmentId {
+		t.Fatalf("expected the LB of west in its own stack, found %#v, %v", west, err)
+	}
+
+	if err := r.EnsureLoadBalancerDeleted("east", newLBService(nil)); err != nil {
+		t.Fatalf("Couldn't delete the LB of east: %v", err)
+	}
+	if _, exists, err := r.GetLoadBalancer("west", newLBService(nil)); err != nil || !exists {
+		t.Errorf("expected the LB of west to be left alone, found %v, %v", exists, err)
+	}
+}
+
+func TestLegacyLBName(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	service := newLBService(nil)
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}}
+
+	if _, err := r.EnsureLoadBalancer("east", service, nodes); err != nil {
+		t.Fatalf("Couldn't ensure the LB: %v", err)
+	}
+	// the LB was created before the names included the cluster
+	lb, err := r.getLBByName(lbName("east", service))
+	if err != nil || lb == nil {
+		t.Fatalf("expected the LB to exist, found %#v, %v", lb, err)
+	}
+	cattle.Lock()
+	cattle.resources["loadbalancerservices"][lb.Id]["name"] = legacyLBName(service)
+	cattle.resources["loadbalancerservices"][lb.Id]["metadata"] = map[string]interface{}{}
+	cattle.Unlock()
+
+	if _, exists, err := r.GetLoadBalancer("east", service); err != nil || !exists {
+		t.Errorf("expected the LB to be found under its former name, found %v, %v", exists, err)
+	}
+	if _, exists, err := r.GetLoadBalancer("west", service); err != nil || exists {
+		t.Errorf("expected the LB in the stack of east not to be found by west, found %v, %v", exists, err)
+	}
+	if _, err := r.EnsureLoadBalancer("east", service, nodes); err != nil {
+		t.Fatalf("Couldn't ensure the LB again: %v", err)
+	}
+	if err := r.UpdateLoadBalancer("east", service, nodes); err != nil {
+		t.Fatalf("Couldn't update the LB: %v", err)
+	}
+	if count := cattle.count("loadbalancerservices"); count != 1 {
+		t.Errorf("expected the LB to keep its former name, found %d LBs", count)
+	}
+	if err := r.EnsureLoadBalancerDeleted("east", service); err != nil || cattle.count("loadbalancerservices") != 0 {
+		t.Errorf("expected the LB to be deleted under its former name, found %d LBs, %v", cattle.count("loadbalancerservices"), err)
+	}
+}
@@ -0,0 +1,65 @@
+package rancher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+const (
+	// masterNodeLabel marks the control-plane nodes, they never receive LB traffic
+	masterNodeLabel = "node-role.kubernetes.io/master"
+	// defaultExcludeLBNodeLabel is the default exclude-lb-node-label
+	defaultExcludeLBNodeLabel = "node.kubernetes.io/exclude-from-external-load-balancers"
+)
+
+// lbNodeExclusion returns why node doesn't receive LB traffic, or "" if it does
+func (r *CloudProvider) lbNodeExclusion(node *api.Node) string {
+	if _, ok := node.Labels[masterNodeLabel]; ok {
+		return "master"
+	}
+	if node.Spec.Unschedulable {
+		return "unschedulable"
+	}
+	if label := r.conf.Global.ExcludeLBNodeLabel; label != "" {
+		if _, ok := node.Labels[label]; ok {
+			return "labeled " + label
+		}
+	}
+	return ""
+}
+
+// lbNodes returns the nodes service is balanced to: nodes without the master role, schedulable,
+// and without the exclude-lb-node-label. Ensure and Update both filter the nodes they are given
+// with it, so that the hosts of LBs don't flap. It fails if every node is excluded, rather than
+// leaving the LB without backends.
+func (r *CloudProvider) lbNodes(service *api.Service, nodes []*api.Node) ([]*api.Node, error) {
+	included := []*api.Node{}
+	excluded := map[string][]string{}
+	for _, node := range nodes {
+		if reason := r.lbNodeExclusion(node); reason != "" {
+			excluded[reason] = append(excluded[reason], node.Name)
+			continue
+		}
+		included = append(included, node)
+	}
+	if len(excluded) == 0 {
+		return included, nil
+	}
+
+	reasons := []string{}
+	for reason, names := range excluded {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", reason, strings.Join(names, ", ")))
+	}
+	sort.Strings(reasons)
+	if len(included) == 0 {
+		return nil, fmt.Errorf("No nodes to balance service %s to, all %d nodes are excluded (%s)",
+			serviceKey(service), len(nodes), strings.Join(reasons, "; "))
+	}
+	glog.V(4).Infof("Excluding nodes from the LB of service %s (%s)", serviceKey(service), strings.Join(reasons, "; "))
+	return included, nil
+}
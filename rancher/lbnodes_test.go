@@ -0,0 +1,92 @@
+package rancher
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+func TestLBNodesExcluded(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		node   *api.Node
+	}{
+		{
+			name: "master",
+			node: &api.Node{ObjectMeta: metav1.ObjectMeta{Name: "host2", Labels: map[string]string{masterNodeLabel: ""}}},
+		},
+		{
+			name: "unschedulable",
+			node: &api.Node{ObjectMeta: metav1.ObjectMeta{Name: "host2"}, Spec: api.NodeSpec{Unschedulable: true}},
+		},
+		{
+			name: "default label",
+			node: &api.Node{ObjectMeta: metav1.ObjectMeta{Name: "host2", Labels: map[string]string{defaultExcludeLBNodeLabel: "true"}}},
+		},
+		{
+			name:   "configured label",
+			config: "exclude-lb-node-label = example.com/storage\n",
+			node:   &api.Node{ObjectMeta: metav1.ObjectMeta{Name: "host2", Labels: map[string]string{"example.com/storage": ""}}},
+		},
+	}
+
+	for _, test := range tests {
+		cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1", "host2": "10.0.0.2"})
+		cloud, err := newRancherCloud(strings.NewReader("[Global]\ncattle-url = " + cattle.URL + "/v2-beta\n" + test.config))
+		if err != nil {
+			t.Fatalf("%s: couldn't create the cloud provider: %v", test.name, err)
+		}
+		r := cloud.(*CloudProvider)
+		service := newLBService(nil)
+		nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}, test.node}
+
+		if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err != nil {
+			t.Errorf("%s: couldn't ensure the LB: %v", test.name, err)
+			cattle.Close()
+			continue
+		}
+		lb, err := r.getLBByName(lbName("kubernetes", service))
+		if err != nil || lb == nil {
+			t.Errorf("%s: expected the LB to exist, found %#v, %v", test.name, lb, err)
+			cattle.Close()
+			continue
+		}
+		if links, err := r.lbServiceLinks(lb); err != nil || len(links) != 1 || cattle.count("externalservices") != 1 {
+			t.Errorf("%s: expected the LB to link host1 only, found %v, %v", test.name, links, err)
+		}
+		// the update excludes the node too, so the LB is left alone
+		mutations := cattle.mutationCount()
+		if err := r.UpdateLoadBalancer("kubernetes", service, nodes); err != nil {
+			t.Errorf("%s: couldn't update the LB: %v", test.name, err)
+		}
+		if found := cattle.mutationCount() - mutations; found != 0 {
+			t.Errorf("%s: expected the update to change nothing, found %d changes", test.name, found)
+		}
+		cattle.Close()
+	}
+}
+
+func TestLBNodesAllExcluded(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1", "host2": "10.0.0.2"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	service := newLBService(nil)
+	nodes := []*api.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "host1", Labels: map[string]string{masterNodeLabel: ""}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "host2"}, Spec: api.NodeSpec{Unschedulable: true}},
+	}
+
+	_, err := r.EnsureLoadBalancer("kubernetes", service, nodes)
+	if err == nil || !strings.Contains(err.Error(), "master: host1") || !strings.Contains(err.Error(), "unschedulable: host2") {
+		t.Errorf("expected the LB not to be ensured without nodes, explaining why, found %v", err)
+	}
+	if count := cattle.count("loadbalancerservices"); count != 0 {
+		t.Errorf("expected no LB to be created, found %d", count)
+	}
+	if err := r.UpdateLoadBalancer("kubernetes", service, nodes); err == nil || !strings.Contains(err.Error(), "excluded") {
+		t.Errorf("expected the LB not to be updated without nodes, found %v", err)
+	}
+}
@@ -0,0 +1,721 @@
+package rancher
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+// fakeLBCattle is a Rancher API server keeping the LBs, external services and stacks it's asked
+// to create, and applying the updates and upgrades it's asked for. New LBs are inactive until
+// activated, after which they publish lbEndpoint, or get lbVip if internal. LBs scheduled to a
+// host publish its IP instead. Requests changing resources are counted in mutations, reads of the
+// links of services in linkReads.
+type fakeLBCattle struct {
+	*httptest.Server
+
+	sync.Mutex
+	resources map[string]map[string]map[string]interface{}
+	links     map[string][]string
+	nextID    int
+	mutations int
+	linkReads int
+	// scheduledHost is the hostname of the host the LBs not pinned to a host are scheduled to,
+	// if any
+	scheduledHost string
+}
+
+// lbEndpoint is the public IP of the LBs of fakeLBCattle, lbVip their IP on the managed network
+// if they are assigned one
+const (
+	lbEndpoint = "203.0.113.10"
+	lbVip      = "10.43.0.10"
+)
+
+// fakeLBCollections maps the collections of fakeLBCattle to the type of their resources
+var fakeLBCollections = map[string]string{
+	"loadbalancerservices": client.LOAD_BALANCER_SERVICE_TYPE,
+	"externalservices":     client.EXTERNAL_SERVICE_TYPE,
+	"environments":         client.ENVIRONMENT_TYPE,
+	"certificates":         client.CERTIFICATE_TYPE,
+	"hosts":                client.HOST_TYPE,
+	"projects":             client.PROJECT_TYPE,
+}
+
+func newFakeLBCattle(hosts map[string]string) *fakeLBCattle {
+	f := &fakeLBCattle{
+		resources: map[string]map[string]map[string]interface{}{},
+		links:     map[string][]string{},
+	}
+	for collection := range fakeLBCollections {
+		f.resources[collection] = map[string]map[string]interface{}{}
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	for name, ip := range hosts {
+		host := f.add("hosts", map[string]interface{}{"hostname": name, "state": "active"})
+		host["ipAddresses"] = []map[string]interface{}{{"address": ip}}
+	}
+	return f
+}
+
+// add stores a resource in collection, giving it an ID and links
+func (f *fakeLBCattle) add(collection string, resource map[string]interface{}) map[string]interface{} {
+	f.nextID++
+	id := fmt.Sprintf("1s%d", f.nextID)
+	self := f.URL + "/v2-beta/" + collection + "/" + id
+	resource["id"] = id
+	resource["type"] = fakeLBCollections[collection]
+	resource["links"] = map[string]string{
+		"self":               self,
+		"consumedservices":   self + "/consumedservices",
+		"consumedbyservices": self + "/consumedbyservices",
+	}
+	f.setActions(resource)
+	f.resources[collection][id] = resource
+	return resource
+}
+
+// setActions sets the actions of resource available in its state
+func (f *fakeLBCattle) setActions(resource map[string]interface{}) {
+	self := resource["links"].(map[string]string)["self"]
+	actions := map[string]string{
+		"setservicelinks":   self + "/?action=setservicelinks",
+		"addservicelink":    self + "/?action=addservicelink",
+		"removeservicelink": self + "/?action=removeservicelink",
+		"upgrade":           self + "/?action=upgrade",
+		"finishupgrade":     self + "/?action=finishupgrade",
+	}
+	if resource["state"] == "active" {
+		actions["deactivate"] = self + "/?action=deactivate"
+	} else {
+		actions["activate"] = self + "/?action=activate"
+	}
+	resource["actions"] = actions
+}
+
+// services returns the LBs and external services
+func (f *fakeLBCattle) services() map[string]map[string]interface{} {
+	services := map[string]map[string]interface{}{}
+	for _, collection := range []string{"loadbalancerservices", "externalservices"} {
+		for id, resource := range f.resources[collection] {
+			services[id] = resource
+		}
+	}
+	return services
+}
+
+func (f *fakeLBCattle) count(collection string) int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.resources[collection])
+}
+
+func (f *fakeLBCattle) mutationCount() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.mutations
+}
+
+func (f *fakeLBCattle) linkReadCount() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.linkReads
+}
+
+func (f *fakeLBCattle) serve(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if req.Method != "GET" {
+		f.mutations++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/v2-beta"), "/"), "/")
+	switch {
+	case req.URL.Path == "/v2-beta":
+		w.Header().Set("X-API-Schemas", f.URL+"/v2-beta/schemas")
+		w.Write([]byte("{}"))
+	case parts[0] == "schemas":
+		schemas := client.Schemas{}
+		for collection, schemaType := range fakeLBCollections {
+			schemas.Data = append(schemas.Data, fakeLBSchema(schemaType, f.URL+"/v2-beta/"+collection))
+		}
+		schemas.Data = append(schemas.Data, fakeLBSchema(client.SERVICE_TYPE, f.URL+"/v2-beta/services"))
+		json.NewEncoder(w).Encode(schemas)
+	case len(parts) == 1:
+		resources := f.resources[parts[0]]
+		if parts[0] == "services" {
+			resources = f.services()
+		}
+		if resources == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if req.Method == "POST" {
+			resource := map[string]interface{}{}
+			json.NewDecoder(req.Body).Decode(&resource)
+			resource["state"] = "active"
+			if parts[0] == "loadbalancerservices" {
+				resource["state"] = "inactive"
+			}
+			json.NewEncoder(w).Encode(f.add(parts[0], resource))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": fakeLBFilter(resources, req)})
+	case len(parts) == 2:
+		resource, ok := f.services()[parts[1]]
+		if !ok {
+			resource, ok = f.resources[parts[0]][parts[1]]
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch req.Method {
+		case "DELETE":
+			delete(f.resources[parts[0]], parts[1])
+			delete(f.links, parts[1])
+		case "PUT":
+			json.NewDecoder(req.Body).Decode(&resource)
+			if resource["type"] == client.LOAD_BALANCER_SERVICE_TYPE && resource["state"] == "active" {
+				f.publish(resource)
+			}
+		case "POST":
+			f.act(resource, req)
+		}
+		json.NewEncoder(w).Encode(resource)
+	case len(parts) == 3 && parts[2] == "consumedservices":
+		f.linkReads++
+		services := f.services()
+		consumed := []map[string]interface{}{}
+		for _, id := range f.links[parts[1]] {
+			if service, ok := services[id]; ok {
+				consumed = append(consumed, service)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": consumed})
+	case len(parts) == 3 && parts[2] == "consumedbyservices":
+		consumers := []map[string]interface{}{}
+		for lbID, ids := range f.links {
+			for _, id := range ids {
+				if id == parts[1] {
+					consumers = append(consumers, f.resources["loadbalancerservices"][lbID])
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": consumers})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// act runs the action of the request on resource
+func (f *fakeLBCattle) act(resource map[string]interface{}, req *http.Request) {
+	switch req.URL.Query().Get("action") {
+	case "activate":
+		resource["state"] = "active"
+		resource["healthState"] = "healthy"
+		f.publish(resource)
+		if resource["assignServiceIpAddress"] == true {
+			resource["vip"] = lbVip
+		}
+	case "setservicelinks":
+		input := &struct {
+			ServiceLinks []client.LoadBalancerServiceLink
+		}{}
+		json.NewDecoder(req.Body).Decode(input)
+		ids := []string{}
+		for _, link := range input.ServiceLinks {
+			ids = append(ids, link.ServiceId)
+		}
+		f.links[resource["id"].(string)] = ids
+	case "addservicelink", "removeservicelink":
+		input := &client.AddRemoveLoadBalancerServiceLinkInput{}
+		json.NewDecoder(req.Body).Decode(input)
+		id := resource["id"].(string)
+		ids := []string{}
+		for _, linked := range f.links[id] {
+			if linked != input.ServiceLink.ServiceId {
+				ids = append(ids, linked)
+			}
+		}
+		if req.URL.Query().Get("action") == "addservicelink" {
+			ids = append(ids, input.ServiceLink.ServiceId)
+		}
+		f.links[id] = ids
+	case "upgrade":
+		input := &struct {
+			InServiceStrategy struct {
+				LaunchConfig map[string]interface{}
+			}
+		}{}
+		json.NewDecoder(req.Body).Decode(input)
+		resource["launchConfig"] = input.InServiceStrategy.LaunchConfig
+		if resource["state"] == "active" {
+			f.publish(resource)
+		}
+	}
+	f.setActions(resource)
+}
+
+// publish sets the public endpoints of an LB publishing its ports, on the hosts its containers
+// are scheduled to
+func (f *fakeLBCattle) publish(resource map[string]interface{}) {
+	launchConfig, ok := resource["launchConfig"].(map[string]interface{})
+	if !ok || launchConfig["ports"] == nil {
+		return
+	}
+	endpoints := []map[string]interface{}{}
+	for _, id := range f.scheduledHosts(resource, launchConfig) {
+		ip := f.resources["hosts"][id]["ipAddresses"].([]map[string]interface{})[0]["address"]
+		endpoints = append(endpoints, map[string]interface{}{"ipAddress": ip, "port": 80, "hostId": id})
+	}
+	if len(endpoints) == 0 {
+		endpoints = append(endpoints, map[string]interface{}{"ipAddress": lbEndpoint, "port": 80})
+	}
+	resource["publicEndpoints"] = endpoints
+}
+
+// scheduledHosts returns the IDs of the hosts the containers of an LB run on: its requested host,
+// or the hosts with its host label sorted by hostname, up to its scale unless it's global. An LB
+// with a single container runs on scheduledHost unless it has a host label.
+func (f *fakeLBCattle) scheduledHosts(resource, launchConfig map[string]interface{}) []string {
+	if id, _ := launchConfig["requestedHostId"].(string); id != "" {
+		return []string{id}
+	}
+	labels, _ := launchConfig["labels"].(map[string]interface{})
+	hostLabel, _ := labels[lbHostAffinityLabel].(string)
+	hostnames := []string{}
+	ids := map[string]string{}
+	for id, host := range f.resources["hosts"] {
+		hostLabels, _ := host["labels"].(map[string]interface{})
+		if parts := strings.SplitN(hostLabel, "=", 2); hostLabel != "" && hostLabels[parts[0]] != parts[1] {
+			continue
+		}
+		hostname := host["hostname"].(string)
+		hostnames = append(hostnames, hostname)
+		ids[hostname] = id
+	}
+	sort.Strings(hostnames)
+
+	scale := 1
+	if value, ok := resource["scale"].(float64); ok {
+		scale = int(value)
+	}
+	switch {
+	case labels[lbGlobalLabel] == "true":
+	case scale > 1 || hostLabel != "":
+		if len(hostnames) > scale {
+			hostnames = hostnames[:scale]
+		}
+	default:
+		hostnames = []string{f.scheduledHost}
+	}
+	scheduled := []string{}
+	for _, hostname := range hostnames {
+		if id, ok := ids[hostname]; ok {
+			scheduled = append(scheduled, id)
+		}
+	}
+	return scheduled
+}
+
+func fakeLBSchema(schemaType, collection string) client.Schema {
+	return client.Schema{
+		Resource: client.Resource{
+			Id:    schemaType,
+			Links: map[string]string{"collection": collection},
+		},
+		CollectionMethods: []string{"GET", "POST"},
+		ResourceMethods:   []string{"GET", "PUT", "DELETE"},
+	}
+}
+
+// fakeLBFilter returns the resources matching the filters of req
+func fakeLBFilter(resources map[string]map[string]interface{}, req *http.Request) []map[string]interface{} {
+	fields := map[string]string{"name": "name", "environmentId": "environmentId", "external_id": "externalId"}
+	matching := []map[string]interface{}{}
+	for _, resource := range resources {
+		matches := true
+		for filter, field := range fields {
+			if value := req.URL.Query().Get(filter); value != "" && fmt.Sprint(resource[field]) != value {
+				matches = false
+			}
+		}
+		if matches {
+			matching = append(matching, resource)
+		}
+	}
+	return matching
+}
+
+// newFakeLBCloud returns a provider using the API of cattle
+func newFakeLBCloud(t *testing.T, cattle *fakeLBCattle) *CloudProvider {
+	cloud, err := newRancherCloud(strings.NewReader("[Global]\ncattle-url = " + cattle.URL + "/v2-beta\n"))
+	if err != nil {
+		t.Fatalf("Couldn't create the cloud provider: %v", err)
+	}
+	return cloud.(*CloudProvider)
+}
+
+func newLBService(annotations map[string]string) *api.Service {
+	return &api.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			UID:         types.UID("8f4a6c2e-7d4b-11e7-bb31-be2e44b06b34"),
+			Annotations: annotations,
+		},
+		Spec: api.ServiceSpec{
+			Type:            api.ServiceTypeLoadBalancer,
+			Ports:           []api.ServicePort{{Port: 80, NodePort: 30080, Protocol: api.ProtocolTCP}},
+			SessionAffinity: api.ServiceAffinityNone,
+		},
+	}
+}
+
+func TestLoadBalancerLifecycle(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1", "host2": "10.0.0.2"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	service := newLBService(nil)
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}, {ObjectMeta: metav1.ObjectMeta{Name: "host2"}}}
+	name := lbName("prod", service)
+
+	status, err := r.EnsureLoadBalancer("prod", service, nodes)
+	if err != nil {
+		t.Fatalf("Couldn't ensure the LB: %v", err)
+	}
+	if len(status.Ingress) != 1 || status.Ingress[0].IP != lbEndpoint {
+		t.Errorf("expected the ingress %s, found %#v", lbEndpoint, status.Ingress)
+	}
+	lb, err := r.getLBByName(name)
+	if err != nil || lb == nil {
+		t.Fatalf("expected LB %s to exist, found %#v, %v", name, lb, err)
+	}
+	env, err := r.client.Environment.ById(lb.EnvironmentId)
+	if err != nil || env == nil || env.Name != "kubernetes-loadbalancers-prod" || env.ExternalId != "kubernetes-loadbalancers://prod" {
+		t.Errorf("expected the LB in the stack of the cluster, found %#v, %v", env, err)
+	}
+	if len(lb.LaunchConfig.Ports) != 1 || lb.LaunchConfig.Ports[0] != "80:30080/tcp" {
+		t.Errorf("expected the LB to forward port 80 to the node port, found %v", lb.LaunchConfig.Ports)
+	}
+	if links, err := r.lbServiceLinks(lb); err != nil || len(links) != 2 {
+		t.Errorf("expected the LB to link both hosts, found %v, %v", links, err)
+	}
+
+	// ensuring the LB again changes nothing
+	mutations := cattle.mutationCount()
+	if _, err := r.EnsureLoadBalancer("prod", service, nodes); err != nil {
+		t.Fatalf("Couldn't ensure the LB again: %v", err)
+	}
+	if cattle.mutationCount() != mutations || cattle.count("loadbalancerservices") != 1 {
+		t.Errorf("expected the LB to be left alone, found %d changes and %d LBs",
+			cattle.mutationCount()-mutations, cattle.count("loadbalancerservices"))
+	}
+
+	if status, exists, err := r.GetLoadBalancer("prod", service); err != nil || !exists || len(status.Ingress) != 1 {
+		t.Errorf("expected the LB to be found, found %#v, %v, %v", status, exists, err)
+	}
+
+	if err := r.UpdateLoadBalancer("prod", service, nodes[:1]); err != nil {
+		t.Fatalf("Couldn't update the LB: %v", err)
+	}
+	if links, err := r.lbServiceLinks(lb); err != nil || len(links) != 1 {
+		t.Errorf("expected the LB to link host1 only, found %v, %v", links, err)
+	}
+
+	if err := r.EnsureLoadBalancerDeleted("prod", service); err != nil {
+		t.Fatalf("Couldn't delete the LB: %v", err)
+	}
+	for _, collection := range []string{"loadbalancerservices", "externalservices", "environments"} {
+		if count := cattle.count(collection); count != 0 {
+			t.Errorf("expected the %s to be deleted, found %d", collection, count)
+		}
+	}
+	if err := r.EnsureLoadBalancerDeleted("prod", service); err != nil {
+		t.Errorf("expected deleting a deleted LB to succeed, found %v", err)
+	}
+}
+
+func TestLBEnvironmentName(t *testing.T) {
+	tests := []struct {
+		cluster    string
+		name       string
+		externalID string
+	}{
+		{"", "kubernetes-loadbalancers", "kubernetes-loadbalancers://"},
+		{"kubernetes", "kubernetes-loadbalancers", "kubernetes-loadbalancers://"},
+		{"prod", "kubernetes-loadbalancers-prod", "kubernetes-loadbalancers://prod"},
+		{"eu_west.1", "kubernetes-loadbalancers-eu-west-1", "kubernetes-loadbalancers://eu-west-1"},
+	}
+	for _, test := range tests {
+		name, externalID := lbEnvironmentName(test.cluster)
+		if name != test.name || externalID != test.externalID {
+			t.Errorf("%q: expected %s and %s, found %s and %s", test.cluster, test.name, test.externalID, name, externalID)
+		}
+	}
+}
+
+func TestSameServiceIDs(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		same bool
+	}{
+		{nil, []string{}, true},
+		{[]string{"1s1", "1s2"}, []string{"1s2", "1s1"}, true},
+		{[]string{"1s1"}, []string{"1s1", "1s2"}, false},
+		{[]string{"1s1", "1s1"}, []string{"1s1", "1s2"}, false},
+	}
+	for _, test := range tests {
+		if same := sameServiceIDs(test.a, test.b); same != test.same {
+			t.Errorf("%v and %v: expected %v, found %v", test.a, test.b, test.same, same)
+		}
+	}
+}
+
+func TestServiceLBPorts(t *testing.T) {
+	tests := []struct {
+		ports   []api.ServicePort
+		lbPorts []string
+		invalid bool
+	}{
+		{
+			ports:   []api.ServicePort{{Port: 80, NodePort: 30080}},
+			lbPorts: []string{"80:30080/tcp"},
+		},
+		{
+			ports:   []api.ServicePort{{Port: 53, NodePort: 30053, Protocol: api.ProtocolUDP}},
+			lbPorts: []string{"53:30053/udp"},
+		},
+		{
+			ports: []api.ServicePort{
+				{Port: 80, NodePort: 30080, Protocol: api.ProtocolTCP},
+				{Port: 27015, NodePort: 30015, Protocol: api.ProtocolUDP},
+			},
+			lbPorts: []string{"80:30080/tcp", "27015:30015/udp"},
+		},
+		{
+			ports: []api.ServicePort{
+				{Port: 53, NodePort: 30053, Protocol: api.ProtocolTCP},
+				{Port: 53, NodePort: 30054, Protocol: api.ProtocolUDP},
+			},
+			invalid: true,
+		},
+		{
+			ports:   []api.ServicePort{{Port: 80, NodePort: 30080, Protocol: api.Protocol("SCTP")}},
+			invalid: true,
+		},
+	}
+	for _, test := range tests {
+		lbPorts, err := serviceLBPorts(test.ports)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%v: expected an error, found %v", test.ports, lbPorts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.ports, err)
+		} else if !reflect.DeepEqual(lbPorts, test.lbPorts) {
+			t.Errorf("%v: expected %v, found %v", test.ports, test.lbPorts, lbPorts)
+		}
+	}
+}
+
+func TestUDPLoadBalancer(t *testing.T) {
+	tests := []struct {
+		name    string
+		ports   []api.ServicePort
+		lbPorts []string
+		invalid bool
+	}{
+		{
+			name:    "udp only",
+			ports:   []api.ServicePort{{Port: 53, NodePort: 30053, Protocol: api.ProtocolUDP}},
+			lbPorts: []string{"53:30053/udp"},
+		},
+		{
+			name: "mixed",
+			ports: []api.ServicePort{
+				{Port: 80, NodePort: 30080, Protocol: api.ProtocolTCP},
+				{Port: 27015, NodePort: 30015, Protocol: api.ProtocolUDP},
+			},
+			lbPorts: []string{"27015:30015/udp", "80:30080/tcp"},
+		},
+		{
+			name: "same port with both protocols",
+			ports: []api.ServicePort{
+				{Port: 53, NodePort: 30053, Protocol: api.ProtocolTCP},
+				{Port: 53, NodePort: 30054, Protocol: api.ProtocolUDP},
+			},
+			invalid: true,
+		},
+	}
+	for _, test := range tests {
+		cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1"})
+		r := newFakeLBCloud(t, cattle)
+		service := newLBService(nil)
+		service.Spec.Ports = test.ports
+		nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}}
+
+		status, err := r.EnsureLoadBalancer("kubernetes", service, nodes)
+		if test.invalid {
+			if err == nil || cattle.count("loadbalancerservices") != 0 {
+				t.Errorf("%s: expected the LB not to be created, found %d LBs, %v", test.name, cattle.count("loadbalancerservices"), err)
+			}
+			cattle.Close()
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: couldn't ensure the LB: %v", test.name, err)
+			cattle.Close()
+			continue
+		}
+		if len(status.Ingress) != 1 {
+			t.Errorf("%s: expected an ingress, found %#v", test.name, status.Ingress)
+		}
+		lb, err := r.getLBByName(lbName("kubernetes", service))
+		if err != nil || lb == nil {
+			t.Errorf("%s: expected the LB to exist, found %#v, %v", test.name, lb, err)
+		} else if ports := append([]string{}, lb.LaunchConfig.Ports...); portsChanged(ports, test.lbPorts) {
+			t.Errorf("%s: expected the LB ports %v, found %v", test.name, test.lbPorts, lb.LaunchConfig.Ports)
+		}
+
+		// an LB missing a port of the service has no ingress
+		service.Spec.Ports = append(service.Spec.Ports, api.ServicePort{Port: 5353, NodePort: 30353, Protocol: api.ProtocolUDP})
+		status, exists, err := r.GetLoadBalancer("kubernetes", service)
+		if err != nil || !exists || len(status.Ingress) != 0 {
+			t.Errorf("%s: expected an existing LB without ingress, found %#v, %v, %v", test.name, status, exists, err)
+		}
+		cattle.Close()
+	}
+}
+
+func TestInternalLoadBalancer(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	service := newLBService(map[string]string{annotationLBInternal: "true"})
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}}
+	name := lbName("kubernetes", service)
+
+	steps := []struct {
+		internal string
+		ingress  string
+	}{
+		{"true", lbVip},
+		{"false", lbEndpoint},
+		{"true", lbVip},
+	}
+	for _, step := range steps {
+		service.Annotations[annotationLBInternal] = step.internal
+		status, err := r.EnsureLoadBalancer("kubernetes", service, nodes)
+		if err != nil {
+			t.Fatalf("internal %s: couldn't ensure the LB: %v", step.internal, err)
+		}
+		if len(status.Ingress) != 1 || status.Ingress[0].IP != step.ingress {
+			t.Errorf("internal %s: expected the ingress %s, found %#v", step.internal, step.ingress, status.Ingress)
+		}
+		lb, err := r.getLBByName(name)
+		if err != nil || lb == nil {
+			t.Fatalf("internal %s: expected the LB to exist, found %#v, %v", step.internal, lb, err)
+		}
+		if internal := lbInternal(lb); internal != (step.internal == "true") || len(lbForwardedPorts(lb)) != 1 {
+			t.Errorf("internal %s: expected the LB to forward its port, found internal %v and %#v", step.internal, internal, lb.LaunchConfig)
+		}
+		if cattle.count("loadbalancerservices") != 1 {
+			t.Errorf("internal %s: expected a single LB, found %d", step.internal, cattle.count("loadbalancerservices"))
+		}
+		// the status doesn't flap between addresses
+		for i := 0; i < 2; i++ {
+			status, exists, err := r.GetLoadBalancer("kubernetes", service)
+			if err != nil || !exists || len(status.Ingress) != 1 || status.Ingress[0].IP != step.ingress {
+				t.Errorf("internal %s: expected the ingress %s, found %#v, %v, %v", step.internal, step.ingress, status, exists, err)
+			}
+		}
+	}
+
+	service.Annotations[annotationLBInternal] = "yes please"
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err == nil {
+		t.Errorf("expected an invalid value to fail")
+	}
+}
+
+func TestUpdateLoadBalancerDiffsHosts(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1", "host2": "10.0.0.2", "host3": "10.0.0.3"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	service := newLBService(nil)
+	nodes := []*api.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "host1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "host2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "host3"}},
+	}
+	name := lbName("kubernetes", service)
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes[:2]); err != nil {
+		t.Fatalf("Couldn't ensure the LB: %v", err)
+	}
+
+	steps := []struct {
+		name      string
+		nodes     []*api.Node
+		mutations int
+	}{
+		{name: "unchanged", nodes: nodes[:2], mutations: 0},
+		// the external service of host3 is created and linked
+		{name: "node added", nodes: nodes, mutations: 2},
+		// the link to host3 is removed and its external service deleted
+		{name: "node removed", nodes: nodes[:2], mutations: 2},
+	}
+	for _, step := range steps {
+		lb, err := r.getLBByName(name)
+		if err != nil || lb == nil {
+			t.Fatalf("%s: expected LB %s to exist, found %#v, %v", step.name, name, lb, err)
+		}
+		before, err := r.lbServiceLinks(lb)
+		if err != nil {
+			t.Fatalf("%s: couldn't get the links of the LB: %v", step.name, err)
+		}
+		mutations, linkReads := cattle.mutationCount(), cattle.linkReadCount()
+		if err := r.UpdateLoadBalancer("kubernetes", service, step.nodes); err != nil {
+			t.Fatalf("%s: couldn't update the LB: %v", step.name, err)
+		}
+		if found := cattle.mutationCount() - mutations; found != step.mutations {
+			t.Errorf("%s: expected %d changes, found %d", step.name, step.mutations, found)
+		}
+		// the links are changed against the single listing they're diffed with
+		if found := cattle.linkReadCount() - linkReads; found != 1 {
+			t.Errorf("%s: expected the links of the LB to be read once, found %d reads", step.name, found)
+		}
+		after, err := r.lbServiceLinks(lb)
+		if err != nil || len(after) != len(step.nodes) {
+			t.Errorf("%s: expected the LB to link %d hosts, found %v, %v", step.name, len(step.nodes), after, err)
+		}
+		// the links of the hosts left alone are kept
+		kept := before
+		if len(after) < len(before) {
+			kept = after
+		}
+		for i := range kept {
+			if i >= len(after) || after[i] != before[i] {
+				t.Errorf("%s: expected the links %v to be kept, found %v", step.name, kept, after)
+				break
+			}
+		}
+	}
+	if count := cattle.count("externalservices"); count != 2 {
+		t.Errorf("expected the external services of host1 and host2 to be left, found %d", count)
+	}
+}
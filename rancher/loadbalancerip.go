@@ -0,0 +1,100 @@
+package rancher
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/golang/glog"
+	"github.com/rancher/go-rancher/client"
+
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+// serviceLoadBalancerIP returns the loadBalancerIP of service, the public IP of the host its LB
+// must run on, or "" if it has none
+func serviceLoadBalancerIP(service *api.Service) (string, error) {
+	ip := service.Spec.LoadBalancerIP
+	if ip == "" {
+		return "", nil
+	}
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("Invalid loadBalancerIP %q of service %s, must be an IP address", ip, serviceKey(service))
+	}
+	return ip, nil
+}
+
+// hostIDWithIP returns the ID of the host with the IP address ip
+func (r *CloudProvider) hostIDWithIP(ip string) (string, error) {
+	hosts, err := r.listHosts()
+	if err != nil {
+		return "", err
+	}
+	for _, host := range hosts {
+		for _, address := range host.IPAddresses {
+			if address.Address == ip {
+				return host.RancherHost.Id, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("No Rancher host has the loadBalancerIP %s", ip)
+}
+
+// lbRequestedHost returns the ID of the host lb is pinned to, or "" if it may run on any host
+func lbRequestedHost(lb *client.LoadBalancerService) string {
+	if lb.LaunchConfig == nil {
+		return ""
+	}
+	return lb.LaunchConfig.RequestedHostId
+}
+
+// ensureLBRequestedHost pins lb to the host with the given ID, upgrading it in place. Rancher
+// moves the LB to that host.
+func (r *CloudProvider) ensureLBRequestedHost(lb *client.LoadBalancerService, hostID string) (*client.LoadBalancerService, error) {
+	if lbRequestedHost(lb) == hostID {
+		return lb, nil
+	}
+	launchConfig := client.LaunchConfig{}
+	if lb.LaunchConfig != nil {
+		launchConfig = *lb.LaunchConfig
+	}
+	launchConfig.RequestedHostId = hostID
+	glog.Infof("Pinning LB %s to host %s", lb.Name, hostID)
+	return r.upgradeLBLaunchConfig(lb, &launchConfig)
+}
+
+// pinLBToEndpointHost pins a new lb to the host it was scheduled to, so its address doesn't move
+// when Rancher reschedules it
+func (r *CloudProvider) pinLBToEndpointHost(lb *client.LoadBalancerService) (*client.LoadBalancerService, error) {
+	for _, epObj := range lb.PublicEndpoints {
+		ep := PublicEndpoint{}
+		if err := convertObject(epObj, &ep); err != nil {
+			return nil, err
+		}
+		if ep.HostId != "" {
+			return r.ensureLBRequestedHost(lb, ep.HostId)
+		}
+	}
+	return lb, nil
+}
+
+// loadBalancerIPStatus returns status with the loadBalancerIP ip as its only IP, or an empty status
+// until the LB serves on it. The status is left alone if ip is "".
+func loadBalancerIPStatus(status *api.LoadBalancerStatus, ip string) *api.LoadBalancerStatus {
+	if ip == "" {
+		return status
+	}
+	serving := false
+	ingress := []api.LoadBalancerIngress{}
+	for _, i := range status.Ingress {
+		if i.IP == ip {
+			serving = true
+		}
+		if i.IP == ip || i.IP == "" {
+			ingress = append(ingress, i)
+		}
+	}
+	if !serving {
+		return &api.LoadBalancerStatus{}
+	}
+	return &api.LoadBalancerStatus{Ingress: ingress}
+}
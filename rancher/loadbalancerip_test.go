@@ -0,0 +1,105 @@
+package rancher
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+// fakeHostID returns the ID of the host of cattle with the given hostname
+func fakeHostID(cattle *fakeLBCattle, hostname string) string {
+	cattle.Lock()
+	defer cattle.Unlock()
+	for id, host := range cattle.resources["hosts"] {
+		if host["hostname"] == hostname {
+			return id
+		}
+	}
+	return ""
+}
+
+func TestLoadBalancerIP(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "198.51.100.1", "host2": "198.51.100.2"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	service := newLBService(nil)
+	service.Spec.LoadBalancerIP = "198.51.100.2"
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}, {ObjectMeta: metav1.ObjectMeta{Name: "host2"}}}
+	name := lbName("kubernetes", service)
+
+	status, err := r.EnsureLoadBalancer("kubernetes", service, nodes)
+	if err != nil {
+		t.Fatalf("Couldn't ensure the LB: %v", err)
+	}
+	if len(status.Ingress) != 1 || status.Ingress[0].IP != "198.51.100.2" {
+		t.Errorf("expected the ingress 198.51.100.2, found %#v", status.Ingress)
+	}
+	lb, err := r.getLBByName(name)
+	if err != nil || lb == nil || lbRequestedHost(lb) != fakeHostID(cattle, "host2") {
+		t.Fatalf("expected the LB to be pinned to host2, found %#v, %v", lb, err)
+	}
+
+	// the LB is moved to another host by hand
+	if _, err := r.ensureLBRequestedHost(lb, fakeHostID(cattle, "host1")); err != nil {
+		t.Fatalf("Couldn't move the LB: %v", err)
+	}
+	if status, exists, err := r.GetLoadBalancer("kubernetes", service); err != nil || !exists || len(status.Ingress) != 0 {
+		t.Errorf("expected the LB not to report an address it doesn't serve on, found %#v, %v, %v", status, exists, err)
+	}
+	if err := r.UpdateLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't update the LB: %v", err)
+	}
+	if status, exists, err := r.GetLoadBalancer("kubernetes", service); err != nil || !exists ||
+		len(status.Ingress) != 1 || status.Ingress[0].IP != "198.51.100.2" {
+		t.Errorf("expected the LB to be moved back to 198.51.100.2, found %#v, %v, %v", status, exists, err)
+	}
+
+	for _, ip := range []string{"198.51.100.3", "host2"} {
+		service.Spec.LoadBalancerIP = ip
+		if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err == nil {
+			t.Errorf("%s: expected the LB not to be placed", ip)
+		}
+		if err := r.UpdateLoadBalancer("kubernetes", service, nodes); err == nil {
+			t.Errorf("%s: expected the LB not to be updated", ip)
+		}
+	}
+	if current, err := r.getLBByName(name); err != nil || current == nil || lbRequestedHost(current) != fakeHostID(cattle, "host2") {
+		t.Errorf("expected the LB to stay on host2, found %#v, %v", current, err)
+	}
+}
+
+func TestLBAddressSticky(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "198.51.100.1", "host2": "198.51.100.2"})
+	defer cattle.Close()
+	cattle.scheduledHost = "host1"
+	r := newFakeLBCloud(t, cattle)
+	service := newLBService(nil)
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}, {ObjectMeta: metav1.ObjectMeta{Name: "host2"}}}
+	name := lbName("kubernetes", service)
+
+	status, err := r.EnsureLoadBalancer("kubernetes", service, nodes)
+	if err != nil {
+		t.Fatalf("Couldn't ensure the LB: %v", err)
+	}
+	if len(status.Ingress) != 1 || status.Ingress[0].IP != "198.51.100.1" {
+		t.Errorf("expected the ingress of the host the LB was scheduled to, found %#v", status.Ingress)
+	}
+
+	// the LB stays on its host when Rancher would schedule it elsewhere
+	cattle.Lock()
+	cattle.scheduledHost = "host2"
+	cattle.Unlock()
+	for i := 0; i < 2; i++ {
+		if err := r.UpdateLoadBalancer("kubernetes", service, nodes[1:]); err != nil {
+			t.Fatalf("Couldn't update the LB: %v", err)
+		}
+		lb, err := r.getLBByName(name)
+		if err != nil || lb == nil || lbRequestedHost(lb) != fakeHostID(cattle, "host1") {
+			t.Errorf("expected the LB to stay pinned to host1, found %#v, %v", lb, err)
+		}
+		if status, _, err := r.GetLoadBalancer("kubernetes", service); err != nil || len(status.Ingress) != 1 || status.Ingress[0].IP != "198.51.100.1" {
+			t.Errorf("expected the ingress to stay 198.51.100.1, found %#v, %v", status, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package rancher
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "k8s.io/kubernetes/pkg/api/v1"
+	v1service "k8s.io/kubernetes/pkg/api/v1/service"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
+)
+
+// SetEndpointsClient gives the provider a client to read the endpoints of the services routing
+// external traffic to their local endpoints only with
+func (r *CloudProvider) SetEndpointsClient(endpoints corev1.EndpointsGetter) {
+	r.endpoints = endpoints
+}
+
+// endpointNodes returns the names of the nodes running the ready endpoints of endpoints. Known is
+// false if an endpoint doesn't tell its node.
+func endpointNodes(endpoints *api.Endpoints) (nodes map[string]bool, known bool) {
+	nodes = map[string]bool{}
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if address.NodeName == nil || *address.NodeName == "" {
+				return nil, false
+			}
+			nodes[*address.NodeName] = true
+		}
+	}
+	return nodes, true
+}
+
+// localTrafficHosts returns the hosts the LB of service balances its traffic to. The traffic of a
+// service routing external traffic to its local endpoints only is dropped by the nodes without
+// ready endpoints, those are left out. The other services are balanced to all hosts.
+func (r *CloudProvider) localTrafficHosts(service *api.Service, hosts []string) ([]string, error) {
+	if !v1service.NeedsHealthCheck(service) {
+		return hosts, nil
+	}
+	if r.endpoints == nil {
+		glog.Warningf("Can't read the endpoints of service %s, no endpoints client", serviceKey(service))
+		return hosts, nil
+	}
+
+	endpoints, err := r.endpoints.Endpoints(service.Namespace).Get(service.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		endpoints = &api.Endpoints{}
+	} else if err != nil {
+		return nil, fmt.Errorf("Couldn't get the endpoints of service %s. Error: %v", serviceKey(service), err)
+	}
+	nodes, ok := endpointNodes(endpoints)
+	if !ok {
+		glog.Warningf("Endpoints of service %s don't tell their nodes, balancing to all hosts", serviceKey(service))
+		return hosts, nil
+	}
+	local := []string{}
+	for _, host := range hosts {
+		if nodes[host] {
+			local = append(local, host)
+		}
+	}
+	glog.V(2).Infof("Service %s has local endpoints on hosts %v", serviceKey(service), local)
+	return local, nil
+}
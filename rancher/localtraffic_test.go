@@ -0,0 +1,111 @@
+package rancher
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "k8s.io/kubernetes/pkg/api/v1"
+	v1service "k8s.io/kubernetes/pkg/api/v1/service"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
+)
+
+// fakeKubeEndpoints serves the Endpoints in endpoints by namespace/name
+type fakeKubeEndpoints struct {
+	corev1.EndpointsInterface
+	namespace string
+	endpoints map[string]*api.Endpoints
+}
+
+func (f *fakeKubeEndpoints) Endpoints(namespace string) corev1.EndpointsInterface {
+	return &fakeKubeEndpoints{namespace: namespace, endpoints: f.endpoints}
+}
+
+func (f *fakeKubeEndpoints) Get(name string, options metav1.GetOptions) (*api.Endpoints, error) {
+	endpoints, ok := f.endpoints[f.namespace+"/"+name]
+	if !ok {
+		return nil, apierrors.NewNotFound(api.Resource("endpoints"), name)
+	}
+	return endpoints, nil
+}
+
+// endpointsOn returns endpoints with a ready address on each of nodes
+func endpointsOn(nodes ...string) *api.Endpoints {
+	addresses := []api.EndpointAddress{}
+	for i := range nodes {
+		addresses = append(addresses, api.EndpointAddress{IP: "10.42.0.1", NodeName: &nodes[i]})
+	}
+	return &api.Endpoints{Subsets: []api.EndpointSubset{{Addresses: addresses}}}
+}
+
+// linkedHosts returns the names of the external services linked to the LB named name, sorted
+func linkedHosts(t *testing.T, r *CloudProvider, cattle *fakeLBCattle, name string) []string {
+	lb, err := r.getLBByName(name)
+	if err != nil || lb == nil {
+		t.Fatalf("expected LB %s to exist, found %#v, %v", name, lb, err)
+	}
+	links, err := r.lbServiceLinks(lb)
+	if err != nil {
+		t.Fatalf("Couldn't get the links of LB %s: %v", name, err)
+	}
+	cattle.Lock()
+	defer cattle.Unlock()
+	hosts := []string{}
+	for _, id := range links {
+		hosts = append(hosts, cattle.resources["externalservices"][id]["name"].(string))
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func TestLocalTrafficHosts(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1", "host2": "10.0.0.2", "host3": "10.0.0.3"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	endpoints := &fakeKubeEndpoints{endpoints: map[string]*api.Endpoints{"default/web": endpointsOn("host1")}}
+	r.SetEndpointsClient(endpoints)
+	nodes := []*api.Node{}
+	for _, name := range []string{"host1", "host2", "host3"} {
+		nodes = append(nodes, &api.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+
+	service := newLBService(map[string]string{
+		v1service.BetaAnnotationExternalTraffic:     v1service.AnnotationValueExternalTrafficLocal,
+		v1service.BetaAnnotationHealthCheckNodePort: "32000",
+	})
+	name := lbName("kubernetes", service)
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't ensure the LB: %v", err)
+	}
+	if hosts := linkedHosts(t, r, cattle, name); len(hosts) != 1 || !strings.HasPrefix(hosts[0], "host1-") {
+		t.Errorf("expected only the host with an endpoint to be linked, found %v", hosts)
+	}
+
+	// the endpoint moves
+	endpoints.endpoints["default/web"] = endpointsOn("host2", "host3")
+	if err := r.UpdateLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't update the LB: %v", err)
+	}
+	if hosts := linkedHosts(t, r, cattle, name); len(hosts) != 2 || !strings.HasPrefix(hosts[0], "host2-") || !strings.HasPrefix(hosts[1], "host3-") {
+		t.Errorf("expected the hosts the endpoints moved to to be linked, found %v", hosts)
+	}
+
+	delete(endpoints.endpoints, "default/web")
+	if err := r.UpdateLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't update the LB: %v", err)
+	}
+	if hosts := linkedHosts(t, r, cattle, name); len(hosts) != 0 {
+		t.Errorf("expected no host to be linked without endpoints, found %v", hosts)
+	}
+
+	// the services balanced to the whole cluster link all hosts
+	service.Annotations = nil
+	if err := r.UpdateLoadBalancer("kubernetes", service, nodes); err != nil {
+		t.Fatalf("Couldn't update the LB: %v", err)
+	}
+	if hosts := linkedHosts(t, r, cattle, name); len(hosts) != 3 {
+		t.Errorf("expected all hosts to be linked, found %v", hosts)
+	}
+}
@@ -0,0 +1,59 @@
+package rancher
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hostMetadataLabelPrefix prefixes the host labels set by Rancher agents and operators to describe
+// hosts, e.g. io.rancher.host.os or io.rancher.host.docker_version
+const hostMetadataLabelPrefix = "io.rancher.host."
+
+// InstanceMetadataByProviderID returns the metadata of the host with the given providerID: its
+// io.rancher.host labels, by name without the prefix. Other host labels are ignored.
+func (r *CloudProvider) InstanceMetadataByProviderID(providerID string) (map[string]string, error) {
+	hostID, err := r.parseProviderID(providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	host, err := r.hostGetById(hostID)
+	if err != nil {
+		return nil, err
+	}
+	return hostMetadata(host), nil
+}
+
+// hostMetadata returns the metadata fields of host from its labels
+func hostMetadata(host *Host) map[string]string {
+	metadata := map[string]string{}
+	for key, value := range host.RancherHost.Labels {
+		field := strings.TrimPrefix(key, hostMetadataLabelPrefix)
+		if field == key || field == "" || value == nil {
+			continue
+		}
+		metadata[field] = fmt.Sprint(value)
+	}
+	return metadata
+}
+
+// HostLabelsByProviderID returns the labels of the host with the given providerID. Labels without
+// a value are ignored.
+func (r *CloudProvider) HostLabelsByProviderID(providerID string) (map[string]string, error) {
+	hostID, err := r.parseProviderID(providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	host, err := r.hostGetById(hostID)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{}
+	for key, value := range host.RancherHost.Labels {
+		if value != nil {
+			labels[key] = fmt.Sprint(value)
+		}
+	}
+	return labels, nil
+}
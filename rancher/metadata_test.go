@@ -0,0 +1,62 @@
+package rancher
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+)
+
+func TestInstanceMetadataByProviderID(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	hostList = &client.HostCollection{
+		Data: []client.Host{
+			client.Host{
+				Resource: client.Resource{Id: "1h11"},
+				Hostname: "armhost",
+				Labels: map[string]interface{}{
+					"io.rancher.host.os":             "linux",
+					"io.rancher.host.arch":           "arm64",
+					"io.rancher.host.docker_version": "1.12",
+					"io.rancher.host.kvm":            nil,
+					"app":                            "web",
+				},
+			},
+		},
+	}
+	ipAddressLinks["1h11"] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "10.0.0.11"}}}
+
+	metadata, err := cloudProvider.InstanceMetadataByProviderID("rancher://1h11")
+	expected := map[string]string{"os": "linux", "arch": "arm64", "docker_version": "1.12"}
+	if err != nil || !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("expected metadata %v, found %v, err: %v", expected, metadata, err)
+	}
+	if _, err := cloudProvider.InstanceMetadataByProviderID("rancher://1h12"); err == nil {
+		t.Errorf("expected an error for a missing host")
+	}
+}
+
+func TestHostLabelsByProviderID(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	hostList = &client.HostCollection{
+		Data: []client.Host{
+			client.Host{
+				Resource: client.Resource{Id: "1h11"},
+				Hostname: "gpuhost",
+				Labels:   map[string]interface{}{"storage": "ssd", "gpu": true, "io.rancher.host.kvm": nil},
+			},
+		},
+	}
+	ipAddressLinks["1h11"] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "10.0.0.11"}}}
+
+	labels, err := cloudProvider.HostLabelsByProviderID("rancher://1h11")
+	expected := map[string]string{"storage": "ssd", "gpu": "true"}
+	if err != nil || !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected labels %v, found %v, err: %v", expected, labels, err)
+	}
+	if _, err := cloudProvider.HostLabelsByProviderID("rancher://1h12"); err == nil {
+		t.Errorf("expected an error for a missing host")
+	}
+}
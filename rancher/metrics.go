@@ -0,0 +1,247 @@
+package rancher
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "rancher_ccm"
+
+	// errorWindowBucket is the granularity of the rolling error ratio
+	errorWindowBucket = 10 * time.Second
+	// errorWindowBuckets * errorWindowBucket is the span of the rolling error ratio
+	errorWindowBuckets = 30
+)
+
+var (
+	apiRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_requests_total",
+			Help:      "Number of requests sent to the Rancher API, partitioned by operation.",
+		},
+		[]string{"operation"},
+	)
+
+	apiErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_errors_total",
+			Help:      "Number of failed Rancher API requests, partitioned by operation and status class (401, 403, 404, 429, 4xx, 5xx, timeout or network).",
+		},
+		[]string{"operation", "status_class"},
+	)
+
+	apiRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_request_duration_seconds",
+			Help:      "Latency of Rancher API requests in seconds, partitioned by operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		},
+		[]string{"operation"},
+	)
+
+	apiRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_retries_total",
+			Help:      "Number of Rancher API requests sent again after a transient error, partitioned by operation and status class (429 or 5xx).",
+		},
+		[]string{"operation", "status_class"},
+	)
+
+	apiFailovers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_endpoint_failovers_total",
+			Help:      "Number of times requests switched to another Rancher API endpoint, partitioned by the endpoint switched to.",
+		},
+		[]string{"endpoint"},
+	)
+
+	hostCacheLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "host_cache_lookups_total",
+			Help:      "Number of host lookups served from the host list cache (hit) or from the Rancher API (miss).",
+		},
+		[]string{"result"},
+	)
+
+	hostCacheRefreshErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "host_cache_refresh_errors_total",
+			Help:      "Number of failed refreshes of the host list cache.",
+		},
+	)
+
+	hostEventSubscriptionUp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "host_event_subscription_up",
+			Help:      "Whether the subscription to the host events of the Rancher API is connected.",
+		},
+	)
+
+	hostEvents = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "host_events_total",
+			Help:      "Number of host change events received from the Rancher API.",
+		},
+	)
+
+	routeOperations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "route_operations_total",
+			Help:      "Number of route operations, partitioned by operation (create or delete) and result (success or error).",
+		},
+		[]string{"operation", "result"},
+	)
+
+	routeOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "route_operation_duration_seconds",
+			Help:      "Latency of route operations in seconds, partitioned by operation (create or delete).",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		},
+		[]string{"operation"},
+	)
+
+	managedRoutes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "managed_routes",
+			Help:      "Number of routes of the pod network stored in the labels of the hosts, as of the last listing.",
+		},
+	)
+
+	apiErrorWindow = newErrorWindow(time.Now)
+
+	apiErrorRatio = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_error_ratio",
+			Help:      "Ratio of failed to total Rancher API requests over the last 5 minutes.",
+		},
+		func() float64 { return apiErrorWindow.ratio() },
+	)
+)
+
+func init() {
+	prometheus.MustRegister(apiRequests)
+	prometheus.MustRegister(apiErrors)
+	prometheus.MustRegister(apiRequestDuration)
+	prometheus.MustRegister(apiFailovers)
+	prometheus.MustRegister(apiRetries)
+	prometheus.MustRegister(apiErrorRatio)
+	prometheus.MustRegister(hostCacheLookups)
+	prometheus.MustRegister(hostCacheRefreshErrors)
+	prometheus.MustRegister(hostEventSubscriptionUp)
+	prometheus.MustRegister(hostEvents)
+	prometheus.MustRegister(routeOperations)
+	prometheus.MustRegister(routeOperationDuration)
+	prometheus.MustRegister(managedRoutes)
+}
+
+// APIErrorRatio returns the ratio of failed to total Rancher API requests over the last 5 minutes
+func (r *CloudProvider) APIErrorRatio() float64 {
+	return apiErrorWindow.ratio()
+}
+
+// observeAPIRequest records the outcome of a single request to the Rancher API
+func observeAPIRequest(operation string, resp *http.Response, err error, latency time.Duration) {
+	apiRequests.WithLabelValues(operation).Inc()
+	apiRequestDuration.WithLabelValues(operation).Observe(latency.Seconds())
+
+	class := statusClass(resp, err)
+	if class != "" {
+		apiErrors.WithLabelValues(operation, class).Inc()
+	}
+	apiErrorWindow.observe(class != "")
+}
+
+// statusClass buckets a failed request by the kind of response it calls for. It returns
+// "" for successful requests.
+func statusClass(resp *http.Response, err error) string {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return "timeout"
+	}
+	if err != nil || resp == nil {
+		return "network"
+	}
+
+	switch code := resp.StatusCode; {
+	case code < 400:
+		return ""
+	case code == http.StatusUnauthorized, code == http.StatusForbidden,
+		code == http.StatusNotFound, code == http.StatusTooManyRequests:
+		return strconv.Itoa(code)
+	case code < 500:
+		return "4xx"
+	default:
+		return "5xx"
+	}
+}
+
+type errorWindowSlot struct {
+	start    time.Time
+	requests int
+	errors   int
+}
+
+// errorWindow keeps request and error counts over a rolling window in fixed size slots
+type errorWindow struct {
+	sync.Mutex
+	now   func() time.Time
+	slots [errorWindowBuckets]errorWindowSlot
+}
+
+func newErrorWindow(now func() time.Time) *errorWindow {
+	return &errorWindow{now: now}
+}
+
+func (w *errorWindow) observe(failed bool) {
+	w.Lock()
+	defer w.Unlock()
+
+	start := w.now().Truncate(errorWindowBucket)
+	slot := &w.slots[(start.UnixNano()/int64(errorWindowBucket))%errorWindowBuckets]
+	if !slot.start.Equal(start) {
+		*slot = errorWindowSlot{start: start}
+	}
+	slot.requests++
+	if failed {
+		slot.errors++
+	}
+}
+
+func (w *errorWindow) ratio() float64 {
+	w.Lock()
+	defer w.Unlock()
+
+	oldest := w.now().Truncate(errorWindowBucket).Add(-(errorWindowBuckets - 1) * errorWindowBucket)
+	requests, errors := 0, 0
+	for _, slot := range w.slots {
+		if slot.start.Before(oldest) {
+			continue
+		}
+		requests += slot.requests
+		errors += slot.errors
+	}
+
+	if requests == 0 {
+		return 0
+	}
+	return float64(errors) / float64(requests)
+}
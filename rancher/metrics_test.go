@@ -0,0 +1,308 @@
+package rancher
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rancher/go-rancher/client"
+)
+
+// fakeCattle is a minimal Rancher API server. It serves the schemas the provider needs
+// and answers every other request with the configured status code, after the configured delay.
+// Host listings return the configured hosts, pageSize at a time. The statuses of responses, if
+// any, are answered first, one per request. Requests with a rejected Authorization get a 401.
+// The API of an environment is served under /v2-beta/projects/<id> too.
+// projectPath matches the paths of the API of an environment
+var projectPath = regexp.MustCompile("^(/v2-beta/projects/[^/]+)(.*)$")
+
+type fakeCattle struct {
+	*httptest.Server
+
+	sync.Mutex
+	status        int
+	responses     []int
+	retryAfter    string
+	requests      int
+	delay         time.Duration
+	authorization string
+	path          string
+	hosts         []listedHost
+	pageSize      int
+	rejected      map[string]bool
+}
+
+func newFakeCattle() *fakeCattle {
+	f := &fakeCattle{status: http.StatusOK}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// newFakeCattleTLS returns a fakeCattle served over TLS with config, and a self-signed certificate
+func newFakeCattleTLS(config *tls.Config) *fakeCattle {
+	f := &fakeCattle{status: http.StatusOK}
+	f.Server = httptest.NewUnstartedServer(http.HandlerFunc(f.serve))
+	f.Server.TLS = config
+	f.Server.StartTLS()
+	return f
+}
+
+func (f *fakeCattle) setStatus(status int) {
+	f.Lock()
+	defer f.Unlock()
+	f.status = status
+}
+
+func (f *fakeCattle) lastAuthorization() string {
+	f.Lock()
+	defer f.Unlock()
+	return f.authorization
+}
+
+func (f *fakeCattle) lastPath() string {
+	f.Lock()
+	defer f.Unlock()
+	return f.path
+}
+
+func (f *fakeCattle) serve(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	f.authorization = req.Header.Get("Authorization")
+	f.path = req.URL.Path
+	rejected := f.rejected[f.authorization]
+	f.Unlock()
+	if rejected {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	base, path := "/v2-beta", req.URL.Path
+	if match := projectPath.FindStringSubmatch(path); match != nil {
+		base, path = match[1], "/v2-beta"+match[2]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch path {
+	case "/v2-beta":
+		w.Header().Set("X-API-Schemas", f.URL+base+"/schemas")
+		w.Write([]byte("{}"))
+	case "/v2-beta/schemas":
+		json.NewEncoder(w).Encode(client.Schemas{
+			Data: []client.Schema{
+				{
+					Resource: client.Resource{
+						Id:    client.HOST_TYPE,
+						Links: map[string]string{"collection": f.URL + base + "/hosts"},
+					},
+					PluralName:        "hosts",
+					CollectionMethods: []string{"GET"},
+					ResourceMethods:   []string{"GET"},
+				},
+			},
+		})
+	default:
+		f.Lock()
+		f.requests++
+		status, delay := f.status, f.delay
+		if len(f.responses) > 0 {
+			status, f.responses = f.responses[0], f.responses[1:]
+		}
+		if f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		var hosts *listedHostCollection
+		if path == "/v2-beta/hosts" && f.hosts != nil {
+			hosts = f.hostsPage(req.URL.Query().Get("marker"))
+		}
+		f.Unlock()
+		time.Sleep(delay)
+		if hosts != nil && status == http.StatusOK {
+			json.NewEncoder(w).Encode(hosts)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"data":[]}`))
+	}
+}
+
+// hostsPage returns the page of hosts starting at marker, linking to the next page if any
+func (f *fakeCattle) hostsPage(marker string) *listedHostCollection {
+	start := 0
+	if marker != "" {
+		start, _ = strconv.Atoi(marker)
+	}
+	end := len(f.hosts)
+	if f.pageSize > 0 && start+f.pageSize < end {
+		end = start + f.pageSize
+	}
+	page := &listedHostCollection{Data: f.hosts[start:end]}
+	if end < len(f.hosts) {
+		page.Pagination = &client.Pagination{Next: fmt.Sprintf("%s/v2-beta/hosts?marker=%d", f.URL, end)}
+	}
+	return page
+}
+
+func (f *fakeCattle) client(t *testing.T) *client.RancherClient {
+	return f.clientWithConfig(t, configGlobal{})
+}
+
+func (f *fakeCattle) clientWithConfig(t *testing.T, conf configGlobal) *client.RancherClient {
+	conf.CattleURLs = []string{f.URL + "/v2-beta"}
+	c, err := getRancherClient(&rConfig{Global: conf})
+	if err != nil {
+		t.Fatalf("Couldn't create client for fake Rancher API: %v", err)
+	}
+	return c
+}
+
+func counterValue(t *testing.T, c *prometheus.CounterVec, labels ...string) float64 {
+	m := &dto.Metric{}
+	if err := c.WithLabelValues(labels...).Write(m); err != nil {
+		t.Fatalf("Couldn't read metric %v: %v", labels, err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestAPIErrorsByStatusClass(t *testing.T) {
+	cattle := newFakeCattle()
+	defer cattle.Close()
+	c := cattle.client(t)
+
+	tests := []struct {
+		status int
+		class  string
+	}{
+		{status: http.StatusUnauthorized, class: "401"},
+		{status: http.StatusForbidden, class: "403"},
+		{status: http.StatusNotFound, class: "404"},
+		{status: http.StatusTooManyRequests, class: "429"},
+		{status: http.StatusConflict, class: "4xx"},
+		{status: http.StatusInternalServerError, class: "5xx"},
+		{status: http.StatusBadGateway, class: "5xx"},
+	}
+
+	for _, test := range tests {
+		cattle.setStatus(test.status)
+		before := counterValue(t, apiErrors, "get_hosts", test.class)
+
+		if _, err := c.Host.List(nil); err == nil {
+			t.Errorf("expected listing hosts to fail with status %d", test.status)
+		}
+
+		if after := counterValue(t, apiErrors, "get_hosts", test.class); after != before+1 {
+			t.Errorf("expected status %d to be counted as %s errors, count went from %v to %v", test.status, test.class, before, after)
+		}
+	}
+
+	cattle.setStatus(http.StatusOK)
+	requests := counterValue(t, apiRequests, "get_hosts")
+	if _, err := c.Host.List(nil); err != nil {
+		t.Errorf("Error listing hosts: %v", err)
+	}
+	if after := counterValue(t, apiRequests, "get_hosts"); after != requests+1 {
+		t.Errorf("expected successful request to be counted, count went from %v to %v", requests, after)
+	}
+
+	cattle.Close()
+	before := counterValue(t, apiErrors, "get_hosts", "network")
+	if _, err := c.Host.List(nil); err == nil {
+		t.Errorf("expected listing hosts to fail when the server is down")
+	}
+	if after := counterValue(t, apiErrors, "get_hosts", "network"); after != before+1 {
+		t.Errorf("expected unreachable server to be counted as network errors, count went from %v to %v", before, after)
+	}
+}
+
+func TestAPIOperation(t *testing.T) {
+	tests := []struct {
+		method    string
+		url       string
+		operation string
+	}{
+		{"GET", "http://rancher/v2-beta", "get_root"},
+		{"GET", "http://rancher/v2-beta/projects/1a5/hosts", "get_hosts"},
+		{"GET", "http://rancher/v2-beta/projects/1a5/hosts/1h1", "get_hosts"},
+		{"GET", "http://rancher/v2-beta/projects/1a5/hosts/1h1/ipaddresses", "get_ipaddresses"},
+		{"POST", "http://rancher/v2-beta/projects/1a5/loadbalancerservices/1s3/?action=activate", "post_loadbalancerservices_activate"},
+		{"GET", "http://rancher/v3/nodes", "get_nodes"},
+		{"GET", "http://rancher/v3/nodes/c-abc12:m-7k2lq", "get_nodes"},
+		{"GET", "http://rancher/v3/nodes/local:machine-7k2lq", "get_nodes"},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if op := apiOperation(req); op != test.operation {
+			t.Errorf("expected operation %s for %s %s, found %s", test.operation, test.method, test.url, op)
+		}
+	}
+}
+
+func TestErrorWindowRatio(t *testing.T) {
+	now := time.Date(2017, 5, 1, 12, 0, 0, 0, time.UTC)
+	w := newErrorWindow(func() time.Time { return now })
+
+	if ratio := w.ratio(); ratio != 0 {
+		t.Errorf("expected ratio 0 without requests, found %v", ratio)
+	}
+
+	w.observe(true)
+	w.observe(false)
+	now = now.Add(2 * time.Minute)
+	w.observe(false)
+	w.observe(false)
+
+	if ratio := w.ratio(); ratio != 0.25 {
+		t.Errorf("expected ratio 0.25, found %v", ratio)
+	}
+
+	// the first two requests fall out of the window
+	now = now.Add(4 * time.Minute)
+	if ratio := w.ratio(); ratio != 0 {
+		t.Errorf("expected ratio 0 once failures left the window, found %v", ratio)
+	}
+
+	now = now.Add(time.Hour)
+	w.observe(true)
+	if ratio := w.ratio(); ratio != 1 {
+		t.Errorf("expected ratio 1, found %v", ratio)
+	}
+}
+
+func TestAPIRequestTimeout(t *testing.T) {
+	cattle := newFakeCattle()
+	defer cattle.Close()
+	c := cattle.client(t)
+	apiURLs := []string{cattle.URL + "/v2-beta"}
+	setRequestTimeout(apiURLs, 20*time.Millisecond)
+	defer setRequestTimeout(apiURLs, 0)
+
+	cattle.Lock()
+	cattle.delay = 200 * time.Millisecond
+	cattle.Unlock()
+	before := counterValue(t, apiErrors, "get_hosts", "timeout")
+	if _, err := c.Host.List(nil); err == nil {
+		t.Errorf("expected listing hosts to time out")
+	}
+	if after := counterValue(t, apiErrors, "get_hosts", "timeout"); after != before+1 {
+		t.Errorf("expected the request to be counted as timed out, count went from %v to %v", before, after)
+	}
+
+	cattle.Lock()
+	cattle.delay = 0
+	cattle.Unlock()
+	if _, err := c.Host.List(nil); err != nil {
+		t.Errorf("expected a request answered in time to succeed, found %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package rancher
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	api "k8s.io/kubernetes/pkg/api/v1"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
+)
+
+const (
+	// annotationLBMode selects how a service is load balanced, overriding load-balancer-mode
+	annotationLBMode = "rancher.io/load-balancer-mode"
+
+	// rancherLBMode load balances services with a Rancher LB
+	rancherLBMode = "rancher"
+	// nodePortLBMode creates no Rancher LB, services resolve to the node addresses instead
+	nodePortLBMode = "nodeport"
+)
+
+// SetServicesClient gives the provider a client to update the load balancer status of services
+// with, the service controller only updates it when services change, not when nodes do
+func (r *CloudProvider) SetServicesClient(services corev1.ServicesGetter) {
+	r.services = services
+}
+
+// SetServiceRecorder gives the provider a recorder for the events of services
+func (r *CloudProvider) SetServiceRecorder(recorder record.EventRecorder) {
+	r.serviceRecorder = recorder
+}
+
+// lbMode returns the load balancer mode of service
+func (r *CloudProvider) lbMode(service *api.Service) (string, error) {
+	mode, ok := service.Annotations[annotationLBMode]
+	if !ok {
+		return r.conf.Global.LoadBalancerMode, nil
+	}
+	if mode != rancherLBMode && mode != nodePortLBMode {
+		return "", fmt.Errorf("Invalid %s annotation [%s] on service %s: must be %s or %s",
+			annotationLBMode, mode, serviceKey(service), rancherLBMode, nodePortLBMode)
+	}
+	return mode, nil
+}
+
+// nodePortStatus returns the load balancer status listing the addresses of the schedulable,
+// ready nodes
+func (r *CloudProvider) nodePortStatus(nodes []*api.Node) *api.LoadBalancerStatus {
+	addressType := api.NodeAddressType(r.conf.Global.NodePortAddressType)
+	seen := map[string]bool{}
+	ips := []string{}
+	for _, node := range nodes {
+		if node.Spec.Unschedulable || !nodeReady(node) {
+			continue
+		}
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == addressType && !seen[addr.Address] {
+				seen[addr.Address] = true
+				ips = append(ips, addr.Address)
+			}
+		}
+	}
+	sort.Strings(ips)
+
+	ingress := []api.LoadBalancerIngress{}
+	for _, ip := range ips {
+		ingress = append(ingress, api.LoadBalancerIngress{IP: ip})
+	}
+	return &api.LoadBalancerStatus{Ingress: ingress}
+}
+
+// updateNodePortStatus writes the node port status of service if it changed
+func (r *CloudProvider) updateNodePortStatus(service *api.Service, nodes []*api.Node) error {
+	status := r.nodePortStatus(nodes)
+	if api.LoadBalancerStatusEqual(&service.Status.LoadBalancer, status) {
+		return nil
+	}
+	if r.services == nil {
+		glog.Warningf("Can't update the addresses of service %s, no services client", serviceKey(service))
+		return nil
+	}
+
+	current, err := r.services.Services(service.Namespace).Get(service.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("Couldn't get service %s. Error: %v", serviceKey(service), err)
+	}
+	current.Status.LoadBalancer = *status
+	if _, err := r.services.Services(service.Namespace).UpdateStatus(current); err != nil {
+		return fmt.Errorf("Couldn't update the status of service %s. Error: %v", serviceKey(service), err)
+	}
+	glog.Infof("Updated the addresses of service %s to %v", serviceKey(service), status.Ingress)
+	return nil
+}
+
+func nodeReady(node *api.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == api.NodeReady {
+			return cond.Status == api.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package rancher
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "k8s.io/kubernetes/pkg/api/v1"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
+)
+
+// fakeKubeServices serves service and records the status updates
+type fakeKubeServices struct {
+	corev1.ServiceInterface
+	service *api.Service
+	updates []api.LoadBalancerStatus
+}
+
+func (f *fakeKubeServices) Services(namespace string) corev1.ServiceInterface {
+	return f
+}
+
+func (f *fakeKubeServices) Get(name string, options metav1.GetOptions) (*api.Service, error) {
+	service := *f.service
+	return &service, nil
+}
+
+func (f *fakeKubeServices) UpdateStatus(service *api.Service) (*api.Service, error) {
+	f.updates = append(f.updates, service.Status.LoadBalancer)
+	return service, nil
+}
+
+func testNode(name, ip string, ready, unschedulable bool) *api.Node {
+	status := api.ConditionFalse
+	if ready {
+		status = api.ConditionTrue
+	}
+	return &api.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       api.NodeSpec{Unschedulable: unschedulable},
+		Status: api.NodeStatus{
+			Conditions: []api.NodeCondition{{Type: api.NodeReady, Status: status}},
+			Addresses: []api.NodeAddress{
+				{Type: api.NodeExternalIP, Address: ip},
+				{Type: api.NodeInternalIP, Address: "10.0.0." + name},
+			},
+		},
+	}
+}
+
+func TestNodePortLoadBalancer(t *testing.T) {
+	conf := &rConfig{Global: configGlobal{LoadBalancerMode: nodePortLBMode, NodePortAddressType: string(api.NodeExternalIP)}}
+	// without a Rancher client, any Rancher API call fails the test
+	r := &CloudProvider{conf: conf}
+	service := &api.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}}
+	nodes := []*api.Node{
+		testNode("2", "1.1.1.2", true, false),
+		testNode("1", "1.1.1.1", true, false),
+		testNode("3", "1.1.1.3", false, false),
+		testNode("4", "1.1.1.4", true, true),
+	}
+
+	status, err := r.EnsureLoadBalancer("kubernetes", service, nodes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []api.LoadBalancerIngress{{IP: "1.1.1.1"}, {IP: "1.1.1.2"}}
+	if !reflect.DeepEqual(status.Ingress, expected) {
+		t.Errorf("expected ingress %v, found %v", expected, status.Ingress)
+	}
+
+	// nodes churn
+	service.Status.LoadBalancer = *status
+	services := &fakeKubeServices{service: service}
+	r.SetServicesClient(services)
+	if err := r.UpdateLoadBalancer("kubernetes", service, nodes[:2]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(services.updates) != 0 {
+		t.Errorf("expected unchanged status not to be written, found %v", services.updates)
+	}
+	if err := r.UpdateLoadBalancer("kubernetes", service, nodes[1:]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = []api.LoadBalancerIngress{{IP: "1.1.1.1"}}
+	if len(services.updates) != 1 || !reflect.DeepEqual(services.updates[0].Ingress, expected) {
+		t.Errorf("expected status update to %v, found %v", expected, services.updates)
+	}
+
+	if _, exists, err := r.GetLoadBalancer("kubernetes", service); !exists || err != nil {
+		t.Errorf("expected load balancer to exist, err: %v", err)
+	}
+	if err := r.EnsureLoadBalancerDeleted("kubernetes", service); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLBMode(t *testing.T) {
+	r := &CloudProvider{conf: &rConfig{Global: configGlobal{LoadBalancerMode: rancherLBMode}}}
+	tests := []struct {
+		annotations map[string]string
+		mode        string
+		valid       bool
+	}{
+		{mode: rancherLBMode, valid: true},
+		{annotations: map[string]string{annotationLBMode: nodePortLBMode}, mode: nodePortLBMode, valid: true},
+		{annotations: map[string]string{annotationLBMode: "elb"}},
+	}
+
+	for _, test := range tests {
+		service := &api.Service{ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations}}
+		mode, err := r.lbMode(service)
+		if test.valid != (err == nil) || mode != test.mode {
+			t.Errorf("%v: expected mode %q, valid %v, found %q, err: %v", test.annotations, test.mode, test.valid, mode, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package rancher
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rancher/go-rancher/client"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const providerIDSeparator = "://"
+
+// providerIDSegment matches the environment and host IDs of providerIDs: Rancher 1.x IDs, e.g. 1a5
+// or 1h123, and Rancher 2.x IDs, e.g. c-abc12:m-7k2lq
+var providerIDSegment = regexp.MustCompile(`^[A-Za-z0-9][-A-Za-z0-9_.:]*$`)
+
+// BuildProviderID returns the canonical providerID of the host with the given ID, scoped to the
+// environment with the given ID unless it's empty
+func BuildProviderID(envID, hostID string) string {
+	return buildProviderID(providerName, envID, hostID)
+}
+
+func buildProviderID(scheme, envID, hostID string) string {
+	id := hostID
+	if envID != "" {
+		id = envID + "/" + hostID
+	}
+	if scheme == bareProviderIDScheme {
+		return id
+	}
+	return scheme + providerIDSeparator + id
+}
+
+// ParseProviderID returns the environment and host IDs of a providerID. It accepts the forms
+// written over time: rancher://<host>, rancher://<env>/<host> and bare host IDs set by older
+// agents. The environment ID is empty unless the providerID is scoped to an environment.
+func ParseProviderID(providerID string) (envID, hostID string, err error) {
+	return parseProviderID(providerID, providerName)
+}
+
+// parseProviderID is ParseProviderID accepting the given schemes besides rancher
+func parseProviderID(providerID string, schemes ...string) (string, string, error) {
+	id := providerID
+	if idx := strings.Index(providerID, providerIDSeparator); idx >= 0 {
+		scheme := providerID[:idx]
+		if !validProviderIDScheme(scheme, schemes) {
+			return "", "", &invalidProviderIDError{providerID: providerID, reason: fmt.Sprintf("unsupported scheme %q", scheme)}
+		}
+		id = providerID[idx+len(providerIDSeparator):]
+	}
+	if id == "" {
+		return "", "", &invalidProviderIDError{providerID: providerID, reason: "no host ID"}
+	}
+
+	var envID, hostID string
+	switch segments := strings.Split(id, "/"); len(segments) {
+	case 1:
+		hostID = segments[0]
+	case 2:
+		envID, hostID = segments[0], segments[1]
+		if !providerIDSegment.MatchString(envID) {
+			return "", "", &invalidProviderIDError{providerID: providerID, reason: fmt.Sprintf("invalid environment ID %q", envID)}
+		}
+	default:
+		return "", "", &invalidProviderIDError{providerID: providerID, reason: "expected [<environment ID>/]<host ID>"}
+	}
+	if !providerIDSegment.MatchString(hostID) {
+		return "", "", &invalidProviderIDError{providerID: providerID, reason: fmt.Sprintf("invalid host ID %q", hostID)}
+	}
+	return envID, hostID, nil
+}
+
+func validProviderIDScheme(scheme string, schemes []string) bool {
+	if scheme == providerName {
+		return true
+	}
+	for _, s := range schemes {
+		if s == scheme && s != bareProviderIDScheme {
+			return true
+		}
+	}
+	return false
+}
+
+// buildProviderID returns the providerID of host using the configured scheme, scoped to the
+// environment of host so that hosts of other environments can't be mistaken for it. The IDs of
+// the v3 API already tell their cluster.
+func (r *CloudProvider) buildProviderID(host *client.Host) string {
+	envID := ""
+	if r.conf.apiVersion != apiVersionV3 {
+		envID = host.AccountId
+		if envID == "" {
+			envID = r.conf.Global.EnvironmentID
+		}
+	}
+	return buildProviderID(r.conf.Global.ProviderIDScheme, envID, host.Id)
+}
+
+// parseProviderID returns the host ID from a providerID, in any of the forms ParseProviderID
+// accepts or with the configured scheme. ProviderIDs scoped to another environment than the
+// configured one are rejected.
+func (r *CloudProvider) parseProviderID(providerID string) (string, error) {
+	envID, hostID, err := parseProviderID(providerID, r.conf.Global.ProviderIDScheme)
+	if err != nil {
+		return "", err
+	}
+	if envID != "" && r.conf.apiVersion != apiVersionV3 && r.conf.Global.EnvironmentID != "" && envID != r.conf.Global.EnvironmentID {
+		return "", &invalidProviderIDError{providerID: providerID, reason: fmt.Sprintf("not in environment %s", r.conf.Global.EnvironmentID)}
+	}
+	return hostID, nil
+}
+
+// invalidProviderIDError is returned for providerIDs that don't identify a Rancher host, unlike
+// cloudprovider.InstanceNotFound returned for hosts that are gone
+type invalidProviderIDError struct {
+	providerID string
+	reason     string
+}
+
+func (e *invalidProviderIDError) Error() string {
+	return fmt.Sprintf("Invalid providerID [%s]: %s", e.providerID, e.reason)
+}
+
+// InvalidProviderID returns the providerID
+func (e *invalidProviderIDError) InvalidProviderID() string {
+	return e.providerID
+}
+
+// OwnsProviderID tells whether providerID identifies a Rancher host, in any of the forms
+// parseProviderID accepts
+func (r *CloudProvider) OwnsProviderID(providerID string) bool {
+	_, err := r.parseProviderID(providerID)
+	return err == nil
+}
+
+// ProviderIDByNodeName returns the providerID of the host of a node, for nodes whose kubelet didn't
+// set one. Unlike InstanceID, which returns the UUID of the host, it's built from the host ID that
+// the providerID lookups expect.
+func (r *CloudProvider) ProviderIDByNodeName(name types.NodeName) (string, error) {
+	host, err := r.hostGetOrFetchFromCache(string(name))
+	if err != nil {
+		return "", err
+	}
+	return r.buildProviderID(host.RancherHost), nil
+}
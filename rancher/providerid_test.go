@@ -0,0 +1,166 @@
+package rancher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+func TestProviderID(t *testing.T) {
+	tests := []struct {
+		scheme     string
+		providerID string
+		hostID     string
+		valid      bool
+	}{
+		{scheme: "rancher", providerID: "rancher://1h1", hostID: "1h1", valid: true},
+		{scheme: "rancher", providerID: "1h1", hostID: "1h1", valid: true},
+		{scheme: "rancher", providerID: "cattle://1h1"},
+		{scheme: "rancher", providerID: "rancher://"},
+		{scheme: "rancher", providerID: ""},
+		{scheme: "cattle", providerID: "cattle://1h1", hostID: "1h1", valid: true},
+		{scheme: "cattle", providerID: "rancher://1h1", hostID: "1h1", valid: true},
+		{scheme: "cattle", providerID: "aws://1h1"},
+		{scheme: "none", providerID: "1h1", hostID: "1h1", valid: true},
+		{scheme: "none", providerID: "rancher://1h1", hostID: "1h1", valid: true},
+		{scheme: "none", providerID: "none://1h1"},
+		{scheme: "rancher", providerID: "rancher://1a5/1h1", hostID: "1h1", valid: true},
+		{scheme: "cattle", providerID: "cattle://1a5/1h1", hostID: "1h1", valid: true},
+		{scheme: "rancher", providerID: "1a5/1h1", hostID: "1h1", valid: true},
+	}
+
+	for _, test := range tests {
+		r := &CloudProvider{conf: &rConfig{Global: configGlobal{ProviderIDScheme: test.scheme}}}
+		hostID, err := r.parseProviderID(test.providerID)
+		if r.OwnsProviderID(test.providerID) != test.valid {
+			t.Errorf("expected providerID [%s] to be owned with scheme %s: %v", test.providerID, test.scheme, test.valid)
+		}
+		if !test.valid {
+			if err == nil {
+				t.Errorf("expected providerID [%s] to be rejected with scheme %s, found host %s", test.providerID, test.scheme, hostID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error parsing providerID [%s] with scheme %s: %v", test.providerID, test.scheme, err)
+			continue
+		}
+		if hostID != test.hostID {
+			t.Errorf("expected host %s from providerID [%s], found %s", test.hostID, test.providerID, hostID)
+		}
+
+		// whatever the provider writes, it must read back
+		host := &client.Host{Resource: client.Resource{Id: hostID}, AccountId: "1a5"}
+		if parsed, err := r.parseProviderID(r.buildProviderID(host)); err != nil || parsed != hostID {
+			t.Errorf("expected providerID %s to round trip with scheme %s, found %s (%v)", r.buildProviderID(host), test.scheme, parsed, err)
+		}
+	}
+}
+
+func TestParseProviderID(t *testing.T) {
+	tests := []struct {
+		providerID string
+		envID      string
+		hostID     string
+		valid      bool
+	}{
+		// forms written by the provider and by agents over time
+		{providerID: "rancher://1h123", hostID: "1h123", valid: true},
+		{providerID: "rancher://1a5/1h123", envID: "1a5", hostID: "1h123", valid: true},
+		{providerID: "1h123", hostID: "1h123", valid: true},
+		{providerID: "1a5/1h123", envID: "1a5", hostID: "1h123", valid: true},
+		{providerID: "rancher://c-abc12:m-7k2lq", hostID: "c-abc12:m-7k2lq", valid: true},
+		{providerID: "rancher://host_1.example", hostID: "host_1.example", valid: true},
+		// garbage
+		{providerID: ""},
+		{providerID: "rancher://"},
+		{providerID: "rancher:///1h123"},
+		{providerID: "rancher://1a5/"},
+		{providerID: "rancher://1a5//1h123"},
+		{providerID: "rancher://1a5/1h123/"},
+		{providerID: "rancher://1a5/1h123/extra"},
+		{providerID: "rancher://1h 123"},
+		{providerID: "rancher://-1h123"},
+		{providerID: "rancher://1a5?/1h123"},
+		{providerID: "rancher://rancher://1h123"},
+		{providerID: "aws:///us-east-1a/i-0123456789abcdef0"},
+		{providerID: "gce://project/us-central1-a/instance"},
+		{providerID: "cattle://1h123"},
+		{providerID: "://1h123"},
+		{providerID: "/1h123"},
+	}
+
+	for _, test := range tests {
+		envID, hostID, err := ParseProviderID(test.providerID)
+		if (err == nil) != test.valid {
+			t.Errorf("[%s]: expected valid %v, err: %v", test.providerID, test.valid, err)
+			continue
+		}
+		if err != nil {
+			if _, ok := err.(*invalidProviderIDError); !ok || err.Error() == "" {
+				t.Errorf("[%s]: expected a descriptive invalid providerID error, found %#v", test.providerID, err)
+			}
+			continue
+		}
+		if envID != test.envID || hostID != test.hostID {
+			t.Errorf("[%s]: expected environment %q and host %q, found %q and %q", test.providerID, test.envID, test.hostID, envID, hostID)
+		}
+		if parsedEnv, parsedHost, err := ParseProviderID(BuildProviderID(envID, hostID)); err != nil || parsedEnv != envID || parsedHost != hostID {
+			t.Errorf("[%s]: expected %s to round trip, found %q and %q, err: %v", test.providerID, BuildProviderID(envID, hostID), parsedEnv, parsedHost, err)
+		}
+	}
+}
+
+func TestParseProviderIDOfEnvironment(t *testing.T) {
+	tests := []struct {
+		environment string
+		apiVersion  string
+		providerID  string
+		valid       bool
+	}{
+		{environment: "1a5", providerID: "rancher://1a5/1h1", valid: true},
+		{environment: "1a5", providerID: "rancher://1h1", valid: true},
+		{environment: "1a5", providerID: "rancher://1a7/1h1"},
+		{providerID: "rancher://1a7/1h1", valid: true},
+		{environment: "c-abc12", apiVersion: apiVersionV3, providerID: "rancher://c-abc12:m-7k2lq", valid: true},
+	}
+
+	for _, test := range tests {
+		r := &CloudProvider{conf: &rConfig{Global: configGlobal{ProviderIDScheme: "rancher", EnvironmentID: test.environment}, apiVersion: test.apiVersion}}
+		if _, err := r.parseProviderID(test.providerID); (err == nil) != test.valid {
+			t.Errorf("[%s] in environment %s: expected valid %v, err: %v", test.providerID, test.environment, test.valid, err)
+		}
+	}
+}
+
+func TestProviderIDByNodeName(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	hostList = &client.HostCollection{
+		Data: []client.Host{
+			client.Host{
+				Resource:  client.Resource{Id: "1h7"},
+				Hostname:  "pidhost",
+				Uuid:      "c8b5e4a6-uuid",
+				AccountId: "1a5",
+			},
+		},
+	}
+	ipAddressLinks["1h7"] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "10.0.0.7"}}}
+	r := &CloudProvider{
+		client:    testClient,
+		conf:      &rConfig{Global: configGlobal{ProviderIDScheme: "rancher"}},
+		hostCache: cache.NewTTLStore(hostStoreKeyFunc, time.Duration(24)*time.Hour),
+	}
+
+	providerID, err := r.ProviderIDByNodeName("pidhost")
+	if err != nil || providerID != "rancher://1a5/1h7" {
+		t.Errorf("expected providerID rancher://1a5/1h7, found %s, err: %v", providerID, err)
+	}
+	if _, err := r.ProviderIDByNodeName("missinghost"); err != cloudprovider.InstanceNotFound {
+		t.Errorf("expected InstanceNotFound for a missing host, found %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package rancher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/rancher/go-rancher/client"
+
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+const (
+	// annotationLBProxyProtocol makes the LB of a service send the PROXY protocol header to the
+	// hosts, telling the source addresses of the clients. It's true or * for all the TCP ports of
+	// the service, or the comma separated ports to send it on.
+	annotationLBProxyProtocol = "rancher.io/lb-proxy-protocol"
+
+	// lbProxyProtocolPortsLabel lists the ports a Rancher LB sends the PROXY protocol header on
+	lbProxyProtocolPortsLabel = "io.rancher.loadbalancer.proxy-protocol.ports"
+)
+
+// serviceLBPortList returns the TCP ports of service the annotation asks for, nil if it's missing,
+// false or empty
+func serviceLBPortList(service *api.Service, annotation string) ([]string, error) {
+	value, ok := service.Annotations[annotation]
+	if !ok {
+		return nil, nil
+	}
+	var ports []string
+	switch strings.TrimSpace(value) {
+	case "false":
+		return nil, nil
+	case "true", "*":
+		for _, port := range service.Spec.Ports {
+			if port.Protocol != api.ProtocolUDP {
+				ports = append(ports, strconv.Itoa(int(port.Port)))
+			}
+		}
+		return ports, nil
+	}
+	for _, port := range strings.Split(value, ",") {
+		port = strings.TrimSpace(port)
+		if !servicePort(service, port) || serviceUDPPort(service, port) {
+			return nil, fmt.Errorf("Invalid value %q of annotation %s of service %s, must be true, false, * or TCP ports of the service",
+				value, annotation, serviceKey(service))
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// serviceUDPPort tells whether port is a UDP port of service
+func serviceUDPPort(service *api.Service, port string) bool {
+	for _, p := range service.Spec.Ports {
+		if strconv.Itoa(int(p.Port)) == port && p.Protocol == api.ProtocolUDP {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceProxyProtocolPorts returns the comma separated ports the LB of service sends the PROXY
+// protocol header on
+func serviceProxyProtocolPorts(service *api.Service) (string, error) {
+	ports, err := serviceLBPortList(service, annotationLBProxyProtocol)
+	return strings.Join(ports, ","), err
+}
+
+// lbProxyProtocolPorts returns the ports lb sends the PROXY protocol header on
+func lbProxyProtocolPorts(lb *client.LoadBalancerService) string {
+	if lb.LaunchConfig == nil {
+		return ""
+	}
+	ports, _ := lb.LaunchConfig.Labels[lbProxyProtocolPortsLabel].(string)
+	return ports
+}
+
+func setLBProxyProtocolLabel(launchConfig *client.LaunchConfig, ports string) {
+	labels := map[string]interface{}{}
+	for k, v := range launchConfig.Labels {
+		labels[k] = v
+	}
+	if ports == "" {
+		delete(labels, lbProxyProtocolPortsLabel)
+	} else {
+		labels[lbProxyProtocolPortsLabel] = ports
+	}
+	launchConfig.Labels = labels
+}
+
+// ensureLBProxyProtocol makes lb send the PROXY protocol header on ports, upgrading it in place
+func (r *CloudProvider) ensureLBProxyProtocol(lb *client.LoadBalancerService, ports string) (*client.LoadBalancerService, error) {
+	if lbProxyProtocolPorts(lb) == ports {
+		return lb, nil
+	}
+	launchConfig := client.LaunchConfig{}
+	if lb.LaunchConfig != nil {
+		launchConfig = *lb.LaunchConfig
+	}
+	setLBProxyProtocolLabel(&launchConfig, ports)
+	glog.Infof("Sending the PROXY protocol header on ports [%s] of LB %s", ports, lb.Name)
+	return r.upgradeLBLaunchConfig(lb, &launchConfig)
+}
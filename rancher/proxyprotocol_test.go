@@ -0,0 +1,99 @@
+package rancher
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+func TestServiceProxyProtocol(t *testing.T) {
+	ports := []api.ServicePort{
+		{Port: 80, Protocol: api.ProtocolTCP},
+		{Port: 443, Protocol: api.ProtocolTCP},
+		{Port: 53, Protocol: api.ProtocolUDP},
+	}
+	tests := []struct {
+		value      *string
+		proxyPorts string
+		invalid    bool
+	}{
+		{value: nil},
+		{value: strPtr("false")},
+		{value: strPtr("true"), proxyPorts: "80,443"},
+		{value: strPtr("*"), proxyPorts: "80,443"},
+		{value: strPtr("443"), proxyPorts: "443"},
+		{value: strPtr("80, 443"), proxyPorts: "80,443"},
+		{value: strPtr("8080"), invalid: true},
+		{value: strPtr("53"), invalid: true},
+		{value: strPtr("yes"), invalid: true},
+		{value: strPtr(""), invalid: true},
+	}
+	for _, test := range tests {
+		annotations := map[string]string{}
+		if test.value != nil {
+			annotations[annotationLBProxyProtocol] = *test.value
+		}
+		service := &api.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: annotations},
+			Spec:       api.ServiceSpec{Ports: ports},
+		}
+		found, err := serviceProxyProtocolPorts(service)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%v: expected an error, found %q", annotations, found)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", annotations, err)
+		} else if found != test.proxyPorts {
+			t.Errorf("%v: expected %q, found %q", annotations, test.proxyPorts, found)
+		}
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestLBProxyProtocolToggled(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1"})
+	defer cattle.Close()
+	r := newFakeLBCloud(t, cattle)
+	service := newLBService(map[string]string{})
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}}
+	name := lbName("kubernetes", service)
+
+	steps := []struct {
+		annotations map[string]string
+		proxyPorts  string
+	}{
+		{annotations: map[string]string{annotationLBProxyProtocol: "true"}, proxyPorts: "80"},
+		{annotations: map[string]string{annotationLBProxyProtocol: "false"}},
+		{annotations: map[string]string{annotationLBProxyProtocol: "80"}, proxyPorts: "80"},
+		{annotations: map[string]string{}},
+	}
+	lbID := ""
+	for i, step := range steps {
+		service.Annotations = step.annotations
+		if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err != nil {
+			t.Fatalf("step %d: couldn't ensure the LB: %v", i, err)
+		}
+		lb, err := r.getLBByName(name)
+		if err != nil || lb == nil {
+			t.Fatalf("step %d: expected LB %s to exist, found %#v, %v", i, name, lb, err)
+		}
+		if lbID == "" {
+			lbID = lb.Id
+		} else if lb.Id != lbID || cattle.count("loadbalancerservices") != 1 {
+			t.Errorf("step %d: expected LB %s to be updated in place, found %s", i, lbID, lb.Id)
+		}
+		if ports := lbProxyProtocolPorts(lb); ports != step.proxyPorts {
+			t.Errorf("step %d: expected the PROXY protocol on [%s], found [%s]", i, step.proxyPorts, ports)
+		}
+		if config := lb.LoadBalancerConfig; config != nil && config.HaproxyConfig != nil {
+			t.Errorf("step %d: expected no haproxy config, found %#v", i, config.HaproxyConfig)
+		}
+	}
+}
@@ -12,14 +12,18 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/rancher/go-rancher/client"
 
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 
 	api "k8s.io/kubernetes/pkg/api/v1"
+	corev1 "k8s.io/kubernetes/pkg/client/clientset_generated/clientset/typed/core/v1"
 	"k8s.io/kubernetes/pkg/cloudprovider"
 
 	"k8s.io/apimachinery/pkg/types"
@@ -33,6 +37,7 @@ type Host struct {
 type PublicEndpoint struct {
 	IPAddress string
 	Port      int
+	HostId    string
 }
 
 const (
@@ -40,6 +45,11 @@ const (
 	lbNameFormat         string = "lb-%s"
 	kubernetesEnvName    string = "kubernetes-loadbalancers"
 	kubernetesExternalId string = "kubernetes-loadbalancers://"
+	// defaultClusterName is the cluster name of the controller manager unless configured
+	defaultClusterName = "kubernetes"
+
+	// lbReadyPollInterval is how often LBs are checked while waiting for them to be ready
+	lbReadyPollInterval = 2 * time.Second
 )
 
 var allowedChars = regexp.MustCompile("[^a-zA-Z0-9-]")
@@ -50,6 +60,40 @@ type CloudProvider struct {
 	client    *client.RancherClient
 	conf      *rConfig
 	hostCache cache.Store
+	// hosts serves host lookups from a listing of all the hosts, if host-cache-ttl is positive
+	hosts *hostListCache
+	// environmentCache holds the names of environments by ID
+	environmentCache cache.Store
+	// services updates the status of nodeport mode services, if set
+	services corev1.ServicesGetter
+	// secrets reads the TLS Secrets of services, if set
+	secrets corev1.SecretsGetter
+	// endpoints reads the endpoints of the services balanced to their local endpoints, if set
+	endpoints corev1.EndpointsGetter
+	// routeRecorder records the events of the routes on their nodes, if set
+	routeRecorder record.EventRecorder
+	// serviceRecorder records the events of the services, if set
+	serviceRecorder record.EventRecorder
+
+	// The service controller may sync several services at once. The locks below serialize
+	// changes to the Rancher resources services share.
+
+	// envLock guards creating the environment of the LBs
+	envLock sync.Mutex
+	// externalServicesLock guards the external services of the hosts, which are linked to every LB
+	externalServicesLock sync.Mutex
+
+	// disconnectedSince holds when hosts were first seen disconnected by host ID, guarded by
+	// disconnectedLock
+	disconnectedLock  sync.Mutex
+	disconnectedSince map[string]time.Time
+
+	// routeFailures holds the nodes the last route creation failed for, guarded by routeFailuresLock
+	routeFailuresLock sync.Mutex
+	routeFailures     map[types.NodeName]bool
+
+	// lbUpdates coalesces the concurrent updates of the hosts of an LB
+	lbUpdates lbUpdates
 }
 
 // ProviderName returns the cloud provider ID.
@@ -77,16 +121,6 @@ func (r *CloudProvider) Instances() (cloudprovider.Instances, bool) {
 	return r, true
 }
 
-// Clusters not supported
-func (r *CloudProvider) Clusters() (cloudprovider.Clusters, bool) {
-	return nil, false
-}
-
-// Routes not supported
-func (r *CloudProvider) Routes() (cloudprovider.Routes, bool) {
-	return nil, false
-}
-
 // --- LoadBalancer Functions ---
 
 type instanceCollection struct {
@@ -115,62 +149,207 @@ func init() {
 
 // GetLoadBalancer is an implementation of LoadBalancer.GetLoadBalancer
 func (r *CloudProvider) GetLoadBalancer(clusterName string, service *api.Service) (status *api.LoadBalancerStatus, exists bool, retErr error) {
-	name := formatLBName(cloudprovider.GetLoadBalancerName(service))
+	name := lbName(clusterName, service)
 	glog.Infof("GetLoadBalancer [%s]", name)
 
-	lb, err := r.getLBByName(name)
+	mode, err := r.lbMode(service)
+	if err != nil {
+		return nil, false, err
+	}
+	if mode == nodePortLBMode {
+		status := service.Status.LoadBalancer
+		return &status, len(status.Ingress) > 0, nil
+	}
+
+	lb, err := r.getAdoptedLB(service)
 	if err != nil {
 		return nil, false, err
 	}
+	if lb == nil {
+		lb, err = r.getServiceLB(clusterName, service)
+		if err != nil {
+			return nil, false, err
+		}
+	}
 
 	if lb == nil {
 		glog.Infof("Can't find lb by name [%s]", name)
 		return &api.LoadBalancerStatus{}, false, nil
 	}
 
-	return r.toLBStatus(lb)
+	// The LB doesn't serve the service until it forwards all of its ports
+	lbPorts, err := serviceLBPorts(service.Spec.Ports)
+	if err != nil {
+		return nil, true, err
+	}
+	if missing := withoutStrings(lbPorts, lbForwardedPorts(lb)); len(missing) > 0 {
+		glog.Infof("LB %s doesn't forward ports %v of service %s", lb.Name, missing, serviceKey(service))
+		return &api.LoadBalancerStatus{}, true, nil
+	}
+
+	status, exists, err = r.toLBStatus(lb)
+	if err != nil {
+		return nil, exists, err
+	}
+	return loadBalancerIPStatus(status, service.Spec.LoadBalancerIP), exists, nil
 }
 
 // EnsureLoadBalancer is an implementation of LoadBalancer.EnsureLoadBalancer.
 func (r *CloudProvider) EnsureLoadBalancer(clusterName string, service *api.Service, nodes []*api.Node) (*api.LoadBalancerStatus, error) {
+	nodes, err := r.lbNodes(service, nodes)
+	if err != nil {
+		return nil, err
+	}
 	hosts := []string{}
 
 	for _, node := range nodes {
 		hosts = append(hosts, node.Name)
 	}
 
-	name := formatLBName(cloudprovider.GetLoadBalancerName(service))
+	name := lbName(clusterName, service)
 	loadBalancerIP := service.Spec.LoadBalancerIP
 	ports := service.Spec.Ports
 	affinity := service.Spec.SessionAffinity
 	glog.Infof("EnsureLoadBalancer [%s] [%#v] [%#v] [%s] [%s]", name, loadBalancerIP, ports, hosts, affinity)
 
-	if loadBalancerIP != "" {
-		// Rancher doesn't support specifying loadBalancer IP
-		return nil, fmt.Errorf("loadBalancerIP cannot be specified for Rancher LoadBalancer")
+	mode, err := r.lbMode(service)
+	if err != nil {
+		return nil, err
+	}
+	if mode == nodePortLBMode {
+		if loadBalancerIP != "" {
+			// The service resolves to all the nodes
+			return nil, fmt.Errorf("loadBalancerIP cannot be specified for services in %s mode", nodePortLBMode)
+		}
+		// kube-proxy on the nodes handles session affinity
+		glog.Infof("Publishing the node addresses for [%s] instead of creating an LB", name)
+		return r.nodePortStatus(nodes), nil
 	}
 
-	if affinity != api.ServiceAffinityNone {
-		// Rancher supports sticky sessions, but only when configured for HTTP/HTTPS
-		return nil, fmt.Errorf("Unsupported load balancer affinity: %v", affinity)
+	if service.DeletionTimestamp != nil {
+		// The LB would outlive the service, the finalizer of the service deletes it
+		return nil, fmt.Errorf("Service %s is being deleted", serviceKey(service))
 	}
 
-	lb, err := r.getLBByName(name)
+	lbPorts, err := serviceLBPorts(ports)
 	if err != nil {
 		return nil, err
 	}
 
-	lbPorts := []string{}
-	for _, port := range ports {
-		if port.NodePort == 0 {
-			glog.Warningf("Ignoring port without NodePort: %s", port)
+	dnsName, err := serviceDNSName(service)
+	if err != nil {
+		return nil, err
+	}
+	lbConfig, err := serviceLBConfig(service)
+	if err != nil {
+		r.recordSourceRangesNotEnforced(clusterName, service, err)
+		return nil, err
+	}
+	tls, err := serviceLBTLS(service)
+	if err != nil {
+		return nil, err
+	}
+	internal, err := serviceLBInternal(service)
+	if err != nil {
+		return nil, err
+	}
+	healthCheck, err := serviceHealthCheck(service)
+	if err != nil {
+		return nil, err
+	}
+	lbHosts, err := r.localTrafficHosts(service, hosts)
+	if err != nil {
+		return nil, err
+	}
+	proxyProtocolPorts, err := serviceProxyProtocolPorts(service)
+	if err != nil {
+		return nil, err
+	}
+	lbIP, err := serviceLoadBalancerIP(service)
+	if err != nil {
+		return nil, err
+	}
+	if lbIP != "" && internal {
+		return nil, fmt.Errorf("loadBalancerIP can't be combined with %s", annotationLBInternal)
+	}
+	scheduling, err := serviceLBScheduling(service)
+	if err != nil {
+		return nil, err
+	}
+	if lbIP != "" && !scheduling.pinnable() {
+		return nil, fmt.Errorf("loadBalancerIP can't be combined with %s and %s", annotationLBScale, annotationLBHostLabel)
+	}
+
+	adopted, err := r.getAdoptedLB(service)
+	if err != nil {
+		return nil, err
+	}
+	if adopted != nil {
+		if dnsName != "" {
+			return nil, fmt.Errorf("Annotation %s can't be combined with %s", annotationDNSName, annotationExistingLBID)
+		}
+		if lbConfig != nil {
+			return nil, fmt.Errorf("Annotations %s, %s and ClientIP session affinity can't be combined with %s",
+				annotationLBAlgorithm, annotationLBStickyPolicy, annotationExistingLBID)
+		}
+		if tls != nil {
+			return nil, fmt.Errorf("Annotation %s can't be combined with %s", annotationLBTLSSecret, annotationExistingLBID)
+		}
+		if internal {
+			return nil, fmt.Errorf("Annotation %s can't be combined with %s", annotationLBInternal, annotationExistingLBID)
+		}
+		if proxyProtocolPorts != "" {
+			return nil, fmt.Errorf("Annotation %s can't be combined with %s", annotationLBProxyProtocol, annotationExistingLBID)
+		}
+		if lbIP != "" {
+			return nil, fmt.Errorf("loadBalancerIP can't be combined with %s", annotationExistingLBID)
+		}
+		if !scheduling.pinnable() {
+			return nil, fmt.Errorf("Annotations %s and %s can't be combined with %s",
+				annotationLBScale, annotationLBHostLabel, annotationExistingLBID)
+		}
+		if hasHealthCheckAnnotations(service) {
+			return nil, fmt.Errorf("Annotations %s can't be combined with %s",
+				strings.Join(healthCheckAnnotations, ", "), annotationExistingLBID)
+		}
+		return r.ensureAdoptedLB(adopted, service, lbPorts, lbHosts)
+	}
+
+	lb, err := r.getServiceLB(clusterName, service)
+	if err != nil {
+		return nil, err
+	}
+	if lb != nil {
+		// LBs created before the names included the cluster keep their name, and so their
+		// certificate
+		name = lb.Name
+	}
+
+	if err := r.checkDNSNameConflict(dnsName, name); err != nil {
+		return nil, err
+	}
+
+	requestedHost := ""
+	if lbIP != "" {
+		requestedHost, err = r.hostIDWithIP(lbIP)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't place the LB of service %s. Error: %v", serviceKey(service), err)
 		}
-		lbPorts = append(lbPorts, fmt.Sprintf("%v:%v/tcp", port.Port, port.NodePort))
 	}
 
-	if lb != nil && portsChanged(lbPorts, lb.LaunchConfig.Ports) {
+	certID, sslPorts := "", ""
+	if tls != nil {
+		certID, err = r.ensureLBCertificate(name, tls)
+		if err != nil {
+			return nil, err
+		}
+		sslPorts = strings.Join(tls.ports, ",")
+	}
+
+	if lb != nil && (portsChanged(lbPorts, lbForwardedPorts(lb)) || lbInternal(lb) != internal) {
 		glog.Infof("Deleting the lb because the ports changed %s", lb.Name)
-		// Cannot update ports on an LB, so if the ports have changed, need to recreate
+		// Cannot update ports on an LB, so if the ports have changed, need to recreate. Publishing
+		// them or not is a change of ports too.
 		err = r.deleteLoadBalancer(lb)
 		if err != nil {
 			return nil, err
@@ -178,27 +357,85 @@ func (r *CloudProvider) EnsureLoadBalancer(clusterName string, service *api.Serv
 		lb = nil
 	}
 
-	if lb == nil {
-		env, err := r.getOrCreateEnvironment()
+	if lb != nil {
+		lb, err = r.ensureLBConfig(lb, lbConfig)
+		if err != nil {
+			return nil, err
+		}
+		hadCert := lb.DefaultCertificateId != ""
+		lb, err = r.ensureLBTLS(lb, certID, sslPorts)
+		if err != nil {
+			return nil, err
+		}
+		if hadCert && tls == nil {
+			if err := r.deleteLBCertificate(name); err != nil {
+				return nil, err
+			}
+		}
+		lb, err = r.ensureLBProxyProtocol(lb, proxyProtocolPorts)
 		if err != nil {
 			return nil, err
 		}
+		lb, err = r.ensureLBScheduling(lb, scheduling)
+		if err != nil {
+			return nil, err
+		}
+		lb, err = r.ensureLBOwnership(lb, clusterName, service)
+		if err != nil {
+			return nil, err
+		}
+		// Without a loadBalancerIP the LB stays on the host it was pinned to
+		if requestedHost != "" {
+			lb, err = r.ensureLBRequestedHost(lb, requestedHost)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
 
+	created := false
+
+	if lb == nil {
 		lb = &client.LoadBalancerService{
-			Name:          name,
-			EnvironmentId: env.Id,
-			LaunchConfig: &client.LaunchConfig{
-				Ports: lbPorts,
-			},
+			Name:                 name,
+			LaunchConfig:         &client.LaunchConfig{},
+			LoadBalancerConfig:   lbConfig,
+			DefaultCertificateId: certID,
 		}
+		if internal {
+			lb.LaunchConfig.Expose = lbPorts
+			lb.AssignServiceIpAddress = true
+		} else {
+			lb.LaunchConfig.Ports = lbPorts
+		}
+		if dnsName != "" {
+			setLBDNSLabels(lb.LaunchConfig, dnsName)
+		}
+		if sslPorts != "" {
+			setLBSSLPortsLabel(lb.LaunchConfig, sslPorts)
+		}
+		if proxyProtocolPorts != "" {
+			setLBProxyProtocolLabel(lb.LaunchConfig, proxyProtocolPorts)
+		}
+
+		lb.Metadata = map[string]interface{}{}
+		for k, v := range lbOwnershipMetadata(clusterName, service) {
+			lb.Metadata[k] = v
+		}
+		lb.LaunchConfig.RequestedHostId = requestedHost
+		if !scheduling.global {
+			lb.Scale = scheduling.scale
+		}
+		setLBSchedulingLabels(lb.LaunchConfig, scheduling)
 
-		lb, err = r.client.LoadBalancerService.Create(lb)
+		lb, err = r.createLB(clusterName, lb)
 		if err != nil {
-			return nil, fmt.Errorf("Unable to create load balancer for service %s. Error: %#v", name, err)
+			return nil, err
 		}
+		created = true
 	}
 
-	err = r.setLBHosts(lb, hosts)
+	err = r.setLBHosts(lb, lbHosts, healthCheck)
 	if err != nil {
 		return nil, err
 	}
@@ -229,10 +466,21 @@ func (r *CloudProvider) EnsureLoadBalancer(clusterName string, service *api.Serv
 	}
 	lb = convertLB(lbInterface)
 
-	epChannel := r.waitForLBPublicEndpoints(1, lb)
-	_, ok = <-epChannel
-	if !ok {
-		return nil, fmt.Errorf("Couldn't get publicEndpoints for LB %s", name)
+	lb, err = r.ensureLBDNSName(lb, dnsName)
+	if err != nil {
+		return nil, err
+	}
+
+	if internal {
+		if _, ok := <-r.waitForLBVip(lb); !ok {
+			return nil, fmt.Errorf("Couldn't get the managed network IP of LB %s", name)
+		}
+	} else {
+		epChannel := r.waitForLBPublicEndpoints(1, lb)
+		_, ok = <-epChannel
+		if !ok {
+			return nil, fmt.Errorf("Couldn't get publicEndpoints for LB %s", name)
+		}
 	}
 
 	lb, err = r.reloadLBService(lb)
@@ -240,14 +488,65 @@ func (r *CloudProvider) EnsureLoadBalancer(clusterName string, service *api.Serv
 		return nil, fmt.Errorf("Error creating LB %s. Couldn't reload LB to get status. Error: %#v", name, err)
 	}
 
+	lb, err = r.waitForLBReady(lb)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the address Rancher chose for the LB
+	if created && requestedHost == "" && !internal && scheduling.pinnable() {
+		lb, err = r.pinLBToEndpointHost(lb)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dnsName != "" && lb.Fqdn == "" {
+		if lbInterface, ok := <-r.waitForLBFqdn(lb); ok {
+			lb = convertLB(lbInterface)
+		} else {
+			glog.Warningf("External DNS didn't report the FQDN of LB %s yet", name)
+		}
+	}
+
 	status, _, err := r.toLBStatus(lb)
 	if err != nil {
 		return nil, err
 	}
+	status = loadBalancerIPStatus(status, lbIP)
+	if lbIP != "" && len(status.Ingress) == 0 {
+		return nil, fmt.Errorf("LB %s doesn't serve on loadBalancerIP %s yet", name, lbIP)
+	}
 
 	return status, nil
 }
 
+// serviceLBPorts returns the LB ports forwarding the ports of a service to its node ports, with
+// their protocols. A port can't be forwarded with both protocols, Rancher LBs identify their
+// listeners by port.
+func serviceLBPorts(ports []api.ServicePort) ([]string, error) {
+	lbPorts := []string{}
+	protocols := map[int32]api.Protocol{}
+	for _, port := range ports {
+		if port.NodePort == 0 {
+			glog.Warningf("Ignoring port without NodePort: %v", port)
+		}
+		protocol := port.Protocol
+		if protocol == "" {
+			protocol = api.ProtocolTCP
+		}
+		if protocol != api.ProtocolTCP && protocol != api.ProtocolUDP {
+			return nil, fmt.Errorf("Unsupported protocol %s of port %d, must be %s or %s", protocol, port.Port, api.ProtocolTCP, api.ProtocolUDP)
+		}
+		if other, ok := protocols[port.Port]; ok && other != protocol {
+			return nil, fmt.Errorf("Port %d can't be forwarded with both %s and %s by a Rancher LB", port.Port, other, protocol)
+		}
+		protocols[port.Port] = protocol
+		lbPorts = append(lbPorts, fmt.Sprintf("%v:%v/%s", port.Port, port.NodePort, strings.ToLower(string(protocol))))
+	}
+	return lbPorts, nil
+}
+
 func (r *CloudProvider) waitForLBPublicEndpoints(count int, lb *client.LoadBalancerService) <-chan interface{} {
 	cb := func(result chan<- interface{}) (bool, error) {
 		lb, err := r.reloadLBService(lb)
@@ -263,6 +562,39 @@ func (r *CloudProvider) waitForLBPublicEndpoints(count int, lb *client.LoadBalan
 	return r.waitForAction("publicEndpoints", cb)
 }
 
+// waitForLBReady waits for lb to be active and healthy if configured to, so its address isn't
+// published before it serves traffic. It fails once lb-ready-timeout passed.
+func (r *CloudProvider) waitForLBReady(lb *client.LoadBalancerService) (*client.LoadBalancerService, error) {
+	if !r.conf.Global.WaitForLBReady {
+		return lb, nil
+	}
+
+	glog.Infof("Waiting up to %v for LB %s to be active and healthy", r.conf.lbReadyTimeout, lb.Name)
+	err := wait.PollImmediate(lbReadyPollInterval, r.conf.lbReadyTimeout, func() (bool, error) {
+		current, err := r.reloadLBService(lb)
+		if err != nil {
+			glog.Errorf("Error waiting for LB %s to be ready: %v", lb.Name, err)
+			return false, nil
+		}
+		lb = current
+		return lbReady(lb), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil, fmt.Errorf("LB %s is not ready after %v: waiting for state active and health healthy, found state %s and health %s",
+			lb.Name, r.conf.lbReadyTimeout, lb.State, lb.HealthState)
+	}
+	return lb, err
+}
+
+// lbReady tells whether the containers of lb are running and pass their health checks
+func lbReady(lb *client.LoadBalancerService) bool {
+	if !strings.EqualFold(lb.State, "active") {
+		return false
+	}
+	// Rancher versions without health states only report the state
+	return lb.HealthState == "" || strings.EqualFold(lb.HealthState, "healthy")
+}
+
 func (r *CloudProvider) reloadLBService(lb *client.LoadBalancerService) (*client.LoadBalancerService, error) {
 	lb, err := r.client.LoadBalancerService.ById(lb.Id)
 	if err != nil {
@@ -271,6 +603,15 @@ func (r *CloudProvider) reloadLBService(lb *client.LoadBalancerService) (*client
 	return lb, nil
 }
 
+// updateLB updates lb with updates, padded to a length the client can send by padUpdates
+func (r *CloudProvider) updateLB(lb *client.LoadBalancerService, updates map[string]interface{}) (*client.LoadBalancerService, error) {
+	padded, err := padUpdates(updates, lb.Name, lb.Description)
+	if err != nil {
+		return nil, err
+	}
+	return r.client.LoadBalancerService.Update(lb, padded)
+}
+
 func convertLB(intf interface{}) *client.LoadBalancerService {
 	lb, ok := intf.(*client.LoadBalancerService)
 	if !ok {
@@ -281,15 +622,51 @@ func convertLB(intf interface{}) *client.LoadBalancerService {
 
 // UpdateLoadBalancer is an implementation of LoadBalancer.UpdateLoadBalancer.
 func (r *CloudProvider) UpdateLoadBalancer(clusterName string, service *api.Service, nodes []*api.Node) error {
+	name := lbName(clusterName, service)
+	return r.lbUpdates.run(name, func() error {
+		return r.updateLoadBalancer(clusterName, service, nodes)
+	})
+}
+
+func (r *CloudProvider) updateLoadBalancer(clusterName string, service *api.Service, nodes []*api.Node) error {
+	nodes, err := r.lbNodes(service, nodes)
+	if err != nil {
+		return err
+	}
 	hosts := []string{}
 
 	for _, node := range nodes {
 		hosts = append(hosts, node.Name)
 	}
 
-	name := formatLBName(cloudprovider.GetLoadBalancerName(service))
+	name := lbName(clusterName, service)
 	glog.Infof("UpdateLoadBalancer [%s] [%s]", name, hosts)
-	lb, err := r.getLBByName(name)
+	mode, err := r.lbMode(service)
+	if err != nil {
+		return err
+	}
+	if mode == nodePortLBMode {
+		return r.updateNodePortStatus(service, nodes)
+	}
+
+	adopted, err := r.getAdoptedLB(service)
+	if err != nil {
+		return err
+	}
+	if adopted != nil {
+		lbPorts, err := serviceLBPorts(service.Spec.Ports)
+		if err != nil {
+			return err
+		}
+		lbHosts, err := r.localTrafficHosts(service, hosts)
+		if err != nil {
+			return err
+		}
+		_, err = r.adoptLB(adopted, service, lbPorts, lbHosts)
+		return err
+	}
+
+	lb, err := r.getServiceLB(clusterName, service)
 	if err != nil {
 		return err
 	}
@@ -297,13 +674,54 @@ func (r *CloudProvider) UpdateLoadBalancer(clusterName string, service *api.Serv
 	if lb == nil {
 		return fmt.Errorf("Couldn't find LB with name %s", name)
 	}
+	name = lb.Name
 
-	err = r.deleteLBConsumedServices(lb)
+	lbConfig, err := serviceLBConfig(service)
 	if err != nil {
+		r.recordSourceRangesNotEnforced(clusterName, service, err)
 		return err
 	}
-
-	err = r.setLBHosts(lb, hosts)
+	tls, err := serviceLBTLS(service)
+	if err != nil {
+		return err
+	}
+	healthCheck, err := serviceHealthCheck(service)
+	if err != nil {
+		return err
+	}
+	lbHosts, err := r.localTrafficHosts(service, hosts)
+	if err != nil {
+		return err
+	}
+	lbIP, err := serviceLoadBalancerIP(service)
+	if err != nil {
+		return err
+	}
+	requestedHost := ""
+	if lbIP != "" {
+		requestedHost, err = r.hostIDWithIP(lbIP)
+		if err != nil {
+			return fmt.Errorf("Couldn't place the LB of service %s. Error: %v", serviceKey(service), err)
+		}
+	}
+	lb, err = r.ensureLBConfig(lb, lbConfig)
+	if err != nil {
+		return err
+	}
+	// Move the LB back to the host with the loadBalancerIP if it was repinned
+	if requestedHost != "" {
+		lb, err = r.ensureLBRequestedHost(lb, requestedHost)
+		if err != nil {
+			return err
+		}
+	}
+	// Rotate the certificate, the service controller only ensures the LB when the service changes
+	if tls != nil && lb.DefaultCertificateId != "" {
+		if _, err := r.ensureLBCertificate(name, tls); err != nil {
+			return err
+		}
+	}
+	err = r.setLBHosts(lb, lbHosts, healthCheck)
 	if err != nil {
 		return err
 	}
@@ -313,52 +731,281 @@ func (r *CloudProvider) UpdateLoadBalancer(clusterName string, service *api.Serv
 
 // EnsureLoadBalancerDeleted is an implementation of LoadBalancer.EnsureLoadBalancerDeleted.
 func (r *CloudProvider) EnsureLoadBalancerDeleted(clusterName string, service *api.Service) error {
-	name := formatLBName(cloudprovider.GetLoadBalancerName(service))
+	name := lbName(clusterName, service)
 	glog.Infof("EnsureLoadBalancerDeleted [%s]", name)
-	lb, err := r.getLBByName(name)
+	if mode, err := r.lbMode(service); err == nil && mode == nodePortLBMode {
+		// There is nothing in Rancher to delete, the service controller clears the status
+		return nil
+	}
+	if _, ok := service.Annotations[annotationExistingLBID]; ok {
+		// Adopted LBs are never deleted
+		return r.releaseAdoptedLB(service)
+	}
+
+	lb, err := r.getServiceLB(clusterName, service)
 	if err != nil {
 		return err
 	}
 
 	if lb == nil {
-		glog.Infof("Couldn't find LB %s to delete. Nothing to do.")
+		glog.Infof("Couldn't find LB %s to delete. Nothing to do.", name)
 		return nil
 	}
+	return r.deleteLB(lb)
+}
+
+// deleteLB deletes lb along with its certificate, and its stack once empty
+func (r *CloudProvider) deleteLB(lb *client.LoadBalancerService) error {
+	if err := r.deleteLoadBalancer(lb); err != nil {
+		return err
+	}
+	if lb.DefaultCertificateId != "" {
+		if err := r.deleteLBCertificate(lb.Name); err != nil {
+			return err
+		}
+	}
+	return r.deleteEnvironmentIfEmpty(lb.EnvironmentId)
+}
+
+// lbEnvironmentName returns the name and the external ID of the stack holding the LBs of the
+// cluster named clusterName. The default cluster keeps the stack shared by all clusters before.
+func lbEnvironmentName(clusterName string) (string, string) {
+	cluster := buildExternalServiceName(clusterName)
+	if cluster == "" || cluster == defaultClusterName {
+		return kubernetesEnvName, kubernetesExternalId
+	}
+	return kubernetesEnvName + "-" + cluster, kubernetesExternalId + cluster
+}
+
+// createLB creates lb in the stack of the LBs of the cluster named clusterName, creating the stack
+// if it doesn't exist
+func (r *CloudProvider) createLB(clusterName string, lb *client.LoadBalancerService) (*client.LoadBalancerService, error) {
+	r.envLock.Lock()
+	defer r.envLock.Unlock()
+
+	env, err := r.getOrCreateEnvironment(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	lb.EnvironmentId = env.Id
+	created, err := r.client.LoadBalancerService.Create(lb)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create load balancer for service %s. Error: %#v", lb.Name, err)
+	}
+	return created, nil
+}
+
+// getOrCreateEnvironment returns the stack of the LBs of the cluster named clusterName, creating
+// it if it doesn't exist. Callers must hold envLock.
+func (r *CloudProvider) getOrCreateEnvironment(clusterName string) (*client.Environment, error) {
+	env, err := r.getEnvironment(clusterName)
+	if err != nil || env != nil {
+		return env, err
+	}
 
-	return r.deleteLoadBalancer(lb)
+	name, externalID := lbEnvironmentName(clusterName)
+	env = &client.Environment{
+		Name:       name,
+		ExternalId: externalID,
+	}
+
+	env, err = r.client.Environment.Create(env)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create environment for kubernetes LBs. Error: %#v", err)
+	}
+	return env, nil
 }
 
-func (r *CloudProvider) getOrCreateEnvironment() (*client.Environment, error) {
+// getEnvironment returns the stack of the LBs of the cluster named clusterName, or nil if it
+// doesn't exist
+func (r *CloudProvider) getEnvironment(clusterName string) (*client.Environment, error) {
+	name, externalID := lbEnvironmentName(clusterName)
 	opts := client.NewListOpts()
-	opts.Filters["name"] = kubernetesEnvName
+	opts.Filters["name"] = name
 	opts.Filters["removed_null"] = "1"
-	opts.Filters["external_id"] = kubernetesExternalId
+	opts.Filters["external_id"] = externalID
 
 	envs, err := r.client.Environment.List(opts)
 	if err != nil {
-		return nil, fmt.Errorf("Coudln't get host by name [%s]. Error: %#v", kubernetesEnvName, err)
+		return nil, fmt.Errorf("Coudln't get host by name [%s]. Error: %#v", name, err)
 	}
 
 	if len(envs.Data) >= 1 {
 		return &envs.Data[0], nil
 	}
+	return nil, nil
+}
 
-	env := &client.Environment{
-		Name:       kubernetesEnvName,
-		ExternalId: kubernetesExternalId,
+// deleteEnvironmentIfEmpty deletes the stack of LBs with the given ID once it holds no more
+// services. Stacks the provider didn't create are left alone.
+func (r *CloudProvider) deleteEnvironmentIfEmpty(envID string) error {
+	r.envLock.Lock()
+	defer r.envLock.Unlock()
+
+	opts := client.NewListOpts()
+	opts.Filters["environmentId"] = envID
+	opts.Filters["removed_null"] = "1"
+	services, err := r.client.Service.List(opts)
+	if err != nil {
+		return fmt.Errorf("Couldn't list the services of environment %s. Error: %#v", envID, err)
+	}
+	for _, service := range services.Data {
+		if !serviceRemoved(service.State) {
+			return nil
+		}
 	}
 
-	env, err = r.client.Environment.Create(env)
+	env, err := r.client.Environment.ById(envID)
+	if apiErr, ok := err.(*client.ApiError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("Couldn't create environment for kubernetes LBs. Error: %#v", err)
+		return fmt.Errorf("Couldn't get environment %s. Error: %#v", envID, err)
 	}
-	return env, nil
+	if env == nil || env.Removed != "" || !strings.HasPrefix(env.ExternalId, kubernetesExternalId) {
+		return nil
+	}
+	glog.Infof("Deleting environment %s, it holds no more LBs", env.Name)
+	if err := r.client.Environment.Delete(env); err != nil {
+		return fmt.Errorf("Couldn't delete environment %s. Error: %#v", env.Name, err)
+	}
+	return nil
+}
+
+// serviceRemoved tells whether a service in state is being removed
+func serviceRemoved(state string) bool {
+	switch strings.ToLower(state) {
+	case "removing", "removed", "purging", "purged":
+		return true
+	}
+	return false
+}
+
+// setLBHosts links the external services of hosts checked with check to lb
+func (r *CloudProvider) setLBHosts(lb *client.LoadBalancerService, hosts []string, check *client.InstanceHealthCheck) error {
+	r.externalServicesLock.Lock()
+	defer r.externalServicesLock.Unlock()
+
+	serviceIDs, err := r.hostExternalServices(lb, hosts, check)
+	if err != nil {
+		return err
+	}
+	current, err := r.lbServiceLinks(lb)
+	if err != nil {
+		return err
+	}
+	if err := r.setLBServiceLinks(lb, current, serviceIDs); err != nil {
+		return err
+	}
+	// The external services of a former health check are left unlinked
+	for _, id := range withoutStrings(current, serviceIDs) {
+		r.deleteUnusedService(id)
+	}
+	return nil
+}
+
+// lbServiceLinks returns the IDs of the services linked to lb
+func (r *CloudProvider) lbServiceLinks(lb *client.LoadBalancerService) ([]string, error) {
+	coll := &client.ServiceCollection{}
+	if err := r.client.GetLink(lb.Resource, "consumedservices", coll); err != nil {
+		return nil, fmt.Errorf("Couldn't get consumed services of LB %s. Error: %#v", lb.Name, err)
+	}
+	ids := []string{}
+	for _, service := range coll.Data {
+		ids = append(ids, service.Id)
+	}
+	return ids, nil
+}
+
+// setLBServiceLinks replaces the current service links of lb with the services with the given
+// IDs. Only the links added or removed change, the balancing of the others is left alone.
+func (r *CloudProvider) setLBServiceLinks(lb *client.LoadBalancerService, current, serviceIDs []string) error {
+	if sameServiceIDs(current, serviceIDs) {
+		glog.V(4).Infof("Service links of LB %s are up to date", lb.Name)
+		return nil
+	}
+
+	for _, id := range withoutStrings(serviceIDs, current) {
+		if err := r.changeLBServiceLink(lb, "addservicelink", id); err != nil {
+			return err
+		}
+	}
+	for _, id := range withoutStrings(current, serviceIDs) {
+		if err := r.changeLBServiceLink(lb, "removeservicelink", id); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (r *CloudProvider) setLBHosts(lb *client.LoadBalancerService, hosts []string) error {
-	serviceLinks := &client.SetLoadBalancerServiceLinksInput{}
+// changeLBServiceLink adds or removes the link of lb to the service with the given ID, with the
+// addservicelink or removeservicelink action
+func (r *CloudProvider) changeLBServiceLink(lb *client.LoadBalancerService, action, serviceID string) error {
+	actionChannel := r.waitForLBAction(action, lb)
+	lbInterface, ok := <-actionChannel
+	if !ok {
+		return fmt.Errorf("Couldn't call %s on LB %s", action, lb.Name)
+	}
+	lb = convertLB(lbInterface)
+
+	input := &client.AddRemoveLoadBalancerServiceLinkInput{ServiceLink: client.LoadBalancerServiceLink{ServiceId: serviceID}}
+	var err error
+	if action == "addservicelink" {
+		_, err = r.client.LoadBalancerService.ActionAddservicelink(lb, input)
+	} else {
+		_, err = r.client.LoadBalancerService.ActionRemoveservicelink(lb, input)
+	}
+	if err != nil {
+		return fmt.Errorf("Error setting hosts for LB %s. Couldn't call %s for service %s. Error: %#v.", lb.Name, action, serviceID, err)
+	}
+	return nil
+}
+
+// deleteUnusedService deletes the service with the given ID if no other service consumes it
+func (r *CloudProvider) deleteUnusedService(id string) {
+	service, err := r.client.Service.ById(id)
+	if err != nil || service == nil {
+		glog.Warningf("Couldn't get service %s. It won't be deleted. Error: %#v", id, err)
+		return
+	}
+	consumedBy := &client.ServiceCollection{}
+	if err := r.client.GetLink(service.Resource, "consumedbyservices", consumedBy); err != nil {
+		glog.Errorf("Error getting consumedby services for service %s. This service won't be deleted. Error: %#v", id, err)
+		return
+	}
+	if len(consumedBy.Data) > 0 {
+		return
+	}
+	if err := r.client.Service.Delete(service); err != nil {
+		glog.Warningf("Error deleting service %s. Moving on. Error: %#v", id, err)
+	}
+}
+
+// sameServiceIDs tells whether a and b hold the same service IDs, in any order
+func sameServiceIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	ids := map[string]int{}
+	for _, id := range a {
+		ids[id]++
+	}
+	for _, id := range b {
+		if ids[id] == 0 {
+			return false
+		}
+		ids[id]--
+	}
+	return true
+}
+
+// hostExternalServices returns the IDs of the active external services of hosts checked with
+// check in the environment of lb, creating the missing ones. Callers must hold
+// externalServicesLock.
+func (r *CloudProvider) hostExternalServices(lb *client.LoadBalancerService, hosts []string, check *client.InstanceHealthCheck) ([]string, error) {
+	serviceIDs := []string{}
 	for _, hostname := range hosts {
-		extSvcName := buildExternalServiceName(hostname)
+		extSvcName := hostExternalServiceName(hostname, check)
 		opts := client.NewListOpts()
 		opts.Filters["name"] = extSvcName
 		opts.Filters["environmentId"] = lb.EnvironmentId
@@ -366,16 +1013,19 @@ func (r *CloudProvider) setLBHosts(lb *client.LoadBalancerService, hosts []strin
 
 		exSvces, err := r.client.ExternalService.List(opts)
 		if err != nil {
-			return fmt.Errorf("Couldn't get external service %s for LB %s. Error: %#v.", extSvcName, lb.Name, err)
+			return nil, fmt.Errorf("Couldn't get external service %s for LB %s. Error: %#v.", extSvcName, lb.Name, err)
 		}
 
 		var exSvc *client.ExternalService
 		if len(exSvces.Data) > 0 {
-			exSvc = &exSvces.Data[0]
+			exSvc, err = r.refreshExternalServiceIP(&exSvces.Data[0], hostname)
+			if err != nil {
+				return nil, fmt.Errorf("Couldn't update external service %s for LB %s. Error: %#v", extSvcName, lb.Name, err)
+			}
 		} else {
 			host, err := r.hostGetOrFetchFromCache(hostname)
 			if err != nil {
-				return fmt.Errorf("Couldn't create extrnal service %s for LB %s. Error: %#v", hostname, lb.Name, err)
+				return nil, fmt.Errorf("Couldn't create extrnal service %s for LB %s. Error: %#v", hostname, lb.Name, err)
 			}
 
 			if len(host.IPAddresses) < 1 {
@@ -386,10 +1036,11 @@ func (r *CloudProvider) setLBHosts(lb *client.LoadBalancerService, hosts []strin
 				Name:                extSvcName,
 				ExternalIpAddresses: []string{host.IPAddresses[0].Address},
 				EnvironmentId:       lb.EnvironmentId,
+				HealthCheck:         check,
 			}
 			exSvc, err = r.client.ExternalService.Create(exSvc)
 			if err != nil {
-				return fmt.Errorf("Error setting hosts for LB %s. Couldn't create external service for host %s. Error: %#v",
+				return nil, fmt.Errorf("Error setting hosts for LB %s. Couldn't create external service for host %s. Error: %#v",
 					lb.Name, extSvcName, err)
 			}
 		}
@@ -398,7 +1049,7 @@ func (r *CloudProvider) setLBHosts(lb *client.LoadBalancerService, hosts []strin
 			actionChannel := r.waitForSvcAction("activate", exSvc)
 			svcInterface, ok := <-actionChannel
 			if !ok {
-				return fmt.Errorf("Couldn't call activate on external service %s for LB %s", exSvc.Id, lb.Name)
+				return nil, fmt.Errorf("Couldn't call activate on external service %s for LB %s", exSvc.Id, lb.Name)
 			}
 			exSvc, ok = svcInterface.(*client.ExternalService)
 			if !ok {
@@ -407,26 +1058,28 @@ func (r *CloudProvider) setLBHosts(lb *client.LoadBalancerService, hosts []strin
 
 			_, err = r.client.ExternalService.ActionActivate(exSvc)
 			if err != nil {
-				return fmt.Errorf("Couldn't activate service for LB %s. Error: %#v", lb.Name, err)
+				return nil, fmt.Errorf("Couldn't activate service for LB %s. Error: %#v", lb.Name, err)
 			}
 		}
-		serviceLinks.ServiceLinks = append(serviceLinks.ServiceLinks, &client.LoadBalancerServiceLink{ServiceId: exSvc.Id})
-
+		serviceIDs = append(serviceIDs, exSvc.Id)
 	}
+	return serviceIDs, nil
+}
 
-	actionChannel := r.waitForLBAction("setservicelinks", lb)
-	lbInterface, ok := <-actionChannel
-	if !ok {
-		return fmt.Errorf("Couldn't call setservicelinks on LB %s", lb.Name)
+// refreshExternalServiceIP points the external service of the host with the given name to the
+// current IP of the host, the external services are kept across the updates of the LBs
+func (r *CloudProvider) refreshExternalServiceIP(exSvc *client.ExternalService, hostname string) (*client.ExternalService, error) {
+	host, err := r.hostGetOrFetchFromCache(hostname)
+	if err != nil || len(host.IPAddresses) < 1 {
+		// The host is balanced to at the IP it had
+		return exSvc, nil
 	}
-	lb = convertLB(lbInterface)
-
-	_, err := r.client.LoadBalancerService.ActionSetservicelinks(lb, serviceLinks)
-	if err != nil {
-		return fmt.Errorf("Error setting hosts for LB%s. Couldn't set LB service links. Error: %#v.", lb.Name, err)
+	ip := host.IPAddresses[0].Address
+	if len(exSvc.ExternalIpAddresses) == 1 && exSvc.ExternalIpAddresses[0] == ip {
+		return exSvc, nil
 	}
-
-	return nil
+	glog.Infof("Updating the IP of external service %s to %s", exSvc.Name, ip)
+	return r.client.ExternalService.Update(exSvc, map[string]interface{}{"externalIpAddresses": []string{ip}})
 }
 
 func buildExternalServiceName(hostname string) string {
@@ -529,7 +1182,16 @@ func (r *CloudProvider) toLBStatus(lb *client.LoadBalancerService) (*api.LoadBal
 	eps := lb.PublicEndpoints
 
 	ingress := []api.LoadBalancerIngress{}
+	// Internal LBs are only reachable at their managed network IP
+	if lbInternal(lb) {
+		eps = nil
+		if lb.Vip != "" {
+			ingress = append(ingress, api.LoadBalancerIngress{IP: lb.Vip})
+		}
+	}
 
+	// The LB publishes an endpoint for every port of each of its containers
+	ips := []string{}
 	for _, epObj := range eps {
 		ep := PublicEndpoint{}
 
@@ -537,10 +1199,19 @@ func (r *CloudProvider) toLBStatus(lb *client.LoadBalancerService) (*api.LoadBal
 		if err != nil {
 			return nil, false, err
 		}
-		ingress = append(ingress, api.LoadBalancerIngress{IP: ep.IPAddress})
+		if !contains(ips, ep.IPAddress) {
+			ips = append(ips, ep.IPAddress)
+		}
+	}
+	sort.Strings(ips)
+	for _, ip := range ips {
+		ingress = append(ingress, api.LoadBalancerIngress{IP: ip})
+	}
+	if lb.Fqdn != "" && lbDNSName(lb) != "" {
+		ingress = append(ingress, api.LoadBalancerIngress{Hostname: strings.TrimSuffix(lb.Fqdn, ".")})
 	}
 
-	return &api.LoadBalancerStatus{ingress}, true, nil
+	return &api.LoadBalancerStatus{Ingress: ingress}, true, nil
 }
 
 func (r *CloudProvider) deleteLoadBalancer(lb *client.LoadBalancerService) error {
@@ -557,6 +1228,9 @@ func (r *CloudProvider) deleteLoadBalancer(lb *client.LoadBalancerService) error
 }
 
 func (r *CloudProvider) deleteLBConsumedServices(lb *client.LoadBalancerService) error {
+	r.externalServicesLock.Lock()
+	defer r.externalServicesLock.Unlock()
+
 	coll := &client.ServiceCollection{}
 	err := r.client.GetLink(lb.Resource, "consumedservices", coll)
 	if err != nil {
@@ -597,34 +1271,54 @@ func (r *CloudProvider) NodeAddresses(name types.NodeName) ([]api.NodeAddress, e
 	if err != nil {
 		return nil, err
 	}
-
-	addresses := []api.NodeAddress{}
-	for _, ip := range host.IPAddresses {
-		addresses = append(addresses, api.NodeAddress{Type: api.NodeExternalIP, Address: ip.Address})
-		addresses = append(addresses, api.NodeAddress{Type: api.NodeLegacyHostIP, Address: ip.Address})
-	}
-	addresses = append(addresses, api.NodeAddress{Type: api.NodeHostName, Address: host.RancherHost.Hostname})
-
-	return addresses, nil
+	return hostAddresses(host), nil
 }
 
 //NodeAddressesByProviderID returns the node addresses of an instances with the specified unique providerID
 // This method will not be called from the node that is requesting this ID. i.e. metadata service
 // and other local methods cannot be used here
 func (r *CloudProvider) NodeAddressesByProviderID(providerID string) ([]api.NodeAddress, error) {
-	host, err := r.hostGetById(providerID)
+	hostID, err := r.parseProviderID(providerID)
 	if err != nil {
 		return nil, err
 	}
 
+	host, err := r.hostGetById(hostID)
+	if err != nil {
+		return nil, err
+	}
+	return hostAddresses(host), nil
+}
+
+// hostPublicIPLabel is the host label telling the public IP of hosts behind NAT, also used by the
+// Rancher external DNS services
+const hostPublicIPLabel = "io.rancher.host.external_dns_ip"
+
+// hostAddresses returns the addresses of host: its IPs as internal IPs, its public IP as external IP,
+// or its IPs if it has none, and its hostname. The IPs are legacy host IPs too, for older clients.
+func hostAddresses(host *Host) []api.NodeAddress {
 	addresses := []api.NodeAddress{}
 	for _, ip := range host.IPAddresses {
-		addresses = append(addresses, api.NodeAddress{Type: api.NodeExternalIP, Address: ip.Address})
-		addresses = append(addresses, api.NodeAddress{Type: api.NodeLegacyHostIP, Address: ip.Address})
+		if ip.Address != "" {
+			addresses = append(addresses, api.NodeAddress{Type: api.NodeInternalIP, Address: ip.Address})
+		}
+	}
+	if publicIP := hostLabel(host, hostPublicIPLabel); publicIP != "" {
+		addresses = append(addresses, api.NodeAddress{Type: api.NodeExternalIP, Address: publicIP})
+	} else {
+		for _, ip := range host.IPAddresses {
+			if ip.Address != "" {
+				addresses = append(addresses, api.NodeAddress{Type: api.NodeExternalIP, Address: ip.Address})
+			}
+		}
+	}
+	for _, ip := range host.IPAddresses {
+		if ip.Address != "" {
+			addresses = append(addresses, api.NodeAddress{Type: api.NodeLegacyHostIP, Address: ip.Address})
+		}
 	}
 	addresses = append(addresses, api.NodeAddress{Type: api.NodeHostName, Address: host.RancherHost.Hostname})
-
-	return addresses, nil
+	return addresses
 }
 
 // ExternalID returns the cloud provider ID of the specified instance (deprecated).
@@ -660,7 +1354,12 @@ func (r *CloudProvider) InstanceType(name types.NodeName) (string, error) {
 // This method will not be called from the node that is requesting this ID. i.e. metadata service
 // and other local methods cannot be used here
 func (r *CloudProvider) InstanceTypeByProviderID(providerID string) (string, error) {
-	_, err := r.hostGetById(providerID)
+	hostID, err := r.parseProviderID(providerID)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = r.hostGetById(hostID)
 	if err != nil {
 		return "", err
 	}
@@ -668,18 +1367,40 @@ func (r *CloudProvider) InstanceTypeByProviderID(providerID string) (string, err
 	return "rancher", nil
 }
 
+// InstanceExistsByProviderID tells whether the host with the given providerID still exists. Hosts
+// being removed or purged don't, while hosts in any other state, even deactivated or disconnected,
+// do. Errors of the Rancher API are returned as is, they never mean the host is gone.
+func (r *CloudProvider) InstanceExistsByProviderID(providerID string) (bool, error) {
+	hostID, err := r.parseProviderID(providerID)
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := r.hosts.getByID(hostID); ok {
+		// removed hosts aren't listed
+		return true, nil
+	}
+
+	host, err := r.hostAPI().hostByID(hostID)
+	if err != nil {
+		return false, fmt.Errorf("Couldn't get host by Id [%s]. Error: %#v", hostID, err)
+	}
+	if host == nil {
+		return false, nil
+	}
+	return !hostRemoved(&host.Host), nil
+}
+
 // List lists instances that match 'filter' which is a regular expression which must match the entire instance name (fqdn)
 func (r *CloudProvider) List(filter string) ([]types.NodeName, error) {
 	glog.Infof("List %s", filter)
 
-	opts := client.NewListOpts()
-	opts.Filters["removed_null"] = "1"
-	hosts, err := r.client.Host.List(opts)
+	hosts, err := r.hostAPI().listHosts()
 	if err != nil {
 		return nil, fmt.Errorf("Coudln't get hosts by filter [%s]. Error: %#v", filter, err)
 	}
 
-	if len(hosts.Data) == 0 {
+	if len(hosts) == 0 {
 		return nil, fmt.Errorf("No hosts found")
 	}
 
@@ -693,7 +1414,7 @@ func (r *CloudProvider) List(filter string) ([]types.NodeName, error) {
 	}
 
 	retHosts := []types.NodeName{}
-	for _, host := range hosts.Data {
+	for _, host := range hosts {
 		if re.MatchString(host.Hostname) {
 			retHosts = append(retHosts, types.NodeName(host.Hostname))
 		}
@@ -742,105 +1463,207 @@ func (r *CloudProvider) getHostFromCache(name string) *Host {
 	return host
 }
 
+// InvalidateHostCache evicts cached hosts so the next lookup goes to the Rancher API, and makes it
+// list the hosts again.
+// If key is empty the whole cache is flushed, otherwise only the hosts whose
+// hostname or UUID match key are evicted. It returns the number of evicted hosts.
+func (r *CloudProvider) InvalidateHostCache(key string) int {
+	r.hosts.invalidate()
+	evicted := 0
+	for _, obj := range r.hostCache.List() {
+		host, ok := obj.(*Host)
+		if !ok {
+			continue
+		}
+		if key != "" && !strings.EqualFold(host.RancherHost.Hostname, key) && host.RancherHost.Uuid != key {
+			continue
+		}
+		if err := r.hostCache.Delete(host); err != nil {
+			glog.Warningf("Couldn't evict host %s from cache. Error: %#v", host.RancherHost.Hostname, err)
+			continue
+		}
+		evicted++
+	}
+	return evicted
+}
+
+// HostnamesByUUID returns the hostnames of the hosts keyed by their UUID, from the host list cache
+// unless it's disabled
+func (r *CloudProvider) HostnamesByUUID() (map[string]string, error) {
+	if hostnames, ok := r.hosts.hostnamesByUUID(); ok {
+		return hostnames, nil
+	}
+	hosts, err := r.listHosts()
+	if err != nil {
+		return nil, err
+	}
+	hostnames := make(map[string]string, len(hosts))
+	for _, host := range hosts {
+		hostnames[host.RancherHost.Uuid] = host.RancherHost.Hostname
+	}
+	return hostnames, nil
+}
+
 func (r *CloudProvider) hostGetOrFetchFromCache(name string) (*Host, error) {
-	host, err := r.getHostByName(name)
+	host, err := r.cachedHostByName(name)
+	if host == nil && err == nil {
+		host, err = r.getHostByName(name)
+	}
 	if err != nil {
 		if err == cloudprovider.InstanceNotFound {
 			// evict from cache
 			r.removeFromCache(name)
 			return nil, err
+		} else if _, ok := err.(*ambiguousHostError); ok {
+			// the cached host may be any of them
+			return nil, err
 		} else {
 			host := r.getHostFromCache(name)
 			if host != nil {
 				return host, nil
 			}
+			return nil, err
 		}
 	}
 	r.addHostToCache(host)
 	return host, nil
 }
 
+// hostGetById returns the host with the given ID from the host list cache, or from the Rancher
+// API if it isn't listed
 func (r *CloudProvider) hostGetById(id string) (*Host, error) {
-	rancherHost, err := r.client.Host.ById(id)
+	if host, ok := r.hosts.getByID(id); ok && len(host.IPAddresses) > 0 {
+		return host, nil
+	}
+	return r.fetchHostById(id)
+}
+
+func (r *CloudProvider) fetchHostById(id string) (*Host, error) {
+	api := r.hostAPI()
+	rancherHost, err := api.hostByID(id)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't get host by Id [%s]. Error: %#v", id, err)
 	}
 
-	if rancherHost == nil {
-		return nil, fmt.Errorf("Coudln't get host by Id [%s]", id)
+	if rancherHost == nil || hostRemoved(&rancherHost.Host) {
+		return nil, cloudprovider.InstanceNotFound
 	}
 
-	coll := &client.IpAddressCollection{}
-	err = r.client.GetLink(rancherHost.Resource, "ipAddresses", coll)
+	ips, err := api.hostIPAddresses(rancherHost)
 	if err != nil {
 		return nil, fmt.Errorf("Error getting ip addresses for node [%s]. Error: %#v", id, err)
 	}
 
-	if len(coll.Data) == 0 {
+	if len(ips) == 0 {
 		return nil, cloudprovider.InstanceNotFound
 	}
 
 	host := &Host{
-		RancherHost: rancherHost,
-		IPAddresses: coll.Data,
+		RancherHost: &rancherHost.Host,
+		IPAddresses: ips,
 	}
 
 	return host, nil
 }
 
 func (r *CloudProvider) getHostByName(name string) (*Host, error) {
-	opts := client.NewListOpts()
-	opts.Filters["removed_null"] = "1"
-	hosts, err := r.client.Host.List(opts)
+	api := r.hostAPI()
+	hosts, err := api.listHosts()
 	if err != nil {
 		return nil, fmt.Errorf("Coudln't get host by name [%s]. Error: %#v", name, err)
 	}
 
-	hostsToReturn := make([]client.Host, 0)
-	for _, host := range hosts.Data {
+	hostsToReturn := make([]listedHost, 0)
+	for _, host := range hosts {
 		if strings.EqualFold(host.Hostname, name) {
 			hostsToReturn = append(hostsToReturn, host)
 		}
 	}
 
-	if len(hostsToReturn) == 0 {
+	// A reinstalled host can leave its removed predecessor behind under the same name
+	activeHosts := make([]listedHost, 0)
+	for _, host := range hostsToReturn {
+		if !hostRemoved(&host.Host) {
+			activeHosts = append(activeHosts, host)
+		}
+	}
+
+	if len(activeHosts) == 0 {
 		return nil, cloudprovider.InstanceNotFound
 	}
 
-	if len(hostsToReturn) > 1 {
-		return nil, fmt.Errorf("multiple instances found for name: %s", name)
+	if len(activeHosts) > 1 {
+		candidates := []*client.Host{}
+		for i := range activeHosts {
+			candidates = append(candidates, &activeHosts[i].Host)
+		}
+		return nil, newAmbiguousHostError(name, candidates)
 	}
 
-	rancherHost := &hostsToReturn[0]
+	rancherHost := &activeHosts[0]
 
-	coll := &client.IpAddressCollection{}
-	err = r.client.GetLink(rancherHost.Resource, "ipAddresses", coll)
+	ips, err := api.hostIPAddresses(rancherHost)
 	if err != nil {
-		return nil, fmt.Errorf("Error getting ip addresses for node [%s]. Error: %#v", name, err)
+		return nil, err
 	}
 
-	if len(coll.Data) == 0 {
+	if len(ips) == 0 {
 		return nil, cloudprovider.InstanceNotFound
 	}
 
 	host := &Host{
-		RancherHost: rancherHost,
-		IPAddresses: coll.Data,
+		RancherHost: &rancherHost.Host,
+		IPAddresses: ips,
 	}
 
 	return host, nil
 }
 
-// --- Zones Functions ---
+// ambiguousHostError is returned when several active hosts have the name of a node, e.g. hosts of
+// several environments when no environment-id is configured
+type ambiguousHostError struct {
+	name string
+	ids  []string
+	// envs are the environments of the hosts
+	envs []string
+}
+
+func newAmbiguousHostError(name string, hosts []*client.Host) *ambiguousHostError {
+	e := &ambiguousHostError{name: name}
+	for _, host := range hosts {
+		e.ids = append(e.ids, host.Id)
+		e.envs = append(e.envs, host.AccountId)
+	}
+	return e
+}
+
+func (e *ambiguousHostError) Error() string {
+	candidates := []string{}
+	for i, id := range e.ids {
+		if e.envs[i] != "" {
+			id += " in environment " + e.envs[i]
+		}
+		candidates = append(candidates, id)
+	}
+	return fmt.Sprintf("multiple instances found for name: %s: %s", e.name, strings.Join(candidates, ", "))
+}
 
-// GetZone is an implementation of Zones.GetZone
-func (r *CloudProvider) GetZone() (cloudprovider.Zone, error) {
-	return cloudprovider.Zone{
-		FailureDomain: "FailureDomain1",
-		Region:        "Region1",
-	}, nil
+// Candidates returns the IDs of the hosts with the name of the node
+func (e *ambiguousHostError) Candidates() []string {
+	return e.ids
+}
+
+// hostRemoved tells whether host was removed, even if it's still listed
+func hostRemoved(host *client.Host) bool {
+	switch strings.ToLower(host.State) {
+	case "removing", "removed", "purging", "purged":
+		return true
+	}
+	return host.Removed != ""
 }
 
+// --- Zones Functions ---
+
 // --- Utility functions ---
 
 func Init(configFilePath string) (cloudprovider.Interface, error) {
@@ -858,50 +1681,67 @@ func Init(configFilePath string) (cloudprovider.Interface, error) {
 	return newRancherCloud(nil)
 }
 
-type configGlobal struct {
-	CattleURL       string `gcfg:"cattle-url"`
-	CattleAccessKey string `gcfg:"cattle-access-key"`
-	CattleSecretKey string `gcfg:"cattle-secret-key"`
-}
-
-type rConfig struct {
-	Global configGlobal
-}
-
 func newRancherCloud(config io.Reader) (cloudprovider.Interface, error) {
-	url := os.Getenv("CATTLE_URL")
-	accessKey := os.Getenv("CATTLE_ACCESS_KEY")
-	secretKey := os.Getenv("CATTLE_SECRET_KEY")
-	conf := rConfig{
-		Global: configGlobal{
-			CattleURL:       url,
-			CattleAccessKey: accessKey,
-			CattleSecretKey: secretKey,
-		},
+	conf, err := readConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Using providerID scheme [%s]", conf.Global.ProviderIDScheme)
+	if conf.Global.EnvironmentID != "" {
+		glog.Infof("Using Rancher environment [%s]", conf.Global.EnvironmentID)
+	} else {
+		glog.Warningf("environment-id isn't set: nodes are looked up in every environment the API key has access to, " +
+			"and nodes whose hostname several environments have can't be resolved")
 	}
+	if conf.Global.InsecureSkipVerify {
+		glog.Warningf("insecure-skip-verify is set: the certificate of the Rancher API is NOT verified, "+
+			"the API credentials can be intercepted by anyone able to impersonate %v", conf.Global.CattleURLs)
+	}
+
 	client, err := getRancherClient(conf)
 	if err != nil {
-		return nil, fmt.Errorf("Could not create rancher client: %#v", err)
+		return nil, fmt.Errorf("Could not create rancher client: %v", err)
+	}
+	glog.Infof("Using Rancher API [%s]", conf.apiVersion)
+	if conf.apiVersion == apiVersionV3 && conf.Global.LoadBalancerMode == rancherLBMode {
+		return nil, fmt.Errorf("Invalid cloud config: Rancher load balancers aren't available with the %s API, set load-balancer-mode = %s",
+			apiVersionV3, nodePortLBMode)
 	}
 
 	cache := cache.NewTTLStore(hostStoreKeyFunc, time.Duration(24)*time.Hour)
 
-	return &CloudProvider{
-		client:    client,
-		conf:      &conf,
-		hostCache: cache,
-	}, nil
+	r := &CloudProvider{
+		client:           client,
+		conf:             conf,
+		hostCache:        cache,
+		environmentCache: newEnvironmentCache(),
+	}
+	r.hosts = newHostListCache(conf.hostCacheTTL, r.listHosts)
+	return r, nil
 }
 
 func hostStoreKeyFunc(obj interface{}) (string, error) {
 	return obj.(*Host).RancherHost.Hostname, nil
 }
 
-func getRancherClient(conf rConfig) (*client.RancherClient, error) {
+// getRancherClient creates the client of the Rancher API, detecting the version of the API if it
+// isn't configured
+func getRancherClient(conf *rConfig) (*client.RancherClient, error) {
+	if err := registerAPIEndpoints(conf.Global.CattleURLs, conf.Global.Token, conf.tls); err != nil {
+		return nil, err
+	}
+	setMaxAttempts(conf.Global.CattleURLs, conf.Global.MaxRequestAttempts)
+	conf.apiVersion = conf.Global.APIVersion
+	if conf.apiVersion == "" {
+		conf.apiVersion = detectAPIVersion(conf.Global.CattleURLs[0])
+	}
+
+	// requests to the first url fail over to the others in the rancherTransport
 	return client.NewRancherClient(&client.ClientOpts{
-		Url:       conf.Global.CattleURL,
+		Url:       conf.apiURL(),
 		AccessKey: conf.Global.CattleAccessKey,
 		SecretKey: conf.Global.CattleSecretKey,
+		Timeout:   conf.httpTimeout,
 	})
 }
 
@@ -910,13 +1750,12 @@ func (r *CloudProvider) get(url string) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't get %s: Error creating request: %v", url, err)
 	}
-	req.Header.Add("Authorization", basicAuth(r.conf.Global.CattleAccessKey, r.conf.Global.CattleSecretKey))
+	req.Header.Add("Authorization", r.conf.authorization())
 	resp, err := http.DefaultClient.Do(req)
-
-	defer resp.Body.Close()
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't get %s: %v", url, err)
 	}
+	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -2,15 +2,20 @@ package rancher
 
 import (
 	"fmt"
+	"net/http"
 	"os"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/rancher/go-rancher/client"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/kubernetes/pkg/api"
+	api "k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
 )
 
 var (
@@ -21,6 +26,9 @@ var (
 	lbTestSerializer   *sync.Mutex
 
 	hostList                *client.HostCollection
+	hostByIdErrors          map[string]error
+	projects                map[string]*client.Project
+	projectLookups          int
 	loadBalancerServiceList *client.LoadBalancerServiceCollection
 	serviceList             *client.ServiceCollection
 	externalServiceList     *client.ExternalServiceCollection
@@ -29,6 +37,8 @@ var (
 	lbConsumedServicesLinks   map[string]*client.ServiceCollection
 	lbConsumedByServicesLinks map[string]*client.ServiceCollection
 	lbServiceLinks            map[string]*client.SetLoadBalancerServiceLinksInput
+	lbAddedServiceLinks       map[string][]string
+	lbRemovedServiceLinks     map[string][]string
 )
 
 type fakeRancherBaseClient struct {
@@ -37,6 +47,8 @@ type fakeRancherBaseClient struct {
 
 func (f *fakeRancherBaseClient) GetLink(resource client.Resource, link string, respObject interface{}) error {
 	switch link {
+	case "next":
+		return nextHostListPage(resource.Links[link], respObject.(*listedHostCollection))
 	case "ipAddresses":
 		if tmp, ok := ipAddressLinks[resource.Id]; ok {
 			respObject.(*client.IpAddressCollection).Data = tmp.Data
@@ -68,7 +80,15 @@ func (f *fakeHostClient) Update(existing *client.Host, updates interface{}) (*cl
 }
 
 func (f *fakeHostClient) ById(id string) (*client.Host, error) {
-	return nil, fmt.Errorf("not implemented")
+	if err, ok := hostByIdErrors[id]; ok {
+		return nil, err
+	}
+	for i := range hostList.Data {
+		if hostList.Data[i].Id == id {
+			return &hostList.Data[i], nil
+		}
+	}
+	return nil, nil
 }
 
 func (f *fakeHostClient) Delete(container *client.Host) error {
@@ -118,7 +138,17 @@ func (f *fakeLoadBalancerServiceClient) Create(opts *client.LoadBalancerService)
 }
 
 func (f *fakeLoadBalancerServiceClient) Update(existing *client.LoadBalancerService, updates interface{}) (*client.LoadBalancerService, error) {
-	return nil, fmt.Errorf("not implemented")
+	for pos := range loadBalancerServiceList.Data {
+		lbserv := &loadBalancerServiceList.Data[pos]
+		if lbserv.Id == existing.Id {
+			if metadata, ok := updates.(map[string]interface{})["metadata"]; ok {
+				lbserv.Metadata = metadata.(map[string]interface{})
+			}
+			updated := *lbserv
+			return &updated, nil
+		}
+	}
+	return nil, fmt.Errorf("Could not find lb service")
 }
 
 func (f *fakeLoadBalancerServiceClient) ById(id string) (*client.LoadBalancerService, error) {
@@ -145,8 +175,9 @@ func (f *fakeLoadBalancerServiceClient) ActionActivate(*client.LoadBalancerServi
 	return nil, fmt.Errorf("not implemented")
 }
 
-func (f *fakeLoadBalancerServiceClient) ActionAddservicelink(*client.LoadBalancerService, *client.AddRemoveLoadBalancerServiceLinkInput) (*client.Service, error) {
-	return nil, fmt.Errorf("not implemented")
+func (f *fakeLoadBalancerServiceClient) ActionAddservicelink(lb *client.LoadBalancerService, input *client.AddRemoveLoadBalancerServiceLinkInput) (*client.Service, error) {
+	lbAddedServiceLinks[lb.Id] = append(lbAddedServiceLinks[lb.Id], input.ServiceLink.ServiceId)
+	return nil, nil
 }
 
 func (f *fakeLoadBalancerServiceClient) ActionCancelrollback(*client.LoadBalancerService) (*client.Service, error) {
@@ -173,8 +204,18 @@ func (f *fakeLoadBalancerServiceClient) ActionRemove(*client.LoadBalancerService
 	return nil, fmt.Errorf("not implemented")
 }
 
-func (f *fakeLoadBalancerServiceClient) ActionRemoveservicelink(*client.LoadBalancerService, *client.AddRemoveLoadBalancerServiceLinkInput) (*client.Service, error) {
-	return nil, fmt.Errorf("not implemented")
+func (f *fakeLoadBalancerServiceClient) ActionRemoveservicelink(lb *client.LoadBalancerService, input *client.AddRemoveLoadBalancerServiceLinkInput) (*client.Service, error) {
+	lbRemovedServiceLinks[lb.Id] = append(lbRemovedServiceLinks[lb.Id], input.ServiceLink.ServiceId)
+	if consumedBy, ok := lbConsumedByServicesLinks[input.ServiceLink.ServiceId]; ok {
+		remaining := []client.Service{}
+		for _, service := range consumedBy.Data {
+			if service.Id != lb.Id {
+				remaining = append(remaining, service)
+			}
+		}
+		consumedBy.Data = remaining
+	}
+	return nil, nil
 }
 
 func (f *fakeLoadBalancerServiceClient) ActionRestart(*client.LoadBalancerService, *client.ServiceRestart) (*client.Service, error) {
@@ -213,7 +254,12 @@ func (f *fakeServiceClient) Update(existing *client.Service, updates interface{}
 }
 
 func (f *fakeServiceClient) ById(id string) (*client.Service, error) {
-	return nil, fmt.Errorf("not implemented")
+	for _, serv := range serviceList.Data {
+		if serv.Id == id {
+			return &serv, nil
+		}
+	}
+	return nil, fmt.Errorf("Could not find service")
 }
 
 func (f *fakeServiceClient) Delete(s *client.Service) error {
@@ -356,6 +402,19 @@ func (f *fakeExternalServiceClient) ActionUpgrade(*client.ExternalService, *clie
 	return nil, fmt.Errorf("not implemented")
 }
 
+type fakeProjectClient struct {
+	client.ProjectOperations
+}
+
+func (f *fakeProjectClient) ById(id string) (*client.Project, error) {
+	projectLookups++
+	project, ok := projects[id]
+	if !ok {
+		return nil, nil
+	}
+	return project, nil
+}
+
 func TestMain(m *testing.M) {
 	hostTestSerializer = &sync.Mutex{}
 	lbTestSerializer = &sync.Mutex{}
@@ -380,15 +439,22 @@ func TestMain(m *testing.M) {
 	externalServiceClient := &fakeExternalServiceClient{}
 	testClient.ExternalService = externalServiceClient
 
+	testClient.Project = &fakeProjectClient{}
+	testClient.PhysicalHost = &fakePhysicalHostClient{}
+	projects = make(map[string]*client.Project)
+
 	ipAddressLinks = make(map[string]*client.IpAddressCollection)
 	lbConsumedServicesLinks = make(map[string]*client.ServiceCollection)
 	lbConsumedByServicesLinks = make(map[string]*client.ServiceCollection)
 	lbServiceLinks = make(map[string]*client.SetLoadBalancerServiceLinksInput)
+	lbAddedServiceLinks = make(map[string][]string)
+	lbRemovedServiceLinks = make(map[string][]string)
 
 	cloudProvider = &CloudProvider{
-		client:    testClient,
-		conf:      &rConfig{},
-		hostCache: cache.NewTTLStore(hostStoreKeyFunc, time.Duration(24)*time.Hour),
+		client:           testClient,
+		conf:             &rConfig{},
+		hostCache:        cache.NewTTLStore(hostStoreKeyFunc, time.Duration(24)*time.Hour),
+		environmentCache: newEnvironmentCache(),
 	}
 	os.Exit(m.Run())
 }
@@ -464,29 +530,53 @@ func TestNodeAddresses(t *testing.T) {
 				Hostname: "test2",
 				Uuid:     "abcd",
 			},
+			client.Host{
+				Resource: client.Resource{Id: "1h3"},
+				Hostname: "natted",
+				Labels:   map[string]interface{}{hostPublicIPLabel: "203.0.113.3"},
+			},
 		},
 	}
 	coll := new(client.IpAddressCollection)
 	coll.Data = make([]client.IpAddress, 1)
 	coll.Data = append(coll.Data, client.IpAddress{Address: "192.168.1.1"})
 	ipAddressLinks["1h2"] = coll
+	ipAddressLinks["1h3"] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "192.168.1.3"}}}
 
+	expected := []api.NodeAddress{
+		{Type: api.NodeInternalIP, Address: "192.168.1.1"},
+		{Type: api.NodeExternalIP, Address: "192.168.1.1"},
+		{Type: api.NodeLegacyHostIP, Address: "192.168.1.1"},
+		{Type: api.NodeHostName, Address: "test2"},
+	}
 	addresses, err := cloudProvider.NodeAddresses("test2")
-
-	if len(addresses) != 5 {
-		t.Errorf("expected 5 addresses, found, [%+v], err: [%v]", addresses, err)
+	if err != nil || !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected addresses %v by name, found %v, err: %v", expected, addresses, err)
 	}
-
-	if addresses[2].Type != api.NodeExternalIP || addresses[2].Address != "192.168.1.1" {
-		t.Errorf("expected address 0 to be 192.168.1.1, found %s", addresses[2].Address)
+	addresses, err = cloudProvider.NodeAddressesByProviderID("rancher://1h2")
+	if err != nil || !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected addresses %v by providerID, found %v, err: %v", expected, addresses, err)
 	}
 
-	if addresses[3].Type != api.NodeLegacyHostIP || addresses[3].Address != "192.168.1.1" {
-		t.Errorf("expected address 1 to be 192.168.1.1, found %s", addresses[3].Address)
+	expected = []api.NodeAddress{
+		{Type: api.NodeInternalIP, Address: "192.168.1.3"},
+		{Type: api.NodeExternalIP, Address: "203.0.113.3"},
+		{Type: api.NodeLegacyHostIP, Address: "192.168.1.3"},
+		{Type: api.NodeHostName, Address: "natted"},
+	}
+	addresses, err = cloudProvider.NodeAddressesByProviderID("rancher://1h3")
+	if err != nil || !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected the public IP to be the external IP %v, found %v, err: %v", expected, addresses, err)
 	}
 
-	if addresses[4].Type != api.NodeHostName || addresses[4].Address != "test2" {
-		t.Errorf("expected address 2 to be test2, found %s", addresses[4].Address)
+	if _, err := cloudProvider.NodeAddressesByProviderID("rancher://1h4"); err != cloudprovider.InstanceNotFound {
+		t.Errorf("expected a missing host not to be found, found %v", err)
+	}
+	for _, providerID := range []string{"", "aws://i-1", "rancher://"} {
+		_, err := cloudProvider.NodeAddressesByProviderID(providerID)
+		if invalid, ok := err.(*invalidProviderIDError); !ok || invalid.InvalidProviderID() != providerID {
+			t.Errorf("expected providerID %q to be invalid, found %v", providerID, err)
+		}
 	}
 }
 
@@ -511,7 +601,7 @@ func TestGetLoadBalancer(t *testing.T) {
 	}
 
 	service := api.Service{
-		ObjectMeta: api.ObjectMeta{
+		ObjectMeta: metav1.ObjectMeta{
 			Name: "test-lb-1",
 			UID:  "test-lb-1",
 		},
@@ -552,7 +642,7 @@ func TestEnsureLoadBalancerDeleted(t *testing.T) {
 	}
 
 	service := api.Service{
-		ObjectMeta: api.ObjectMeta{
+		ObjectMeta: metav1.ObjectMeta{
 			Name: "test-lb-1",
 			UID:  "test-lb-1",
 		},
@@ -630,6 +720,7 @@ func TestEnsureLoadBalancerDeleted(t *testing.T) {
 func TestUpdateLoadBalancer(t *testing.T) {
 	lbTestSerializer.Lock()
 	defer lbTestSerializer.Unlock()
+	hostList = &client.HostCollection{}
 	externalServiceList = &client.ExternalServiceCollection{
 		Data: []client.ExternalService{
 			client.ExternalService{
@@ -649,7 +740,8 @@ func TestUpdateLoadBalancer(t *testing.T) {
 				Resource: client.Resource{
 					Id: "1lb1",
 					Actions: map[string]string{
-						"setservicelinks": "setservicelinks",
+						"addservicelink":    "addservicelink",
+						"removeservicelink": "removeservicelink",
 					},
 				},
 				PublicEndpoints: []interface{}{
@@ -665,7 +757,7 @@ func TestUpdateLoadBalancer(t *testing.T) {
 	}
 
 	service := api.Service{
-		ObjectMeta: api.ObjectMeta{
+		ObjectMeta: metav1.ObjectMeta{
 			Name: "test-lb-1",
 			UID:  "test-lb-1",
 		},
@@ -705,7 +797,9 @@ func TestUpdateLoadBalancer(t *testing.T) {
 		},
 	}
 
-	err := cloudProvider.UpdateLoadBalancer("", &service, []string{"host1"})
+	delete(lbAddedServiceLinks, "1lb1")
+	delete(lbRemovedServiceLinks, "1lb1")
+	err := cloudProvider.UpdateLoadBalancer("", &service, []*api.Node{&api.Node{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}})
 
 	if err != nil {
 		t.Errorf("Error deleting load balancer, err: [%v]", err)
@@ -715,23 +809,18 @@ func TestUpdateLoadBalancer(t *testing.T) {
 		t.Errorf("consumed services have not been deleted as expected, remaining services %+v", serviceList.Data)
 	}
 
-	lbServiceLink, ok := lbServiceLinks["1lb1"]
-	if !ok {
-		t.Errorf("lb service links not updated as expected")
-	}
-
-	if len(lbServiceLink.ServiceLinks) != 1 {
-		t.Errorf("lb service links not updated as expected")
+	if links := lbAddedServiceLinks["1lb1"]; !reflect.DeepEqual(links, []string{"1s2"}) {
+		t.Errorf("expected the link to 1s2 to be added, found %v", links)
 	}
-
-	if lbServiceLink.ServiceLinks[0].(*client.LoadBalancerServiceLink).ServiceId != "1s2" {
-		t.Errorf("lb service links not updated as expected")
+	if links := lbRemovedServiceLinks["1lb1"]; !reflect.DeepEqual(links, []string{"1s1"}) {
+		t.Errorf("expected the link to 1s1 to be removed, found %v", links)
 	}
 }
 
 func TestEnsureLoadBalancer(t *testing.T) {
 	lbTestSerializer.Lock()
 	defer lbTestSerializer.Unlock()
+	hostList = &client.HostCollection{}
 	externalServiceList = &client.ExternalServiceCollection{
 		Data: []client.ExternalService{
 			client.ExternalService{
@@ -751,8 +840,9 @@ func TestEnsureLoadBalancer(t *testing.T) {
 				Resource: client.Resource{
 					Id: "1lb1",
 					Actions: map[string]string{
-						"setservicelinks": "setservicelinks",
-						"deactivate":      "deactivate",
+						"addservicelink":    "addservicelink",
+						"removeservicelink": "removeservicelink",
+						"deactivate":        "deactivate",
 					},
 				},
 				PublicEndpoints: []interface{}{
@@ -774,7 +864,7 @@ func TestEnsureLoadBalancer(t *testing.T) {
 	}
 
 	service := api.Service{
-		ObjectMeta: api.ObjectMeta{
+		ObjectMeta: metav1.ObjectMeta{
 			Name: "test-lb-1",
 			UID:  "test-lb-1",
 		},
@@ -825,23 +915,19 @@ func TestEnsureLoadBalancer(t *testing.T) {
 		},
 	}
 
-	status, err := cloudProvider.EnsureLoadBalancer("", &service, []string{"host1"})
+	delete(lbAddedServiceLinks, "1lb1")
+	delete(lbRemovedServiceLinks, "1lb1")
+	status, err := cloudProvider.EnsureLoadBalancer("", &service, []*api.Node{&api.Node{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}})
 
 	if err != nil {
 		t.Errorf("Error ensuring load balancer, err: [%v]", err)
 	}
 
-	lbServiceLink, ok := lbServiceLinks["1lb1"]
-	if !ok {
-		t.Errorf("lb service links not updated as expected")
+	if links := lbAddedServiceLinks["1lb1"]; !reflect.DeepEqual(links, []string{"1s2"}) {
+		t.Errorf("expected the link to 1s2 to be added, found %v", links)
 	}
-
-	if len(lbServiceLink.ServiceLinks) != 1 {
-		t.Errorf("lb service links not updated as expected")
-	}
-
-	if lbServiceLink.ServiceLinks[0].(*client.LoadBalancerServiceLink).ServiceId != "1s2" {
-		t.Errorf("lb service links not updated as expected")
+	if links := lbRemovedServiceLinks["1lb1"]; !reflect.DeepEqual(links, []string{"1s1"}) {
+		t.Errorf("expected the link to 1s1 to be removed, found %v", links)
 	}
 
 	if len(status.Ingress) != 1 {
@@ -852,3 +938,155 @@ func TestEnsureLoadBalancer(t *testing.T) {
 		t.Errorf("Expected to find IP 192.168.1.2, found %s", status.Ingress[0].IP)
 	}
 }
+
+func TestDuplicateHostnames(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	// reinstalling the host created a new one, the old one is removed but still listed
+	hostList = &client.HostCollection{
+		Data: []client.Host{
+			client.Host{
+				Resource: client.Resource{Id: "1h21"},
+				Hostname: "reinstalled",
+				Uuid:     "old",
+				State:    "removed",
+				Removed:  "2017-06-01T00:00:00Z",
+			},
+			client.Host{
+				Resource: client.Resource{Id: "1h22"},
+				Hostname: "reinstalled",
+				Uuid:     "new",
+				State:    "active",
+			},
+		},
+	}
+	ipAddressLinks["1h22"] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "10.0.0.22"}}}
+
+	id, err := cloudProvider.ExternalID("reinstalled")
+	if err != nil || id != "new" {
+		t.Errorf("expected the active host new, found [%v], err: [%v]", id, err)
+	}
+
+	// both are active
+	hostList.Data[0].State = "active"
+	hostList.Data[0].Removed = ""
+	_, err = cloudProvider.ExternalID("reinstalled")
+	ambiguous, ok := err.(*ambiguousHostError)
+	if !ok {
+		t.Fatalf("expected an ambiguous host error, found [%v]", err)
+	}
+	if !reflect.DeepEqual(ambiguous.Candidates(), []string{"1h21", "1h22"}) {
+		t.Errorf("expected candidates [1h21 1h22], found %v", ambiguous.Candidates())
+	}
+
+	// hosts of two environments, visible to an account API key
+	hostList.Data[0].AccountId = "1a5"
+	hostList.Data[1].AccountId = "1a7"
+	_, err = cloudProvider.ExternalID("reinstalled")
+	if err == nil || !strings.Contains(err.Error(), "1h21 in environment 1a5, 1h22 in environment 1a7") {
+		t.Errorf("expected the error to name the hosts and their environments, found [%v]", err)
+	}
+}
+
+func TestWaitForLBReady(t *testing.T) {
+	lbTestSerializer.Lock()
+	defer lbTestSerializer.Unlock()
+
+	r := &CloudProvider{
+		client: testClient,
+		conf:   &rConfig{Global: configGlobal{WaitForLBReady: true}, lbReadyTimeout: 10 * time.Millisecond},
+	}
+	tests := []struct {
+		state  string
+		health string
+		ready  bool
+	}{
+		{state: "activating", health: "initializing"},
+		{state: "active", health: "unhealthy"},
+		{state: "active", health: "healthy", ready: true},
+		{state: "active", ready: true},
+	}
+
+	for _, test := range tests {
+		loadBalancerServiceList = &client.LoadBalancerServiceCollection{
+			Data: []client.LoadBalancerService{
+				{Resource: client.Resource{Id: "1s1"}, Name: "lb-test", State: test.state, HealthState: test.health},
+			},
+		}
+		_, err := r.waitForLBReady(&client.LoadBalancerService{Resource: client.Resource{Id: "1s1"}, Name: "lb-test"})
+		if test.ready != (err == nil) {
+			t.Errorf("%s/%s: expected ready %v, found err: %v", test.state, test.health, test.ready, err)
+		}
+	}
+}
+
+func TestInstanceExistsByProviderID(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	defer func() { hostByIdErrors = nil }()
+
+	hostByIdErrors = map[string]error{
+		"1h404":  &client.ApiError{StatusCode: http.StatusNotFound},
+		"1h500":  &client.ApiError{StatusCode: http.StatusInternalServerError},
+		"1hdown": fmt.Errorf("connection refused"),
+	}
+	testCases := []struct {
+		name          string
+		host          client.Host
+		expectedExist bool
+		expectedError bool
+	}{
+		{name: "requested", host: client.Host{State: "requested"}, expectedExist: true},
+		{name: "registering", host: client.Host{State: "registering"}, expectedExist: true},
+		{name: "activating", host: client.Host{State: "activating"}, expectedExist: true},
+		{name: "active", host: client.Host{State: "active", AgentState: "active"}, expectedExist: true},
+		{name: "reconnecting", host: client.Host{State: "active", AgentState: "reconnecting"}, expectedExist: true},
+		{name: "disconnected", host: client.Host{State: "active", AgentState: "disconnected"}, expectedExist: true},
+		{name: "updating-active", host: client.Host{State: "updating-active"}, expectedExist: true},
+		{name: "deactivating", host: client.Host{State: "deactivating"}, expectedExist: true},
+		{name: "inactive", host: client.Host{State: "inactive"}, expectedExist: true},
+		{name: "updating-inactive", host: client.Host{State: "updating-inactive"}, expectedExist: true},
+		{name: "erroring", host: client.Host{State: "erroring"}, expectedExist: true},
+		{name: "error", host: client.Host{State: "error"}, expectedExist: true},
+		{name: "restoring", host: client.Host{State: "restoring"}, expectedExist: true},
+		{name: "removing", host: client.Host{State: "removing"}},
+		{name: "removed", host: client.Host{State: "removed", Removed: "2017-08-01T10:00:00Z"}},
+		{name: "purging", host: client.Host{State: "purging", Removed: "2017-08-01T10:00:00Z"}},
+		{name: "purged", host: client.Host{State: "purged", Removed: "2017-08-01T10:00:00Z"}},
+		{name: "uppercase removed", host: client.Host{State: "Removed"}},
+		{name: "removed timestamp", host: client.Host{State: "active", Removed: "2017-08-01T10:00:00Z"}},
+		{name: "unknown host", host: client.Host{Resource: client.Resource{Id: "1hunknown"}}},
+		{name: "not found", host: client.Host{Resource: client.Resource{Id: "1h404"}}},
+		{name: "server error", host: client.Host{Resource: client.Resource{Id: "1h500"}}, expectedError: true},
+		{name: "unreachable API", host: client.Host{Resource: client.Resource{Id: "1hdown"}}, expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		providerID := "rancher://" + tc.host.Id
+		hostList = &client.HostCollection{}
+		if tc.host.Id == "" {
+			tc.host.Id = "1h11"
+			providerID = "rancher://1h11"
+			hostList.Data = []client.Host{tc.host}
+		}
+
+		exists, err := cloudProvider.InstanceExistsByProviderID(providerID)
+		if tc.expectedError {
+			if err == nil || exists {
+				t.Errorf("%s: expected an error and no host, found %v, err: %v", tc.name, exists, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if exists != tc.expectedExist {
+			t.Errorf("%s: expected host to exist: %v, found %v", tc.name, tc.expectedExist, exists)
+		}
+	}
+
+	if _, err := cloudProvider.InstanceExistsByProviderID("aws:///i-123"); err == nil {
+		t.Errorf("expected an error for an invalid providerID")
+	}
+}
@@ -0,0 +1,81 @@
+package rancher
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	// retryBaseDelay is the delay before the first retry of a request, doubled for every retry
+	retryBaseDelay = 500 * time.Millisecond
+	// retryMaxDelay caps the delay between retries, and the Retry-After delays honored
+	retryMaxDelay = 30 * time.Second
+)
+
+// setMaxAttempts makes the requests to the Rancher API at apiURLs that fail with a transient status
+// be sent up to attempts times
+func setMaxAttempts(apiURLs []string, attempts int) {
+	if apiTransport == nil {
+		return
+	}
+	for _, apiURL := range apiURLs {
+		u, err := url.Parse(apiURL)
+		if err != nil {
+			continue
+		}
+		if group := apiTransport.group(u.Host); group != nil {
+			group.setMaxAttempts(attempts)
+		}
+	}
+}
+
+// transientStatus tells whether a request answered with status may succeed if sent again
+func transientStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns how long to wait before sending a request again after attempt failed with
+// resp: what its Retry-After header asks for, or an exponential backoff with jitter. It returns
+// false if the server asks to wait longer than retryMaxDelay.
+func retryDelay(attempt int, resp *http.Response) (time.Duration, bool) {
+	if after := resp.Header.Get("Retry-After"); after != "" {
+		var delay time.Duration
+		if seconds, err := strconv.Atoi(after); err == nil {
+			delay = time.Duration(seconds) * time.Second
+		} else if at, err := http.ParseTime(after); err == nil {
+			delay = at.Sub(time.Now())
+		}
+		if delay > retryMaxDelay {
+			return 0, false
+		}
+		if delay > 0 {
+			return delay, true
+		}
+	}
+
+	delay := retryBaseDelay
+	for i := 1; i < attempt && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	// between half and all of the backoff, so that clients failing together don't retry together
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)), true
+}
+
+// discard reads what is left of a response body and closes it, so that its connection is reused
+func discard(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, body)
+	body.Close()
+}
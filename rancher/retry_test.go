@@ -0,0 +1,126 @@
+package rancher
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryTransientStatuses(t *testing.T) {
+	defer func(delay time.Duration) { retryBaseDelay = delay }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	tests := []struct {
+		name       string
+		method     string
+		attempts   int
+		responses  []int
+		status     int
+		requests   int
+		retries    float64
+		retryClass string
+	}{
+		{name: "rate limited", method: "GET", attempts: 3, responses: []int{429}, status: 200, requests: 2, retries: 1, retryClass: "429"},
+		{name: "proxy upgrading", method: "GET", attempts: 3, responses: []int{502, 503}, status: 200, requests: 3, retries: 2, retryClass: "5xx"},
+		{name: "gateway timeout", method: "GET", attempts: 3, responses: []int{504}, status: 200, requests: 2, retries: 1, retryClass: "5xx"},
+		{name: "out of attempts", method: "GET", attempts: 3, responses: []int{500, 500, 500}, status: 500, requests: 3, retries: 2, retryClass: "5xx"},
+		{name: "retries disabled", method: "GET", attempts: 1, responses: []int{503}, status: 503, requests: 1, retryClass: "5xx"},
+		{name: "not found", method: "GET", attempts: 3, responses: []int{404}, status: 404, requests: 1, retryClass: "404"},
+		{name: "unauthorized", method: "GET", attempts: 3, responses: []int{401}, status: 401, requests: 1, retryClass: "401"},
+		{name: "forbidden", method: "GET", attempts: 3, responses: []int{403}, status: 403, requests: 1, retryClass: "403"},
+		{name: "not implemented", method: "GET", attempts: 3, responses: []int{501}, status: 501, requests: 1, retryClass: "5xx"},
+		{name: "post", method: "POST", attempts: 3, responses: []int{503}, status: 503, requests: 1, retryClass: "5xx"},
+	}
+
+	for _, test := range tests {
+		cattle := newFakeCattle()
+		cattle.clientWithConfig(t, configGlobal{MaxRequestAttempts: test.attempts})
+		cattle.responses = test.responses
+
+		operation := "get_hosts"
+		if test.method == "POST" {
+			operation = "post_hosts"
+		}
+		before := counterValue(t, apiRetries, operation, test.retryClass)
+		req, _ := http.NewRequest(test.method, cattle.URL+"/v2-beta/hosts", strings.NewReader("{}"))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			cattle.Close()
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: expected status %d, found %d", test.name, test.status, resp.StatusCode)
+		}
+		cattle.Lock()
+		if cattle.requests != test.requests {
+			t.Errorf("%s: expected %d requests, found %d", test.name, test.requests, cattle.requests)
+		}
+		cattle.Unlock()
+		if retries := counterValue(t, apiRetries, operation, test.retryClass) - before; retries != test.retries {
+			t.Errorf("%s: expected %v retries to be counted, found %v", test.name, test.retries, retries)
+		}
+		cattle.Close()
+	}
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	cattle := newFakeCattle()
+	defer cattle.Close()
+	cattle.clientWithConfig(t, configGlobal{MaxRequestAttempts: 2})
+	cattle.responses = []int{429}
+	cattle.retryAfter = "1"
+
+	start := time.Now()
+	resp, err := http.Get(cattle.URL + "/v2-beta/hosts")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the request to succeed once retried, found status %d", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected the retry to wait for Retry-After, it was sent after %v", elapsed)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	defer func(delay time.Duration) { retryBaseDelay = delay }(retryBaseDelay)
+	retryBaseDelay = 100 * time.Millisecond
+
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter string
+		min        time.Duration
+		max        time.Duration
+		retry      bool
+	}{
+		{name: "first retry", attempt: 1, min: 50 * time.Millisecond, max: 100 * time.Millisecond, retry: true},
+		{name: "third retry", attempt: 3, min: 200 * time.Millisecond, max: 400 * time.Millisecond, retry: true},
+		{name: "capped", attempt: 20, min: retryMaxDelay / 2, max: retryMaxDelay, retry: true},
+		{name: "retry after seconds", attempt: 1, retryAfter: "5", min: 5 * time.Second, max: 5 * time.Second, retry: true},
+		{name: "retry after date", attempt: 1, retryAfter: time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), min: 8 * time.Second, max: 10 * time.Second, retry: true},
+		{name: "retry after too long", attempt: 1, retryAfter: "3600"},
+		{name: "invalid retry after", attempt: 1, retryAfter: "soon", min: 50 * time.Millisecond, max: 100 * time.Millisecond, retry: true},
+	}
+
+	for _, test := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if test.retryAfter != "" {
+			resp.Header.Set("Retry-After", test.retryAfter)
+		}
+		delay, retry := retryDelay(test.attempt, resp)
+		if retry != test.retry {
+			t.Errorf("%s: expected retry %v, found %v", test.name, test.retry, retry)
+			continue
+		}
+		if retry && (delay < test.min || delay > test.max) {
+			t.Errorf("%s: expected a delay between %v and %v, found %v", test.name, test.min, test.max, delay)
+		}
+	}
+}
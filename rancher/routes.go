@@ -0,0 +1,196 @@
+package rancher
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/rancher/go-rancher/client"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	api "k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+const (
+	// hostPodCIDRLabel is the host label holding the pod CIDR routed to the host, for the agents
+	// programming the routes of the pod network
+	hostPodCIDRLabel = "io.rancher.k8s.pod-cidr"
+	// hostRouteClusterLabel is the host label telling the cluster whose route hostPodCIDRLabel is
+	hostRouteClusterLabel = "io.rancher.k8s.cluster"
+
+	// eventFailedToCreateRoute is the reason of the events of the nodes whose route couldn't be
+	// created
+	eventFailedToCreateRoute = "FailedToCreateRoute"
+	// eventRouteCreated is the reason of the events of the nodes whose route is created after failing
+	eventRouteCreated = "RouteCreated"
+)
+
+// Routes returns the routes of the pod network if manage-routes is set. The routes are the pod
+// CIDRs of the hosts, stored in their labels.
+func (r *CloudProvider) Routes() (cloudprovider.Routes, bool) {
+	if r.conf == nil || !r.conf.Global.ManageRoutes {
+		return nil, false
+	}
+	if r.conf.apiVersion == apiVersionV3 {
+		glog.Warningf("manage-routes is set, but routes aren't supported with the %s API", apiVersionV3)
+		return nil, false
+	}
+	return r, true
+}
+
+// SetRouteRecorder gives the provider a recorder for the events of the routes on their nodes
+func (r *CloudProvider) SetRouteRecorder(recorder record.EventRecorder) {
+	r.routeRecorder = recorder
+}
+
+// routeName returns the name of the route to the node named nodeName in the cluster named
+// clusterName
+func routeName(clusterName string, nodeName types.NodeName) string {
+	return lbClusterID(clusterName) + "-" + string(nodeName)
+}
+
+// ListRoutes is an implementation of Routes.ListRoutes. The hosts that are gone have no routes.
+func (r *CloudProvider) ListRoutes(clusterName string) ([]*cloudprovider.Route, error) {
+	hosts, err := r.hostAPI().listHosts()
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't list the routes of cluster %s. Error: %#v", clusterName, err)
+	}
+	routes := []*cloudprovider.Route{}
+	for i := range hosts {
+		host := &hosts[i].Host
+		cidr, _ := host.Labels[hostPodCIDRLabel].(string)
+		cluster, _ := host.Labels[hostRouteClusterLabel].(string)
+		if cidr == "" || cluster != lbClusterID(clusterName) || hostRemoved(host) {
+			continue
+		}
+		nodeName := types.NodeName(host.Hostname)
+		routes = append(routes, &cloudprovider.Route{
+			Name:            routeName(clusterName, nodeName),
+			TargetNode:      nodeName,
+			DestinationCIDR: cidr,
+		})
+	}
+	managedRoutes.Set(float64(len(routes)))
+	return routes, nil
+}
+
+// CreateRoute is an implementation of Routes.CreateRoute. nameHint is ignored, routes are named
+// after their node.
+func (r *CloudProvider) CreateRoute(clusterName string, nameHint string, route *cloudprovider.Route) error {
+	start := time.Now()
+	err := r.createRoute(clusterName, route)
+	observeRouteOperation("create", start, err)
+	r.recordRouteEvent(route, err)
+	return err
+}
+
+func (r *CloudProvider) createRoute(clusterName string, route *cloudprovider.Route) error {
+	host, err := r.getHostByName(string(route.TargetNode))
+	if err != nil {
+		return fmt.Errorf("Couldn't create the route to node %s. Error: %v", route.TargetNode, err)
+	}
+	labels := map[string]interface{}{}
+	for k, v := range host.RancherHost.Labels {
+		labels[k] = v
+	}
+	routed := labels[hostRouteClusterLabel] == lbClusterID(clusterName) && labels[hostPodCIDRLabel] != nil
+	if routed && labels[hostPodCIDRLabel] == route.DestinationCIDR {
+		return nil
+	}
+	labels[hostPodCIDRLabel] = route.DestinationCIDR
+	labels[hostRouteClusterLabel] = lbClusterID(clusterName)
+	glog.Infof("Routing %s to node %s", route.DestinationCIDR, route.TargetNode)
+	if _, err := r.updateHost(host.RancherHost, map[string]interface{}{"labels": labels}); err != nil {
+		return fmt.Errorf("Couldn't create the route to node %s. Error: %#v", route.TargetNode, err)
+	}
+	if !routed {
+		managedRoutes.Inc()
+	}
+	return nil
+}
+
+// DeleteRoute is an implementation of Routes.DeleteRoute. The routes of hosts that are gone are
+// gone with them.
+func (r *CloudProvider) DeleteRoute(clusterName string, route *cloudprovider.Route) error {
+	start := time.Now()
+	err := r.deleteRoute(clusterName, route)
+	observeRouteOperation("delete", start, err)
+	return err
+}
+
+func (r *CloudProvider) deleteRoute(clusterName string, route *cloudprovider.Route) error {
+	host, err := r.getHostByName(string(route.TargetNode))
+	if err == cloudprovider.InstanceNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Couldn't delete the route to node %s. Error: %v", route.TargetNode, err)
+	}
+	if cluster, _ := host.RancherHost.Labels[hostRouteClusterLabel].(string); cluster != lbClusterID(clusterName) {
+		return nil
+	}
+	labels := map[string]interface{}{}
+	for k, v := range host.RancherHost.Labels {
+		if k != hostPodCIDRLabel && k != hostRouteClusterLabel {
+			labels[k] = v
+		}
+	}
+	glog.Infof("Deleting the route of %s to node %s", route.DestinationCIDR, route.TargetNode)
+	if _, err := r.updateHost(host.RancherHost, map[string]interface{}{"labels": labels}); err != nil {
+		return fmt.Errorf("Couldn't delete the route to node %s. Error: %#v", route.TargetNode, err)
+	}
+	if _, ok := host.RancherHost.Labels[hostPodCIDRLabel]; ok {
+		managedRoutes.Dec()
+	}
+	return nil
+}
+
+// observeRouteOperation records the outcome of a route operation started at start
+func observeRouteOperation(operation string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	routeOperations.WithLabelValues(operation, result).Inc()
+	routeOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// recordRouteEvent records a Warning event on the node of route when creating the route failed,
+// and a Normal event once it's created after failing
+func (r *CloudProvider) recordRouteEvent(route *cloudprovider.Route, err error) {
+	r.routeFailuresLock.Lock()
+	if r.routeFailures == nil {
+		r.routeFailures = map[types.NodeName]bool{}
+	}
+	failed := r.routeFailures[route.TargetNode]
+	if err != nil {
+		r.routeFailures[route.TargetNode] = true
+	} else {
+		delete(r.routeFailures, route.TargetNode)
+	}
+	r.routeFailuresLock.Unlock()
+
+	if r.routeRecorder == nil {
+		return
+	}
+	// Nodes are referenced by name, like the kubelet does
+	ref := &api.ObjectReference{Kind: "Node", Name: string(route.TargetNode), UID: types.UID(route.TargetNode)}
+	if err != nil {
+		r.routeRecorder.Eventf(ref, api.EventTypeWarning, eventFailedToCreateRoute,
+			"Couldn't route %s to the node: %v", route.DestinationCIDR, err)
+	} else if failed {
+		r.routeRecorder.Eventf(ref, api.EventTypeNormal, eventRouteCreated,
+			"Routed %s to the node", route.DestinationCIDR)
+	}
+}
+
+// updateHost updates host with updates, padded to a length the client can send by padUpdates
+func (r *CloudProvider) updateHost(host *client.Host, updates map[string]interface{}) (*client.Host, error) {
+	padded, err := padUpdates(updates, host.Name, host.Description)
+	if err != nil {
+		return nil, err
+	}
+	return r.client.Host.Update(host, padded)
+}
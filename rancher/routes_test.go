@@ -0,0 +1,157 @@
+package rancher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+func TestRoutes(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1", "host2": "10.0.0.2"})
+	defer cattle.Close()
+	if _, ok := newFakeLBCloud(t, cattle).Routes(); ok {
+		t.Errorf("expected no routes unless manage-routes is set")
+	}
+	cloud, err := newRancherCloud(strings.NewReader("[Global]\ncattle-url = " + cattle.URL + "/v2-beta\nmanage-routes = true\n"))
+	if err != nil {
+		t.Fatalf("Couldn't create the cloud provider: %v", err)
+	}
+	routes, ok := cloud.Routes()
+	if !ok {
+		t.Fatalf("expected routes with manage-routes")
+	}
+
+	for _, route := range []*cloudprovider.Route{
+		{TargetNode: "host1", DestinationCIDR: "10.244.1.0/24"},
+		{TargetNode: "host2", DestinationCIDR: "10.244.2.0/24"},
+	} {
+		if err := routes.CreateRoute("kubernetes", "8f4a6c2e", route); err != nil {
+			t.Fatalf("Couldn't create the route to %s: %v", route.TargetNode, err)
+		}
+	}
+	// re-created routes are left alone
+	mutations := cattle.mutationCount()
+	if err := routes.CreateRoute("kubernetes", "8f4a6c2e", &cloudprovider.Route{TargetNode: "host1", DestinationCIDR: "10.244.1.0/24"}); err != nil {
+		t.Errorf("Couldn't create the route again: %v", err)
+	}
+	if found := cattle.mutationCount() - mutations; found != 0 {
+		t.Errorf("expected the route to be created once, found %d changes", found)
+	}
+	if other, err := routes.ListRoutes("other"); err != nil || len(other) != 0 {
+		t.Errorf("expected another cluster to have no routes, found %v, %v", other, err)
+	}
+
+	// host2 disappears
+	cattle.Lock()
+	for id, host := range cattle.resources["hosts"] {
+		if host["hostname"] == "host2" {
+			delete(cattle.resources["hosts"], id)
+		}
+	}
+	cattle.Unlock()
+	listed, err := routes.ListRoutes("kubernetes")
+	expected := []*cloudprovider.Route{{Name: "kubernetes-host1", TargetNode: "host1", DestinationCIDR: "10.244.1.0/24"}}
+	if err != nil || !reflect.DeepEqual(listed, expected) {
+		t.Errorf("expected the route to host1, found %v, %v", listed, err)
+	}
+	if err := routes.DeleteRoute("kubernetes", &cloudprovider.Route{Name: "kubernetes-host2", TargetNode: "host2"}); err != nil {
+		t.Errorf("expected the route to a host that is gone to be deleted, found %v", err)
+	}
+
+	if err := routes.DeleteRoute("kubernetes", listed[0]); err != nil {
+		t.Fatalf("Couldn't delete the route: %v", err)
+	}
+	if listed, err := routes.ListRoutes("kubernetes"); err != nil || len(listed) != 0 {
+		t.Errorf("expected the route to be deleted, found %v, %v", listed, err)
+	}
+	mutations = cattle.mutationCount()
+	if err := routes.DeleteRoute("kubernetes", expected[0]); err != nil || cattle.mutationCount() != mutations {
+		t.Errorf("expected deleting the route again to change nothing, found %v, %d changes", err, cattle.mutationCount()-mutations)
+	}
+}
+
+func TestRouteEventsAndMetrics(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "10.0.0.1"})
+	defer cattle.Close()
+	cloud, err := newRancherCloud(strings.NewReader("[Global]\ncattle-url = " + cattle.URL + "/v2-beta\nmanage-routes = true\n"))
+	if err != nil {
+		t.Fatalf("Couldn't create the cloud provider: %v", err)
+	}
+	recorder := record.NewFakeRecorder(10)
+	cloud.(*CloudProvider).SetRouteRecorder(recorder)
+	routes, _ := cloud.Routes()
+	route := &cloudprovider.Route{TargetNode: "host2", DestinationCIDR: "10.244.2.0/24"}
+
+	created, failed := counterValue(t, routeOperations, "create", "success"), counterValue(t, routeOperations, "create", "error")
+	if err := routes.CreateRoute("kubernetes", "8f4a6c2e", route); err == nil {
+		t.Fatalf("expected the route to a missing host to fail")
+	}
+	if event := nextEvent(recorder); !strings.HasPrefix(event, "Warning FailedToCreateRoute") {
+		t.Errorf("expected a FailedToCreateRoute event, found %q", event)
+	}
+	if found := counterValue(t, routeOperations, "create", "error") - failed; found != 1 {
+		t.Errorf("expected 1 failed route creation, found %v", found)
+	}
+
+	// host2 shows up, its route is created on the next attempt
+	cattle.Lock()
+	host := cattle.add("hosts", map[string]interface{}{"hostname": "host2", "state": "active"})
+	host["ipAddresses"] = []map[string]interface{}{{"address": "10.0.0.2"}}
+	cattle.Unlock()
+	if err := routes.CreateRoute("kubernetes", "8f4a6c2e", route); err != nil {
+		t.Fatalf("Couldn't create the route: %v", err)
+	}
+	if event := nextEvent(recorder); !strings.HasPrefix(event, "Normal RouteCreated") {
+		t.Errorf("expected a RouteCreated event, found %q", event)
+	}
+	if err := routes.CreateRoute("kubernetes", "8f4a6c2e", route); err != nil {
+		t.Fatalf("Couldn't create the route again: %v", err)
+	}
+	if event := nextEvent(recorder); event != "" {
+		t.Errorf("expected no event once the route is created, found %q", event)
+	}
+	if found := counterValue(t, routeOperations, "create", "success") - created; found != 2 {
+		t.Errorf("expected 2 successful route creations, found %v", found)
+	}
+
+	if _, err := routes.ListRoutes("kubernetes"); err != nil {
+		t.Fatalf("Couldn't list the routes: %v", err)
+	}
+	if found := gaugeValue(t, managedRoutes); found != 1 {
+		t.Errorf("expected 1 managed route, found %v", found)
+	}
+	deleted := counterValue(t, routeOperations, "delete", "success")
+	if err := routes.DeleteRoute("kubernetes", route); err != nil {
+		t.Fatalf("Couldn't delete the route: %v", err)
+	}
+	if found := gaugeValue(t, managedRoutes); found != 0 {
+		t.Errorf("expected no managed routes, found %v", found)
+	}
+	if found := counterValue(t, routeOperations, "delete", "success") - deleted; found != 1 {
+		t.Errorf("expected 1 route deletion, found %v", found)
+	}
+}
+
+// nextEvent returns the next event of recorder, or "" if there's none
+func nextEvent(recorder *record.FakeRecorder) string {
+	select {
+	case event := <-recorder.Events:
+		return event
+	default:
+		return ""
+	}
+}
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	if err := g.Write(m); err != nil {
+		t.Fatalf("Couldn't read metric: %v", err)
+	}
+	return m.GetGauge().GetValue()
+}
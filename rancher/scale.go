@@ -0,0 +1,148 @@
+package rancher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/rancher/go-rancher/client"
+
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+const (
+	// annotationLBScale is how many containers the LB of a service runs, on different hosts, or
+	// global to run one on every host
+	annotationLBScale = "rancher.io/lb-scale"
+
+	// annotationLBHostLabel restricts the containers of the LB of a service to the hosts with the
+	// given key=value label
+	annotationLBHostLabel = "rancher.io/lb-host-label"
+
+	globalLBScale = "global"
+
+	// The Rancher scheduler labels of the containers of an LB
+	lbGlobalLabel        = "io.rancher.scheduler.global"
+	lbHostAffinityLabel  = "io.rancher.scheduler.affinity:host_label"
+	lbAntiAffinityLabel  = "io.rancher.scheduler.affinity:container_label_ne"
+	lbAntiAffinityTarget = "io.rancher.stack_service.name=${stack_name}/${service_name}"
+)
+
+// lbScheduling is where the containers of the LB of a service run
+type lbScheduling struct {
+	scale     int64
+	global    bool
+	hostLabel string
+}
+
+// pinnable tells whether the LB runs a single container which can be pinned to a host
+func (s lbScheduling) pinnable() bool {
+	return s.scale == 1 && !s.global && s.hostLabel == ""
+}
+
+// serviceLBScheduling returns where the annotations of service ask to run its LB, a single
+// container on any host if they are missing
+func serviceLBScheduling(service *api.Service) (lbScheduling, error) {
+	s := lbScheduling{scale: 1}
+	if value, ok := service.Annotations[annotationLBScale]; ok {
+		if value == globalLBScale {
+			s.global = true
+		} else {
+			scale, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || scale < 1 {
+				return s, fmt.Errorf("Invalid value %q of annotation %s of service %s, must be a number of containers or %s",
+					value, annotationLBScale, serviceKey(service), globalLBScale)
+			}
+			s.scale = scale
+		}
+	}
+	if value, ok := service.Annotations[annotationLBHostLabel]; ok {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.ContainsAny(value, ", ") {
+			return s, fmt.Errorf("Invalid value %q of annotation %s of service %s, must be a host label key=value",
+				value, annotationLBHostLabel, serviceKey(service))
+		}
+		s.hostLabel = value
+	}
+	return s, nil
+}
+
+// lbSchedulingLabels returns the scheduler labels of the containers of an LB scheduled with s,
+// the labels to remove set to ""
+func lbSchedulingLabels(s lbScheduling) map[string]string {
+	labels := map[string]string{lbGlobalLabel: "", lbHostAffinityLabel: s.hostLabel, lbAntiAffinityLabel: ""}
+	if s.global {
+		labels[lbGlobalLabel] = "true"
+	} else if s.scale > 1 {
+		labels[lbAntiAffinityLabel] = lbAntiAffinityTarget
+	}
+	return labels
+}
+
+func setLBSchedulingLabels(launchConfig *client.LaunchConfig, s lbScheduling) {
+	labels := map[string]interface{}{}
+	for k, v := range launchConfig.Labels {
+		labels[k] = v
+	}
+	for k, v := range lbSchedulingLabels(s) {
+		if v == "" {
+			delete(labels, k)
+		} else {
+			labels[k] = v
+		}
+	}
+	launchConfig.Labels = labels
+}
+
+// lbSchedulingChanged tells whether the scheduler labels of lb differ from the ones of s
+func lbSchedulingChanged(lb *client.LoadBalancerService, s lbScheduling) bool {
+	for k, v := range lbSchedulingLabels(s) {
+		current := ""
+		if lb.LaunchConfig != nil {
+			current, _ = lb.LaunchConfig.Labels[k].(string)
+		}
+		if current != v {
+			return true
+		}
+	}
+	return false
+}
+
+// lbScale returns the number of containers of lb, Rancher defaults it to 1
+func lbScale(lb *client.LoadBalancerService) int64 {
+	if lb.Scale == 0 {
+		return 1
+	}
+	return lb.Scale
+}
+
+// ensureLBScheduling schedules the containers of lb as s asks, in place. An LB running several
+// containers or on some hosts only is no longer pinned to a host.
+func (r *CloudProvider) ensureLBScheduling(lb *client.LoadBalancerService, s lbScheduling) (*client.LoadBalancerService, error) {
+	if lbSchedulingChanged(lb, s) || (!s.pinnable() && lbRequestedHost(lb) != "") {
+		launchConfig := client.LaunchConfig{}
+		if lb.LaunchConfig != nil {
+			launchConfig = *lb.LaunchConfig
+		}
+		setLBSchedulingLabels(&launchConfig, s)
+		if !s.pinnable() {
+			launchConfig.RequestedHostId = ""
+		}
+		glog.Infof("Rescheduling LB %s", lb.Name)
+		var err error
+		lb, err = r.upgradeLBLaunchConfig(lb, &launchConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !s.global && lbScale(lb) != s.scale {
+		glog.Infof("Scaling LB %s to %d containers", lb.Name, s.scale)
+		updated, err := r.updateLB(lb, map[string]interface{}{"scale": s.scale})
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't scale LB %s. Error: %#v", lb.Name, err)
+		}
+		lb = updated
+	}
+	return lb, nil
+}
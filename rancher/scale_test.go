@@ -0,0 +1,108 @@
+package rancher
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	api "k8s.io/kubernetes/pkg/api/v1"
+)
+
+func TestServiceLBScheduling(t *testing.T) {
+	tests := []struct {
+		annotations map[string]string
+		scheduling  lbScheduling
+		invalid     bool
+	}{
+		{annotations: nil, scheduling: lbScheduling{scale: 1}},
+		{annotations: map[string]string{annotationLBScale: "3"}, scheduling: lbScheduling{scale: 3}},
+		{annotations: map[string]string{annotationLBScale: "global"}, scheduling: lbScheduling{scale: 1, global: true}},
+		{
+			annotations: map[string]string{annotationLBScale: "global", annotationLBHostLabel: "lb=true"},
+			scheduling:  lbScheduling{scale: 1, global: true, hostLabel: "lb=true"},
+		},
+		{annotations: map[string]string{annotationLBHostLabel: "zone="}, scheduling: lbScheduling{scale: 1, hostLabel: "zone="}},
+		{annotations: map[string]string{annotationLBScale: "0"}, invalid: true},
+		{annotations: map[string]string{annotationLBScale: "many"}, invalid: true},
+		{annotations: map[string]string{annotationLBHostLabel: "lb"}, invalid: true},
+		{annotations: map[string]string{annotationLBHostLabel: "=true"}, invalid: true},
+		{annotations: map[string]string{annotationLBHostLabel: "lb=true,zone=a"}, invalid: true},
+	}
+	for _, test := range tests {
+		service := &api.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: test.annotations}}
+		scheduling, err := serviceLBScheduling(service)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%v: expected an error, found %#v", test.annotations, scheduling)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.annotations, err)
+		} else if scheduling != test.scheduling {
+			t.Errorf("%v: expected %#v, found %#v", test.annotations, test.scheduling, scheduling)
+		}
+	}
+}
+
+func TestLBScaled(t *testing.T) {
+	cattle := newFakeLBCattle(map[string]string{"host1": "198.51.100.1", "host2": "198.51.100.2", "host3": "198.51.100.3"})
+	defer cattle.Close()
+	cattle.Lock()
+	for _, host := range cattle.resources["hosts"] {
+		if host["hostname"] != "host2" {
+			host["labels"] = map[string]interface{}{"lb": "true"}
+		}
+	}
+	cattle.Unlock()
+	r := newFakeLBCloud(t, cattle)
+	service := newLBService(nil)
+	nodes := []*api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "host1"}}}
+	name := lbName("kubernetes", service)
+
+	steps := []struct {
+		annotations map[string]string
+		ingress     []string
+	}{
+		{map[string]string{annotationLBScale: "2"}, []string{"198.51.100.1", "198.51.100.2"}},
+		{map[string]string{annotationLBScale: "3"}, []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"}},
+		{map[string]string{annotationLBScale: "1"}, []string{lbEndpoint}},
+		{map[string]string{annotationLBScale: "global", annotationLBHostLabel: "lb=true"}, []string{"198.51.100.1", "198.51.100.3"}},
+		{map[string]string{annotationLBScale: "global"}, []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"}},
+		{map[string]string{}, []string{lbEndpoint}},
+	}
+	lbID := ""
+	for i, step := range steps {
+		service.Annotations = step.annotations
+		status, err := r.EnsureLoadBalancer("kubernetes", service, nodes)
+		if err != nil {
+			t.Fatalf("step %d: couldn't ensure the LB: %v", i, err)
+		}
+		found, exists, err := r.GetLoadBalancer("kubernetes", service)
+		if err != nil || !exists || !reflect.DeepEqual(found, status) {
+			t.Errorf("step %d: expected the LB to report %#v, found %#v, %v, %v", i, status, found, exists, err)
+		}
+		ingress := []string{}
+		for _, i := range status.Ingress {
+			ingress = append(ingress, i.IP)
+		}
+		if !reflect.DeepEqual(ingress, step.ingress) {
+			t.Errorf("step %d: expected the ingress %v, found %v", i, step.ingress, ingress)
+		}
+		lb, err := r.getLBByName(name)
+		if err != nil || lb == nil {
+			t.Fatalf("step %d: expected LB %s to exist, found %#v, %v", i, name, lb, err)
+		}
+		if lbID == "" {
+			lbID = lb.Id
+		} else if lb.Id != lbID || cattle.count("loadbalancerservices") != 1 {
+			t.Errorf("step %d: expected LB %s to be rescheduled in place, found %s", i, lbID, lb.Id)
+		}
+	}
+
+	service.Annotations = map[string]string{annotationLBScale: "2"}
+	service.Spec.LoadBalancerIP = "198.51.100.1"
+	if _, err := r.EnsureLoadBalancer("kubernetes", service, nodes); err == nil {
+		t.Errorf("expected a loadBalancerIP to be rejected for a scaled LB")
+	}
+}
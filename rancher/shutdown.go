@@ -0,0 +1,116 @@
+package rancher
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/rancher/go-rancher/client"
+
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// hostTransitioningError is returned when a host is between states, e.g. activating or
+// reconnecting, and whether it's shut down can't be told until it settles
+type hostTransitioningError struct {
+	hostID string
+	state  string
+}
+
+func (e *hostTransitioningError) Error() string {
+	return fmt.Sprintf("Host [%s] is %s, retry once it settles", e.hostID, e.state)
+}
+
+// InstanceShutdownByProviderID tells whether the host with the given providerID is shut down: it
+// was deactivated, its agent has been disconnected for longer than disconnected-grace-period, or
+// its machine is stopped. Hosts that are gone return cloudprovider.InstanceNotFound, and hosts
+// activating or reconnecting an error, so that callers retry instead of acting on a stale state.
+func (r *CloudProvider) InstanceShutdownByProviderID(providerID string) (bool, error) {
+	hostID, err := r.parseProviderID(providerID)
+	if err != nil {
+		return false, err
+	}
+
+	host, err := r.hostAPI().hostByID(hostID)
+	if err != nil {
+		return false, fmt.Errorf("Couldn't get host by Id [%s]. Error: %#v", hostID, err)
+	}
+	if host == nil || hostRemoved(&host.Host) {
+		r.hostConnected(hostID)
+		return false, cloudprovider.InstanceNotFound
+	}
+	return r.hostShutdown(&host.Host)
+}
+
+// hostShutdown tells whether host is shut down, from its state, the state of its agent and the
+// state of its machine
+func (r *CloudProvider) hostShutdown(host *client.Host) (bool, error) {
+	state := strings.ToLower(host.State)
+	agentState := strings.ToLower(host.AgentState)
+	switch {
+	case state == "activating" || state == "reconnecting":
+		return false, &hostTransitioningError{hostID: host.Id, state: state}
+	case agentState == "activating" || agentState == "reconnecting" || agentState == "finishing-reconnect":
+		return false, &hostTransitioningError{hostID: host.Id, state: "agent " + agentState}
+	case state == "deactivating" || state == "inactive":
+		r.hostConnected(host.Id)
+		return true, nil
+	case agentState == "disconnecting" || agentState == "disconnected":
+		return r.hostDisconnected(host.Id), nil
+	}
+
+	r.hostConnected(host.Id)
+	if host.PhysicalHostId == "" {
+		return false, nil
+	}
+	return r.machineStopped(host.PhysicalHostId)
+}
+
+// machineStopped tells whether the machine with the given ID is reported stopped
+func (r *CloudProvider) machineStopped(id string) (bool, error) {
+	machine, err := r.client.PhysicalHost.ById(id)
+	if apiErr, ok := err.(*client.ApiError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Couldn't get machine by Id [%s]. Error: %#v", id, err)
+	}
+	if machine == nil {
+		return false, nil
+	}
+	switch strings.ToLower(machine.State) {
+	case "stopping", "stopped", "inactive":
+		return true, nil
+	}
+	return false, nil
+}
+
+// hostDisconnected records that the agent of the host with the given ID is disconnected and tells
+// whether it has been for longer than the grace period
+func (r *CloudProvider) hostDisconnected(hostID string) bool {
+	r.disconnectedLock.Lock()
+	defer r.disconnectedLock.Unlock()
+
+	if r.disconnectedSince == nil {
+		r.disconnectedSince = map[string]time.Time{}
+	}
+	since, ok := r.disconnectedSince[hostID]
+	if !ok {
+		since = time.Now()
+		r.disconnectedSince[hostID] = since
+	}
+	if disconnected := time.Since(since); disconnected < r.conf.disconnectedGracePeriod {
+		glog.V(4).Infof("Host [%s] disconnected %v ago, not reporting it shut down yet", hostID, disconnected)
+		return false
+	}
+	return true
+}
+
+// hostConnected forgets when the agent of the host with the given ID was disconnected
+func (r *CloudProvider) hostConnected(hostID string) {
+	r.disconnectedLock.Lock()
+	defer r.disconnectedLock.Unlock()
+	delete(r.disconnectedSince, hostID)
+}
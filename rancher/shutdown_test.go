@@ -0,0 +1,168 @@
+package rancher
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// physicalHosts are returned by the fake physical host client by ID
+var physicalHosts map[string]*client.PhysicalHost
+
+type fakePhysicalHostClient struct{}
+
+func (f *fakePhysicalHostClient) List(opts *client.ListOpts) (*client.PhysicalHostCollection, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakePhysicalHostClient) Create(opts *client.PhysicalHost) (*client.PhysicalHost, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakePhysicalHostClient) Update(existing *client.PhysicalHost, updates interface{}) (*client.PhysicalHost, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakePhysicalHostClient) ById(id string) (*client.PhysicalHost, error) {
+	if machine, ok := physicalHosts[id]; ok {
+		return machine, nil
+	}
+	return nil, &client.ApiError{StatusCode: http.StatusNotFound}
+}
+
+func (f *fakePhysicalHostClient) Delete(container *client.PhysicalHost) error {
+	return fmt.Errorf("not implemented")
+}
+
+func (f *fakePhysicalHostClient) ActionBootstrap(*client.PhysicalHost) (*client.PhysicalHost, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakePhysicalHostClient) ActionCreate(*client.PhysicalHost) (*client.PhysicalHost, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakePhysicalHostClient) ActionError(*client.PhysicalHost) (*client.PhysicalHost, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakePhysicalHostClient) ActionRemove(*client.PhysicalHost) (*client.PhysicalHost, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakePhysicalHostClient) ActionUpdate(*client.PhysicalHost) (*client.PhysicalHost, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func TestInstanceShutdownByProviderID(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	defer func() { hostByIdErrors, physicalHosts = nil, nil }()
+
+	hostByIdErrors = map[string]error{
+		"1h404": &client.ApiError{StatusCode: http.StatusNotFound},
+		"1h500": &client.ApiError{StatusCode: http.StatusInternalServerError},
+	}
+	physicalHosts = map[string]*client.PhysicalHost{
+		"1ph1": {State: "active"},
+		"1ph2": {State: "stopped"},
+		"1ph3": {State: "error"},
+	}
+	testCases := []struct {
+		name             string
+		host             client.Host
+		expectedShutdown bool
+		expectedError    bool
+		expectedNotFound bool
+	}{
+		{name: "active", host: client.Host{State: "active", AgentState: "active"}},
+		{name: "active without agent state", host: client.Host{State: "active"}},
+		{name: "requested", host: client.Host{State: "requested"}},
+		{name: "registering", host: client.Host{State: "registering"}},
+		{name: "updating-active", host: client.Host{State: "updating-active", AgentState: "active"}},
+		{name: "error", host: client.Host{State: "error", AgentState: "active"}},
+		{name: "deactivating", host: client.Host{State: "deactivating", AgentState: "active"}, expectedShutdown: true},
+		{name: "inactive", host: client.Host{State: "inactive"}, expectedShutdown: true},
+		{name: "uppercase inactive", host: client.Host{State: "Inactive"}, expectedShutdown: true},
+		{name: "updating-inactive", host: client.Host{State: "updating-inactive"}},
+		{name: "disconnecting", host: client.Host{State: "active", AgentState: "disconnecting"}, expectedShutdown: true},
+		{name: "disconnected", host: client.Host{State: "active", AgentState: "disconnected"}, expectedShutdown: true},
+		{name: "activating", host: client.Host{State: "activating"}, expectedError: true},
+		{name: "reconnecting", host: client.Host{State: "reconnecting"}, expectedError: true},
+		{name: "agent activating", host: client.Host{State: "active", AgentState: "activating"}, expectedError: true},
+		{name: "agent reconnecting", host: client.Host{State: "active", AgentState: "reconnecting"}, expectedError: true},
+		{name: "agent finishing reconnect", host: client.Host{State: "active", AgentState: "finishing-reconnect"}, expectedError: true},
+		{name: "running machine", host: client.Host{State: "active", PhysicalHostId: "1ph1"}},
+		{name: "stopped machine", host: client.Host{State: "active", PhysicalHostId: "1ph2"}, expectedShutdown: true},
+		{name: "failed machine", host: client.Host{State: "active", PhysicalHostId: "1ph3"}},
+		{name: "unknown machine", host: client.Host{State: "active", PhysicalHostId: "1ph4"}},
+		{name: "removing", host: client.Host{State: "removing"}, expectedNotFound: true},
+		{name: "removed", host: client.Host{State: "removed", Removed: "2017-08-01T10:00:00Z"}, expectedNotFound: true},
+		{name: "purged", host: client.Host{State: "purged", Removed: "2017-08-01T10:00:00Z"}, expectedNotFound: true},
+		{name: "unknown host", host: client.Host{Resource: client.Resource{Id: "1hunknown"}}, expectedNotFound: true},
+		{name: "not found", host: client.Host{Resource: client.Resource{Id: "1h404"}}, expectedNotFound: true},
+		{name: "server error", host: client.Host{Resource: client.Resource{Id: "1h500"}}, expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		providerID := "rancher://" + tc.host.Id
+		hostList = &client.HostCollection{}
+		if tc.host.Id == "" {
+			tc.host.Id = "1h11"
+			providerID = "rancher://1h11"
+			hostList.Data = []client.Host{tc.host}
+		}
+
+		shutdown, err := cloudProvider.InstanceShutdownByProviderID(providerID)
+		switch {
+		case tc.expectedNotFound:
+			if err != cloudprovider.InstanceNotFound {
+				t.Errorf("%s: expected the host not to be found, found %v, err: %v", tc.name, shutdown, err)
+			}
+		case tc.expectedError:
+			if err == nil || err == cloudprovider.InstanceNotFound || shutdown {
+				t.Errorf("%s: expected an error and no shutdown, found %v, err: %v", tc.name, shutdown, err)
+			}
+		case err != nil:
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		case shutdown != tc.expectedShutdown:
+			t.Errorf("%s: expected host to be shut down: %v, found %v", tc.name, tc.expectedShutdown, shutdown)
+		}
+	}
+}
+
+func TestInstanceShutdownByProviderIDDisconnectedGracePeriod(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+
+	r := &CloudProvider{client: testClient, conf: &rConfig{disconnectedGracePeriod: time.Minute}}
+	hostList = &client.HostCollection{
+		Data: []client.Host{{Resource: client.Resource{Id: "1h11"}, State: "active", AgentState: "disconnected"}},
+	}
+
+	// the agent just disconnected
+	if shutdown, err := r.InstanceShutdownByProviderID("rancher://1h11"); err != nil || shutdown {
+		t.Errorf("expected a host disconnected within the grace period not to be shut down, found %v, err: %v", shutdown, err)
+	}
+
+	// the agent has been disconnected for longer than the grace period
+	r.disconnectedSince["1h11"] = time.Now().Add(-2 * time.Minute)
+	if shutdown, err := r.InstanceShutdownByProviderID("rancher://1h11"); err != nil || !shutdown {
+		t.Errorf("expected a host disconnected past the grace period to be shut down, found %v, err: %v", shutdown, err)
+	}
+
+	// the agent reconnects, then disconnects again
+	hostList.Data[0].AgentState = "active"
+	if shutdown, err := r.InstanceShutdownByProviderID("rancher://1h11"); err != nil || shutdown {
+		t.Errorf("expected a connected host not to be shut down, found %v, err: %v", shutdown, err)
+	}
+	hostList.Data[0].AgentState = "disconnected"
+	if shutdown, err := r.InstanceShutdownByProviderID("rancher://1h11"); err != nil || shutdown {
+		t.Errorf("expected the grace period to start over once the host disconnects again, found %v, err: %v", shutdown, err)
+	}
+}
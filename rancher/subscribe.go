@@ -0,0 +1,178 @@
+package rancher
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/websocket"
+	"github.com/rancher/go-rancher/client"
+)
+
+var (
+	// subscribeBaseDelay is the delay before resubscribing after the subscription failed, doubled
+	// for every failure in a row up to subscribeMaxDelay
+	subscribeBaseDelay = time.Second
+	subscribeMaxDelay  = 5 * time.Minute
+	// subscribeReadTimeout is how long the subscription may stay silent, Rancher sends a ping event
+	// every few seconds
+	subscribeReadTimeout = time.Minute
+)
+
+// hostEvent is an event of the subscription to the Rancher API
+type hostEvent struct {
+	Name         string `json:"name"`
+	ResourceType string `json:"resourceType"`
+	ResourceID   string `json:"resourceId"`
+	Data         struct {
+		Resource client.Host `json:"resource"`
+	} `json:"data"`
+}
+
+// WatchHostEvents subscribes to the host change events of the Rancher API if subscribe-host-events
+// is set, until stop is closed. The host list cache is updated with the changed hosts, and changed
+// is called with their names. The subscription is made again with backoff whenever it fails, the
+// hosts are polled as usual meanwhile.
+func (r *CloudProvider) WatchHostEvents(stop <-chan struct{}, changed func(name string)) {
+	if !r.conf.Global.SubscribeHostEvents {
+		return
+	}
+	if r.conf.apiVersion == apiVersionV3 {
+		glog.Warningf("Host events are only subscribed to with the %s API, polling the nodes instead", apiVersionV2Beta)
+		return
+	}
+
+	failures := 0
+	for {
+		start := time.Now()
+		err := r.subscribe(stop, changed)
+		hostEventSubscriptionUp.Set(0)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		// a subscription that was up for a while failed on its own
+		if time.Since(start) > subscribeMaxDelay {
+			failures = 0
+		}
+		failures++
+		delay := subscribeDelay(failures)
+		glog.Warningf("Subscription to the host events of the Rancher API failed, subscribing again in %v. Error: %v", delay, err)
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// subscribeDelay returns how long to wait before subscribing again after failures failures in a row
+func subscribeDelay(failures int) time.Duration {
+	delay := subscribeBaseDelay
+	for i := 1; i < failures && delay < subscribeMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > subscribeMaxDelay {
+		delay = subscribeMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// subscribe reads the host events of a subscription until it fails or stop is closed
+func (r *CloudProvider) subscribe(stop <-chan struct{}, changed func(name string)) error {
+	conn, err := r.dialSubscription()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// unblock the read below once stopped
+		select {
+		case <-stop:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	glog.Infof("Subscribed to the host events of the Rancher API")
+	// hosts may have changed while unsubscribed
+	r.hosts.invalidate()
+	hostEventSubscriptionUp.Set(1)
+	for {
+		conn.SetReadDeadline(time.Now().Add(subscribeReadTimeout))
+		event := &hostEvent{}
+		if err := conn.ReadJSON(event); err != nil {
+			return err
+		}
+		if event.Name != "resource.change" || event.ResourceType != client.HOST_TYPE {
+			continue
+		}
+
+		hostEvents.Inc()
+		host := &event.Data.Resource
+		glog.V(2).Infof("Host [%s] %s changed, state %s, agent state %s", host.Id, host.Hostname, host.State, host.AgentState)
+		r.hosts.update(host)
+		if host.Hostname != "" {
+			r.removeFromCache(host.Hostname)
+			if changed != nil {
+				changed(strings.ToLower(host.Hostname))
+			}
+		}
+	}
+}
+
+// dialSubscription opens the subscription to the host events, at the first endpoint of the
+// Rancher API that accepts it
+func (r *CloudProvider) dialSubscription() (*websocket.Conn, error) {
+	u, err := url.Parse(r.conf.apiURL())
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/subscribe"
+	u.RawQuery = url.Values{"eventNames": {"resource.change"}}.Encode()
+
+	header := http.Header{}
+	authorization := r.conf.authorization()
+	if apiTransport != nil {
+		if group := apiTransport.group(u.Host); group != nil {
+			// the credentials may have been rotated
+			if rotated := group.requestAuthorization(); rotated != "" {
+				authorization = rotated
+			}
+		}
+	}
+	header.Set("Authorization", authorization)
+	dialer := &websocket.Dialer{TLSClientConfig: r.conf.tls, HandshakeTimeout: 30 * time.Second}
+
+	var lastErr error
+	for _, apiURL := range r.conf.Global.CattleURLs {
+		endpoint, err := url.Parse(apiURL)
+		if err != nil {
+			continue
+		}
+		subscription := *u
+		subscription.Host = endpoint.Host
+		subscription.Scheme = "ws"
+		if endpoint.Scheme == "https" {
+			subscription.Scheme = "wss"
+		}
+
+		conn, resp, err := dialer.Dial(subscription.String(), header)
+		if err == nil {
+			return conn, nil
+		}
+		if resp != nil {
+			err = fmt.Errorf("%v: %s", err, resp.Status)
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
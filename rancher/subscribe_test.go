@@ -0,0 +1,138 @@
+package rancher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rancher/go-rancher/client"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newFakeSubscription returns a Rancher API accepting subscriptions, whose connections are sent to
+// the returned channel
+func newFakeSubscription(t *testing.T) (*httptest.Server, chan *websocket.Conn) {
+	connections := make(chan *websocket.Conn, 5)
+	upgrader := &websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v2-beta/subscribe" || req.URL.Query().Get("eventNames") != "resource.change" {
+			t.Errorf("unexpected subscription %s", req.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if req.Header.Get("Authorization") != basicAuth("access", "secret") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			t.Errorf("Couldn't upgrade the subscription: %v", err)
+			return
+		}
+		connections <- conn
+	}))
+	return server, connections
+}
+
+func TestWatchHostEvents(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	defer func(delay time.Duration) { subscribeBaseDelay = delay }(subscribeBaseDelay)
+	subscribeBaseDelay = time.Millisecond
+
+	hostList = &client.HostCollection{Data: []client.Host{{Resource: client.Resource{Id: "1h1"}, Hostname: "Host1", State: "active"}}}
+	ipAddressLinks["1h1"] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "10.0.0.1"}}}
+	server, connections := newFakeSubscription(t)
+	defer server.Close()
+
+	r := newCachingCloudProvider(time.Hour)
+	r.conf.Global.CattleURLs = []string{server.URL + "/v2-beta"}
+	r.conf.Global.CattleAccessKey, r.conf.Global.CattleSecretKey = "access", "secret"
+	r.conf.Global.SubscribeHostEvents = true
+
+	stop := make(chan struct{})
+	changed := make(chan string, 5)
+	watching := make(chan struct{})
+	go func() {
+		r.WatchHostEvents(stop, func(name string) { changed <- name })
+		close(watching)
+	}()
+
+	var conn *websocket.Conn
+	select {
+	case conn = <-connections:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the host events to be subscribed to")
+	}
+	// the cache is invalidated once subscribed
+	for subscriptionUp(t) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := r.NodeAddresses("host1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	listed := hostListPages
+
+	event := &hostEvent{Name: "resource.change", ResourceType: client.HOST_TYPE, ResourceID: "1h1"}
+	event.Data.Resource = client.Host{Resource: client.Resource{Id: "1h1"}, Hostname: "Host1", State: "inactive"}
+	conn.WriteJSON(map[string]string{"name": "ping"})
+	conn.WriteJSON(event)
+	select {
+	case name := <-changed:
+		if name != "host1" {
+			t.Errorf("expected host1 to change, found %s", name)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the host change to be reported")
+	}
+	if host, ok := r.hosts.getByID("1h1"); !ok || host.RancherHost.State != "inactive" || len(host.IPAddresses) != 1 {
+		t.Errorf("expected the cached host to be updated, found %#v", host)
+	}
+	if hostListPages != listed {
+		t.Errorf("expected the hosts not to be listed again, listed %d pages", hostListPages-listed)
+	}
+
+	// the subscription is made again once closed
+	conn.Close()
+	select {
+	case conn = <-connections:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the host events to be subscribed to again")
+	}
+
+	close(stop)
+	select {
+	case <-watching:
+	case <-time.After(10 * time.Second):
+		t.Errorf("expected the subscription to stop")
+	}
+	conn.Close()
+
+	// events aren't subscribed to unless configured
+	r.conf.Global.SubscribeHostEvents = false
+	r.WatchHostEvents(make(chan struct{}), nil)
+}
+
+func subscriptionUp(t *testing.T) float64 {
+	m := &dto.Metric{}
+	if err := hostEventSubscriptionUp.Write(m); err != nil {
+		t.Fatalf("Error reading metric: %v", err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestSubscribeDelay(t *testing.T) {
+	for failures := 1; failures < 20; failures++ {
+		delay := subscribeDelay(failures)
+		max := subscribeBaseDelay << uint(failures-1)
+		if max > subscribeMaxDelay || max <= 0 {
+			max = subscribeMaxDelay
+		}
+		if delay < max/2 || delay > max {
+			t.Errorf("%d failures: expected a delay between %v and %v, found %v", failures, max/2, max, delay)
+		}
+	}
+}
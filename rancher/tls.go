@@ -0,0 +1,85 @@
+package rancher
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// tlsConfig returns the TLS configuration of the connections to the Rancher API, or nil to use
+// the defaults. A CA that can't be read or parsed is an error rather than falling back to the
+// system trust store.
+func (c *rConfig) tlsConfig() (*tls.Config, error) {
+	g := c.Global
+	if g.CAFile == "" && g.CAData == "" && g.ClientCertFile == "" && g.ClientKeyFile == "" && !g.InsecureSkipVerify {
+		return nil, nil
+	}
+	if g.InsecureSkipVerify && (g.CAFile != "" || g.CAData != "") {
+		return nil, fmt.Errorf("Invalid cloud config: insecure-skip-verify can't be combined with ca-file or ca-data")
+	}
+	if g.CAFile != "" && g.CAData != "" {
+		return nil, fmt.Errorf("Invalid cloud config: ca-file can't be combined with ca-data")
+	}
+	if (g.ClientCertFile == "") != (g.ClientKeyFile == "") {
+		return nil, fmt.Errorf("Invalid cloud config: client-cert-file and client-key-file must be set together")
+	}
+
+	config := &tls.Config{InsecureSkipVerify: g.InsecureSkipVerify}
+
+	var ca []byte
+	switch {
+	case g.CAFile != "":
+		data, err := ioutil.ReadFile(g.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't read ca-file [%s]. Error: %v", g.CAFile, err)
+		}
+		ca = data
+	case g.CAData != "":
+		data, err := base64.StdEncoding.DecodeString(g.CAData)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ca-data: must be a base64 encoded PEM certificate. Error: %v", err)
+		}
+		ca = data
+	}
+	if ca != nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("Invalid cloud config: no PEM certificate found in the CA of the Rancher API")
+		}
+		config.RootCAs = pool
+	}
+
+	if g.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(g.ClientCertFile, g.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't load client-cert-file [%s] and client-key-file [%s]. Error: %v",
+				g.ClientCertFile, g.ClientKeyFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// newTLSTransport returns a transport connecting with config, set up like http.DefaultTransport
+func newTLSTransport(config *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       config,
+	}
+}
@@ -0,0 +1,110 @@
+package rancher
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed client certificate and its key to dir, and returns
+// their paths and the certificate
+func writeTestCertificate(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Couldn't generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cloud-controller-manager"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Couldn't create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Couldn't marshal key: %v", err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile, cert
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rancher-tls")
+	if err != nil {
+		t.Fatalf("Couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, clientCert := writeTestCertificate(t, dir)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	cattle := newFakeCattleTLS(&tls.Config{})
+	defer cattle.Close()
+	mutualCattle := newFakeCattleTLS(&tls.Config{ClientCAs: clientCAs, ClientAuth: tls.RequireAndVerifyClientCert})
+	defer mutualCattle.Close()
+
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cattle.TLS.Certificates[0].Certificate[0]})
+	caFile := filepath.Join(dir, "ca.crt")
+	ioutil.WriteFile(caFile, serverCA, 0600)
+	badCAFile := filepath.Join(dir, "bad.crt")
+	ioutil.WriteFile(badCAFile, []byte("not a certificate"), 0600)
+
+	tests := []struct {
+		name      string
+		url       string
+		config    string
+		invalid   bool
+		connected bool
+	}{
+		{name: "system CAs", url: cattle.URL},
+		{name: "ca file", url: cattle.URL, config: "ca-file = " + caFile, connected: true},
+		{name: "ca data", url: cattle.URL, config: "ca-data = " + base64.StdEncoding.EncodeToString(serverCA), connected: true},
+		{name: "insecure", url: cattle.URL, config: "insecure-skip-verify = true", connected: true},
+		{name: "bad ca file", url: cattle.URL, config: "ca-file = " + badCAFile, invalid: true},
+		{name: "missing ca file", url: cattle.URL, config: "ca-file = " + filepath.Join(dir, "missing.crt"), invalid: true},
+		{name: "ca data not base64", url: cattle.URL, config: "ca-data = " + string(serverCA[:27]), invalid: true},
+		{name: "insecure with ca", url: cattle.URL, config: "ca-file = " + caFile + "\ninsecure-skip-verify = true", invalid: true},
+		{name: "client cert without key", url: cattle.URL, config: "client-cert-file = " + certFile, invalid: true},
+		{name: "no client cert", url: mutualCattle.URL, config: "ca-file = " + caFile},
+		{name: "client cert", url: mutualCattle.URL, config: "ca-file = " + caFile + "\nclient-cert-file = " + certFile + "\nclient-key-file = " + keyFile, connected: true},
+		{name: "client key mismatch", url: mutualCattle.URL, config: "client-cert-file = " + certFile + "\nclient-key-file = " + caFile, invalid: true},
+	}
+
+	for _, test := range tests {
+		config := "[Global]\ncattle-url = " + test.url + "/v2-beta\n" + test.config + "\n"
+		if _, err := readConfig(strings.NewReader(config)); (err != nil) != test.invalid {
+			t.Errorf("%s: expected invalid %v, err: %v", test.name, test.invalid, err)
+			continue
+		}
+		if test.invalid {
+			continue
+		}
+		_, err := newRancherCloud(strings.NewReader(config))
+		if (err == nil) != test.connected {
+			t.Errorf("%s: expected connected %v, err: %v", test.name, test.connected, err)
+		}
+	}
+}
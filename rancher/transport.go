@@ -0,0 +1,279 @@
+package rancher
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	apiVersionSegment = regexp.MustCompile("^v[0-9]+(-[a-z]+)?$")
+	resourceIDSegment = regexp.MustCompile("^[0-9]+[a-z]+[0-9]+$")
+	// v3 node IDs are the ID of their cluster and of their machine, e.g. c-abc12:m-7k2lq
+	v3ResourceIDSegment = regexp.MustCompile("^[a-z0-9-]+:[a-z0-9-]+$")
+
+	installTransport sync.Once
+	apiTransport     *rancherTransport
+)
+
+// rancherTransport instruments the requests sent to the Rancher API, fails over between the
+// endpoints of the API, retries requests failing with a transient status and authenticates
+// requests with a bearer token or with rotated credentials if there are any.
+// The go-rancher client builds its http.Client without a transport and only knows a single URL
+// and basic auth, so the only way to hook into its requests is to wrap http.DefaultTransport.
+// Requests to hosts that were not registered as Rancher API endpoints are passed through untouched.
+type rancherTransport struct {
+	base http.RoundTripper
+
+	sync.RWMutex
+	// groups maps the host of every registered endpoint to the endpoints it can fail over to
+	groups map[string]*endpointGroup
+}
+
+// registerAPIEndpoints makes sure requests to the Rancher API at apiURLs go through the rancherTransport.
+// Requests to any of the URLs are sent to the first one that is reachable.
+// If token is set it replaces the basic auth credentials the go-rancher client sends. If tlsConfig
+// is set the connections to the endpoints are made with it.
+func registerAPIEndpoints(apiURLs []string, token string, tlsConfig *tls.Config) error {
+	if len(apiURLs) == 0 {
+		return fmt.Errorf("No Rancher API url configured")
+	}
+
+	endpoints := []*url.URL{}
+	for _, apiURL := range apiURLs {
+		u, err := url.Parse(apiURL)
+		if err != nil {
+			return fmt.Errorf("Couldn't parse url [%s]. Error: %#v", apiURL, err)
+		}
+		endpoints = append(endpoints, u)
+	}
+
+	installTransport.Do(func() {
+		apiTransport = &rancherTransport{
+			base:   http.DefaultTransport,
+			groups: map[string]*endpointGroup{},
+		}
+		http.DefaultTransport = apiTransport
+	})
+
+	group := newEndpointGroup(endpoints, token, time.Now)
+	if tlsConfig != nil {
+		group.transport = newTLSTransport(tlsConfig)
+	}
+	apiTransport.Lock()
+	defer apiTransport.Unlock()
+	for _, u := range endpoints {
+		apiTransport.groups[u.Host] = group
+	}
+	return nil
+}
+
+// setRequestTimeout makes the requests to the Rancher API at apiURLs fail after timeout, 0 for no limit
+func setRequestTimeout(apiURLs []string, timeout time.Duration) {
+	if apiTransport == nil {
+		return
+	}
+	for _, apiURL := range apiURLs {
+		u, err := url.Parse(apiURL)
+		if err != nil {
+			continue
+		}
+		if group := apiTransport.group(u.Host); group != nil {
+			group.setTimeout(timeout)
+		}
+	}
+}
+
+// SetRequestTimeout makes the requests to the Rancher API fail after timeout, 0 for no limit
+func (r *CloudProvider) SetRequestTimeout(timeout time.Duration) {
+	setRequestTimeout(r.conf.Global.CattleURLs, timeout)
+}
+
+func (t *rancherTransport) group(host string) *endpointGroup {
+	t.RLock()
+	defer t.RUnlock()
+	return t.groups[host]
+}
+
+// roundTripper returns the transport connecting to the endpoints of group
+func (t *rancherTransport) roundTripper(group *endpointGroup) http.RoundTripper {
+	if group != nil && group.transport != nil {
+		return group.transport
+	}
+	return t.base
+}
+
+func (t *rancherTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	group := t.group(req.URL.Host)
+	if group == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	// the body is sent again on failover, so it has to be kept around
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	attempts := group.attempts()
+	for attempt := 1; ; attempt++ {
+		resp, err := t.send(req, group, body)
+		if err != nil || !transientStatus(resp.StatusCode) || !idempotent(req.Method) || attempt >= attempts {
+			return resp, err
+		}
+		delay, ok := retryDelay(attempt, resp)
+		if !ok {
+			return resp, err
+		}
+
+		glog.V(2).Infof("Rancher API request %s %s failed with status %d, retrying in %v", req.Method, req.URL, resp.StatusCode, delay)
+		apiRetries.WithLabelValues(apiOperation(req), statusClass(resp, nil)).Inc()
+		discard(resp.Body)
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// send sends req to the endpoints of group, failing over to the next endpoint if one can't be reached
+func (t *rancherTransport) send(req *http.Request, group *endpointGroup, body []byte) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+	timeout := group.requestTimeout()
+	authorization := group.requestAuthorization()
+	for _, i := range group.candidates() {
+		attempt := forEndpoint(req, group.endpoints[i], body, authorization)
+		if glog.V(6) {
+			glog.Infof("Rancher API request: %s %s %v", attempt.Method, attempt.URL, redactHeader(attempt.Header))
+		}
+		cancel := context.CancelFunc(func() {})
+		if timeout > 0 {
+			var ctx context.Context
+			ctx, cancel = context.WithTimeout(attempt.Context(), timeout)
+			attempt = attempt.WithContext(ctx)
+		}
+
+		start := time.Now()
+		resp, err = t.roundTripper(group).RoundTrip(attempt)
+		if err != nil && attempt.Context().Err() == context.DeadlineExceeded {
+			err = &requestTimeoutError{method: attempt.Method, url: attempt.URL.String(), timeout: timeout}
+		}
+		observeAPIRequest(apiOperation(attempt), resp, err, time.Since(start))
+		if err == nil {
+			group.succeeded(i)
+			// the deadline covers reading the body too
+			resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		cancel()
+		if !retriable(req.Method, err) {
+			break
+		}
+		glog.V(2).Infof("Rancher API request %s %s failed: %v", attempt.Method, attempt.URL, err)
+	}
+	return resp, err
+}
+
+// requestTimeoutError is returned by the requests to the Rancher API that timed out
+type requestTimeoutError struct {
+	method  string
+	url     string
+	timeout time.Duration
+}
+
+func (e *requestTimeoutError) Error() string {
+	return fmt.Sprintf("Rancher API request %s %s timed out after %v", e.method, e.url, e.timeout)
+}
+
+// Timeout and Temporary make requestTimeoutError a net.Error
+func (e *requestTimeoutError) Timeout() bool   { return true }
+func (e *requestTimeoutError) Temporary() bool { return true }
+
+// cancelOnClose releases the context of a request once its response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// forEndpoint returns a copy of req addressed to endpoint. A RoundTripper must not modify
+// the request it was given.
+func forEndpoint(req *http.Request, endpoint *url.URL, body []byte, authorization string) *http.Request {
+	r := new(http.Request)
+	*r = *req
+
+	u := *req.URL
+	u.Scheme = endpoint.Scheme
+	u.Host = endpoint.Host
+	r.URL = &u
+	r.Host = ""
+
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+
+	if body != nil {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return r
+}
+
+// apiOperation names the operation a Rancher API request performs after its method, the type of
+// resource it addresses and the action it invokes, e.g. get_hosts or post_loadbalancerservices_activate.
+func apiOperation(req *http.Request) string {
+	resource := "root"
+	for _, segment := range strings.Split(strings.Trim(req.URL.Path, "/"), "/") {
+		if segment == "" || apiVersionSegment.MatchString(segment) || resourceIDSegment.MatchString(segment) ||
+			v3ResourceIDSegment.MatchString(segment) {
+			continue
+		}
+		resource = strings.ToLower(segment)
+	}
+
+	operation := strings.ToLower(req.Method) + "_" + resource
+	if action := req.URL.Query().Get("action"); action != "" {
+		operation += "_" + strings.ToLower(action)
+	}
+	return operation
+}
+
+// redactHeader returns a copy of h that is safe to log: credentials are reduced to their
+// auth scheme, whether they are basic auth keys or bearer tokens.
+func redactHeader(h http.Header) http.Header {
+	redacted := make(http.Header, len(h))
+	for k, v := range h {
+		redacted[k] = v
+	}
+	if auth := h.Get("Authorization"); auth != "" {
+		scheme := strings.SplitN(auth, " ", 2)[0]
+		redacted.Set("Authorization", scheme+" <redacted>")
+	}
+	return redacted
+}
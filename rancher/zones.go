@@ -0,0 +1,85 @@
+package rancher
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// Default host labels telling the zone and region of a host. Hosts without them have no zone.
+const (
+	hostZoneLabel   = "io.rancher.host.zone"
+	hostRegionLabel = "io.rancher.host.region"
+)
+
+// localNodeName returns the name of the node the controller manager runs on, from the NODE_NAME
+// environment variable set with the downward API, or the hostname
+var localNodeName = func() string {
+	if name := os.Getenv("NODE_NAME"); name != "" {
+		return name
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+// GetZone returns the zone of the host the controller manager runs on. It's empty if that host
+// can't be found, e.g. when the controller manager runs outside of the cluster.
+func (r *CloudProvider) GetZone() (cloudprovider.Zone, error) {
+	name := localNodeName()
+	if name == "" {
+		return cloudprovider.Zone{}, nil
+	}
+	host, err := r.hostGetOrFetchFromCache(strings.ToLower(name))
+	if err != nil {
+		glog.V(2).Infof("Couldn't find the host of the controller manager %s, it has no zone. Error: %v", name, err)
+		return cloudprovider.Zone{}, nil
+	}
+	return r.hostZone(host), nil
+}
+
+// GetZoneByProviderID returns the zone of the host with the given providerID
+func (r *CloudProvider) GetZoneByProviderID(providerID string) (cloudprovider.Zone, error) {
+	hostID, err := r.parseProviderID(providerID)
+	if err != nil {
+		return cloudprovider.Zone{}, err
+	}
+
+	host, err := r.hostGetById(hostID)
+	if err != nil {
+		return cloudprovider.Zone{}, err
+	}
+	return r.hostZone(host), nil
+}
+
+// GetZoneByNodeName returns the zone of the host of a node
+func (r *CloudProvider) GetZoneByNodeName(nodeName types.NodeName) (cloudprovider.Zone, error) {
+	host, err := r.hostGetOrFetchFromCache(string(nodeName))
+	if err != nil {
+		return cloudprovider.Zone{}, err
+	}
+	return r.hostZone(host), nil
+}
+
+// hostZone returns the zone of host from its zone and region labels
+func (r *CloudProvider) hostZone(host *Host) cloudprovider.Zone {
+	zoneLabel, regionLabel := r.conf.zoneLabels()
+	zone := cloudprovider.Zone{
+		FailureDomain: hostLabel(host, zoneLabel),
+		Region:        hostLabel(host, regionLabel),
+	}
+	glog.V(4).Infof("Host %s is in zone [%s] of region [%s]", host.RancherHost.Id, zone.FailureDomain, zone.Region)
+	return zone
+}
+
+func hostLabel(host *Host, key string) string {
+	value, ok := host.RancherHost.Labels[key]
+	if !ok || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,114 @@
+package rancher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/client"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+func TestGetZoneByNode(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	hostList = &client.HostCollection{
+		Data: []client.Host{
+			client.Host{
+				Resource: client.Resource{Id: "1h8"},
+				Hostname: "zonehost",
+				Labels:   map[string]interface{}{hostZoneLabel: "eu-west-1a", hostRegionLabel: "eu-west-1"},
+			},
+			client.Host{
+				Resource: client.Resource{Id: "1h9"},
+				Hostname: "nozonehost",
+			},
+		},
+	}
+	ipAddressLinks["1h8"] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "10.0.0.8"}}}
+	ipAddressLinks["1h9"] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "10.0.0.9"}}}
+	expected := cloudprovider.Zone{FailureDomain: "eu-west-1a", Region: "eu-west-1"}
+
+	zone, err := cloudProvider.GetZoneByProviderID("rancher://1h8")
+	if err != nil || zone != expected {
+		t.Errorf("expected zone %v by providerID, found %v, err: %v", expected, zone, err)
+	}
+	zone, err = cloudProvider.GetZoneByNodeName("zonehost")
+	if err != nil || zone != expected {
+		t.Errorf("expected zone %v by node name, found %v, err: %v", expected, zone, err)
+	}
+
+	zone, err = cloudProvider.GetZoneByProviderID("rancher://1h9")
+	if err != nil || zone != (cloudprovider.Zone{}) {
+		t.Errorf("expected no zone for a host without zone labels, found %v, err: %v", zone, err)
+	}
+	if _, err := cloudProvider.GetZoneByProviderID("rancher://1h10"); err == nil {
+		t.Errorf("expected an error for a missing host")
+	}
+}
+
+func TestZonesFromConfiguredLabels(t *testing.T) {
+	hostTestSerializer.Lock()
+	defer hostTestSerializer.Unlock()
+	defer func(name func() string) { localNodeName = name }(localNodeName)
+	hostList = &client.HostCollection{
+		Data: []client.Host{
+			client.Host{
+				Resource: client.Resource{Id: "1h8"},
+				Hostname: "rackhost",
+				Labels:   map[string]interface{}{"rack": "r12", "io.rancher.host.datacenter": "fra1", hostZoneLabel: "eu-west-1a"},
+			},
+			client.Host{
+				Resource: client.Resource{Id: "1h9"},
+				Hostname: "norackhost",
+				Labels:   map[string]interface{}{"rack": nil},
+			},
+		},
+	}
+	ipAddressLinks["1h8"] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "10.0.0.8"}}}
+	ipAddressLinks["1h9"] = &client.IpAddressCollection{Data: []client.IpAddress{{Address: "10.0.0.9"}}}
+	r := newCachingCloudProvider(time.Minute)
+	r.conf.Global.ZoneLabel, r.conf.Global.RegionLabel = "rack", "io.rancher.host.datacenter"
+
+	tests := []struct {
+		name   string
+		lookup func() (cloudprovider.Zone, error)
+		expect cloudprovider.Zone
+	}{
+		{
+			name:   "by providerID",
+			lookup: func() (cloudprovider.Zone, error) { return r.GetZoneByProviderID("rancher://1h8") },
+			expect: cloudprovider.Zone{FailureDomain: "r12", Region: "fra1"},
+		},
+		{
+			name:   "by node name",
+			lookup: func() (cloudprovider.Zone, error) { return r.GetZoneByNodeName("rackhost") },
+			expect: cloudprovider.Zone{FailureDomain: "r12", Region: "fra1"},
+		},
+		{
+			name:   "without labels",
+			lookup: func() (cloudprovider.Zone, error) { return r.GetZoneByNodeName("norackhost") },
+		},
+		{
+			name: "of the controller",
+			lookup: func() (cloudprovider.Zone, error) {
+				localNodeName = func() string { return "RackHost" }
+				return r.GetZone()
+			},
+			expect: cloudprovider.Zone{FailureDomain: "r12", Region: "fra1"},
+		},
+		{
+			name: "of a controller outside of the cluster",
+			lookup: func() (cloudprovider.Zone, error) {
+				localNodeName = func() string { return "laptop" }
+				return r.GetZone()
+			},
+		},
+	}
+
+	for _, test := range tests {
+		zone, err := test.lookup()
+		if err != nil || zone != test.expect {
+			t.Errorf("%s: expected zone %v, found %v, err: %v", test.name, test.expect, zone, err)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+// Package testutil helps testing the controllers under adverse conditions
+package testutil
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/cloudprovider"
+)
+
+// ErrInjected is returned by the calls FaultyCloud fails, unless Faults.Err is set
+var ErrInjected = errors.New("injected fault")
+
+// Faults are injected in the calls of a method of FaultyCloud
+type Faults struct {
+	// Latency returns how long to delay each call, if set
+	Latency func() time.Duration
+	// ErrorRate is the fraction of the calls failing with Err
+	ErrorRate float64
+	// Err is returned by the failed calls, ErrInjected if nil
+	Err error
+}
+
+// UniformLatency returns latencies evenly distributed between min and max
+func UniformLatency(min, max time.Duration) func() time.Duration {
+	return func() time.Duration {
+		return min + time.Duration(rand.Int63n(int64(max-min)+1))
+	}
+}
+
+// FaultyCloud is a cloud provider delegating to another one while injecting faults. The calls of
+// the instances, zones and load balancer methods can be delayed, failed, answered by another
+// provider after some calls, and paused so tests can interleave goroutines deterministically.
+// Methods are named as in cloudprovider, e.g. "ExternalID".
+type FaultyCloud struct {
+	cloud cloudprovider.Interface
+
+	lock     sync.Mutex
+	rand     *rand.Rand
+	faults   map[string]Faults
+	calls    map[string]int
+	switches map[string]switchAfter
+	gates    map[string]*gate
+}
+
+// switchAfter makes the calls of a method after the first n answered by cloud
+type switchAfter struct {
+	n     int
+	cloud cloudprovider.Interface
+}
+
+// gate holds the calls of a paused method until it's resumed
+type gate struct {
+	open    chan struct{}
+	waiting int
+	arrived *sync.Cond
+}
+
+var _ cloudprovider.Interface = &FaultyCloud{}
+
+// NewFaultyCloud wraps cloud. Faults are drawn from a source seeded with seed, so runs can be
+// reproduced.
+func NewFaultyCloud(cloud cloudprovider.Interface, seed int64) *FaultyCloud {
+	return &FaultyCloud{
+		cloud:    cloud,
+		rand:     rand.New(rand.NewSource(seed)),
+		faults:   map[string]Faults{},
+		calls:    map[string]int{},
+		switches: map[string]switchAfter{},
+		gates:    map[string]*gate{},
+	}
+}
+
+// SetFaults injects faults in the calls of method
+func (f *FaultyCloud) SetFaults(method string, faults Faults) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.faults[method] = faults
+}
+
+// SwitchAfter makes cloud answer the calls of method after the first n ones
+func (f *FaultyCloud) SwitchAfter(method string, n int, cloud cloudprovider.Interface) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.switches[method] = switchAfter{n: n, cloud: cloud}
+}
+
+// Pause holds the calls of method until Resume is called
+func (f *FaultyCloud) Pause(method string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.gates[method]; !ok {
+		f.gates[method] = &gate{open: make(chan struct{}), arrived: sync.NewCond(&f.lock)}
+	}
+}
+
+// Resume lets the held and later calls of method proceed
+func (f *FaultyCloud) Resume(method string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if g, ok := f.gates[method]; ok {
+		close(g.open)
+		delete(f.gates, method)
+	}
+}
+
+// WaitForPaused waits until n calls of the paused method are held, or fails after timeout
+func (f *FaultyCloud) WaitForPaused(method string, n int, timeout time.Duration) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	g, ok := f.gates[method]
+	if !ok {
+		return fmt.Errorf("%s isn't paused", method)
+	}
+
+	timedOut := false
+	timer := time.AfterFunc(timeout, func() {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		timedOut = true
+		g.arrived.Broadcast()
+	})
+	defer timer.Stop()
+	for g.waiting < n {
+		if timedOut {
+			return fmt.Errorf("%d calls of %s held after %v, expected %d", g.waiting, method, timeout, n)
+		}
+		g.arrived.Wait()
+	}
+	return nil
+}
+
+// Calls returns the number of calls of method so far
+func (f *FaultyCloud) Calls(method string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.calls[method]
+}
+
+// call accounts for a call of method and applies its faults. It returns the cloud answering it.
+func (f *FaultyCloud) call(method string) (cloudprovider.Interface, error) {
+	f.lock.Lock()
+	f.calls[method]++
+	cloud := f.cloud
+	if sw, ok := f.switches[method]; ok && f.calls[method] > sw.n {
+		cloud = sw.cloud
+	}
+	faults := f.faults[method]
+	failed := faults.ErrorRate > 0 && f.rand.Float64() < faults.ErrorRate
+	g := f.gates[method]
+	if g != nil {
+		g.waiting++
+		g.arrived.Broadcast()
+	}
+	f.lock.Unlock()
+
+	if g != nil {
+		<-g.open
+	}
+	if faults.Latency != nil {
+		time.Sleep(faults.Latency())
+	}
+	if failed {
+		if faults.Err != nil {
+			return nil, faults.Err
+		}
+		return nil, ErrInjected
+	}
+	return cloud, nil
+}
+
+func (f *FaultyCloud) instances(method string) (cloudprovider.Instances, error) {
+	cloud, err := f.call(method)
+	if err != nil {
+		return nil, err
+	}
+	instances, ok := cloud.Instances()
+	if !ok {
+		return nil, fmt.Errorf("cloud provider doesn't support instances")
+	}
+	return instances, nil
+}
+
+func (f *FaultyCloud) loadBalancer(method string) (cloudprovider.LoadBalancer, error) {
+	cloud, err := f.call(method)
+	if err != nil {
+		return nil, err
+	}
+	lb, ok := cloud.LoadBalancer()
+	if !ok {
+		return nil, fmt.Errorf("cloud provider doesn't support load balancers")
+	}
+	return lb, nil
+}
+
+func (f *FaultyCloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
+	_, ok := f.cloud.LoadBalancer()
+	return f, ok
+}
+
+func (f *FaultyCloud) Instances() (cloudprovider.Instances, bool) {
+	_, ok := f.cloud.Instances()
+	return f, ok
+}
+
+func (f *FaultyCloud) Zones() (cloudprovider.Zones, bool) {
+	_, ok := f.cloud.Zones()
+	return f, ok
+}
+
+func (f *FaultyCloud) Clusters() (cloudprovider.Clusters, bool) {
+	return f.cloud.Clusters()
+}
+
+func (f *FaultyCloud) Routes() (cloudprovider.Routes, bool) {
+	return f.cloud.Routes()
+}
+
+func (f *FaultyCloud) ProviderName() string {
+	return f.cloud.ProviderName()
+}
+
+func (f *FaultyCloud) ScrubDNS(nameservers, searches []string) (nsOut, srchOut []string) {
+	return f.cloud.ScrubDNS(nameservers, searches)
+}
+
+// InvalidateHostCache is passed on to the wrapped provider if it caches hosts
+func (f *FaultyCloud) InvalidateHostCache(key string) int {
+	if invalidator, ok := f.cloud.(interface {
+		InvalidateHostCache(key string) int
+	}); ok {
+		return invalidator.InvalidateHostCache(key)
+	}
+	return 0
+}
+
+// --- Instances ---
+
+func (f *FaultyCloud) NodeAddresses(name types.NodeName) ([]v1.NodeAddress, error) {
+	instances, err := f.instances("NodeAddresses")
+	if err != nil {
+		return nil, err
+	}
+	return instances.NodeAddresses(name)
+}
+
+func (f *FaultyCloud) NodeAddressesByProviderID(providerID string) ([]v1.NodeAddress, error) {
+	instances, err := f.instances("NodeAddressesByProviderID")
+	if err != nil {
+		return nil, err
+	}
+	return instances.NodeAddressesByProviderID(providerID)
+}
+
+func (f *FaultyCloud) ExternalID(nodeName types.NodeName) (string, error) {
+	instances, err := f.instances("ExternalID")
+	if err != nil {
+		return "", err
+	}
+	return instances.ExternalID(nodeName)
+}
+
+func (f *FaultyCloud) InstanceID(nodeName types.NodeName) (string, error) {
+	instances, err := f.instances("InstanceID")
+	if err != nil {
+		return "", err
+	}
+	return instances.InstanceID(nodeName)
+}
+
+func (f *FaultyCloud) InstanceType(name types.NodeName) (string, error) {
+	instances, err := f.instances("InstanceType")
+	if err != nil {
+		return "", err
+	}
+	return instances.InstanceType(name)
+}
+
+func (f *FaultyCloud) InstanceTypeByProviderID(providerID string) (string, error) {
+	instances, err := f.instances("InstanceTypeByProviderID")
+	if err != nil {
+		return "", err
+	}
+	return instances.InstanceTypeByProviderID(providerID)
+}
+
+func (f *FaultyCloud) AddSSHKeyToAllInstances(user string, keyData []byte) error {
+	instances, err := f.instances("AddSSHKeyToAllInstances")
+	if err != nil {
+		return err
+	}
+	return instances.AddSSHKeyToAllInstances(user, keyData)
+}
+
+func (f *FaultyCloud) CurrentNodeName(hostname string) (types.NodeName, error) {
+	instances, err := f.instances("CurrentNodeName")
+	if err != nil {
+		return "", err
+	}
+	return instances.CurrentNodeName(hostname)
+}
+
+// --- Zones ---
+
+func (f *FaultyCloud) GetZone() (cloudprovider.Zone, error) {
+	cloud, err := f.call("GetZone")
+	if err != nil {
+		return cloudprovider.Zone{}, err
+	}
+	zones, ok := cloud.Zones()
+	if !ok {
+		return cloudprovider.Zone{}, fmt.Errorf("cloud provider doesn't support zones")
+	}
+	return zones.GetZone()
+}
+
+// --- LoadBalancer ---
+
+func (f *FaultyCloud) GetLoadBalancer(clusterName string, service *v1.Service) (*v1.LoadBalancerStatus, bool, error) {
+	lb, err := f.loadBalancer("GetLoadBalancer")
+	if err != nil {
+		return nil, false, err
+	}
+	return lb.GetLoadBalancer(clusterName, service)
+}
+
+func (f *FaultyCloud) EnsureLoadBalancer(clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	lb, err := f.loadBalancer("EnsureLoadBalancer")
+	if err != nil {
+		return nil, err
+	}
+	return lb.EnsureLoadBalancer(clusterName, service, nodes)
+}
+
+func (f *FaultyCloud) UpdateLoadBalancer(clusterName string, service *v1.Service, nodes []*v1.Node) error {
+	lb, err := f.loadBalancer("UpdateLoadBalancer")
+	if err != nil {
+		return err
+	}
+	return lb.UpdateLoadBalancer(clusterName, service, nodes)
+}
+
+func (f *FaultyCloud) EnsureLoadBalancerDeleted(clusterName string, service *v1.Service) error {
+	lb, err := f.loadBalancer("EnsureLoadBalancerDeleted")
+	if err != nil {
+		return err
+	}
+	return lb.EnsureLoadBalancerDeleted(clusterName, service)
+}
@@ -0,0 +1,51 @@
+package testutil
+
+import (
+	"k8s.io/client-go/tools/cache"
+	corelisters "k8s.io/kubernetes/pkg/client/listers/core/v1"
+)
+
+// FakeInformer serves the objects of Indexer and keeps the event handler it's given, so tests can
+// fill the indexer and deliver events themselves
+type FakeInformer struct {
+	cache.SharedIndexInformer
+	Indexer cache.Indexer
+	Handler cache.ResourceEventHandler
+}
+
+// NewFakeInformer returns a FakeInformer with an empty indexer of namespaced objects
+func NewFakeInformer() *FakeInformer {
+	return &FakeInformer{Indexer: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})}
+}
+
+func (f *FakeInformer) AddEventHandler(handler cache.ResourceEventHandler) {
+	f.Handler = handler
+}
+
+func (f *FakeInformer) HasSynced() bool {
+	return true
+}
+
+// FakeServiceInformer is a service informer serving the services of a FakeInformer
+type FakeServiceInformer struct{ *FakeInformer }
+
+func (f FakeServiceInformer) Informer() cache.SharedIndexInformer { return f.FakeInformer }
+func (f FakeServiceInformer) Lister() corelisters.ServiceLister {
+	return corelisters.NewServiceLister(f.Indexer)
+}
+
+// FakeNodeInformer is a node informer serving the nodes of a FakeInformer
+type FakeNodeInformer struct{ *FakeInformer }
+
+func (f FakeNodeInformer) Informer() cache.SharedIndexInformer { return f.FakeInformer }
+func (f FakeNodeInformer) Lister() corelisters.NodeLister {
+	return corelisters.NewNodeLister(f.Indexer)
+}
+
+// FakeEndpointsInformer is an endpoints informer serving the endpoints of a FakeInformer
+type FakeEndpointsInformer struct{ *FakeInformer }
+
+func (f FakeEndpointsInformer) Informer() cache.SharedIndexInformer { return f.FakeInformer }
+func (f FakeEndpointsInformer) Lister() corelisters.EndpointsLister {
+	return corelisters.NewEndpointsLister(f.Indexer)
+}